@@ -11,7 +11,7 @@ import (
 
 func main() {
 	var (
-		configPath = flag.String("config", "", "Path to configuration file (default: soxdrawer.config.toml)")
+		configPath = flag.String("config", "", "Path to configuration file (default: $SOXDRAWER_CONFIG or soxdrawer.config.toml)")
 		showToken  = flag.Bool("show-token", false, "Display current NATS authentication token")
 		newToken   = flag.Bool("new-token", false, "Generate a new NATS authentication token")
 		testConn   = flag.Bool("test", false, "Test connection to NATS server")
@@ -19,20 +19,27 @@ func main() {
 	)
 	flag.Parse()
 
-	cfg, err := config.LoadConfig(*configPath)
+	// Load the configuration the way the server does, so tokens in the
+	// secrets file or referenced from files and the environment are found
+	var args []string
+	if *configPath != "" {
+		args = []string{"-config", *configPath}
+	}
+	loaded, err := config.Load(args, os.Environ())
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	cfg := loaded.Config
 
 	switch {
 	case *showConfig:
-		fmt.Printf("Configuration file: %s\n", getConfigPath(*configPath))
+		fmt.Printf("Configuration file: %s\n", loaded.Path)
 		fmt.Printf("NATS Host: %s\n", cfg.NATS.Host)
 		fmt.Printf("NATS Port: %d\n", cfg.NATS.Port)
 		fmt.Printf("NATS Store Directory: %s\n", cfg.NATS.StoreDir)
 		fmt.Printf("HTTP Address: %s\n", cfg.HTTP.Address)
 		if cfg.NATS.Token != "" {
-			fmt.Printf("NATS Token: %s\n", cfg.NATS.Token)
+			fmt.Printf("NATS Token: (from %s, shown with -show-token)\n", loaded.Origin("nats.token"))
 		} else {
 			fmt.Println("NATS Token: (not configured)")
 		}
@@ -46,16 +53,20 @@ func main() {
 		fmt.Printf("NATS URL: nats://%s:%d\n", cfg.NATS.Host, cfg.NATS.Port)
 
 	case *newToken:
+		// A token set anywhere else would override the secrets file
+		if source := loaded.Sources["nats.token"]; source != config.SourceDefault && source != config.SourceSecrets {
+			log.Fatalf("nats.token is set by %s; remove it there to use a generated token", loaded.Origin("nats.token"))
+		}
 		if err := cfg.GenerateToken(); err != nil {
 			log.Fatalf("Failed to generate new token: %v", err)
 		}
 
-		if err := config.SaveConfig(cfg, *configPath); err != nil {
-			log.Fatalf("Failed to save configuration: %v", err)
+		if err := loaded.SaveSecrets(map[string]string{"nats.token": cfg.NATS.Token}); err != nil {
+			log.Fatalf("Failed to save the new token: %v", err)
 		}
 
-		fmt.Printf("Generated new NATS token: %s\n", cfg.NATS.Token)
-		fmt.Println("Configuration saved. Please restart the soxdrawer server.")
+		fmt.Printf("Generated a new NATS token and saved it to %s\n", loaded.SecretsPath)
+		fmt.Println("Reload or restart the soxdrawer server to use it; -show-token displays it.")
 
 	case *testConn:
 		if cfg.NATS.Token == "" {
@@ -75,13 +86,6 @@ func main() {
 	}
 }
 
-func getConfigPath(configPath string) string {
-	if configPath == "" {
-		return config.DefaultConfigFile
-	}
-	return configPath
-}
-
 func testNATSConnection(cfg *config.Config) error {
 	// Import and use nats package for testing connection
 	// For now, we'll just validate the configuration
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nats-io/nkeys"
+
+	"soxdrawer/internal/config"
+	"soxdrawer/internal/nats"
+	"soxdrawer/internal/store"
+)
+
+// configCommand runs `soxdrawer config <subcommand>` and returns the exit code
+func configCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "validate" && args[0] != "migrate") {
+		fmt.Fprintln(os.Stderr, "Usage: soxdrawer config validate|migrate [flags]")
+		return 2
+	}
+
+	// Check exactly what the server would run with, including the
+	// environment and any setting flags
+	loaded, err := config.Load(args[1:], os.Environ())
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	for _, warning := range loaded.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	if !loaded.Exists {
+		fmt.Printf("%s does not exist; the defaults are valid\n", loaded.Path)
+		return 0
+	}
+
+	if args[0] == "migrate" {
+		return migrateCommand(loaded)
+	}
+	fmt.Printf("%s is valid\n", loaded.Path)
+	return 0
+}
+
+// migrateCommand rewrites an older config file in the current version
+func migrateCommand(loaded *config.Loaded) int {
+	from := loaded.FileVersion
+	backup, err := loaded.Rewrite()
+	if backup != "" {
+		fmt.Printf("Saved the version %d file as %s\n", from, backup)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if backup == "" {
+		fmt.Printf("%s is already version %d\n", loaded.Path, config.CurrentVersion)
+		return 0
+	}
+	fmt.Printf("Upgraded %s from version %d to %d\n", loaded.Path, from, config.CurrentVersion)
+	return 0
+}
+
+// natsCommand runs `soxdrawer nats <subcommand>` and returns the exit code
+func natsCommand(args []string) int {
+	if len(args) == 0 || args[0] != "add-client" {
+		fmt.Fprintln(os.Stderr, "Usage: soxdrawer nats add-client <name> [flags]")
+		return 2
+	}
+	return addClientCommand(args[1:])
+}
+
+// addClientCommand issues a NATS client its own nkey: it writes the seed to
+// a file for the client and adds the public key to the config file
+func addClientCommand(args []string) int {
+	flags := flag.NewFlagSet("soxdrawer nats add-client", flag.ContinueOnError)
+	configPath := flags.String("config", "", "Path to the configuration file (default $"+config.EnvConfigFile+" or "+config.DefaultConfigFile+")")
+	access := flags.String("access", nats.AccessRead, "What the client may do: read, write or admin")
+	buckets := flags.String("buckets", store.DefaultBucket, "Comma-separated buckets the client may use; ignored for admin")
+	out := flags.String("out", "", "File to write the client's seed to (default <name>.nk)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: soxdrawer nats add-client <name> [flags]\n\n")
+		flags.PrintDefaults()
+	}
+
+	// The name may come before or after the flags
+	var name string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if name == "" && flags.NArg() == 1 {
+		name = flags.Arg(0)
+	} else if name == "" || flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+	if *out == "" {
+		*out = name + ".nk"
+	}
+
+	var loadArgs []string
+	if *configPath != "" {
+		loadArgs = []string{"-config", *configPath}
+	}
+	loaded, err := config.Load(loadArgs, os.Environ())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	user, err := nkeys.CreateUser()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to generate nkey: %v\n", err)
+		return 1
+	}
+	publicKey, _ := user.PublicKey()
+	seed, _ := user.Seed()
+	client := config.NATSClientConfig{NKey: publicKey, Access: *access}
+	if *access != nats.AccessAdmin {
+		for _, bucket := range strings.Split(*buckets, ",") {
+			client.Buckets = append(client.Buckets, strings.TrimSpace(bucket))
+		}
+	}
+
+	// Write the seed first, so the config never names a key nobody has
+	file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, config.ConfigFilePerm)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create seed file: %v\n", err)
+		return 1
+	}
+	_, err = fmt.Fprintf(file, "# NATS nkey seed of soxdrawer client %s - keep this file private\n%s\n", name, seed)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = loaded.AddNATSClient(name, client)
+	}
+	if err != nil {
+		os.Remove(*out)
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	scope := "all buckets"
+	if len(client.Buckets) > 0 {
+		scope = strings.Join(client.Buckets, ", ")
+	}
+	fmt.Printf("Added NATS client %s with %s access to %s in %s\n", name, *access, scope, loaded.Path)
+	fmt.Printf("Its seed is in %s; give it only to the client\n\n", *out)
+	fmt.Printf("Connect with the seed file and the client's inbox prefix, e.g.\n")
+	fmt.Printf("  nats.Connect(url, nats.NkeyOptionFromSeed(%q), nats.CustomInboxPrefix(%q))\n", *out, nats.InboxPrefix(name))
+	fmt.Printf("  nats --nkey %s --inbox-prefix %s ...\n\n", *out, nats.InboxPrefix(name))
+	fmt.Println("A running soxdrawer lets the client in once it reloads its config (SIGHUP)")
+	return 0
+}
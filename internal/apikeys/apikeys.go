@@ -0,0 +1,200 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// BucketName is the KV bucket holding API keys
+	BucketName = "api_keys"
+
+	// Prefix starts every API key so they are easy to recognise in scripts
+	// and logs
+	Prefix = "sdk_"
+)
+
+var (
+	// ErrKeyNotFound is returned when no API key exists for an ID
+	ErrKeyNotFound = errors.New("API key not found")
+	// ErrInvalidKey is returned when a presented key is malformed, unknown or revoked
+	ErrInvalidKey = errors.New("invalid API key")
+)
+
+type (
+	// Key lets a script call the API as the user who created it, without
+	// a session. It never has admin rights, whatever the user's role. Only
+	// a hash of the secret is stored.
+	Key struct {
+		ID      string    `json:"id"`
+		Name    string    `json:"name"`
+		User    string    `json:"user"`
+		Created time.Time `json:"created"`
+		Hash    string    `json:"hash,omitempty"` // SHA-256 of the full key
+	}
+
+	// Store keeps API keys in a JetStream KV bucket
+	Store struct {
+		kv nats.KeyValue
+	}
+)
+
+// New creates or opens the API keys bucket
+func New(js nats.JetStreamContext) (*Store, error) {
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{
+		Bucket:      BucketName,
+		Description: "soxdrawer API keys",
+	})
+	if err != nil {
+		kv, err = js.KeyValue(BucketName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create or get API keys bucket: %w", err)
+		}
+	}
+
+	return &Store{kv: kv}, nil
+}
+
+// Create issues a new API key for a user and returns it with the secret,
+// which is not stored and cannot be shown again
+func (s *Store) Create(name, user string) (*Key, string, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return nil, "", err
+	}
+
+	full := Prefix + id + "_" + secret
+	key := &Key{
+		ID:      id,
+		Name:    name,
+		User:    user,
+		Created: time.Now().UTC(),
+		Hash:    hash(full),
+	}
+
+	data, err := json.Marshal(key)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode API key: %w", err)
+	}
+	if _, err := s.kv.Create(id, data); err != nil {
+		return nil, "", fmt.Errorf("failed to store API key: %w", err)
+	}
+	return key, full, nil
+}
+
+// Get returns the API key with the given ID
+func (s *Store) Get(id string) (*Key, error) {
+	entry, err := s.kv.Get(id)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return nil, fmt.Errorf("%w: '%s'", ErrKeyNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to get API key '%s': %w", id, err)
+	}
+
+	var key Key
+	if err := json.Unmarshal(entry.Value(), &key); err != nil {
+		return nil, fmt.Errorf("failed to decode API key '%s': %w", id, err)
+	}
+	return &key, nil
+}
+
+// Verify returns the API key a presented secret belongs to
+func (s *Store) Verify(full string) (*Key, error) {
+	id, _, ok := strings.Cut(strings.TrimPrefix(full, Prefix), "_")
+	if !strings.HasPrefix(full, Prefix) || !ok || id == "" || strings.ContainsAny(id, ".*> ") {
+		return nil, ErrInvalidKey
+	}
+
+	key, err := s.Get(id)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return nil, ErrInvalidKey
+		}
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash(full))) != 1 {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// Revoke deletes an API key so it can no longer be used
+func (s *Store) Revoke(id string) error {
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	if err := s.kv.Delete(id); err != nil {
+		return fmt.Errorf("failed to revoke API key '%s': %w", id, err)
+	}
+	return nil
+}
+
+// RevokeUser deletes every API key of a user and returns how many there were
+func (s *Store) RevokeUser(user string) (int, error) {
+	keys, err := s.List(user)
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		if err := s.Revoke(key.ID); err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// List returns the API keys of a user, or of everyone when user is empty,
+// newest first
+func (s *Store) List(user string) ([]*Key, error) {
+	ids, err := s.kv.Keys()
+	if err != nil {
+		if errors.Is(err, nats.ErrNoKeysFound) {
+			return []*Key{}, nil
+		}
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+
+	keys := make([]*Key, 0, len(ids))
+	for _, id := range ids {
+		key, err := s.Get(id)
+		if err != nil {
+			continue
+		}
+		if user == "" || key.User == user {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Created.After(keys[j].Created)
+	})
+	return keys, nil
+}
+
+func hash(full string) string {
+	sum := sha256.Sum256([]byte(full))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	return hex.EncodeToString(bytes), nil
+}
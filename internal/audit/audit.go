@@ -0,0 +1,189 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// Stream is the append-only JetStream stream holding audit records
+	Stream = "SOXDRAWER_AUDIT"
+	// SubjectPrefix prefixes record subjects: soxdrawer.audit.<action>
+	SubjectPrefix = "soxdrawer.audit"
+
+	ActionLogin           = "login"
+	ActionLogout          = "logout"
+	ActionUpload          = "upload"
+	ActionDownload        = "download"
+	ActionDelete          = "delete"
+	ActionRename          = "rename"
+	ActionCopy            = "copy"
+	ActionMove            = "move"
+	ActionMetadata        = "metadata" // Tags, description or collections changed
+	ActionSessionRevoke   = "session_revoke"
+	ActionTOTPEnable      = "totp_enable"
+	ActionTOTPDisable     = "totp_disable"
+	ActionTOTPReset       = "totp_reset"
+	ActionUploadKeyCreate = "upload_key_create"
+	ActionUploadKeyRevoke = "upload_key_revoke"
+	ActionAPIKeyCreate    = "api_key_create"
+	ActionAPIKeyRevoke    = "api_key_revoke"
+	ActionConfigChange    = "config_change"
+
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied" // Refused before it was attempted, e.g. locked out
+)
+
+// fetchBatch is the number of records read from the stream at a time
+const fetchBatch = 256
+
+type (
+	// Record is one audited action
+	Record struct {
+		Seq       uint64    `json:"seq,omitempty"` // Stream sequence, set when read back
+		Time      time.Time `json:"time"`
+		Actor     string    `json:"actor"`
+		Action    string    `json:"action"`
+		Outcome   string    `json:"outcome"`
+		IP        string    `json:"ip,omitempty"`
+		UserAgent string    `json:"user_agent,omitempty"`
+		Bucket    string    `json:"bucket,omitempty"`
+		Key       string    `json:"key,omitempty"`
+		Digest    string    `json:"digest,omitempty"`
+		Size      uint64    `json:"size,omitempty"`
+		Detail    string    `json:"detail,omitempty"`
+	}
+
+	// Filter selects records; zero values match everything
+	Filter struct {
+		Actor  string
+		Action string
+		Since  time.Time
+		Until  time.Time
+	}
+
+	// Log writes and reads audit records
+	Log struct {
+		js nats.JetStreamContext
+	}
+)
+
+// New creates or updates the audit stream. Records older than retention
+// are discarded; 0 keeps them forever. Deletes and purges are denied so
+// records can't be removed through the JetStream API.
+func New(js nats.JetStreamContext, retention time.Duration) (*Log, error) {
+	info, err := js.StreamInfo(Stream)
+	switch {
+	case errors.Is(err, nats.ErrStreamNotFound):
+		_, err = js.AddStream(&nats.StreamConfig{
+			Name:        Stream,
+			Description: "soxdrawer audit log",
+			Subjects:    []string{SubjectPrefix + ".>"},
+			MaxAge:      retention,
+			Storage:     nats.FileStorage,
+			DenyDelete:  true,
+			DenyPurge:   true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create audit stream: %w", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to get audit stream: %w", err)
+	case info.Config.MaxAge != retention:
+		config := info.Config
+		config.MaxAge = retention
+		if _, err := js.UpdateStream(&config); err != nil {
+			return nil, fmt.Errorf("failed to update audit stream retention: %w", err)
+		}
+	}
+
+	return &Log{js: js}, nil
+}
+
+// Subject returns the subject records of an action are published on
+func Subject(action string) string {
+	return SubjectPrefix + "." + action
+}
+
+// Record appends a record. Failures are logged rather than returned since
+// the audited action has already happened.
+func (l *Log) Record(record Record) {
+	if record.Time.IsZero() {
+		record.Time = time.Now().UTC()
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Failed to encode audit record for %s: %v", record.Action, err)
+		return
+	}
+	if _, err := l.js.Publish(Subject(record.Action), data); err != nil {
+		log.Printf("Failed to write audit record for %s by %s: %v", record.Action, record.Actor, err)
+	}
+}
+
+// Each calls fn for every record matching the filter, oldest first, until
+// fn returns false
+func (l *Log) Each(filter Filter, fn func(*Record) bool) error {
+	subject := SubjectPrefix + ".>"
+	if filter.Action != "" {
+		subject = Subject(filter.Action)
+	}
+
+	start := nats.DeliverAll()
+	if !filter.Since.IsZero() {
+		start = nats.StartTime(filter.Since)
+	}
+
+	sub, err := l.js.PullSubscribe(subject, "",
+		nats.BindStream(Stream),
+		start,
+		nats.AckNone(),
+		nats.InactiveThreshold(time.Minute),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to read audit stream: %w", err)
+	}
+	defer sub.Unsubscribe()
+
+	// Nothing is delivered before the first fetch, so pending is exact
+	info, err := sub.ConsumerInfo()
+	if err != nil {
+		return fmt.Errorf("failed to read audit stream: %w", err)
+	}
+
+	for remaining := info.NumPending; remaining > 0; {
+		msgs, err := sub.Fetch(int(min(remaining, fetchBatch)), nats.MaxWait(5*time.Second))
+		if err != nil {
+			return fmt.Errorf("failed to read audit stream: %w", err)
+		}
+		for _, msg := range msgs {
+			remaining--
+
+			var record Record
+			if err := json.Unmarshal(msg.Data, &record); err != nil {
+				continue
+			}
+			if meta, err := msg.Metadata(); err == nil {
+				record.Seq = meta.Sequence.Stream
+			}
+
+			if !filter.Until.IsZero() && record.Time.After(filter.Until) {
+				return nil
+			}
+			if filter.Actor != "" && record.Actor != filter.Actor {
+				continue
+			}
+			if !fn(&record) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
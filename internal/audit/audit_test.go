@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	natsServer "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// startJetStream runs an in-process NATS server with JetStream for one test
+func startJetStream(t *testing.T) nats.JetStreamContext {
+	t.Helper()
+
+	ns, err := natsServer.NewServer(&natsServer.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create NATS server: %v", err)
+	}
+	ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("failed to connect to NATS: %v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	js, err := conn.JetStream()
+	if err != nil {
+		t.Fatalf("failed to get JetStream context: %v", err)
+	}
+	return js
+}
+
+// collect returns the actions and keys of the records matching filter
+func collect(t *testing.T, log *Log, filter Filter) []string {
+	t.Helper()
+	var got []string
+	err := log.Each(filter, func(record *Record) bool {
+		got = append(got, record.Action+" "+record.Key)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestRecordsCanBeQueried(t *testing.T) {
+	log, err := New(startJetStream(t), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().UTC()
+	log.Record(Record{Actor: "alice", Action: ActionUpload, Outcome: OutcomeSuccess, Key: "a.txt", Time: start})
+	log.Record(Record{Actor: "bob", Action: ActionDelete, Outcome: OutcomeSuccess, Key: "a.txt", Time: start.Add(time.Minute)})
+	log.Record(Record{Actor: "alice", Action: ActionDelete, Outcome: OutcomeFailure, Key: "b.txt", Time: start.Add(2 * time.Minute)})
+
+	for name, test := range map[string]struct {
+		filter Filter
+		want   []string
+	}{
+		"everything": {Filter{}, []string{"upload a.txt", "delete a.txt", "delete b.txt"}},
+		"actor":      {Filter{Actor: "alice"}, []string{"upload a.txt", "delete b.txt"}},
+		"action":     {Filter{Action: ActionDelete}, []string{"delete a.txt", "delete b.txt"}},
+		"both":       {Filter{Actor: "alice", Action: ActionDelete}, []string{"delete b.txt"}},
+		"until":      {Filter{Until: start.Add(time.Minute)}, []string{"upload a.txt", "delete a.txt"}},
+		"since":      {Filter{Since: time.Now().Add(time.Hour)}, nil},
+	} {
+		got := collect(t, log, test.filter)
+		if len(got) != len(test.want) {
+			t.Fatalf("%s: got %v, want %v", name, got, test.want)
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Fatalf("%s: got %v, want %v", name, got, test.want)
+			}
+		}
+	}
+
+	// Records come back with their sequence, and fn can stop early
+	var seqs []uint64
+	err = log.Each(Filter{}, func(record *Record) bool {
+		seqs = append(seqs, record.Seq)
+		return len(seqs) < 2
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seqs) != 2 || seqs[0] != 1 || seqs[1] != 2 {
+		t.Fatalf("sequences = %v, want [1 2]", seqs)
+	}
+}
+
+func TestRecordsCantBeRemoved(t *testing.T) {
+	js := startJetStream(t)
+	log, err := New(js, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Record(Record{Actor: "alice", Action: ActionLogin, Outcome: OutcomeSuccess})
+
+	if err := js.DeleteMsg(Stream, 1); err == nil {
+		t.Fatal("deleting a record succeeded")
+	}
+	if err := js.PurgeStream(Stream); err == nil {
+		t.Fatal("purging the audit stream succeeded")
+	}
+	if got := collect(t, log, Filter{}); len(got) != 1 {
+		t.Fatalf("records after removal attempts = %v", got)
+	}
+}
+
+func TestRetentionIsUpdated(t *testing.T) {
+	js := startJetStream(t)
+	if _, err := New(js, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(js, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := js.StreamInfo(Stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Config.MaxAge != 24*time.Hour || !info.Config.DenyDelete || !info.Config.DenyPurge {
+		t.Fatalf("stream config = %+v", info.Config)
+	}
+}
@@ -0,0 +1,223 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+
+	"soxdrawer/internal/audit"
+	"soxdrawer/internal/quota"
+	"soxdrawer/internal/store"
+)
+
+type (
+	// Bridge stores messages published to put subjects as objects, so
+	// clients that only publish, over NATS, WebSocket or MQTT, can write to
+	// buckets. Each message replaces the object at its key.
+	Bridge struct {
+		conn       *nats.Conn
+		buckets    map[string]*store.ObjectStore
+		quotas     *quota.Tracker
+		audit      *audit.Log
+		clientName func(nkey string) (string, bool)
+		sub        *nats.Subscription
+	}
+
+	// Reply answers messages sent as requests
+	Reply struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Bucket  string `json:"bucket,omitempty"`
+		Key     string `json:"key,omitempty"`
+		Size    uint64 `json:"size,omitempty"`
+		Digest  string `json:"digest,omitempty"`
+	}
+)
+
+const (
+	// Prefix starts every put subject, soxdrawer.put.<bucket>.<key>. MQTT
+	// clients publish to the topic soxdrawer/put/<bucket>/<key>.
+	Prefix = "soxdrawer.put"
+
+	// queue shares the subscription between the servers of a cluster, so
+	// each message is stored once
+	queue = "soxdrawer-bridge"
+
+	// Actor is who bridged writes are audited as when the publisher didn't
+	// log in with its own nkey
+	Actor = "nats-bridge"
+
+	// clientInfoHeader is where the server puts the publisher of a message
+	// that crossed into the bridge's account
+	clientInfoHeader = "Nats-Request-Info"
+)
+
+// New opens the buckets that accept bridged writes, creating them if
+// needed. conn receives the put subjects, and is closed by Stop; objects are
+// written through js. Writes are charged to the buckets' quotas and audited
+// as the client whose nkey clientName knows, or as Actor. quotas, auditLog
+// and clientName may be nil.
+func New(conn *nats.Conn, js nats.JetStreamContext, buckets []string, replicas int, quotas *quota.Tracker, auditLog *audit.Log, clientName func(nkey string) (string, bool)) (*Bridge, error) {
+	b := &Bridge{
+		conn:       conn,
+		buckets:    map[string]*store.ObjectStore{},
+		quotas:     quotas,
+		audit:      auditLog,
+		clientName: clientName,
+	}
+	for _, name := range buckets {
+		objects, err := store.Open(js, name, replicas)
+		if err != nil {
+			return nil, err
+		}
+		b.buckets[name] = objects
+	}
+	return b, nil
+}
+
+// Start subscribes to the put subjects
+func (b *Bridge) Start() error {
+	sub, err := b.conn.QueueSubscribe(Prefix+".>", queue, b.handle)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s.>: %w", Prefix, err)
+	}
+	b.sub = sub
+	return nil
+}
+
+// Stop unsubscribes and closes the connection, letting a write in progress
+// finish
+func (b *Bridge) Stop() {
+	if err := b.conn.Drain(); err != nil {
+		log.Printf("Failed to stop the NATS bridge: %v", err)
+	}
+}
+
+// Key returns the bucket and object key a put subject writes to. Subject
+// tokens after the bucket become folders, and "//", which is how a '.' in
+// an MQTT topic arrives, becomes '.' again: soxdrawer.put.sensors.kitchen.
+// temp//json writes kitchen/temp.json to the sensors bucket.
+func Key(subject string) (bucket, key string, err error) {
+	rest, ok := strings.CutPrefix(subject, Prefix+".")
+	if !ok {
+		return "", "", fmt.Errorf("subject %s doesn't start with %s", subject, Prefix)
+	}
+	bucket, path, ok := strings.Cut(rest, ".")
+	if !ok {
+		return "", "", fmt.Errorf("subject %s has no key after the bucket", subject)
+	}
+
+	key = strings.ReplaceAll(strings.ReplaceAll(path, ".", "/"), "//", ".")
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", "", fmt.Errorf("subject %s has an invalid key %q", subject, key)
+		}
+	}
+	return bucket, key, nil
+}
+
+func (b *Bridge) handle(msg *nats.Msg) {
+	actor := b.publisher(msg)
+	bucket, key, err := Key(msg.Subject)
+	if err != nil {
+		b.reply(msg, Reply{Status: "error", Message: err.Error()})
+		return
+	}
+	objects, ok := b.buckets[bucket]
+	if !ok {
+		b.reply(msg, Reply{Status: "error", Message: fmt.Sprintf("bucket '%s' doesn't accept bridged writes", bucket), Bucket: bucket})
+		return
+	}
+
+	info, err := b.put(objects, key, msg.Data)
+	if err != nil {
+		outcome := audit.OutcomeFailure
+		if errors.Is(err, quota.ErrQuotaExceeded) {
+			outcome = audit.OutcomeDenied
+		}
+		b.record(audit.Record{Actor: actor, Action: audit.ActionUpload, Outcome: outcome, Bucket: bucket, Key: key, Detail: err.Error()})
+		log.Printf("Failed to store %s in bucket %s from %s: %v", key, bucket, msg.Subject, err)
+		b.reply(msg, Reply{Status: "error", Message: err.Error(), Bucket: bucket, Key: key})
+		return
+	}
+
+	b.record(audit.Record{
+		Actor:   actor,
+		Action:  audit.ActionUpload,
+		Outcome: audit.OutcomeSuccess,
+		Bucket:  bucket,
+		Key:     key,
+		Digest:  info.Digest,
+		Size:    info.Size,
+	})
+	b.reply(msg, Reply{
+		Status:  "success",
+		Message: "Content stored successfully",
+		Bucket:  bucket,
+		Key:     key,
+		Size:    info.Size,
+		Digest:  info.Digest,
+	})
+}
+
+// put stores data within the bucket's quota
+func (b *Bridge) put(objects *store.ObjectStore, key string, data []byte) (*nats.ObjectInfo, error) {
+	var reader io.Reader = bytes.NewReader(data)
+	var upload *quota.Upload
+	if b.quotas != nil {
+		var err error
+		upload, err = b.quotas.Begin(objects.Name(), "", int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		defer upload.Done()
+		reader = upload.Reader(reader)
+	}
+	info, err := objects.PutOwned(key, "", reader)
+	if err == nil {
+		upload.Stored(info.Name, int64(info.Size))
+	}
+	return info, err
+}
+
+// publisher names who published msg: "nats:" and the name of a client that
+// logged in with its own nkey, or Actor for everyone else
+func (b *Bridge) publisher(msg *nats.Msg) string {
+	var info struct {
+		User string `json:"user"`
+	}
+	if b.clientName == nil || json.Unmarshal([]byte(msg.Header.Get(clientInfoHeader)), &info) != nil {
+		return Actor
+	}
+	if name, ok := b.clientName(info.User); ok {
+		return "nats:" + name
+	}
+	return Actor
+}
+
+func (b *Bridge) record(record audit.Record) {
+	if b.audit != nil {
+		b.audit.Record(record)
+	}
+}
+
+// reply answers a message sent as a request; plain publishes get no answer
+func (b *Bridge) reply(msg *nats.Msg, reply Reply) {
+	if msg.Reply == "" {
+		return
+	}
+	data, err := json.Marshal(reply)
+	if err != nil {
+		log.Printf("Failed to encode NATS bridge reply: %v", err)
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		log.Printf("Failed to reply to %s: %v", msg.Subject, err)
+	}
+}
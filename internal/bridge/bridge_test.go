@@ -0,0 +1,207 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+
+	"soxdrawer/internal/audit"
+	server "soxdrawer/internal/nats"
+	"soxdrawer/internal/quota"
+)
+
+const testToken = "bridge-test-token-0123456789abcdef0123456789"
+
+// startBridge runs soxdrawer's NATS server with a bridge to the sensors
+// bucket, which takes objects of up to 16 bytes, and a write client named
+// sensor. It returns the bridge and the sensor's key.
+func startBridge(t *testing.T) (*Bridge, *server.NATSServer, nkeys.KeyPair) {
+	t.Helper()
+	sensor, err := nkeys.CreateUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nkey, err := sensor.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ns, err := server.NewServer(&server.Config{
+		Host:     "127.0.0.1",
+		Port:     -1,
+		StoreDir: t.TempDir(),
+		Token:    testToken,
+		Clients:  []server.Client{{Name: "sensor", NKey: nkey, Access: server.AccessWrite, Buckets: []string{"sensors"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ns.Stop(context.Background()) })
+
+	auditLog, err := audit.New(ns.JetStream(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := quota.New(ns.JetStream(), quota.Config{Buckets: map[string]quota.Quota{"sensors": {MaxObjectSize: 16}}})
+	conn, err := ns.CreateBridgeConnection(Prefix + ".>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(conn, ns.JetStream(), []string{"sensors"}, 1, tracker, auditLog, ns.ClientName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Stop)
+	return b, ns, sensor
+}
+
+// put sends data as a request and returns the bridge's reply
+func put(t *testing.T, conn *nats.Conn, subject, data string) Reply {
+	t.Helper()
+	msg, err := conn.Request(subject, []byte(data), 5*time.Second)
+	if err != nil {
+		t.Fatalf("put to %s: %v", subject, err)
+	}
+	var reply Reply
+	if err := json.Unmarshal(msg.Data, &reply); err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+// actors returns who each upload in the audit log was made by, with its outcome
+func actors(t *testing.T, b *Bridge) []string {
+	t.Helper()
+	var got []string
+	err := b.audit.Each(audit.Filter{Action: audit.ActionUpload}, func(record *audit.Record) bool {
+		got = append(got, record.Actor+" "+record.Outcome+" "+record.Key)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestKey(t *testing.T) {
+	for subject, want := range map[string][2]string{
+		"soxdrawer.put.sensors.reading":                {"sensors", "reading"},
+		"soxdrawer.put.sensors.kitchen.temp//json":     {"sensors", "kitchen/temp.json"},
+		"soxdrawer.put.sensors.archive//tar//gz":       {"sensors", "archive.tar.gz"},
+		"soxdrawer.put.photos.2024.summer.beach//jpeg": {"photos", "2024/summer/beach.jpeg"},
+	} {
+		bucket, key, err := Key(subject)
+		if err != nil || bucket != want[0] || key != want[1] {
+			t.Fatalf("Key(%s) = %q, %q, %v; want %q, %q", subject, bucket, key, err, want[0], want[1])
+		}
+	}
+
+	for _, subject := range []string{
+		"soxdrawer.get.sensors.reading", // Not a put subject
+		"soxdrawer.put.sensors",         // No key
+		"soxdrawer.put.sensors.//",      // "."
+		"soxdrawer.put.sensors.a.////",  // ".."
+		"soxdrawer.put.sensors.a.",      // Empty segment
+	} {
+		if bucket, key, err := Key(subject); err == nil {
+			t.Fatalf("Key(%s) = %q, %q; want an error", subject, bucket, key)
+		}
+	}
+}
+
+func TestPutsBecomeObjects(t *testing.T) {
+	b, ns, sensor := startBridge(t)
+	nkey, _ := sensor.PublicKey()
+	conn, err := nats.Connect(ns.URL(), nats.Nkey(nkey, sensor.Sign), nats.CustomInboxPrefix(server.InboxPrefix("sensor")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	reply := put(t, conn, "soxdrawer.put.sensors.kitchen.temp//json", `{"c": 21.5}`)
+	if reply.Status != "success" || reply.Bucket != "sensors" || reply.Key != "kitchen/temp.json" || reply.Size != 11 || reply.Digest == "" {
+		t.Fatalf("reply = %+v", reply)
+	}
+	data, err := b.buckets["sensors"].Get("kitchen/temp.json")
+	if err != nil || string(data) != `{"c": 21.5}` {
+		t.Fatalf("stored %q, %v", data, err)
+	}
+
+	// Plain publishes are stored too, without a reply
+	if err := conn.Publish("soxdrawer.put.sensors.hall", []byte("19")); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if data, err := b.buckets["sensors"].Get("hall"); err == nil && string(data) == "19" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("published message wasn't stored")
+		}
+	}
+
+	// Token clients share the bridge's own name
+	token, err := nats.Connect(ns.URL(), nats.Token(testToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer token.Close()
+	if reply := put(t, token, "soxdrawer.put.sensors.garage", "12"); reply.Status != "success" {
+		t.Fatalf("token put = %+v", reply)
+	}
+
+	// The server sets who published, whatever the publisher claims
+	forged := nats.NewMsg("soxdrawer.put.sensors.attic")
+	forged.Header.Set(clientInfoHeader, `{"user": "`+nkey+`"}`)
+	forged.Data = []byte("15")
+	if _, err := token.RequestMsg(forged, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	got := actors(t, b)
+	want := []string{"nats:sensor success kitchen/temp.json", "nats:sensor success hall", Actor + " success garage", Actor + " success attic"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("audited %v, want %v", got, want)
+	}
+}
+
+func TestRefusedPutsAreAnswered(t *testing.T) {
+	b, ns, _ := startBridge(t)
+	conn, err := nats.Connect(ns.URL(), nats.Token(testToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	reply := put(t, conn, "soxdrawer.put.sensors.big", strings.Repeat("x", 17))
+	if reply.Status != "error" || !strings.Contains(reply.Message, "quota") || reply.Key != "big" {
+		t.Fatalf("put over quota = %+v", reply)
+	}
+	if _, err := b.buckets["sensors"].Get("big"); err == nil {
+		t.Fatal("object over quota was stored")
+	}
+
+	reply = put(t, conn, "soxdrawer.put.photos.cat", "meow")
+	if reply.Status != "error" || reply.Bucket != "photos" || !strings.Contains(reply.Message, "doesn't accept bridged writes") {
+		t.Fatalf("put to an unlisted bucket = %+v", reply)
+	}
+	reply = put(t, conn, "soxdrawer.put.sensors.a.////", "up")
+	if reply.Status != "error" || !strings.Contains(reply.Message, "invalid key") {
+		t.Fatalf("put with an invalid key = %+v", reply)
+	}
+
+	if got := actors(t, b); len(got) != 1 || got[0] != Actor+" "+audit.OutcomeDenied+" big" {
+		t.Fatalf("audited %v, want the denied put", got)
+	}
+}
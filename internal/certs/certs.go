@@ -0,0 +1,200 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ReloadInterval is how often certificate files are checked for changes
+const ReloadInterval = 30 * time.Second
+
+type (
+	// Config describes the certificate material for one TLS listener
+	Config struct {
+		CertFile     string
+		KeyFile      string
+		ClientCAFile string   // Require client certificates signed by this CA (mTLS)
+		MinVersion   string   // "1.2" or "1.3"
+		SelfSigned   bool     // Generate a CA and certificate if the files are missing
+		Hosts        []string // DNS names and IPs for a generated certificate
+	}
+
+	// Reloader holds the current certificate and client CA pool for a
+	// listener and swaps them when the files on disk change
+	Reloader struct {
+		config     Config
+		minVersion uint16
+
+		mu        sync.RWMutex
+		cert      *tls.Certificate
+		clientCAs *x509.CertPool
+		modTimes  map[string]time.Time
+	}
+)
+
+// New loads the certificate described by config, generating a self-signed
+// one first if requested and the files do not exist
+func New(config Config) (*Reloader, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, errors.New("cert_file and key_file are required for TLS")
+	}
+
+	minVersion, err := ParseVersion(config.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.SelfSigned {
+		if err := EnsureSelfSigned(config.CertFile, config.KeyFile, config.Hosts); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &Reloader{
+		config:     config,
+		minVersion: minVersion,
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ParseVersion converts a configured minimum TLS version into its constant.
+// An empty value defaults to TLS 1.2.
+func ParseVersion(version string) (uint16, error) {
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS min_version '%s': expected 1.2 or 1.3", version)
+	}
+}
+
+// TLSConfig returns a server TLS configuration that always serves the most
+// recently loaded certificate and client CA pool
+func (r *Reloader) TLSConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion: r.minVersion,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.cert, nil
+		},
+	}
+	if r.config.ClientCAFile == "" {
+		return base
+	}
+
+	base.ClientAuth = tls.RequireAndVerifyClientCert
+	r.mu.RLock()
+	base.ClientCAs = r.clientCAs
+	r.mu.RUnlock()
+
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		config := base.Clone()
+		config.GetConfigForClient = nil
+		r.mu.RLock()
+		config.ClientCAs = r.clientCAs
+		r.mu.RUnlock()
+		return config, nil
+	}
+	return base
+}
+
+// RequireClientCerts reports whether the listener is configured for mTLS
+func (r *Reloader) RequireClientCerts() bool {
+	return r.config.ClientCAFile != ""
+}
+
+// Watch polls the certificate files until ctx is done and reloads them when
+// they change. A failed reload keeps serving the previous certificate.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.load(); err != nil {
+				log.Printf("Failed to reload TLS certificate %s: %v", r.config.CertFile, err)
+				continue
+			}
+			log.Printf("Reloaded TLS certificate %s", r.config.CertFile)
+		}
+	}
+}
+
+// files returns the paths that make up the listener's certificate material
+func (r *Reloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+	return files
+}
+
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Reloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("failed to stat '%s': %w", file, err)
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate '%s': %w", r.config.CertFile, err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.config.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA '%s': %w", r.config.ClientCAFile, err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA '%s'", r.config.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	r.mu.Unlock()
+	return nil
+}
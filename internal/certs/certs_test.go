@@ -0,0 +1,183 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// caPool returns a pool holding the generated CA in dir
+func caPool(t *testing.T, dir string) *x509.CertPool {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, CAFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		t.Fatal("no CA certificate found")
+	}
+	return pool
+}
+
+// serving returns the certificate a reloader currently serves
+func serving(t *testing.T, r *Reloader) *x509.Certificate {
+	t.Helper()
+	cert, err := r.TLSConfig().GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf
+}
+
+// handshake connects to a listener using config and reports the handshake's
+// outcome as the server saw it
+func handshake(t *testing.T, server, client *tls.Config) error {
+	t.Helper()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			result <- err
+			return
+		}
+		defer conn.Close()
+		result <- conn.(*tls.Conn).Handshake()
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), client)
+	if err == nil {
+		// Client certificates are checked after the client's side is done
+		conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	return <-result
+}
+
+func TestSelfSignedBootstrap(t *testing.T) {
+	dir := t.TempDir()
+	httpCerts, err := New(Config{CertFile: filepath.Join(dir, "http.pem"), KeyFile: filepath.Join(dir, "http-key.pem"), SelfSigned: true, Hosts: []string{"drawer.example.com", "127.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := os.ReadFile(filepath.Join(dir, CAFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf := serving(t, httpCerts)
+	for _, host := range []string{"drawer.example.com", "127.0.0.1"} {
+		if _, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: caPool(t, dir)}); err != nil {
+			t.Fatalf("certificate does not verify for %s: %v", host, err)
+		}
+	}
+	for _, file := range []string{"http-key.pem", CAKeyFile} {
+		info, err := os.Stat(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != keyFilePerm {
+			t.Fatalf("%s has mode %v, want %v", file, perm, os.FileMode(keyFilePerm))
+		}
+	}
+
+	// A second listener in the same directory shares the CA
+	if _, err := New(Config{CertFile: filepath.Join(dir, "nats.pem"), KeyFile: filepath.Join(dir, "nats-key.pem"), SelfSigned: true}); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(filepath.Join(dir, CAFile)); !bytes.Equal(again, ca) {
+		t.Fatal("second listener replaced the CA")
+	}
+	// And existing files are kept
+	if _, err := New(Config{CertFile: filepath.Join(dir, "http.pem"), KeyFile: filepath.Join(dir, "http-key.pem"), SelfSigned: true}); err != nil {
+		t.Fatal(err)
+	}
+	if serving(t, httpCerts).SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		t.Fatal("existing certificate was regenerated")
+	}
+}
+
+func TestWatchReloadsChangedCertificates(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	r, err := New(Config{CertFile: certFile, KeyFile: keyFile, SelfSigned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := serving(t, r).SerialNumber
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	// A broken file is ignored and the old certificate kept
+	if err := os.WriteFile(certFile, []byte("not a certificate"), pemFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if serving(t, r).SerialNumber.Cmp(before) != 0 {
+		t.Fatal("broken certificate replaced the served one")
+	}
+
+	os.Remove(certFile)
+	os.Remove(keyFile)
+	if err := EnsureSelfSigned(certFile, keyFile, nil); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for serving(t, r).SerialNumber.Cmp(before) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("rotated certificate was never loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientCertificatesAreRequired(t *testing.T) {
+	dir := t.TempDir()
+	r, err := New(Config{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem"), SelfSigned: true, Hosts: []string{"127.0.0.1"}, ClientCAFile: filepath.Join(dir, CAFile), MinVersion: "1.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.RequireClientCerts() {
+		t.Fatal("client certificates not required")
+	}
+	client := &tls.Config{RootCAs: caPool(t, dir), ServerName: "127.0.0.1"}
+
+	if err := handshake(t, r.TLSConfig(), client); err == nil {
+		t.Fatal("client without a certificate was accepted")
+	}
+
+	clientCert, clientKey := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	if err := EnsureSelfSigned(clientCert, clientKey, []string{"client"}); err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Certificates = []tls.Certificate{pair}
+	if err := handshake(t, r.TLSConfig(), client); err != nil {
+		t.Fatalf("client with a certificate was refused: %v", err)
+	}
+
+	client.MaxVersion = tls.VersionTLS12
+	if err := handshake(t, r.TLSConfig(), client); err == nil {
+		t.Fatal("TLS 1.2 accepted with min_version 1.3")
+	}
+}
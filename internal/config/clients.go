@@ -0,0 +1,75 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// AddNATSClient appends a [nats.clients.<name>] table to the config file,
+// leaving the rest of it as written. The client is checked first, and the
+// file has to exist and be current so the table lands where it is read.
+func (l *Loaded) AddNATSClient(name string, client NATSClientConfig) error {
+	if !l.Exists {
+		return fmt.Errorf("%s does not exist; start soxdrawer once to create it", l.Path)
+	}
+	if l.FileVersion != CurrentVersion {
+		return fmt.Errorf("%s is a version %d config file; run \"soxdrawer config migrate\" first", l.Path, l.FileVersion)
+	}
+	if _, ok := l.File.NATS.Clients[name]; ok {
+		return fmt.Errorf("client %q is already in %s", name, l.Path)
+	}
+
+	next := *l.Config
+	next.NATS.Clients = maps.Clone(l.Config.NATS.Clients)
+	if next.NATS.Clients == nil {
+		next.NATS.Clients = map[string]NATSClientConfig{}
+	}
+	next.NATS.Clients[name] = client
+	var problems []Problem
+	for _, problem := range next.Validate() {
+		if strings.HasPrefix(problem.Key, "nats.clients."+quoteKey(name)) {
+			problems = append(problems, problem)
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	info, err := os.Stat(l.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	original, err := os.ReadFile(l.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(original)
+	if len(original) > 0 && !bytes.HasSuffix(original, []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	fmt.Fprintf(&buf, "\n[nats.clients.%s]\n", quoteKey(name))
+	if err := toml.NewEncoder(&buf).Encode(client); err != nil {
+		return fmt.Errorf("failed to encode client to TOML: %w", err)
+	}
+
+	// A file that already sets nats.clients some other way, such as inline,
+	// can't take another table
+	var check Config
+	if _, err := toml.Decode(buf.String(), &check); err != nil {
+		return fmt.Errorf("can't add client %q to %s; add it by hand: %w", name, l.Path, err)
+	}
+	if err := writeFile(l.Path, buf.Bytes(), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to save config file: %w", err)
+	}
+
+	l.File.NATS.Clients = maps.Clone(next.NATS.Clients)
+	l.Config.NATS.Clients = next.NATS.Clients
+	return nil
+}
@@ -8,57 +8,350 @@ import (
 	"path/filepath"
 
 	"github.com/BurntSushi/toml"
+
+	"soxdrawer/internal/certs"
 )
 
 type (
 	// Config holds the application configuration
 	Config struct {
-		NATS NATSConfig `toml:"nats"`
-		HTTP HTTPConfig `toml:"http"`
+		Version     int          `toml:"version"`      // Schema version of the file; older files are upgraded when loaded
+		SecretsFile string       `toml:"secrets_file"` // Where generated secrets are saved; default soxdrawer.secrets.toml next to the config file
+		NATS        NATSConfig   `toml:"nats"`
+		HTTP        HTTPConfig   `toml:"http"`
+		Audit       AuditConfig  `toml:"audit"`
+		Quotas      QuotasConfig `toml:"quotas"`
+		Log         LogConfig    `toml:"log"`
+		Reload      ReloadConfig `toml:"reload"`
+	}
+
+	// LogConfig holds logging settings
+	LogConfig struct {
+		Level string `toml:"level"` // info, debug or trace; debug and trace add the embedded NATS server's log
+	}
+
+	// ReloadConfig controls applying config file changes while running.
+	// SIGHUP always reloads.
+	ReloadConfig struct {
+		WatchFile       bool `toml:"watch_file"`       // Reload when the config file changes
+		IntervalSeconds int  `toml:"interval_seconds"` // How often the file is checked
+	}
+
+	// QuotasConfig holds storage quotas. Users and buckets without their own
+	// entry get the default.
+	QuotasConfig struct {
+		UserDefault   QuotaConfig            `toml:"user_default"`
+		Users         map[string]QuotaConfig `toml:"users"`
+		BucketDefault QuotaConfig            `toml:"bucket_default"`
+		Buckets       map[string]QuotaConfig `toml:"buckets"`
+	}
+
+	// QuotaConfig holds the limits of one user or bucket; 0 means unlimited
+	QuotaConfig struct {
+		MaxSizeMB       int64 `toml:"max_size_mb"`
+		MaxObjects      int64 `toml:"max_objects"`
+		MaxObjectSizeMB int64 `toml:"max_object_size_mb"`
+	}
+
+	// AuditConfig holds the audit log settings
+	AuditConfig struct {
+		Enabled       bool `toml:"enabled"`
+		RetentionDays int  `toml:"retention_days"` // Records older than this are discarded; 0 keeps them forever
 	}
 
 	// NATSConfig holds NATS server configuration
 	NATSConfig struct {
-		Host     string `toml:"host"`
-		Port     int    `toml:"port"`
-		StoreDir string `toml:"store_dir"`
-		Token    string `toml:"token"`
+		Host                 string          `toml:"host"`
+		Port                 int             `toml:"port"`
+		StoreDir             string          `toml:"store_dir"`
+		Token                string          `toml:"token" secret:"true"` // The token, or ${NAME} to read it from the environment
+		TokenFile            string          `toml:"token_file"`          // Read the token from this file instead
+		ServerName           string          `toml:"server_name"`         // Empty uses the server's generated ID
+		MaxPayloadKB         int             `toml:"max_payload_kb"`      // Largest message; objects are sent in 128KB chunks
+		WriteDeadlineSeconds int             `toml:"write_deadline_seconds"`
+		MaxConnections       int             `toml:"max_connections"`       // 0 uses the server's default of 65536
+		PingIntervalSeconds  int             `toml:"ping_interval_seconds"` // How often idle clients are pinged
+		JetStream            JetStreamConfig `toml:"jetstream"`
+		Cluster              ClusterConfig   `toml:"cluster"`
+		LeafNodes            LeafNodesConfig `toml:"leafnodes"`
+		Hub                  HubConfig       `toml:"hub"`
+		WebSocket            WebSocketConfig `toml:"websocket"`
+		MQTT                 MQTTConfig      `toml:"mqtt"`
+		Bridge               BridgeConfig    `toml:"bridge"`
+		TLS                  TLSConfig       `toml:"tls"`
+
+		// Clients log in with their own nkeys instead of the token, limited
+		// to some buckets. Only set in the file, as [nats.clients.<name>].
+		Clients map[string]NATSClientConfig `toml:"clients"`
+	}
+
+	// NATSClientConfig is a NATS client's public nkey and what it may do.
+	// "soxdrawer nats add-client" generates the key and adds it here.
+	NATSClientConfig struct {
+		NKey    string   `toml:"nkey"`    // Public user nkey, starting with U
+		Access  string   `toml:"access"`  // read, write or admin
+		Buckets []string `toml:"buckets"` // Buckets it may use; admins may use all of them
+	}
+
+	// ClusterConfig joins the NATS server to other soxdrawer nodes so
+	// JetStream can replicate buckets between them. Port 0 runs standalone.
+	ClusterConfig struct {
+		Name      string   `toml:"name"` // The same on every node
+		Host      string   `toml:"host"`
+		Port      int      `toml:"port"`
+		Routes    []string `toml:"routes"`              // nats-route://host:port of every node; this node's own is ignored
+		Token     string   `toml:"token" secret:"true"` // Shared by every node, or ${NAME}
+		TokenFile string   `toml:"token_file"`          // Read the token from this file instead
+	}
+
+	// LeafNodesConfig lets other soxdrawer instances connect to this one as
+	// leaf nodes and sync with it. Port 0 doesn't accept them.
+	LeafNodesConfig struct {
+		Host      string `toml:"host"`
+		Port      int    `toml:"port"`
+		Token     string `toml:"token" secret:"true"` // Given by every leaf node, or ${NAME}
+		TokenFile string `toml:"token_file"`          // Read the token from this file instead
+	}
+
+	// HubConfig connects to a hub soxdrawer as a leaf node and syncs buckets
+	// with it. No URLs leaves it standalone.
+	HubConfig struct {
+		URLs                []string `toml:"urls"`                // nats-leaf://host:port of the hub's servers
+		Token               string   `toml:"token" secret:"true"` // The hub's nats.leafnodes.token, or ${NAME}
+		TokenFile           string   `toml:"token_file"`          // Read the token from this file instead
+		Domain              string   `toml:"domain"`              // The hub's nats.jetstream.domain
+		SyncBuckets         []string `toml:"sync_buckets"`
+		SyncIntervalSeconds int      `toml:"sync_interval_seconds"`
+	}
+
+	// WebSocketConfig serves NATS over WebSocket for browser apps, with the
+	// same token and client nkeys as the NATS port. Port 0 doesn't listen.
+	WebSocketConfig struct {
+		Host           string   `toml:"host"`
+		Port           int      `toml:"port"`
+		NoTLS          bool     `toml:"no_tls"`          // Serve plain ws://, e.g. behind a proxy that terminates TLS
+		AllowedOrigins []string `toml:"allowed_origins"` // Browser origins allowed to connect; empty allows any
+		Compression    bool     `toml:"compression"`
+	}
+
+	// MQTTConfig accepts MQTT 3.1.1 clients, which log in with the NATS
+	// token as their password. Port 0 doesn't listen.
+	MQTTConfig struct {
+		Host  string `toml:"host"`
+		Port  int    `toml:"port"`
+		NoTLS bool   `toml:"no_tls"` // Accept plain TCP, e.g. behind a proxy that terminates TLS
+	}
+
+	// BridgeConfig stores messages published to soxdrawer.put.<bucket>.<key>
+	// as objects. No buckets leaves it off.
+	BridgeConfig struct {
+		Buckets []string `toml:"buckets"` // Buckets that accept bridged writes
+	}
+
+	// JetStreamConfig holds JetStream's resource limits. 0 leaves a limit
+	// at the server's default, 75% of memory or of the store_dir disk's free
+	// space.
+	JetStreamConfig struct {
+		MaxMemoryMB int64  `toml:"max_memory_mb"` // Memory storage; nothing built in uses it
+		MaxStoreGB  int64  `toml:"max_store_gb"`  // File storage under store_dir, for objects and everything else
+		Domain      string `toml:"domain"`        // JetStream domain; needed to tell hub and leaf apart
+		Replicas    int    `toml:"replicas"`      // Copies of each bucket and stream, 1 to 5; more than 1 needs a cluster
 	}
 
 	// HTTPConfig holds HTTP server configuration
 	HTTPConfig struct {
-		Address string     `toml:"address"`
-		Auth    AuthConfig `toml:"auth"`
+		Address   string          `toml:"address"`
+		Auth      AuthConfig      `toml:"auth"`
+		TLS       TLSConfig       `toml:"tls"`
+		RateLimit RateLimitConfig `toml:"rate_limit"`
+		CORS      CORSConfig      `toml:"cors"`
+	}
+
+	// CORSConfig lists the other origins allowed to call the API from a
+	// browser, such as internal tools embedding the upload widget
+	CORSConfig struct {
+		AllowedOrigins   []string `toml:"allowed_origins"` // Exact origins or "*"; empty disables CORS
+		AllowedMethods   []string `toml:"allowed_methods"`
+		AllowedHeaders   []string `toml:"allowed_headers"`
+		AllowCredentials bool     `toml:"allow_credentials"` // Send the session cookie; not allowed with "*"
+		MaxAgeSeconds    int      `toml:"max_age_seconds"`   // How long browsers may cache preflight results
+	}
+
+	// RateLimitConfig holds request limits per client IP and per credential.
+	// A per_minute of 0 disables that limit.
+	RateLimitConfig struct {
+		LoginPerMinute    float64       `toml:"login_per_minute"`
+		LoginBurst        int           `toml:"login_burst"`
+		UploadPerMinute   float64       `toml:"upload_per_minute"`
+		UploadBurst       int           `toml:"upload_burst"`
+		DownloadPerMinute float64       `toml:"download_per_minute"`
+		DownloadBurst     int           `toml:"download_burst"`
+		Lockout           LockoutConfig `toml:"lockout"`
+	}
+
+	// LockoutConfig holds the backoff applied after repeated failed logins
+	LockoutConfig struct {
+		MaxFailures int `toml:"max_failures"` // Failures before lockouts start, 0 disables
+		BaseSeconds int `toml:"base_seconds"` // First lockout, doubled for each further failure
+		MaxSeconds  int `toml:"max_seconds"`  // Longest lockout
+	}
+
+	// TLSConfig holds TLS settings for a listener
+	TLSConfig struct {
+		Enabled      bool     `toml:"enabled"`
+		CertFile     string   `toml:"cert_file"`
+		KeyFile      string   `toml:"key_file"`
+		ClientCAFile string   `toml:"client_ca_file"` // Require client certificates signed by this CA (mTLS)
+		MinVersion   string   `toml:"min_version"`    // 1.2 or 1.3
+		SelfSigned   bool     `toml:"self_signed"`    // Generate a CA and certificate on first run if the files are missing
+		Hosts        []string `toml:"hosts"`          // Names and IPs for a generated certificate
 	}
 
 	// AuthConfig holds authentication configuration
 	AuthConfig struct {
-		Token           string `toml:"token"`
-		SessionDuration int    `toml:"session_duration_hours"` // Duration in hours
+		Token              string       `toml:"token" secret:"true"`        // The token, or ${NAME} to read it from the environment
+		TokenFile          string       `toml:"token_file"`                 // Read the token from this file instead
+		TokenLogin         bool         `toml:"token_login"`                // Allow logging in with the token; disable to require SSO
+		SessionDuration    int          `toml:"session_duration_hours"`     // Duration in hours
+		SessionIdleTimeout int          `toml:"session_idle_minutes"`       // Idle timeout in minutes
+		SessionMaxLifetime int          `toml:"session_max_lifetime_hours"` // Absolute cap in hours, even with sliding refresh
+		SlidingRefresh     bool         `toml:"sliding_refresh"`            // Extend sessions that are used near expiry
+		Cookie             CookieConfig `toml:"cookie"`
+		OIDC               OIDCConfig   `toml:"oidc"`
+	}
+
+	// OIDCConfig holds OpenID Connect single sign-on settings
+	OIDCConfig struct {
+		Enabled          bool              `toml:"enabled"`
+		Issuer           string            `toml:"issuer"`
+		ClientID         string            `toml:"client_id"`
+		ClientSecret     string            `toml:"client_secret" secret:"true"`
+		ClientSecretFile string            `toml:"client_secret_file"` // Read the client secret from this file instead
+		RedirectURL      string            `toml:"redirect_url"`       // https://<host>/api/auth/oidc/callback
+		Scopes           []string          `toml:"scopes"`
+		ButtonLabel      string            `toml:"button_label"`
+		UsernameClaim    string            `toml:"username_claim"`
+		RoleClaim        string            `toml:"role_claim"`
+		RoleMappings     map[string]string `toml:"role_mappings"` // Claim value to admin or user
+		DefaultRole      string            `toml:"default_role"`  // Role when no mapping matches; empty denies login
+	}
+
+	// CookieConfig holds session cookie attributes
+	CookieConfig struct {
+		Name     string `toml:"name"`
+		Secure   bool   `toml:"secure"`    // Only send the cookie over HTTPS
+		SameSite string `toml:"same_site"` // strict, lax or none
+		Domain   string `toml:"domain"`
 	}
 )
 
 const (
 	DefaultConfigFile = "soxdrawer.config.toml"
 	ConfigDirPerm     = 0755
-	ConfigFilePerm    = 0600 // Restrict access to config file due to sensitive tokens
+	ConfigFilePerm    = 0600 // Restrict access to the config and secrets files
 )
 
 // DefaultConfig returns a configuration with sensible defaults
 func DefaultConfig() *Config {
 	return &Config{
+		Version: CurrentVersion,
 		NATS: NATSConfig{
 			Host:     "127.0.0.1",
 			Port:     4222,
 			StoreDir: "./jetstream",
 			Token:    "", // Will be generated if empty
+
+			MaxPayloadKB:         1024,
+			WriteDeadlineSeconds: 10,
+			PingIntervalSeconds:  120,
+			JetStream: JetStreamConfig{
+				Replicas: 1,
+			},
+			Cluster: ClusterConfig{
+				Host:   "127.0.0.1",
+				Routes: []string{},
+			},
+			LeafNodes: LeafNodesConfig{
+				Host: "0.0.0.0",
+			},
+			Hub: HubConfig{
+				URLs:                []string{},
+				Domain:              "hub",
+				SyncBuckets:         []string{"default"},
+				SyncIntervalSeconds: 30,
+			},
+			WebSocket: WebSocketConfig{
+				Host:           "0.0.0.0",
+				AllowedOrigins: []string{},
+			},
+			MQTT: MQTTConfig{
+				Host: "0.0.0.0",
+			},
+			Bridge: BridgeConfig{
+				Buckets: []string{},
+			},
+			TLS: TLSConfig{
+				CertFile:   "./tls/nats-cert.pem",
+				KeyFile:    "./tls/nats-key.pem",
+				MinVersion: "1.2",
+			},
 		},
 		HTTP: HTTPConfig{
 			Address: ":8080",
 			Auth: AuthConfig{
-				Token:           "", // Will be generated if empty
-				SessionDuration: 12, // 12 hours default
+				Token:              "", // Will be generated if empty
+				TokenLogin:         true,
+				SessionDuration:    12,  // 12 hours default
+				SessionIdleTimeout: 120, // 2 hours default
+				SessionMaxLifetime: 168, // 7 days default
+				SlidingRefresh:     true,
+				Cookie: CookieConfig{
+					Name:     "soxdrawer_session",
+					Secure:   false, // Enable when served over HTTPS
+					SameSite: "strict",
+				},
+				OIDC: OIDCConfig{
+					Scopes:        []string{"openid", "profile", "email"},
+					ButtonLabel:   "Sign in with SSO",
+					UsernameClaim: "preferred_username",
+					RoleClaim:     "groups",
+				},
+			},
+			TLS: TLSConfig{
+				CertFile:   "./tls/http-cert.pem",
+				KeyFile:    "./tls/http-key.pem",
+				MinVersion: "1.2",
+			},
+			RateLimit: RateLimitConfig{
+				LoginPerMinute:    10,
+				LoginBurst:        5,
+				UploadPerMinute:   120,
+				UploadBurst:       30,
+				DownloadPerMinute: 600,
+				DownloadBurst:     100,
+				Lockout: LockoutConfig{
+					MaxFailures: 5,
+					BaseSeconds: 30,
+					MaxSeconds:  3600,
+				},
 			},
+			CORS: CORSConfig{
+				AllowedOrigins: []string{},
+				AllowedMethods: []string{"GET", "POST", "OPTIONS"},
+				AllowedHeaders: []string{"Content-Type", "Authorization", "X-CSRF-Token"},
+				MaxAgeSeconds:  600,
+			},
+		},
+		Audit: AuditConfig{
+			Enabled:       true,
+			RetentionDays: 365,
+		},
+		Log: LogConfig{
+			Level: "info",
+		},
+		Reload: ReloadConfig{
+			WatchFile:       true,
+			IntervalSeconds: 5,
 		},
 	}
 }
@@ -79,13 +372,14 @@ func LoadConfig(configPath string) (*Config, error) {
 		return config, nil
 	}
 
-	// Load existing config
-	var config Config
-	if _, err := toml.DecodeFile(configPath, &config); err != nil {
+	// Load existing config on top of the defaults so keys missing from
+	// older files keep their default values
+	config := DefaultConfig()
+	if _, err := toml.DecodeFile(configPath, config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
-	return &config, nil
+	return config, nil
 }
 
 // SaveConfig saves configuration to file
@@ -113,7 +407,7 @@ func SaveConfig(config *Config, configPath string) error {
 	if _, err := file.WriteString("# SoxDrawer Configuration\n"); err != nil {
 		return fmt.Errorf("failed to write config header: %w", err)
 	}
-	if _, err := file.WriteString("# This file contains sensitive authentication tokens - keep it secure!\n\n"); err != nil {
+	if _, err := file.WriteString("# Generated tokens are kept in the secrets file, not here\n\n"); err != nil {
 		return fmt.Errorf("failed to write config header: %w", err)
 	}
 
@@ -156,3 +450,15 @@ func generateSecureToken() (string, error) {
 	}
 	return hex.EncodeToString(bytes), nil
 }
+
+// Certs converts the TLS settings into the form used by the certs package
+func (t TLSConfig) Certs() certs.Config {
+	return certs.Config{
+		CertFile:     t.CertFile,
+		KeyFile:      t.KeyFile,
+		ClientCAFile: t.ClientCAFile,
+		MinVersion:   t.MinVersion,
+		SelfSigned:   t.SelfSigned,
+		Hosts:        t.Hosts,
+	}
+}
@@ -0,0 +1,473 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	// EnvPrefix prefixes environment variables that override settings, e.g.
+	// SOXDRAWER_HTTP_ADDRESS for http.address
+	EnvPrefix = "SOXDRAWER_"
+	// EnvConfigFile names the config file when the -config flag is not given
+	EnvConfigFile = EnvPrefix + "CONFIG"
+
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+	// Secrets resolved from another file
+	SourceSecretFile = "secret file" // The setting's _file companion
+	SourceSecrets    = "secrets"     // The secrets file of generated secrets
+
+	redacted = "<redacted>"
+)
+
+// decodeError matches TOML errors about a value that doesn't fit its setting
+var decodeError = regexp.MustCompile(`^toml: (?:line \d+ )?\(last key "(.*)"\): (.*)$`)
+
+type (
+	// Loaded is a configuration assembled from defaults, the config file,
+	// SOXDRAWER_* environment variables and command-line flags, each layer
+	// overriding the ones before it
+	Loaded struct {
+		Config      *Config
+		File        *Config           // Defaults and the config file only; save this, never Config
+		Path        string            // Config file path
+		Exists      bool              // The config file was found
+		FileVersion int               // Schema version the config file was written for
+		SecretsPath string            // Secrets file, where generated secrets are saved
+		Sources     map[string]string // Layer that set each setting, by dotted key
+		PrintConfig bool              // --print-config was given
+		Warnings    []string
+
+		secretFiles map[string]string // File each secret was read from, by key
+		document    *document         // Config file upgraded to CurrentVersion
+	}
+
+	// field is one setting, addressed by its dotted TOML key such as
+	// http.auth.token
+	field struct {
+		key    string
+		value  reflect.Value
+		secret bool // Redacted when printed
+	}
+
+	// flagValue records a setting flag so it can be applied after the lower layers
+	flagValue struct {
+		set   map[string]string
+		field field // Default value of the setting, used to check the flag's value
+	}
+)
+
+// Load builds the configuration from command-line arguments and the
+// environment. The file is -config, else $SOXDRAWER_CONFIG, else
+// soxdrawer.config.toml; a missing file leaves the defaults in place. Map
+// settings such as quotas.users can only be set in the file. Unknown keys
+// and invalid values are returned together as a *ValidationError.
+func Load(args, environ []string) (*Loaded, error) {
+	flags := flag.NewFlagSet("soxdrawer", flag.ContinueOnError)
+	configPath := flags.String("config", "", "Path to the configuration file (default $"+EnvConfigFile+" or "+DefaultConfigFile+")")
+	printConfig := flags.Bool("print-config", false, "Print the effective configuration and where each value came from, then exit")
+
+	set := map[string]string{}
+	for _, f := range fields(DefaultConfig()) {
+		if f.value.Kind() == reflect.Map || f.key == "version" {
+			continue
+		}
+		flags.Var(&flagValue{set: set, field: f}, f.key,
+			fmt.Sprintf("Set %s (env %s)", f.key, EnvName(f.key)))
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: soxdrawer [flags]\n\nSettings are read from defaults, the config file, %s* environment variables and flags, later ones winning.\n\n", EnvPrefix)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	vars, env := map[string]string{}, map[string]string{}
+	for _, entry := range environ {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			continue
+		}
+		vars[name] = value
+		if strings.HasPrefix(name, EnvPrefix) {
+			env[name] = value
+		}
+	}
+
+	loaded := &Loaded{
+		Path:        *configPath,
+		Sources:     map[string]string{},
+		PrintConfig: *printConfig,
+		secretFiles: map[string]string{},
+	}
+	if loaded.Path == "" {
+		loaded.Path = env[EnvConfigFile]
+	}
+	if loaded.Path == "" {
+		loaded.Path = DefaultConfigFile
+	}
+
+	// Defaults and file, rejecting keys that don't match any setting
+	loaded.Config = DefaultConfig()
+	var problems []Problem
+	var meta toml.MetaData
+	var positions map[string]position
+	data, err := os.ReadFile(loaded.Path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	default:
+		loaded.Exists = true
+		doc, versionProblems, err := parseDocument(string(data))
+		var parseErr toml.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, &ValidationError{Problems: []Problem{{
+				Where:   fmt.Sprintf("%s:%d:%d", loaded.Path, parseErr.Position.Line, parseErr.Position.Col),
+				Message: parseErr.Message,
+			}}}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", loaded.Path, err)
+		}
+		if versionProblems != nil {
+			loaded.Sources["version"] = SourceFile
+			versionProblems[0].Where = loaded.where("version", keyPositions(string(data)))
+			return nil, &ValidationError{Problems: versionProblems}
+		}
+
+		// Older files are upgraded in memory; config migrate rewrites them
+		loaded.document, loaded.FileVersion = doc, doc.version
+		if doc.version < CurrentVersion {
+			loaded.Warnings = append(loaded.Warnings, fmt.Sprintf("%s is a version %d config file, upgraded to version %d in memory; run \"soxdrawer config migrate\" to rewrite it", loaded.Path, doc.version, CurrentVersion))
+			loaded.Warnings = append(loaded.Warnings, doc.notes...)
+		}
+
+		meta, err = toml.Decode(doc.text(), loaded.Config)
+		positions = doc.positions()
+		if err != nil {
+			// Type mismatches only name the key they happened at
+			if match := decodeError.FindStringSubmatch(err.Error()); match != nil {
+				return nil, &ValidationError{Problems: []Problem{{
+					Key:     match[1],
+					Where:   loaded.where(match[1], positions),
+					Message: match[2],
+				}}}
+			}
+			return nil, fmt.Errorf("failed to parse config file %s: %w", loaded.Path, err)
+		}
+	}
+
+	// The upper layers replace whole values, so a shallow copy keeps the
+	// file's settings intact
+	file := *loaded.Config
+	loaded.File = &file
+
+	// Environment and flags
+	known := map[string]bool{EnvConfigFile: true}
+	var keys []string
+	for _, f := range fields(loaded.Config) {
+		for prefix := f.key; prefix != ""; prefix, _, _ = cutLast(prefix) {
+			if !slices.Contains(keys, prefix) {
+				keys = append(keys, prefix)
+			}
+		}
+
+		loaded.Sources[f.key] = SourceDefault
+		if meta.IsDefined(strings.Split(f.key, ".")...) {
+			loaded.Sources[f.key] = SourceFile
+		}
+		if f.key == "version" {
+			// Describes the file, so only the file sets it
+			continue
+		}
+
+		name := EnvName(f.key)
+		known[name] = true
+
+		if value, ok := env[name]; ok {
+			if err := setField(f, value); err != nil {
+				problems = append(problems, Problem{Key: f.key, Where: "env " + name, Message: err.Error()})
+			}
+			loaded.Sources[f.key] = SourceEnv
+		}
+		if value, ok := set[f.key]; ok {
+			// Already checked when the flag was parsed
+			setField(f, value)
+			loaded.Sources[f.key] = SourceFlag
+		}
+	}
+
+	var unknown []string
+	for name := range env {
+		if !known[name] {
+			unknown = append(unknown, fmt.Sprintf("ignoring unknown environment variable %s", name))
+		}
+	}
+	sort.Strings(unknown)
+	loaded.Warnings = append(loaded.Warnings, unknown...)
+
+	// Secrets referring to the environment or to files
+	loaded.SecretsPath = secretsPath(loaded.Config, loaded.Path)
+	problems = append(problems, loaded.resolveSecrets(vars)...)
+
+	// Report unknown keys once, at the outermost unknown table
+	undecoded := map[string]bool{}
+	for _, key := range meta.Undecoded() {
+		undecoded[strings.Join(key, ".")] = true
+	}
+	for _, key := range meta.Undecoded() {
+		if len(key) > 1 && undecoded[strings.Join(key[:len(key)-1], ".")] {
+			continue
+		}
+		name := strings.Join(key, ".")
+		message := "unknown key"
+		if guess := suggest(name, keys); guess != "" {
+			message += fmt.Sprintf(" (did you mean %s?)", guess)
+		}
+		problems = append(problems, Problem{Key: name, Message: message})
+	}
+
+	problems = append(problems, loaded.Config.Validate()...)
+	for i := range problems {
+		if problems[i].Where == "" {
+			problems[i].Where = loaded.where(problems[i].Key, positions)
+		}
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+
+	return loaded, nil
+}
+
+// where describes the layer a setting came from, with the file position
+// of the key, or of its closest enclosing table, for file settings
+func (l *Loaded) where(key string, positions map[string]position) string {
+	source := ""
+	for prefix := key; prefix != "" && source == ""; prefix, _, _ = cutLast(prefix) {
+		source = l.Sources[prefix]
+	}
+
+	switch source {
+	case SourceEnv:
+		return "env " + EnvName(key)
+	case SourceFlag:
+		return "flag -" + key
+	case SourceDefault:
+		return "default"
+	case SourceSecretFile:
+		return l.secretFiles[key]
+	case SourceSecrets:
+		return l.SecretsPath
+	}
+
+	// File settings and unknown keys
+	for prefix := strings.Join(splitKey(key), "."); prefix != ""; prefix, _, _ = cutLast(prefix) {
+		if pos, ok := positions[prefix]; ok {
+			return fmt.Sprintf("%s:%d:%d", l.Path, pos.line, pos.col)
+		}
+	}
+	return l.Path
+}
+
+// cutLast splits the last part off a dotted key
+func cutLast(key string) (parent, last string, found bool) {
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		return key[:i], key[i+1:], true
+	}
+	return "", key, false
+}
+
+// EnvName returns the environment variable that overrides a setting
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// fields lists the settings of a configuration, in file order
+func fields(config *Config) []field {
+	var result []field
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		for i := range v.NumField() {
+			structField := v.Type().Field(i)
+			name, _, _ := strings.Cut(structField.Tag.Get("toml"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			key := name
+			if prefix != "" {
+				key = prefix + "." + name
+			}
+
+			if structField.Type.Kind() == reflect.Struct {
+				walk(key, v.Field(i))
+				continue
+			}
+			result = append(result, field{
+				key:    key,
+				value:  v.Field(i),
+				secret: structField.Tag.Get("secret") == "true",
+			})
+		}
+	}
+	walk("", reflect.ValueOf(config).Elem())
+	return result
+}
+
+// setField parses a value from the environment or a flag into a setting.
+// Lists are comma separated.
+func setField(f field, value string) error {
+	v := f.value
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		items := []string{}
+		for item := range strings.SplitSeq(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("%s can only be set in the config file", f.key)
+	}
+	return nil
+}
+
+func (f *flagValue) String() string {
+	return ""
+}
+
+func (f *flagValue) Set(value string) error {
+	if err := setField(f.field, value); err != nil {
+		return err
+	}
+	f.set[f.field.key] = value
+	return nil
+}
+
+// IsBoolFlag lets boolean settings be given as -key without a value
+func (f *flagValue) IsBoolFlag() bool {
+	return f.field.value.Kind() == reflect.Bool
+}
+
+// Print writes the effective configuration as key = value lines, each
+// followed by the layer it came from. Secrets are redacted.
+func (l *Loaded) Print(w io.Writer) {
+	fmt.Fprintf(w, "# Effective soxdrawer configuration (file: %s)\n", l.Path)
+	for _, f := range fields(l.Config) {
+		printValue(w, f.key, f.value, f.secret, l.Origin(f.key))
+	}
+}
+
+// Origin describes where a setting's value came from, such as
+// "env SOXDRAWER_HTTP_ADDRESS" or "file soxdrawer.config.toml"
+func (l *Loaded) Origin(key string) string {
+	source := l.Sources[key]
+	switch source {
+	case SourceEnv:
+		source += " " + EnvName(key)
+	case SourceFile:
+		source += " " + l.Path
+	case SourceSecretFile:
+		source += " " + l.secretFiles[key]
+	case SourceSecrets:
+		source += " " + l.SecretsPath
+	}
+	return source
+}
+
+// printValue writes one setting, expanding maps into a line per entry
+func printValue(w io.Writer, key string, v reflect.Value, secret bool, source string) {
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Len() == 0 {
+			fmt.Fprintf(w, "%s = {} # %s\n", key, source)
+			return
+		}
+		names := make([]string, 0, v.Len())
+		for _, name := range v.MapKeys() {
+			names = append(names, name.String())
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			printValue(w, key+"."+quoteKey(name), v.MapIndex(reflect.ValueOf(name)), secret, source)
+		}
+	case reflect.Struct:
+		for i := range v.NumField() {
+			name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("toml"), ",")
+			printValue(w, key+"."+name, v.Field(i), secret, source)
+		}
+	default:
+		fmt.Fprintf(w, "%s = %s # %s\n", key, formatValue(v, secret), source)
+	}
+}
+
+func formatValue(v reflect.Value, secret bool) string {
+	switch v.Kind() {
+	case reflect.String:
+		if secret && v.String() != "" {
+			return redacted
+		}
+		return strconv.Quote(v.String())
+	case reflect.Slice:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = formatValue(v.Index(i), secret)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+// quoteKey quotes map keys that aren't bare TOML keys
+func quoteKey(key string) string {
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return strconv.Quote(key)
+		}
+	}
+	if key == "" {
+		return `""`
+	}
+	return key
+}
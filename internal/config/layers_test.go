@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a current config file with the given settings to a
+// fresh directory and returns its path
+func writeConfig(t *testing.T, settings string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), DefaultConfigFile)
+	data := fmt.Sprintf("version = %d\n\n%s", CurrentVersion, settings)
+	if err := os.WriteFile(path, []byte(data), ConfigFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLaterLayersWin(t *testing.T) {
+	path := writeConfig(t, "[http]\naddress = \":9000\"\n")
+	env := []string{EnvName("http.address") + "=:9001"}
+
+	for _, test := range []struct {
+		args    []string
+		environ []string
+		address string
+		source  string
+	}{
+		{nil, nil, ":9000", SourceFile},
+		{nil, env, ":9001", SourceEnv},
+		{[]string{"-http.address", ":9002"}, env, ":9002", SourceFlag},
+	} {
+		loaded, err := Load(append([]string{"-config", path}, test.args...), test.environ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.Config.HTTP.Address != test.address || loaded.Sources["http.address"] != test.source {
+			t.Fatalf("address = %s from %s, want %s from %s", loaded.Config.HTTP.Address, loaded.Sources["http.address"], test.address, test.source)
+		}
+		// The file layer alone is what gets saved
+		if loaded.File.HTTP.Address != ":9000" {
+			t.Fatalf("file address = %s, want :9000", loaded.File.HTTP.Address)
+		}
+	}
+}
+
+func TestUnknownKeysAreReported(t *testing.T) {
+	path := writeConfig(t, "[http]\naddres = \":9000\"\n")
+
+	_, err := Load([]string{"-config", path}, []string{EnvPrefix + "HTTP_ADRESS=:9001"})
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Load = %v, want a *ValidationError", err)
+	}
+	if len(invalid.Problems) != 1 || invalid.Problems[0].Key != "http.addres" || !strings.Contains(invalid.Problems[0].Message, "did you mean http.address?") {
+		t.Fatalf("problems = %v, want http.addres with a suggestion", invalid.Problems)
+	}
+}
+
+func TestUnknownEnvironmentVariablesWarn(t *testing.T) {
+	loaded, err := Load([]string{"-config", writeConfig(t, "")}, []string{EnvPrefix + "HTTP_ADRESS=:9001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Warnings) != 1 || !strings.Contains(loaded.Warnings[0], EnvPrefix+"HTTP_ADRESS") {
+		t.Fatalf("warnings = %v, want one about %sHTTP_ADRESS", loaded.Warnings, EnvPrefix)
+	}
+}
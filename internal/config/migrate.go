@@ -0,0 +1,253 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// CurrentVersion is the config file schema this build reads and writes.
+// Files without a version are version 1.
+const CurrentVersion = 2
+
+type (
+	// migration upgrades a config file from the version before to version
+	migration struct {
+		version int
+		migrate func(d *document)
+	}
+
+	// document is a config file being upgraded. Edits are made to its lines
+	// so comments and layout survive a rewrite; when an edit can't be made
+	// that way the file is written out again from tree instead.
+	document struct {
+		lines    []string
+		origin   []int          // Line of each line in the original file, 0 for added lines
+		tree     map[string]any // Parsed file, kept in step with lines
+		version  int            // Version of the original file
+		notes    []string       // Deprecated settings the migrations changed
+		secrets  map[string]string
+		reencode bool // lines no longer match tree
+	}
+)
+
+// migrations upgrade config files one version at a time, in order
+var migrations = []migration{
+	{
+		// Secrets were kept in the config file, which older versions also
+		// wrote generated tokens to. They now belong in the secrets file.
+		version: 2,
+		migrate: func(d *document) {
+			for _, f := range fields(DefaultConfig()) {
+				value, ok := d.lookup(f.key).(string)
+				if !f.secret || !ok || value == "" || envReference.MatchString(value) {
+					continue
+				}
+				d.remove(f.key)
+				d.secrets[f.key] = value
+				d.notes = append(d.notes, fmt.Sprintf("%s: plaintext secrets in the config file are deprecated; it moves to the secrets file", f.key))
+			}
+		},
+	},
+}
+
+// parseDocument reads a config file and upgrades it to CurrentVersion in
+// memory
+func parseDocument(data string) (*document, []Problem, error) {
+	d := &document{
+		lines:   strings.Split(data, "\n"),
+		version: 1,
+		secrets: map[string]string{},
+	}
+	for i := range d.lines {
+		d.origin = append(d.origin, i+1)
+	}
+	if _, err := toml.Decode(data, &d.tree); err != nil {
+		return nil, nil, err
+	}
+
+	if value, ok := d.tree["version"]; ok {
+		version, ok := value.(int64)
+		problem := Problem{Key: "version"}
+		switch {
+		case !ok || version < 1:
+			problem.Message = fmt.Sprintf("must be a whole number from 1 to %d", CurrentVersion)
+		case version > CurrentVersion:
+			problem.Message = fmt.Sprintf("version %d is newer than this soxdrawer understands (%d); upgrade soxdrawer", version, CurrentVersion)
+		default:
+			d.version = int(version)
+		}
+		if problem.Message != "" {
+			return nil, []Problem{problem}, nil
+		}
+	}
+
+	for _, m := range migrations {
+		if m.version > d.version {
+			m.migrate(d)
+		}
+	}
+	if d.version < CurrentVersion {
+		d.setVersion(CurrentVersion)
+	}
+	return d, nil, nil
+}
+
+// lookup returns the value of a dotted key, or nil
+func (d *document) lookup(key string) any {
+	var value any = d.tree
+	for _, part := range strings.Split(key, ".") {
+		table, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = table[part]
+	}
+	return value
+}
+
+// remove deletes a setting and the line it is on
+func (d *document) remove(key string) {
+	parent, name, _ := cutLast(key)
+	table := d.tree
+	if parent != "" {
+		table, _ = d.lookup(parent).(map[string]any)
+	}
+	delete(table, name)
+
+	if pos, ok := keyPositions(d.text())[key]; ok && !d.reencode {
+		d.lines = slices.Delete(d.lines, pos.line-1, pos.line)
+		d.origin = slices.Delete(d.origin, pos.line-1, pos.line)
+	}
+	d.check()
+}
+
+// setVersion sets the version, adding it before the first setting or table
+// if the file has none
+func (d *document) setVersion(version int) {
+	d.tree["version"] = int64(version)
+	line := fmt.Sprintf("version = %d", version)
+
+	if pos, ok := keyPositions(d.text())["version"]; ok {
+		d.lines[pos.line-1] = line
+		d.origin[pos.line-1] = 0
+	} else {
+		at := 0
+		for at < len(d.lines) {
+			trimmed := strings.TrimSpace(d.lines[at])
+			if trimmed != "" && trimmed[0] != '#' {
+				break
+			}
+			at++
+		}
+		d.lines = slices.Insert(d.lines, at, line, "")
+		d.origin = slices.Insert(d.origin, at, 0, 0)
+	}
+	d.check()
+}
+
+// check falls back to writing the file from tree if the line edits didn't
+// produce the same settings
+func (d *document) check() {
+	if d.reencode {
+		return
+	}
+	var decoded map[string]any
+	if _, err := toml.Decode(d.text(), &decoded); err != nil || !reflect.DeepEqual(decoded, d.tree) {
+		d.reencode = true
+	}
+}
+
+// text returns the upgraded file
+func (d *document) text() string {
+	if !d.reencode {
+		return strings.Join(d.lines, "\n")
+	}
+	var buf bytes.Buffer
+	buf.WriteString("# SoxDrawer Configuration\n\n")
+	toml.NewEncoder(&buf).Encode(d.tree)
+	return buf.String()
+}
+
+// positions finds keys in the upgraded file and returns where they are in
+// the original file
+func (d *document) positions() map[string]position {
+	if d.reencode {
+		return nil
+	}
+	positions := map[string]position{}
+	for key, pos := range keyPositions(d.text()) {
+		if line := d.origin[pos.line-1]; line > 0 {
+			positions[key] = position{line, pos.col}
+		}
+	}
+	return positions
+}
+
+// Rewrite saves a config file upgraded from an older version, keeping the
+// original next to it as <file>.v<version>.bak. Secrets taken out of the
+// file go to the secrets file. It returns the backup's path, or "" if the
+// file was already current.
+func (l *Loaded) Rewrite() (string, error) {
+	if l.document == nil || l.FileVersion == CurrentVersion {
+		return "", nil
+	}
+
+	info, err := os.Stat(l.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read config file: %w", err)
+	}
+	original, err := os.ReadFile(l.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read config file: %w", err)
+	}
+	backup := fmt.Sprintf("%s.v%d.bak", l.Path, l.FileVersion)
+	if err := writeFile(backup, original, ConfigFilePerm); err != nil {
+		return "", fmt.Errorf("failed to back up config file: %w", err)
+	}
+
+	if len(l.document.secrets) > 0 {
+		if err := l.SaveSecrets(l.document.secrets); err != nil {
+			return backup, err
+		}
+	}
+	if err := writeFile(l.Path, []byte(l.document.text()), info.Mode().Perm()); err != nil {
+		return backup, fmt.Errorf("failed to save config file: %w", err)
+	}
+
+	l.FileVersion = CurrentVersion
+	return backup, nil
+}
+
+// writeFile replaces a file by writing a new one and moving it into place,
+// so readers never see half of it
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	file, err := os.CreateTemp(dir, "."+name+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const fixtureNATSToken = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// copyFixture copies a file from testdata into a temporary directory, so
+// migrating it leaves the fixture alone
+func copyFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), DefaultConfigFile)
+	if err := os.WriteFile(path, data, ConfigFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadFixture(t *testing.T, path string) *Loaded {
+	t.Helper()
+	loaded, err := Load([]string{"-config", path}, []string{"FIXTURE_HTTP_TOKEN=" + strings.Repeat("f", MinTokenLength)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loaded
+}
+
+func TestVersion1IsUpgradedInMemory(t *testing.T) {
+	path := copyFixture(t, "v1.toml")
+	original, _ := os.ReadFile(path)
+
+	loaded := loadFixture(t, path)
+	if loaded.FileVersion != 1 {
+		t.Fatalf("FileVersion = %d, want 1", loaded.FileVersion)
+	}
+	if loaded.Config.NATS.Token != fixtureNATSToken || loaded.Config.NATS.Port != 4333 || loaded.Config.Log.Level != "debug" {
+		t.Fatalf("upgraded config = %+v", loaded.Config.NATS)
+	}
+	warnings := strings.Join(loaded.Warnings, "\n")
+	if !strings.Contains(warnings, "version 1 config file") || !strings.Contains(warnings, "nats.token: plaintext secrets") {
+		t.Fatalf("warnings = %v", loaded.Warnings)
+	}
+	if strings.Contains(warnings, "http.auth.token") {
+		t.Fatalf("environment reference reported as a plaintext secret: %v", loaded.Warnings)
+	}
+	// Problems still point at the original file's lines
+	if where := loaded.where("log.level", loaded.document.positions()); where != path+":17:1" {
+		t.Fatalf("log.level is at %s, want %s:17:1", where, path)
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != string(original) {
+		t.Fatal("loading rewrote the file")
+	}
+}
+
+func TestRewriteUpgradesVersion1(t *testing.T) {
+	path := copyFixture(t, "v1.toml")
+	original, _ := os.ReadFile(path)
+
+	backup, err := loadFixture(t, path).Rewrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup != path+".v1.bak" {
+		t.Fatalf("backup = %s, want %s.v1.bak", backup, path)
+	}
+	if data, err := os.ReadFile(backup); err != nil || string(data) != string(original) {
+		t.Fatalf("backup differs from the original (%v)", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewritten := string(data)
+	if strings.Contains(rewritten, fixtureNATSToken) {
+		t.Fatal("plaintext token left in the config file")
+	}
+	// Everything else is kept as it was, comments included
+	want := strings.Replace(string(original), "# Kept by hand; these comments must survive a migration.\n",
+		"# Kept by hand; these comments must survive a migration.\n\nversion = 2\n", 1)
+	want = strings.Replace(want, "token = \""+fixtureNATSToken+"\"\n", "", 1)
+	if rewritten != want {
+		t.Fatalf("rewritten file:\n%s\nwant:\n%s", rewritten, want)
+	}
+
+	loaded := loadFixture(t, path)
+	if loaded.FileVersion != CurrentVersion || len(loaded.Warnings) > 0 {
+		t.Fatalf("after rewrite: version %d, warnings %v", loaded.FileVersion, loaded.Warnings)
+	}
+	if loaded.Config.NATS.Token != fixtureNATSToken || loaded.Sources["nats.token"] != SourceSecrets {
+		t.Fatalf("nats.token = %q from %s, want it from the secrets file", loaded.Config.NATS.Token, loaded.Sources["nats.token"])
+	}
+
+	// A current file is left alone
+	if backup, err := loaded.Rewrite(); err != nil || backup != "" {
+		t.Fatalf("Rewrite of a current file = %q, %v", backup, err)
+	}
+}
+
+func TestNewerVersionsAreRefused(t *testing.T) {
+	path := copyFixture(t, "v3.toml")
+	_, err := Load([]string{"-config", path}, nil)
+
+	var validation *ValidationError
+	if !errors.As(err, &validation) || len(validation.Problems) != 1 {
+		t.Fatalf("Load = %v, want one problem", err)
+	}
+	problem := validation.Problems[0]
+	if problem.Key != "version" || problem.Where != path+":1:1" || !strings.Contains(problem.Message, "upgrade soxdrawer") {
+		t.Fatalf("problem = %v", problem)
+	}
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"time"
+)
+
+type (
+	// fileState identifies a version of a file; the zero value means missing
+	fileState struct {
+		modTime time.Time
+		size    int64
+	}
+)
+
+// Changed returns the keys of the settings that differ between two
+// configurations. Maps such as quotas.users are compared as a whole.
+func Changed(old, new *Config) []string {
+	oldFields, newFields := fields(old), fields(new)
+
+	var changed []string
+	for i, f := range oldFields {
+		if !reflect.DeepEqual(f.value.Interface(), newFields[i].value.Interface()) {
+			changed = append(changed, f.key)
+		}
+	}
+	return changed
+}
+
+// Watch polls the config file until ctx is done and calls changed when its
+// modification time or size changes
+func Watch(ctx context.Context, path string, interval time.Duration, changed func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := stat(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := stat(path)
+			if current == last {
+				continue
+			}
+			last = current
+			changed()
+		}
+	}
+}
+
+func stat(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{info.ModTime(), info.Size()}
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestChangedListsDifferingSettings(t *testing.T) {
+	path := writeConfig(t, "[log]\nlevel = \"info\"\n")
+	before, err := Load([]string{"-config", path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed := Changed(before.Config, before.Config); len(changed) > 0 {
+		t.Fatalf("Changed(config, config) = %v", changed)
+	}
+
+	settings := "[nats]\nport = 4333\n\n[log]\nlevel = \"debug\"\n\n[quotas.users.alice]\nmax_objects = 10\n"
+	if err := os.WriteFile(path, []byte(fmt.Sprintf("version = %d\n\n%s", CurrentVersion, settings)), ConfigFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	after, err := Load([]string{"-config", path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Maps change as a whole
+	changed := Changed(before.Config, after.Config)
+	slices.Sort(changed)
+	if want := []string{"log.level", "nats.port", "quotas.users"}; !slices.Equal(changed, want) {
+		t.Fatalf("Changed = %v, want %v", changed, want)
+	}
+}
+
+func TestWatchNoticesFileChanges(t *testing.T) {
+	path := writeConfig(t, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan struct{}, 10)
+	done := make(chan struct{})
+	go func() {
+		Watch(ctx, path, 10*time.Millisecond, func() { changes <- struct{}{} })
+		close(done)
+	}()
+
+	expect := func(what string) {
+		t.Helper()
+		select {
+		case <-changes:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no change noticed after %s", what)
+		}
+	}
+
+	// Give Watch time to take its first look
+	time.Sleep(50 * time.Millisecond)
+	if err := os.WriteFile(path, []byte(fmt.Sprintf("version = %d\n\n[log]\nlevel = \"debug\"\n", CurrentVersion)), ConfigFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	expect("editing the file")
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	expect("removing the file")
+
+	select {
+	case <-changes:
+		t.Fatal("change reported with nothing changed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Watch kept running after its context was done")
+	}
+}
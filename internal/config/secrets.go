@@ -0,0 +1,204 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// SecretsFileName is the file generated secrets are saved to, next to the
+// config file, unless secrets_file names another
+const SecretsFileName = "soxdrawer.secrets.toml"
+
+// envReference matches a secret given as ${NAME}, read from the environment
+var envReference = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+// secretsPath returns the secrets file of a configuration loaded from
+// configPath
+func secretsPath(config *Config, configPath string) string {
+	if config.SecretsFile != "" {
+		return config.SecretsFile
+	}
+	return filepath.Join(filepath.Dir(configPath), SecretsFileName)
+}
+
+// resolveSecrets fills in each secret setting from what it refers to: the
+// environment variable named by a ${NAME} value, else the file named by
+// its _file companion, else the secrets file. Values are never written
+// back to the config file.
+func (l *Loaded) resolveSecrets(vars map[string]string) []Problem {
+	saved, err := readSecrets(l.SecretsPath)
+	if err != nil {
+		return []Problem{{Where: l.SecretsPath, Message: err.Error()}}
+	}
+
+	all := fields(l.Config)
+	byKey := map[string]field{}
+	for _, f := range all {
+		byKey[f.key] = f
+	}
+
+	var problems []Problem
+	for _, f := range all {
+		if !f.secret {
+			continue
+		}
+		fileKey := f.key + "_file"
+		value, file := f.value.String(), ""
+		if fileField, ok := byKey[fileKey]; ok {
+			file = fileField.value.String()
+		}
+		stored, inSecrets := saved[f.key]
+		delete(saved, f.key)
+		source := SourceSecrets
+		if l.document != nil {
+			// Taken out of an older config file, which still has the say
+			if secret, ok := l.document.secrets[f.key]; ok {
+				stored, inSecrets, source = secret, true, SourceFile
+			}
+		}
+
+		switch {
+		case value != "" && file != "":
+			_, name, _ := cutLast(f.key)
+			_, fileName, _ := cutLast(fileKey)
+			problems = append(problems, Problem{Key: f.key, Message: fmt.Sprintf("set either %s or %s, not both", name, fileName)})
+		case value != "":
+			match := envReference.FindStringSubmatch(value)
+			if match == nil {
+				if l.Sources[f.key] == SourceFile {
+					l.Warnings = append(l.Warnings, fmt.Sprintf("%s is stored in plaintext in %s; consider %s, a ${NAME} reference or leaving it to the secrets file", f.key, l.Path, fileKey))
+				}
+				continue
+			}
+			secret := vars[match[1]]
+			if secret == "" {
+				problems = append(problems, Problem{Key: f.key, Message: fmt.Sprintf("environment variable %s is not set", match[1])})
+			}
+			f.value.SetString(secret)
+		case file != "":
+			data, err := os.ReadFile(file)
+			secret := strings.TrimRight(string(data), "\r\n")
+			switch {
+			case err != nil:
+				problems = append(problems, Problem{Key: fileKey, Message: err.Error()})
+			case secret == "":
+				problems = append(problems, Problem{Key: fileKey, Message: fmt.Sprintf("%s is empty", file)})
+			}
+			f.value.SetString(secret)
+			l.Sources[f.key] = SourceSecretFile
+			l.secretFiles[f.key] = file
+		case inSecrets:
+			f.value.SetString(stored)
+			l.Sources[f.key] = source
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(saved)) {
+		problems = append(problems, Problem{Key: key, Where: l.SecretsPath, Message: "not a secret setting"})
+	}
+	return problems
+}
+
+// SaveSecrets adds generated secrets, by dotted key, to the secrets file,
+// keeping the ones already there. The file is only readable by its owner.
+func (l *Loaded) SaveSecrets(secrets map[string]string) error {
+	saved, err := readSecrets(l.SecretsPath)
+	if err != nil {
+		return err
+	}
+	maps.Copy(saved, secrets)
+
+	// Nest the dotted keys into tables
+	tree := map[string]any{}
+	for key, value := range saved {
+		table := tree
+		parts := strings.Split(key, ".")
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := table[part].(map[string]any)
+			if !ok {
+				next = map[string]any{}
+				table[part] = next
+			}
+			table = next
+		}
+		table[parts[len(parts)-1]] = value
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.SecretsPath), ConfigDirPerm); err != nil {
+		return fmt.Errorf("failed to create secrets directory: %w", err)
+	}
+	var buf bytes.Buffer
+	buf.WriteString("# SoxDrawer generated secrets - keep this file private\n\n")
+	if err := toml.NewEncoder(&buf).Encode(tree); err != nil {
+		return fmt.Errorf("failed to encode secrets to TOML: %w", err)
+	}
+	if err := writeFile(l.SecretsPath, buf.Bytes(), ConfigFilePerm); err != nil {
+		return fmt.Errorf("failed to save secrets file: %w", err)
+	}
+
+	for key, value := range secrets {
+		if f, ok := findField(l.Config, key); ok {
+			f.value.SetString(value)
+			l.Sources[key] = SourceSecrets
+		}
+	}
+	return nil
+}
+
+// readSecrets reads the secrets file as dotted keys; a missing file has none
+func readSecrets(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets file: %w", err)
+	}
+
+	var tree map[string]any
+	if _, err := toml.Decode(string(data), &tree); err != nil {
+		return nil, fmt.Errorf("failed to parse secrets file: %w", err)
+	}
+
+	secrets := map[string]string{}
+	var flatten func(prefix string, table map[string]any) error
+	flatten = func(prefix string, table map[string]any) error {
+		for name, value := range table {
+			key := prefix + name
+			switch value := value.(type) {
+			case map[string]any:
+				if err := flatten(key+".", value); err != nil {
+					return err
+				}
+			case string:
+				secrets[key] = value
+			default:
+				return fmt.Errorf("secrets file: %s is not a string", key)
+			}
+		}
+		return nil
+	}
+	if err := flatten("", tree); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// findField returns the setting with a dotted key
+func findField(config *Config, key string) (field, bool) {
+	for _, f := range fields(config) {
+		if f.key == key {
+			return f, true
+		}
+	}
+	return field{}, false
+}
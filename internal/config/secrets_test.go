@@ -0,0 +1,65 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSecret = "secret-0123456789abcdef0123456789abcdef"
+
+func TestSecretsResolveFromTheirReferences(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "http-token")
+	if err := os.WriteFile(tokenFile, []byte(testSecret+"-file\n"), ConfigFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	path := writeConfig(t, "[nats]\ntoken = \"${NATS_TOKEN}\"\n\n[http.auth]\ntoken_file = \""+tokenFile+"\"\n")
+
+	loaded, err := Load([]string{"-config", path}, []string{"NATS_TOKEN=" + testSecret + "-env"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Config.NATS.Token != testSecret+"-env" {
+		t.Fatalf("nats.token = %q, want the environment's", loaded.Config.NATS.Token)
+	}
+	if loaded.Config.HTTP.Auth.Token != testSecret+"-file" || loaded.Sources["http.auth.token"] != SourceSecretFile {
+		t.Fatalf("http.auth.token = %q from %s, want the file's", loaded.Config.HTTP.Auth.Token, loaded.Sources["http.auth.token"])
+	}
+	// Only the reference is kept for saving
+	if loaded.File.NATS.Token != "${NATS_TOKEN}" {
+		t.Fatalf("file nats.token = %q, want the reference", loaded.File.NATS.Token)
+	}
+
+	if _, err := Load([]string{"-config", path}, nil); err == nil || !strings.Contains(err.Error(), "NATS_TOKEN is not set") {
+		t.Fatalf("Load without NATS_TOKEN = %v, want it reported", err)
+	}
+}
+
+func TestGeneratedSecretsAreSavedApart(t *testing.T) {
+	path := writeConfig(t, "")
+	loaded, err := Load([]string{"-config", path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.SaveSecrets(map[string]string{"nats.token": testSecret}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(loaded.SecretsPath); err != nil || info.Mode().Perm() != ConfigFilePerm {
+		t.Fatalf("secrets file = %v, %v; want mode %v", info, err, ConfigFilePerm)
+	}
+	if data, _ := os.ReadFile(path); strings.Contains(string(data), testSecret) {
+		t.Fatal("secret written to the config file")
+	}
+
+	loaded, err = Load([]string{"-config", path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Config.NATS.Token != testSecret || loaded.Sources["nats.token"] != SourceSecrets {
+		t.Fatalf("nats.token = %q from %s, want it from the secrets file", loaded.Config.NATS.Token, loaded.Sources["nats.token"])
+	}
+	if loaded.File.NATS.Token != "" {
+		t.Fatal("secret from the secrets file would be saved to the config file")
+	}
+}
@@ -0,0 +1,624 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nkeys"
+
+	"soxdrawer/internal/certs"
+)
+
+// MinTokenLength is the shortest NATS or HTTP token accepted. Generated
+// tokens are 64 hex characters.
+const MinTokenLength = 32
+
+// The NATS payload limit has to fit an object store chunk of 128KB plus
+// headers, and the server refuses limits above its 64MB pending buffer
+const (
+	MinMaxPayloadKB = 256
+	MaxMaxPayloadKB = 64 << 10
+)
+
+type (
+	// Problem is one invalid setting
+	Problem struct {
+		Key     string // Dotted key, e.g. nats.port
+		Where   string // file:line:col, env VAR, flag -key or default
+		Message string
+	}
+
+	// ValidationError lists every problem found in a configuration
+	ValidationError struct {
+		Problems []Problem
+	}
+
+	// validator collects problems while checking a configuration
+	validator struct {
+		problems []Problem
+	}
+
+	// position is a line and column in the config file, starting at 1
+	position struct {
+		line, col int
+	}
+)
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems)+1)
+	lines = append(lines, fmt.Sprintf("invalid configuration (%d problems):", len(e.Problems)))
+	if len(e.Problems) == 1 {
+		lines[0] = "invalid configuration:"
+	}
+	for _, problem := range e.Problems {
+		lines = append(lines, "  "+problem.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (p Problem) String() string {
+	var parts []string
+	if p.Where != "" {
+		parts = append(parts, p.Where)
+	}
+	if p.Key != "" {
+		parts = append(parts, p.Key)
+	}
+	return strings.Join(append(parts, p.Message), ": ")
+}
+
+func (v *validator) add(key, format string, args ...any) {
+	v.problems = append(v.problems, Problem{Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks that settings make sense together and that the files and
+// directories they name are usable. It returns every problem found rather
+// than stopping at the first.
+func (c *Config) Validate() []Problem {
+	v := &validator{}
+
+	// NATS
+	if c.NATS.Host == "" {
+		v.add("nats.host", "must not be empty")
+	}
+	v.port("nats.port", c.NATS.Port)
+	if c.NATS.StoreDir == "" {
+		v.add("nats.store_dir", "must not be empty")
+	} else if err := checkWritableDir(c.NATS.StoreDir); err != nil {
+		v.add("nats.store_dir", "%v", err)
+	}
+	v.token("nats.token", c.NATS.Token)
+	v.name("nats.server_name", c.NATS.ServerName)
+	if c.NATS.MaxPayloadKB < MinMaxPayloadKB || c.NATS.MaxPayloadKB > MaxMaxPayloadKB {
+		v.add("nats.max_payload_kb", "must be between %d, to fit an object chunk, and %d", MinMaxPayloadKB, MaxMaxPayloadKB)
+	}
+	if c.NATS.WriteDeadlineSeconds < 1 {
+		v.add("nats.write_deadline_seconds", "must be at least 1")
+	}
+	if c.NATS.MaxConnections < 0 {
+		v.add("nats.max_connections", "must not be negative; use 0 for the server's default")
+	}
+	if c.NATS.PingIntervalSeconds < 1 {
+		v.add("nats.ping_interval_seconds", "must be at least 1")
+	}
+	if c.NATS.JetStream.MaxMemoryMB < 0 {
+		v.add("nats.jetstream.max_memory_mb", "must not be negative; use 0 for the server's default")
+	}
+	if c.NATS.JetStream.MaxStoreGB < 0 {
+		v.add("nats.jetstream.max_store_gb", "must not be negative; use 0 for the server's default")
+	}
+	v.name("nats.jetstream.domain", c.NATS.JetStream.Domain)
+	if cluster := c.NATS.Cluster; cluster.Port != 0 {
+		v.port("nats.cluster.port", cluster.Port)
+		if cluster.Port == c.NATS.Port {
+			v.add("nats.cluster.port", "must differ from nats.port")
+		}
+		if cluster.Host == "" {
+			v.add("nats.cluster.host", "must not be empty")
+		}
+		if cluster.Name == "" {
+			v.add("nats.cluster.name", "must be set, the same on every node")
+		}
+		v.name("nats.cluster.name", cluster.Name)
+		if c.NATS.ServerName == "" {
+			v.add("nats.server_name", "must be set, unique to each node, when clustering")
+		}
+		if len(cluster.Routes) == 0 {
+			v.add("nats.cluster.routes", "must list the other nodes")
+		}
+		for _, route := range cluster.Routes {
+			if u, err := url.Parse(route); err != nil || (u.Scheme != "nats-route" && u.Scheme != "nats") || u.Port() == "" || u.User != nil {
+				v.add("nats.cluster.routes", "invalid route %q: expected nats-route://host:port; the token is added for you", route)
+			}
+		}
+		if cluster.Token == "" {
+			v.add("nats.cluster.token", "must be set, the same on every node, so only soxdrawer nodes can join")
+		}
+		v.token("nats.cluster.token", cluster.Token)
+	}
+	if leaf := c.NATS.LeafNodes; leaf.Port != 0 {
+		v.port("nats.leafnodes.port", leaf.Port)
+		if leaf.Port == c.NATS.Port || leaf.Port == c.NATS.Cluster.Port {
+			v.add("nats.leafnodes.port", "must differ from nats.port and nats.cluster.port")
+		}
+		if leaf.Host == "" {
+			v.add("nats.leafnodes.host", "must not be empty")
+		}
+		if leaf.Token == "" {
+			v.add("nats.leafnodes.token", "must be set so only soxdrawer leaf nodes can connect")
+		}
+		v.token("nats.leafnodes.token", leaf.Token)
+		if c.NATS.JetStream.Domain == "" {
+			v.add("nats.jetstream.domain", "must be set when accepting leaf nodes, which reach this server's buckets through it")
+		}
+	}
+	if hub := c.NATS.Hub; len(hub.URLs) > 0 {
+		for _, remote := range hub.URLs {
+			if u, err := url.Parse(remote); err != nil || (u.Scheme != "nats-leaf" && u.Scheme != "nats") || u.Port() == "" || u.User != nil {
+				v.add("nats.hub.urls", "invalid URL %q: expected nats-leaf://host:port; the token is added for you", remote)
+			}
+		}
+		if hub.Token == "" {
+			v.add("nats.hub.token", "must be set to the hub's nats.leafnodes.token")
+		}
+		v.token("nats.hub.token", hub.Token)
+		if hub.Domain == "" {
+			v.add("nats.hub.domain", "must be set to the hub's nats.jetstream.domain")
+		}
+		v.name("nats.hub.domain", hub.Domain)
+		for _, bucket := range hub.SyncBuckets {
+			v.bucket("nats.hub.sync_buckets", bucket)
+		}
+		if hub.SyncIntervalSeconds < 5 {
+			v.add("nats.hub.sync_interval_seconds", "must be at least 5")
+		}
+	}
+	if replicas := c.NATS.JetStream.Replicas; replicas < 1 || replicas > 5 {
+		v.add("nats.jetstream.replicas", "must be between 1 and 5")
+	} else if replicas > 1 && c.NATS.Cluster.Port == 0 {
+		v.add("nats.jetstream.replicas", "is %d, which needs a cluster; set nats.cluster.port", replicas)
+	} else if nodes := c.NATS.Cluster.nodes(); replicas > nodes && c.NATS.Cluster.Port != 0 {
+		v.add("nats.jetstream.replicas", "is %d, more than the %d nodes in the cluster", replicas, nodes)
+	}
+	v.tls("nats.tls", c.NATS.TLS)
+	listeners := []int{c.NATS.Port, c.NATS.Cluster.Port, c.NATS.LeafNodes.Port}
+	if ws := c.NATS.WebSocket; ws.Port != 0 {
+		v.listener("nats.websocket", ws.Host, ws.Port, ws.NoTLS, c.NATS.TLS.Enabled, listeners)
+		listeners = append(listeners, ws.Port)
+		for _, origin := range ws.AllowedOrigins {
+			if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+				v.add("nats.websocket.allowed_origins", "invalid origin %q: expected scheme://host[:port]", origin)
+			}
+		}
+	}
+	if mqtt := c.NATS.MQTT; mqtt.Port != 0 {
+		v.listener("nats.mqtt", mqtt.Host, mqtt.Port, mqtt.NoTLS, c.NATS.TLS.Enabled, listeners)
+	}
+	for _, bucket := range c.NATS.Bridge.Buckets {
+		v.bucket("nats.bridge.buckets", bucket)
+	}
+	nkeyNames := map[string]string{}
+	for _, name := range slices.Sorted(maps.Keys(c.NATS.Clients)) {
+		client, key := c.NATS.Clients[name], "nats.clients."+quoteKey(name)
+		if !plainName(name) {
+			v.add(key, "invalid client name %q: use letters, digits, '_' and '-'", name)
+		}
+		if !nkeys.IsValidPublicUserKey(client.NKey) {
+			v.add(key+".nkey", "must be a public user nkey, starting with U")
+		} else if other, ok := nkeyNames[client.NKey]; ok {
+			v.add(key+".nkey", "is also used by client %q", other)
+		}
+		nkeyNames[client.NKey] = name
+		if !slices.Contains([]string{"read", "write", "admin"}, client.Access) {
+			v.add(key+".access", "invalid access %q: expected read, write or admin", client.Access)
+		}
+		if len(client.Buckets) == 0 && client.Access != "admin" {
+			v.add(key+".buckets", "must list the buckets a %s client may use", client.Access)
+		}
+		for _, bucket := range client.Buckets {
+			v.bucket(key+".buckets", bucket)
+			if client.Access == "write" && !slices.Contains(c.NATS.Bridge.Buckets, bucket) {
+				v.add(key+".buckets", "write client bucket %q must be listed in nats.bridge.buckets, since its writes go through the bridge", bucket)
+			}
+		}
+	}
+
+	// HTTP
+	if _, port, err := net.SplitHostPort(c.HTTP.Address); err != nil {
+		v.add("http.address", "invalid address %q: expected host:port or :port", c.HTTP.Address)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		v.add("http.address", "invalid port %q: must be between 1 and 65535", port)
+	}
+	v.tls("http.tls", c.HTTP.TLS)
+
+	auth := c.HTTP.Auth
+	v.token("http.auth.token", auth.Token)
+	if auth.SessionDuration < 1 {
+		v.add("http.auth.session_duration_hours", "must be at least 1")
+	}
+	if auth.SessionIdleTimeout < 0 {
+		v.add("http.auth.session_idle_minutes", "must not be negative; use 0 to disable the idle timeout")
+	}
+	if auth.SessionMaxLifetime < 0 {
+		v.add("http.auth.session_max_lifetime_hours", "must not be negative; use 0 for no cap")
+	} else if auth.SessionMaxLifetime > 0 && auth.SessionMaxLifetime < auth.SessionDuration {
+		v.add("http.auth.session_max_lifetime_hours", "is %d hours, shorter than session_duration_hours (%d)", auth.SessionMaxLifetime, auth.SessionDuration)
+	}
+	if auth.Cookie.Name == "" {
+		v.add("http.auth.cookie.name", "must not be empty")
+	}
+	if !slices.Contains([]string{"", "strict", "lax", "none"}, strings.ToLower(auth.Cookie.SameSite)) {
+		v.add("http.auth.cookie.same_site", "invalid value %q: expected strict, lax or none", auth.Cookie.SameSite)
+	}
+	if !auth.TokenLogin && !auth.OIDC.Enabled {
+		v.add("http.auth.token_login", "token login is disabled but OIDC is not enabled; nobody could log in")
+	}
+	if oidc := auth.OIDC; oidc.Enabled {
+		if u, err := url.Parse(oidc.Issuer); oidc.Issuer == "" || err != nil || u.Scheme == "" || u.Host == "" {
+			v.add("http.auth.oidc.issuer", "must be the issuer's URL, e.g. https://idp.example.com/realms/main")
+		}
+		if oidc.ClientID == "" {
+			v.add("http.auth.oidc.client_id", "must not be empty when OIDC is enabled")
+		}
+		if u, err := url.Parse(oidc.RedirectURL); oidc.RedirectURL == "" || err != nil || u.Scheme == "" || u.Host == "" {
+			v.add("http.auth.oidc.redirect_url", "must be an absolute URL ending in /api/auth/oidc/callback")
+		}
+		for claim, role := range oidc.RoleMappings {
+			v.role("http.auth.oidc.role_mappings."+quoteKey(claim), role, false)
+		}
+		v.role("http.auth.oidc.default_role", oidc.DefaultRole, true)
+	}
+
+	limits := c.HTTP.RateLimit
+	v.rate("http.rate_limit.login", limits.LoginPerMinute, limits.LoginBurst)
+	v.rate("http.rate_limit.upload", limits.UploadPerMinute, limits.UploadBurst)
+	v.rate("http.rate_limit.download", limits.DownloadPerMinute, limits.DownloadBurst)
+	if limits.Lockout.MaxFailures < 0 {
+		v.add("http.rate_limit.lockout.max_failures", "must not be negative; use 0 to disable lockouts")
+	}
+	if limits.Lockout.MaxFailures > 0 {
+		if limits.Lockout.BaseSeconds < 1 {
+			v.add("http.rate_limit.lockout.base_seconds", "must be at least 1 when lockouts are enabled")
+		}
+		if limits.Lockout.MaxSeconds < limits.Lockout.BaseSeconds {
+			v.add("http.rate_limit.lockout.max_seconds", "is %d, shorter than base_seconds (%d)", limits.Lockout.MaxSeconds, limits.Lockout.BaseSeconds)
+		}
+	}
+
+	cors := c.HTTP.CORS
+	for _, origin := range cors.AllowedOrigins {
+		if origin == "*" {
+			if cors.AllowCredentials {
+				v.add("http.cors.allow_credentials", "cannot be combined with allowed_origins = [\"*\"]")
+			}
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			v.add("http.cors.allowed_origins", "invalid origin %q: expected scheme://host[:port] or \"*\"", origin)
+		}
+	}
+	if cors.MaxAgeSeconds < 0 {
+		v.add("http.cors.max_age_seconds", "must not be negative")
+	}
+
+	// Audit and quotas
+	if c.Audit.RetentionDays < 0 {
+		v.add("audit.retention_days", "must not be negative; use 0 to keep records forever")
+	}
+	v.quota("quotas.user_default", c.Quotas.UserDefault)
+	v.quota("quotas.bucket_default", c.Quotas.BucketDefault)
+	for name, quota := range c.Quotas.Users {
+		v.quota("quotas.users."+quoteKey(name), quota)
+	}
+	for name, quota := range c.Quotas.Buckets {
+		v.quota("quotas.buckets."+quoteKey(name), quota)
+	}
+
+	if !slices.Contains([]string{"info", "debug", "trace"}, c.Log.Level) {
+		v.add("log.level", "invalid level %q: expected info, debug or trace", c.Log.Level)
+	}
+	if c.Reload.WatchFile && c.Reload.IntervalSeconds < 1 {
+		v.add("reload.interval_seconds", "must be at least 1 when watch_file is enabled")
+	}
+
+	slices.SortStableFunc(v.problems, func(a, b Problem) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return v.problems
+}
+
+func (v *validator) port(key string, port int) {
+	if port < 1 || port > 65535 {
+		v.add(key, "invalid port %d: must be between 1 and 65535", port)
+	}
+}
+
+// listener checks a WebSocket or MQTT listener, which must not share a
+// port with the others and needs nats.tls unless TLS is left to a proxy
+func (v *validator) listener(key, host string, port int, noTLS, tls bool, others []int) {
+	v.port(key+".port", port)
+	if slices.Contains(others, port) {
+		v.add(key+".port", "must differ from the other NATS ports")
+	}
+	if host == "" {
+		v.add(key+".host", "must not be empty")
+	}
+	if !noTLS && !tls {
+		v.add(key+".no_tls", "is false but nats.tls is not enabled; enable it, or set no_tls = true behind a proxy that terminates TLS")
+	}
+}
+
+// nodes counts the cluster's nodes: its routes, plus this node unless the
+// routes already include it
+func (c ClusterConfig) nodes() int {
+	self := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	for _, route := range c.Routes {
+		if u, err := url.Parse(route); err == nil && u.Host == self {
+			return len(c.Routes)
+		}
+	}
+	return len(c.Routes) + 1
+}
+
+// name checks an optional NATS name, which can't contain spaces, dots or
+// wildcards since it ends up in subjects
+func (v *validator) name(key, name string) {
+	if strings.ContainsAny(name, " \t\r\n\f.*>") {
+		v.add(key, "invalid name %q: must not contain spaces, '.', '*' or '>'", name)
+	}
+}
+
+// bucket checks a bucket name, which ends up in stream names and subjects
+func (v *validator) bucket(key, name string) {
+	if !plainName(name) {
+		v.add(key, "invalid bucket name %q: use letters, digits, '_' and '-'", name)
+	}
+}
+
+// plainName reports whether a NATS client or bucket name only has
+// letters, digits, '_' and '-'
+func plainName(name string) bool {
+	return name != "" && strings.Trim(name, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-") == ""
+}
+
+// token checks a configured token; an empty one is generated at startup
+func (v *validator) token(key, token string) {
+	if token != "" && len(token) < MinTokenLength {
+		v.add(key, "is %d characters, shorter than the minimum of %d; remove it to have a strong one generated", len(token), MinTokenLength)
+	}
+}
+
+func (v *validator) tls(key string, tls TLSConfig) {
+	if _, err := certs.ParseVersion(tls.MinVersion); err != nil {
+		v.add(key+".min_version", "%v", err)
+	}
+	if !tls.Enabled {
+		return
+	}
+	if tls.CertFile == "" {
+		v.add(key+".cert_file", "must not be empty when TLS is enabled")
+	}
+	if tls.KeyFile == "" {
+		v.add(key+".key_file", "must not be empty when TLS is enabled")
+	}
+	if !tls.SelfSigned {
+		for name, file := range map[string]string{"cert_file": tls.CertFile, "key_file": tls.KeyFile} {
+			if _, err := os.Stat(file); file != "" && err != nil {
+				v.add(key+"."+name, "cannot read %s: %v (set self_signed = true to generate one)", file, unwrapPath(err))
+			}
+		}
+	}
+	if tls.ClientCAFile != "" {
+		if _, err := os.Stat(tls.ClientCAFile); err != nil {
+			v.add(key+".client_ca_file", "cannot read %s: %v", tls.ClientCAFile, unwrapPath(err))
+		}
+	}
+}
+
+func (v *validator) rate(key string, perMinute float64, burst int) {
+	if perMinute < 0 {
+		v.add(key+"_per_minute", "must not be negative; use 0 to disable the limit")
+	}
+	if perMinute > 0 && burst < 1 {
+		v.add(key+"_burst", "must be at least 1 when %s_per_minute is set", key)
+	}
+}
+
+func (v *validator) role(key, role string, allowEmpty bool) {
+	if role == "" && allowEmpty {
+		return
+	}
+	if role != "admin" && role != "user" {
+		v.add(key, "invalid role %q: expected admin or user", role)
+	}
+}
+
+func (v *validator) quota(key string, quota QuotaConfig) {
+	if quota.MaxSizeMB < 0 {
+		v.add(key+".max_size_mb", "must not be negative; use 0 for unlimited")
+	}
+	if quota.MaxObjects < 0 {
+		v.add(key+".max_objects", "must not be negative; use 0 for unlimited")
+	}
+	if quota.MaxObjectSizeMB < 0 {
+		v.add(key+".max_object_size_mb", "must not be negative; use 0 for unlimited")
+	}
+}
+
+// checkWritableDir checks that dir, or the nearest parent that exists if it
+// doesn't yet, is a directory its owner can create files in. It only looks
+// at the permission bits, so validating a configuration never writes to disk.
+func checkWritableDir(dir string) error {
+	existing := dir
+	for {
+		info, err := os.Stat(existing)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", existing)
+			}
+			if info.Mode().Perm()&0o200 == 0 {
+				return fmt.Errorf("%s is not writable (mode %v)", existing, info.Mode().Perm())
+			}
+			return nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("cannot access %s: %v", existing, unwrapPath(err))
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return fmt.Errorf("no parent directory of %s exists", dir)
+		}
+		existing = parent
+	}
+}
+
+// unwrapPath drops the path from file errors whose message already names it
+func unwrapPath(err error) error {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err
+	}
+	return err
+}
+
+// keyPositions finds where each key is set in a TOML file, by dotted key.
+// It understands tables, dotted keys and multi-line strings and arrays well
+// enough for configuration files.
+func keyPositions(data string) map[string]position {
+	positions := map[string]position{}
+	table := ""
+	multiline := "" // Delimiter of the multi-line string being skipped
+	depth := 0      // Nesting of a multi-line array being skipped
+
+	for i, line := range strings.Split(data, "\n") {
+		trimmed := strings.TrimSpace(line)
+		col := len(line) - len(strings.TrimLeft(line, " \t")) + 1
+
+		if multiline != "" {
+			if strings.Count(trimmed, multiline)%2 == 1 {
+				multiline = ""
+			}
+			continue
+		}
+		if depth > 0 {
+			depth += strings.Count(trimmed, "[") - strings.Count(trimmed, "]")
+			continue
+		}
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+
+		if trimmed[0] == '[' {
+			name := strings.Trim(trimmed, "[]")
+			if end := strings.Index(trimmed, "]"); end > 0 {
+				name = strings.Trim(trimmed[:end], "[")
+			}
+			table = strings.Join(splitKey(name), ".")
+			if _, ok := positions[table]; !ok {
+				positions[table] = position{i + 1, col}
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(trimmed, "=")
+		if !ok {
+			continue
+		}
+		full := strings.Join(splitKey(key), ".")
+		if table != "" {
+			full = table + "." + full
+		}
+		positions[full] = position{i + 1, col}
+
+		value = strings.TrimSpace(value)
+		for _, delimiter := range []string{`"""`, `'''`} {
+			if strings.HasPrefix(value, delimiter) && strings.Count(value, delimiter) == 1 {
+				multiline = delimiter
+			}
+		}
+		if strings.HasPrefix(value, "[") {
+			depth = strings.Count(value, "[") - strings.Count(value, "]")
+		}
+	}
+	return positions
+}
+
+// splitKey splits a dotted TOML key, removing quotes and spaces
+func splitKey(key string) []string {
+	var parts []string
+	var current strings.Builder
+	quote := rune(0)
+	for _, r := range key {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '.':
+			parts = append(parts, strings.TrimSpace(current.String()))
+			current.Reset()
+		case r != ' ' && r != '\t':
+			current.WriteRune(r)
+		}
+	}
+	return append(parts, strings.TrimSpace(current.String()))
+}
+
+// suggest returns the known key closest to an unknown one, if any is close
+// enough to be a likely typo. Keys inside maps, such as a user's quota, are
+// matched by their last part.
+func suggest(unknown string, known []string) string {
+	best, bestDistance := "", len(unknown)/3+1
+	depth := strings.Count(unknown, ".")
+	for _, key := range known {
+		if strings.Count(key, ".") != depth {
+			continue
+		}
+		if d := editDistance(unknown, key); d < bestDistance {
+			best, bestDistance = key, d
+		}
+	}
+	if best != "" {
+		return best
+	}
+
+	_, last, _ := cutLast(unknown)
+	bestDistance = len(last)/3 + 1
+	for _, key := range known {
+		_, name, _ := cutLast(key)
+		if d := editDistance(last, name); d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// validConfig returns the defaults with the tokens a fresh config gets
+func validConfig(t *testing.T) *Config {
+	t.Helper()
+	config := DefaultConfig()
+	if err := config.GenerateToken(); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.GenerateHTTPToken(); err != nil {
+		t.Fatal(err)
+	}
+	if problems := config.Validate(); len(problems) > 0 {
+		t.Fatalf("defaults are invalid: %v", problems)
+	}
+	return config
+}
+
+func TestWriteClientsNeedBridgedBuckets(t *testing.T) {
+	config := validConfig(t)
+	config.NATS.Clients = map[string]NATSClientConfig{
+		"sensor": {NKey: "UCR5BJ3X2IISJD2WHMXP2YL3WIZPCDVPRQBTQDWD623AGZ2N7TBHYVHR", Access: "write", Buckets: []string{"sensors"}},
+	}
+	problems := config.Validate()
+	if len(problems) != 1 || problems[0].Key != "nats.clients.sensor.buckets" || !strings.Contains(problems[0].String(), "nats.bridge.buckets") {
+		t.Fatalf("problems = %v, want one asking to bridge sensors", problems)
+	}
+
+	config.NATS.Bridge.Buckets = []string{"sensors"}
+	if problems := config.Validate(); len(problems) > 0 {
+		t.Fatalf("problems = %v, want none once sensors is bridged", problems)
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	config := validConfig(t)
+	config.NATS.Port = 0
+	config.HTTP.Auth.Token = "short"
+	config.Log.Level = "verbose"
+
+	problems := config.Validate()
+	var keys []string
+	for _, problem := range problems {
+		keys = append(keys, problem.Key)
+	}
+	if strings.Join(keys, " ") != "http.auth.token log.level nats.port" {
+		t.Fatalf("problems = %v, want one each for http.auth.token, log.level and nats.port, sorted", problems)
+	}
+
+	err := (&ValidationError{Problems: problems}).Error()
+	if !strings.HasPrefix(err, "invalid configuration (3 problems):") || !strings.Contains(err, "log.level: invalid level \"verbose\"") {
+		t.Fatalf("error = %q", err)
+	}
+}
+
+func TestValidateDoesNotWrite(t *testing.T) {
+	dir := t.TempDir()
+	config := validConfig(t)
+	config.NATS.StoreDir = filepath.Join(dir, "data", "jetstream")
+	if problems := config.Validate(); len(problems) > 0 {
+		t.Fatalf("problems = %v, want none for a store dir that can be created", problems)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) > 0 {
+		t.Fatalf("validating left %v behind (%v)", entries, err)
+	}
+
+	readOnly := filepath.Join(dir, "read-only")
+	if err := os.Mkdir(readOnly, 0o555); err != nil {
+		t.Fatal(err)
+	}
+	config.NATS.StoreDir = filepath.Join(readOnly, "jetstream")
+	problems := config.Validate()
+	if len(problems) != 1 || problems[0].Key != "nats.store_dir" || !strings.Contains(problems[0].Message, "not writable") {
+		t.Fatalf("problems = %v, want the read-only store dir reported", problems)
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config.NATS.StoreDir = file
+	if problems := config.Validate(); len(problems) != 1 || !strings.Contains(problems[0].Message, "not a directory") {
+		t.Fatalf("problems = %v, want the file reported", problems)
+	}
+}
@@ -0,0 +1,164 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"soxdrawer/internal/apikeys"
+	"soxdrawer/internal/audit"
+	"soxdrawer/internal/users"
+)
+
+type (
+	APIKeyRequest struct {
+		Name string `json:"name"`
+	}
+
+	APIKeyResponse struct {
+		Status  string       `json:"status"`
+		Message string       `json:"message"`
+		Key     *apikeys.Key `json:"key"`
+		Secret  string       `json:"secret,omitempty"` // Only returned when the key is created
+	}
+
+	APIKeysResponse struct {
+		Status  string         `json:"status"`
+		Message string         `json:"message"`
+		Keys    []*apikeys.Key `json:"keys"`
+	}
+)
+
+// verifyAPIKey returns the API key a bearer token belongs to, as long as its
+// user still exists
+func (s *Server) verifyAPIKey(token string) (*apikeys.Key, error) {
+	key, err := s.apiKeys.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.users.Get(key.User); err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			return nil, apikeys.ErrInvalidKey
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+// apiKeysHandler lists the current user's API keys, or everyone's for
+// administrators, and creates keys for the current user
+func (s *Server) apiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	if s.apiKeys == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	user, err := s.currentUser(r)
+	if err != nil {
+		log.Printf("Failed to load current user: %v", err)
+		sendErrorResponse(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		owner := user.Name
+		if user.IsAdmin() {
+			owner = ""
+		}
+		keys, err := s.apiKeys.List(owner)
+		if err != nil {
+			log.Printf("Failed to list API keys: %v", err)
+			sendErrorResponse(w, "Failed to list API keys", http.StatusInternalServerError)
+			return
+		}
+		for _, key := range keys {
+			key.Hash = ""
+		}
+		sendJSONResponse(w, http.StatusOK, APIKeysResponse{Status: "success", Keys: keys})
+
+	case http.MethodPost:
+		var req APIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			sendErrorResponse(w, "A name is required", http.StatusBadRequest)
+			return
+		}
+
+		key, secret, err := s.apiKeys.Create(req.Name, user.Name)
+		record := audit.Record{Actor: user.Name, Action: audit.ActionAPIKeyCreate, Outcome: outcome(err), Detail: req.Name}
+		if key != nil {
+			record.Detail = key.ID + " " + key.Name
+		}
+		s.audit(r, record)
+		if err != nil {
+			log.Printf("Failed to create API key: %v", err)
+			sendErrorResponse(w, "Failed to create API key", http.StatusInternalServerError)
+			return
+		}
+		key.Hash = ""
+
+		log.Printf("User %s created API key %s (%s)", user.Name, key.ID, key.Name)
+		sendJSONResponse(w, http.StatusCreated, APIKeyResponse{
+			Status:  "success",
+			Message: "API key created; copy the secret now, it will not be shown again",
+			Key:     key,
+			Secret:  secret,
+		})
+
+	default:
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// revokeAPIKeyHandler revokes one of the current user's API keys, or anyone's
+// for administrators: DELETE /api/keys/{id}
+func (s *Server) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.apiKeys == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	user, err := s.currentUser(r)
+	if err != nil {
+		log.Printf("Failed to load current user: %v", err)
+		sendErrorResponse(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/keys/")
+	key, err := s.apiKeys.Get(id)
+	if err == nil && key.User != user.Name && !user.IsAdmin() {
+		// Other users' keys are reported as missing rather than forbidden
+		err = apikeys.ErrKeyNotFound
+	}
+	if err == nil {
+		err = s.apiKeys.Revoke(id)
+	}
+	s.audit(r, audit.Record{Actor: user.Name, Action: audit.ActionAPIKeyRevoke, Outcome: outcome(err), Detail: id})
+	if err != nil {
+		if errors.Is(err, apikeys.ErrKeyNotFound) {
+			sendErrorResponse(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to revoke API key %s: %v", id, err)
+		sendErrorResponse(w, "Failed to revoke API key", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %s revoked API key %s", user.Name, id)
+	sendJSONResponse(w, http.StatusOK, LoginResponse{
+		Status:  "success",
+		Message: "API key revoked",
+	})
+}
@@ -0,0 +1,72 @@
+package http
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"soxdrawer/internal/audit"
+	"soxdrawer/internal/users"
+)
+
+func TestAuditQuery(t *testing.T) {
+	server, b := newBulkTestServer(t)
+	for _, key := range []string{"a.txt", "b.txt", "c.txt"} {
+		server.auditLog.Record(audit.Record{Actor: "alice", Action: audit.ActionUpload, Outcome: audit.OutcomeSuccess, Key: key})
+	}
+
+	// The newest matches come first
+	response := b.get("/api/admin/audit?actor=alice&action=upload&limit=2")
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("query = %d, want %d", response.StatusCode, http.StatusOK)
+	}
+	var result AuditResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Records) != 2 || result.Records[0].Key != "c.txt" || result.Records[1].Key != "b.txt" {
+		t.Fatalf("records = %+v", result.Records)
+	}
+
+	// The export has every match, oldest first, including the admin's login
+	response = b.get("/api/admin/audit?format=jsonl")
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("export = %d %s", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	var actions []string
+	lines := bufio.NewScanner(response.Body)
+	for lines.Scan() {
+		var record audit.Record
+		if err := json.Unmarshal(lines.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		actions = append(actions, record.Actor+" "+record.Action)
+	}
+	if len(actions) != 4 || actions[0] != users.AdminUser+" "+audit.ActionLogin || actions[3] != "alice "+audit.ActionUpload {
+		t.Fatalf("exported %v", actions)
+	}
+
+	for _, query := range []string{"limit=0", "limit=1001", "limit=x", "format=csv", "since=yesterday"} {
+		if response := b.get("/api/admin/audit?" + query); response.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%s = %d, want %d", query, response.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAuditNeedsAnAdministrator(t *testing.T) {
+	server, _ := newBulkTestServer(t)
+	if _, err := server.users.Ensure("alice", users.RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := server.sessions.Create("alice", "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := newBrowser(t, server.routes())
+	b.cookies[SessionCookieName] = &http.Cookie{Name: SessionCookieName, Value: token}
+	if response := b.get("/api/admin/audit"); response.StatusCode != http.StatusForbidden {
+		t.Fatalf("query by a user = %d, want %d", response.StatusCode, http.StatusForbidden)
+	}
+}
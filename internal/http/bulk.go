@@ -0,0 +1,380 @@
+package http
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"soxdrawer/internal/audit"
+	"soxdrawer/internal/store"
+)
+
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+
+	BulkDelete = "delete"
+	BulkTag    = "tag"
+	BulkMove   = "move"
+
+	// MaxBulkItems caps the number of objects a single bulk request may touch
+	MaxBulkItems = 10000
+)
+
+type (
+	// BulkFilter selects objects by the same criteria as /api/list
+	BulkFilter struct {
+		Prefix     string   `json:"prefix,omitempty"`
+		Tags       []string `json:"tags,omitempty"`
+		Collection string   `json:"collection,omitempty"`
+		Kind       string   `json:"kind,omitempty"`
+		Since      string   `json:"since,omitempty"`
+		Until      string   `json:"until,omitempty"`
+	}
+
+	BulkDownloadRequest struct {
+		Keys   []string    `json:"keys,omitempty"`
+		Filter *BulkFilter `json:"filter,omitempty"`
+		Format string      `json:"format,omitempty"` // zip (default) or tar.gz
+		Name   string      `json:"name,omitempty"`   // Archive file name without extension
+	}
+
+	BulkDestination struct {
+		Folder string `json:"folder,omitempty"`
+		Bucket string `json:"bucket,omitempty"`
+	}
+
+	BulkRequest struct {
+		Action      string               `json:"action"`
+		Keys        []string             `json:"keys,omitempty"`
+		Filter      *BulkFilter          `json:"filter,omitempty"`
+		Metadata    *store.MetadataPatch `json:"metadata,omitempty"`    // For tag
+		Destination *BulkDestination     `json:"destination,omitempty"` // For move
+	}
+
+	BulkItemResult struct {
+		Key    string `json:"key"`
+		Status string `json:"status"`
+		NewKey string `json:"new_key,omitempty"`
+		Error  string `json:"error,omitempty"`
+	}
+
+	BulkResponse struct {
+		Status    string           `json:"status"`
+		Message   string           `json:"message"`
+		Succeeded int              `json:"succeeded"`
+		Failed    int              `json:"failed"`
+		Results   []BulkItemResult `json:"results"`
+	}
+)
+
+// bulkDownloadHandler streams a ZIP or tar.gz archive of the selected objects.
+// Objects are read from the store one at a time and written straight to the
+// response, so nothing is buffered beyond a single copy chunk. GET requests
+// take repeated key parameters plus the /api/list filters; POST takes JSON.
+func (s *Server) bulkDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	var req BulkDownloadRequest
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		req.Keys = query["key"]
+		req.Format = query.Get("format")
+		req.Name = query.Get("name")
+		if query.Has("prefix") || query.Has("tag") || query.Has("collection") ||
+			query.Has("kind") || query.Has("since") || query.Has("until") {
+			req.Filter = &BulkFilter{
+				Prefix:     query.Get("prefix"),
+				Tags:       query["tag"],
+				Collection: query.Get("collection"),
+				Kind:       query.Get("kind"),
+				Since:      query.Get("since"),
+				Until:      query.Get("until"),
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	default:
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if req.Format == "" {
+		req.Format = ArchiveZip
+	}
+	if req.Format != ArchiveZip && req.Format != ArchiveTarGz {
+		sendErrorResponse(w, "Unsupported archive format", http.StatusBadRequest)
+		return
+	}
+
+	keys, err := s.resolveBulkKeys(req.Keys, req.Filter)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(keys) == 0 {
+		sendErrorResponse(w, "No objects selected", http.StatusBadRequest)
+		return
+	}
+
+	name := sanitizeFilename(req.Name)
+	if req.Name == "" {
+		name = fmt.Sprintf("soxdrawer-%s", time.Now().Format("20060102-150405"))
+	}
+
+	log.Printf("Streaming %s archive of %d objects", req.Format, len(keys))
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", name, req.Format))
+	if req.Format == ArchiveZip {
+		w.Header().Set("Content-Type", "application/zip")
+		err = s.writeZipArchive(w, keys)
+	} else {
+		w.Header().Set("Content-Type", "application/gzip")
+		err = s.writeTarGzArchive(w, keys)
+	}
+	if err != nil {
+		// Headers are already sent; the truncated archive signals the failure
+		log.Printf("Failed to stream archive: %v", err)
+	}
+	for _, key := range keys {
+		s.audit(r, audit.Record{Action: audit.ActionDownload, Outcome: outcome(err), Bucket: s.ObjectStore.Name(), Key: key, Detail: req.Format + " archive"})
+	}
+}
+
+func (s *Server) writeZipArchive(w io.Writer, keys []string) error {
+	zw := zip.NewWriter(w)
+	for _, key := range keys {
+		result, err := s.ObjectStore.GetReader(key)
+		if err != nil {
+			log.Printf("Skipping %s in archive: %v", key, err)
+			continue
+		}
+
+		info, err := result.Info()
+		if err != nil {
+			result.Close()
+			log.Printf("Skipping %s in archive: %v", key, err)
+			continue
+		}
+
+		entry, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     key,
+			Method:   zip.Deflate,
+			Modified: info.ModTime,
+		})
+		if err == nil {
+			_, err = io.Copy(entry, result)
+		}
+		result.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write %s to archive: %w", key, err)
+		}
+	}
+	return zw.Close()
+}
+
+func (s *Server) writeTarGzArchive(w io.Writer, keys []string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, key := range keys {
+		result, err := s.ObjectStore.GetReader(key)
+		if err != nil {
+			log.Printf("Skipping %s in archive: %v", key, err)
+			continue
+		}
+
+		info, err := result.Info()
+		if err != nil {
+			result.Close()
+			log.Printf("Skipping %s in archive: %v", key, err)
+			continue
+		}
+
+		err = tw.WriteHeader(&tar.Header{
+			Name:     key,
+			Mode:     0644,
+			Size:     int64(info.Size),
+			ModTime:  info.ModTime,
+			Typeflag: tar.TypeReg,
+		})
+		if err == nil {
+			_, err = io.Copy(tw, result)
+		}
+		result.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write %s to archive: %w", key, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// bulkHandler applies delete, tag or move to every selected object and
+// reports the outcome for each one
+func (s *Server) bulkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req BulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var apply func(key string) BulkItemResult
+	switch req.Action {
+	case BulkDelete:
+		apply = func(key string) BulkItemResult {
+			err := s.ObjectStore.Delete(key)
+			s.audit(r, audit.Record{Action: audit.ActionDelete, Outcome: outcome(err), Bucket: s.ObjectStore.Name(), Key: key, Detail: "bulk"})
+			return bulkResult(key, "", err)
+		}
+
+	case BulkTag:
+		if req.Metadata == nil {
+			sendErrorResponse(w, "metadata is required for tag", http.StatusBadRequest)
+			return
+		}
+		apply = func(key string) BulkItemResult {
+			_, err := s.ObjectStore.PatchMetadata(key, req.Metadata)
+			s.audit(r, audit.Record{Action: audit.ActionMetadata, Outcome: outcome(err), Bucket: s.ObjectStore.Name(), Key: key, Detail: "bulk"})
+			return bulkResult(key, "", err)
+		}
+
+	case BulkMove:
+		if req.Destination == nil {
+			sendErrorResponse(w, "destination is required for move", http.StatusBadRequest)
+			return
+		}
+		dst := s.ObjectStore
+		if req.Destination.Bucket != "" && req.Destination.Bucket != s.ObjectStore.Name() {
+			var err error
+			dst, err = s.ObjectStore.OpenBucket(req.Destination.Bucket)
+			if err != nil {
+				sendErrorResponse(w, "Destination bucket not found", http.StatusNotFound)
+				return
+			}
+		}
+		folder := sanitizePath(req.Destination.Folder)
+		apply = func(key string) BulkItemResult {
+			newKey := joinPath(folder, path.Base(key))
+			_, err := s.moveObject(dst, key, newKey)
+			s.audit(r, audit.Record{Action: audit.ActionMove, Outcome: operationOutcome(err), Bucket: s.ObjectStore.Name(), Key: key, Detail: "bulk to " + dst.Name() + "/" + newKey})
+			return bulkResult(key, newKey, err)
+		}
+
+	default:
+		sendErrorResponse(w, "Unknown bulk action", http.StatusBadRequest)
+		return
+	}
+
+	keys, err := s.resolveBulkKeys(req.Keys, req.Filter)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := BulkResponse{
+		Status:  "success",
+		Results: make([]BulkItemResult, 0, len(keys)),
+	}
+	for _, key := range keys {
+		result := apply(key)
+		if result.Status == "success" {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	response.Message = fmt.Sprintf("%s: %d succeeded, %d failed", req.Action, response.Succeeded, response.Failed)
+	if response.Failed > 0 && response.Succeeded == 0 {
+		response.Status = "error"
+	}
+
+	log.Printf("Bulk %s", response.Message)
+	sendJSONResponse(w, http.StatusOK, response)
+}
+
+func bulkResult(key, newKey string, err error) BulkItemResult {
+	if err != nil {
+		log.Printf("Bulk operation failed for %s: %v", key, err)
+		return BulkItemResult{Key: key, Status: "error", Error: err.Error()}
+	}
+	return BulkItemResult{Key: key, Status: "success", NewKey: newKey}
+}
+
+// resolveBulkKeys combines explicitly listed keys with the objects matching a filter
+func (s *Server) resolveBulkKeys(keys []string, filter *BulkFilter) ([]string, error) {
+	if len(keys) == 0 && filter == nil {
+		return nil, errors.New("either keys or filter is required")
+	}
+
+	seen := make(map[string]bool, len(keys))
+	var resolved []string
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key != "" && !seen[key] {
+			seen[key] = true
+			resolved = append(resolved, key)
+		}
+	}
+
+	if filter != nil {
+		opts := store.ListOptions{
+			Prefix:     filter.Prefix,
+			Tags:       filter.Tags,
+			Collection: filter.Collection,
+			Kind:       filter.Kind,
+			Sort:       store.SortByName,
+			Order:      store.OrderAsc,
+		}
+		var err error
+		if opts.Since, err = parseTimeParam(filter.Since); err != nil {
+			return nil, fmt.Errorf("invalid since: %w", err)
+		}
+		if opts.Until, err = parseTimeParam(filter.Until); err != nil {
+			return nil, fmt.Errorf("invalid until: %w", err)
+		}
+
+		// Page through the matches, stopping once there are too many
+		opts.Limit = store.MaxPageSize
+		for {
+			result, err := s.ObjectStore.ListObjectsForAPI(opts)
+			if err != nil {
+				return nil, err
+			}
+			for _, obj := range result.Objects {
+				if !seen[obj.Name] {
+					seen[obj.Name] = true
+					resolved = append(resolved, obj.Name)
+				}
+			}
+			if result.NextCursor == "" || len(resolved) > MaxBulkItems {
+				break
+			}
+			opts.Cursor = result.NextCursor
+		}
+	}
+
+	if len(resolved) > MaxBulkItems {
+		return nil, fmt.Errorf("selection of %d objects exceeds the limit of %d", len(resolved), MaxBulkItems)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,158 @@
+package http
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+
+	"soxdrawer/internal/audit"
+)
+
+// newBulkTestServer returns a logged-in browser for an audited server with
+// the given objects, each holding its own key as content
+func newBulkTestServer(t *testing.T, keys ...string) (*Server, *browser) {
+	t.Helper()
+	server := newTestServer(t, func(config *Config, js nats.JetStreamContext) {
+		auditLog, err := audit.New(js, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.AuditLog = auditLog
+	})
+	for _, key := range keys {
+		if _, err := server.ObjectStore.PutString(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := newBrowser(t, server.routes())
+	b.login(testToken)
+	return server, b
+}
+
+// readArchive returns the files of a zip or tar.gz archive with their content
+func readArchive(t *testing.T, format string, data []byte) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	if format == ArchiveZip {
+		archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range archive.File {
+			r, err := file.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, _ := io.ReadAll(r)
+			r.Close()
+			files[file.Name] = string(content)
+		}
+		return files
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(archive)
+		files[header.Name] = string(content)
+	}
+}
+
+func TestBulkDownloadStreamsTheFilteredObjects(t *testing.T) {
+	_, b := newBulkTestServer(t, "incident/a.txt", "incident/sub/b.txt", "other.txt")
+
+	for _, format := range []string{ArchiveZip, ArchiveTarGz} {
+		response := b.get("/api/bulk/download?prefix=incident/&key=other.txt&name=report&format=" + format)
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("%s download = %d, want %d", format, response.StatusCode, http.StatusOK)
+		}
+		if disposition := response.Header.Get("Content-Disposition"); disposition != `attachment; filename="report.`+format+`"` {
+			t.Fatalf("%s disposition = %s", format, disposition)
+		}
+		data, err := io.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := readArchive(t, format, data)
+		want := map[string]string{"other.txt": "other.txt", "incident/a.txt": "incident/a.txt", "incident/sub/b.txt": "incident/sub/b.txt"}
+		if fmt.Sprint(files) != fmt.Sprint(want) {
+			t.Fatalf("%s archive holds %v, want %v", format, files, want)
+		}
+	}
+
+	if response := b.get("/api/bulk/download?prefix=missing/"); response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("empty selection = %d, want %d", response.StatusCode, http.StatusBadRequest)
+	}
+	if response := b.get("/api/bulk/download?key=other.txt&format=rar"); response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unknown format = %d, want %d", response.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestBulkReportsEachItem(t *testing.T) {
+	server, b := newBulkTestServer(t, "a.txt", "b.txt")
+
+	response := b.postJSON("/api/bulk", `{"action": "tag", "keys": ["a.txt", "missing.txt", "b.txt"], "metadata": {"add_tags": ["urgent"]}}`)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("bulk tag = %d, want %d", response.StatusCode, http.StatusOK)
+	}
+	var result BulkResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	var statuses []string
+	for _, item := range result.Results {
+		statuses = append(statuses, item.Key+" "+item.Status)
+	}
+	if want := []string{"a.txt success", "missing.txt error", "b.txt success"}; !slices.Equal(statuses, want) || result.Succeeded != 2 || result.Failed != 1 {
+		t.Fatalf("results = %v (%d/%d), want %v", statuses, result.Succeeded, result.Failed, want)
+	}
+
+	var audited []string
+	server.auditLog.Each(audit.Filter{Action: audit.ActionMetadata}, func(record *audit.Record) bool {
+		audited = append(audited, record.Key+" "+record.Outcome)
+		return true
+	})
+	if want := []string{"a.txt success", "missing.txt failure", "b.txt success"}; !slices.Equal(audited, want) {
+		t.Fatalf("audited %v, want %v", audited, want)
+	}
+}
+
+func TestBulkRefusesTooManyItems(t *testing.T) {
+	_, b := newBulkTestServer(t)
+
+	keys := make([]string, MaxBulkItems+1)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%d.txt", i)
+	}
+	body, err := json.Marshal(BulkRequest{Action: BulkDelete, Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := b.postJSON("/api/bulk", string(body))
+	if response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bulk of %d items = %d, want %d", len(keys), response.StatusCode, http.StatusBadRequest)
+	}
+	if data, _ := io.ReadAll(response.Body); !strings.Contains(string(data), "exceeds the limit") {
+		t.Fatalf("response = %s, want the limit named", data)
+	}
+}
@@ -0,0 +1,115 @@
+package http
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// CSRFCookieName holds the double-submit token. It is readable by scripts
+	// so the web app can echo it in CSRFHeaderName.
+	CSRFCookieName = "soxdrawer_csrf"
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
+// csrfMiddleware protects cookie-authenticated state-changing requests. It
+// rejects cross-site requests by Sec-Fetch-Site and Origin, then requires the
+// X-CSRF-Token header to match the CSRF cookie. Requests that authMiddleware
+// authenticated with an API key are exempt since browsers never attach a
+// bearer token on their own; merely sending one doesn't exempt a request.
+func csrfMiddleware(cookies CookiePolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := getCSRFToken(r)
+			if token == "" {
+				var err error
+				if token, err = generateCSRFToken(); err != nil {
+					log.Printf("Failed to generate CSRF token: %v", err)
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				cookies.setCSRFCookie(w, token)
+			}
+
+			// The embed endpoint only accepts upload keys, never cookies
+			if isSafeMethod(r.Method) || apiKeyFromContext(r.Context()) != nil || r.URL.Path == "/api/embed/upload" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if site := r.Header.Get("Sec-Fetch-Site"); site == "cross-site" {
+				rejectCSRF(w, r, "cross-site request")
+				return
+			}
+			if origin := r.Header.Get("Origin"); origin != "" && !sameOrigin(origin, r.Host) {
+				rejectCSRF(w, r, "origin "+origin)
+				return
+			}
+
+			header := r.Header.Get(CSRFHeaderName)
+			if header == "" || getCSRFToken(r) == "" ||
+				subtle.ConstantTimeCompare([]byte(header), []byte(getCSRFToken(r))) != 1 {
+				rejectCSRF(w, r, "missing or mismatched token")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// setCSRFCookie issues the double-submit token with the session cookie's attributes
+func (p CookiePolicy) setCSRFCookie(w http.ResponseWriter, token string) {
+	cookie := p.cookie(token, 0)
+	cookie.Name = CSRFCookieName
+	cookie.HttpOnly = false
+	http.SetCookie(w, cookie)
+}
+
+func getCSRFToken(r *http.Request) string {
+	cookie, err := r.Cookie(CSRFCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+func generateCSRFToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// bearerToken returns the token from an Authorization: Bearer header, if any
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// sameOrigin reports whether an Origin header names the host the request was sent to
+func sameOrigin(origin, host string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, host)
+}
+
+func rejectCSRF(w http.ResponseWriter, r *http.Request, reason string) {
+	log.Printf("Rejected %s %s from %s: CSRF check failed (%s)", r.Method, r.URL.Path, clientIP(r), reason)
+	sendErrorResponse(w, "CSRF check failed", http.StatusForbidden)
+}
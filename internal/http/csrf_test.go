@@ -0,0 +1,57 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCSRFRejectsForgedRequests(t *testing.T) {
+	b := newBrowser(t, newTestServer(t, nil).routes())
+	b.login(testToken)
+
+	for name, header := range map[string][2]string{
+		"cross-site":   {"Sec-Fetch-Site", "cross-site"},
+		"other origin": {"Origin", "http://evil.example"},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/api/auth/logout", nil)
+		r.Header.Set(header[0], header[1])
+		if response := b.do(r); response.StatusCode != http.StatusForbidden {
+			t.Fatalf("%s logout = %d, want %d", name, response.StatusCode, http.StatusForbidden)
+		}
+	}
+
+	// A request with the cookies but without the token, as a form on
+	// another site would send
+	r := httptest.NewRequest(http.MethodPost, "/api/auth/logout", nil)
+	for _, cookie := range b.cookies {
+		r.AddCookie(cookie)
+	}
+	recorder := httptest.NewRecorder()
+	b.handler.ServeHTTP(recorder, r)
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("logout without the token = %d, want %d", recorder.Code, http.StatusForbidden)
+	}
+
+	// A bearer token only exempts requests it authenticated; the login
+	// endpoints never check one
+	for _, path := range []string{"/api/auth/logout", "/api/auth/oidc/totp"} {
+		r = httptest.NewRequest(http.MethodPost, path, nil)
+		r.Header.Set("Authorization", "Bearer x")
+		for _, cookie := range b.cookies {
+			r.AddCookie(cookie)
+		}
+		recorder = httptest.NewRecorder()
+		b.handler.ServeHTTP(recorder, r)
+		if recorder.Code != http.StatusForbidden {
+			t.Fatalf("%s with an unchecked bearer = %d, want %d", path, recorder.Code, http.StatusForbidden)
+		}
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/api/auth/logout", strings.NewReader(""))
+	r.Header.Set("Origin", "http://example.com")
+	if response := b.do(r); response.StatusCode != http.StatusOK {
+		t.Fatalf("same-origin logout = %d, want %d", response.StatusCode, http.StatusOK)
+	}
+}
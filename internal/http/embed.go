@@ -0,0 +1,218 @@
+package http
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"soxdrawer/internal/audit"
+	"soxdrawer/internal/store"
+	"soxdrawer/internal/uploadkeys"
+)
+
+//go:embed widget/upload.js
+var uploadWidgetJS []byte
+
+type (
+	UploadKeyRequest struct {
+		Name   string `json:"name"`
+		Bucket string `json:"bucket"`
+		Folder string `json:"folder,omitempty"`
+	}
+
+	UploadKeyResponse struct {
+		Status  string          `json:"status"`
+		Message string          `json:"message"`
+		Key     *uploadkeys.Key `json:"key"`
+		Secret  string          `json:"secret,omitempty"` // Only returned when the key is created
+	}
+
+	UploadKeysResponse struct {
+		Status  string            `json:"status"`
+		Message string            `json:"message"`
+		Keys    []*uploadkeys.Key `json:"keys"`
+	}
+)
+
+// uploadWidgetHandler serves the embeddable upload widget script
+func (s *Server) uploadWidgetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(uploadWidgetJS)
+}
+
+// embedUploadHandler accepts uploads authenticated by a scoped upload key
+// instead of a session. Files can only land in the key's bucket and folder.
+func (s *Server) embedUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.uploadKeys == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	ip := clientIP(r)
+	if locked := s.limits.lockout.Locked(ip); locked > 0 {
+		sendTooManyRequests(w, r, locked)
+		return
+	}
+	if ok, wait := s.limits.uploadByIP.Allow(ip); !ok {
+		sendTooManyRequests(w, r, wait)
+		return
+	}
+
+	key, err := s.uploadKeys.Verify(bearerToken(r))
+	if err != nil {
+		if errors.Is(err, uploadkeys.ErrInvalidKey) {
+			s.limits.lockout.Fail(ip)
+			s.audit(r, audit.Record{Actor: "upload-key", Action: audit.ActionUpload, Outcome: audit.OutcomeDenied, Detail: "invalid upload key"})
+			sendErrorResponse(w, "Invalid upload key", http.StatusUnauthorized)
+			return
+		}
+		log.Printf("Failed to verify upload key: %v", err)
+		sendErrorResponse(w, "Failed to verify upload key", http.StatusInternalServerError)
+		return
+	}
+	if ok, wait := s.limits.uploadByCredential.Allow("upload-key:" + key.ID); !ok {
+		sendTooManyRequests(w, r, wait)
+		return
+	}
+
+	objects, err := s.bucket(key.Bucket)
+	if err != nil {
+		log.Printf("Upload key %s targets missing bucket %s: %v", key.ID, key.Bucket, err)
+		sendErrorResponse(w, "Bucket not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Upload key %s (%s) uploading to bucket %s from %s", key.ID, key.Name, key.Bucket, ip)
+	r = r.WithContext(context.WithValue(r.Context(), actorContextKey, "upload-key:"+key.ID))
+	s.receiveUpload(w, r, objects, key.Folder, key.CreatedBy)
+}
+
+// uploadKeysHandler lists upload keys (GET) or issues a new one (POST)
+func (s *Server) uploadKeysHandler(w http.ResponseWriter, r *http.Request) {
+	if s.uploadKeys == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	admin, err := s.currentUser(r)
+	if err != nil || !admin.IsAdmin() {
+		sendErrorResponse(w, "Administrator access required", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := s.uploadKeys.List()
+		if err != nil {
+			log.Printf("Failed to list upload keys: %v", err)
+			sendErrorResponse(w, "Failed to list upload keys", http.StatusInternalServerError)
+			return
+		}
+		for _, key := range keys {
+			key.Hash = ""
+		}
+		sendJSONResponse(w, http.StatusOK, UploadKeysResponse{Status: "success", Keys: keys})
+
+	case http.MethodPost:
+		var req UploadKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			sendErrorResponse(w, "A name is required", http.StatusBadRequest)
+			return
+		}
+		if req.Bucket == "" {
+			req.Bucket = s.ObjectStore.Name()
+		}
+		if _, err := s.bucket(req.Bucket); err != nil {
+			sendErrorResponse(w, "Bucket not found", http.StatusNotFound)
+			return
+		}
+
+		key, secret, err := s.uploadKeys.Create(req.Name, req.Bucket, sanitizePath(req.Folder), admin.Name)
+		record := audit.Record{Actor: admin.Name, Action: audit.ActionUploadKeyCreate, Outcome: outcome(err), Bucket: req.Bucket, Detail: req.Name}
+		if key != nil {
+			record.Detail = key.ID + " " + key.Name
+		}
+		s.audit(r, record)
+		if err != nil {
+			log.Printf("Failed to create upload key: %v", err)
+			sendErrorResponse(w, "Failed to create upload key", http.StatusInternalServerError)
+			return
+		}
+		key.Hash = ""
+
+		log.Printf("Administrator %s created upload key %s (%s) for bucket %s", admin.Name, key.ID, key.Name, key.Bucket)
+		sendJSONResponse(w, http.StatusCreated, UploadKeyResponse{
+			Status:  "success",
+			Message: "Upload key created; copy the secret now, it will not be shown again",
+			Key:     key,
+			Secret:  secret,
+		})
+
+	default:
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// revokeUploadKeyHandler revokes an upload key: DELETE /api/admin/upload-keys/{id}
+func (s *Server) revokeUploadKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.uploadKeys == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	admin, err := s.currentUser(r)
+	if err != nil || !admin.IsAdmin() {
+		sendErrorResponse(w, "Administrator access required", http.StatusForbidden)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/admin/upload-keys/")
+	err = s.uploadKeys.Revoke(id)
+	s.audit(r, audit.Record{Actor: admin.Name, Action: audit.ActionUploadKeyRevoke, Outcome: outcome(err), Detail: id})
+	if err != nil {
+		if errors.Is(err, uploadkeys.ErrKeyNotFound) {
+			sendErrorResponse(w, "Upload key not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to revoke upload key %s: %v", id, err)
+		sendErrorResponse(w, "Failed to revoke upload key", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Administrator %s revoked upload key %s", admin.Name, id)
+	sendJSONResponse(w, http.StatusOK, LoginResponse{
+		Status:  "success",
+		Message: "Upload key revoked",
+	})
+}
+
+// bucket returns the server's bucket or another existing bucket by name
+func (s *Server) bucket(name string) (*store.ObjectStore, error) {
+	if name == s.ObjectStore.Name() {
+		return s.ObjectStore, nil
+	}
+	return s.ObjectStore.OpenBucket(name)
+}
@@ -0,0 +1,70 @@
+package http
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+
+	"soxdrawer/internal/uploadkeys"
+)
+
+// embedUpload posts note.txt to the embed endpoint with an upload key,
+// asking for a folder outside the key's
+func embedUpload(t *testing.T, handler http.Handler, secret string) int {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	file, err := form.CreateFormFile("file", "note.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("hello"))
+	form.WriteField("folder", "../../escape")
+	form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/embed/upload", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	r.Header.Set("Authorization", "Bearer "+secret)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, r)
+	return recorder.Code
+}
+
+func TestUploadKeyStaysInItsFolder(t *testing.T) {
+	var keys *uploadkeys.Store
+	server := newTestServer(t, func(config *Config, js nats.JetStreamContext) {
+		var err error
+		if keys, err = uploadkeys.New(js); err != nil {
+			t.Fatal(err)
+		}
+		config.UploadKeys = keys
+	})
+	handler := server.routes()
+	key, secret, err := keys.Create("helpdesk", server.ObjectStore.Name(), "tickets", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code := embedUpload(t, handler, secret); code != http.StatusOK {
+		t.Fatalf("upload with key = %d, want %d", code, http.StatusOK)
+	}
+	names, err := server.ObjectStore.ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || !strings.HasPrefix(names[0], "tickets/") {
+		t.Fatalf("bucket holds %v, want one object below tickets/", names)
+	}
+
+	if err := keys.Revoke(key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := embedUpload(t, handler, secret); code != http.StatusUnauthorized {
+		t.Fatalf("upload with a revoked key = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,116 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"soxdrawer/internal/store"
+
+	"github.com/nats-io/nats.go"
+)
+
+type (
+	FolderRequest struct {
+		Path string `json:"path"`
+	}
+
+	RenameFolderRequest struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+
+	FolderResponse struct {
+		Status      string             `json:"status"`
+		Message     string             `json:"message"`
+		Prefix      string             `json:"prefix,omitempty"`
+		Moved       int                `json:"moved,omitempty"`
+		Stranded    []string           `json:"stranded,omitempty"` // Left in the new folder by a failed rename
+		Breadcrumbs []store.Breadcrumb `json:"breadcrumbs,omitempty"`
+	}
+)
+
+// foldersHandler creates an empty folder
+func (s *Server) foldersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req FolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	prefix, err := s.ObjectStore.CreateFolder(sanitizePath(req.Path))
+	if err != nil {
+		if errors.Is(err, store.ErrInvalidPath) {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Failed to create folder %s: %v", req.Path, err)
+		sendErrorResponse(w, "Failed to create folder", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Created folder: %s", prefix)
+	sendJSONResponse(w, http.StatusOK, FolderResponse{
+		Status:      "success",
+		Message:     "Folder created successfully",
+		Prefix:      prefix,
+		Breadcrumbs: store.Breadcrumbs(prefix),
+	})
+}
+
+// renameFolderHandler renames or moves a folder, rewriting the keys of everything inside it
+func (s *Server) renameFolderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RenameFolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	from := sanitizePath(req.From)
+	to := sanitizePath(req.To)
+	moved, err := s.ObjectStore.RenameFolder(from, to)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidPath):
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, store.ErrFolderExists), errors.Is(err, nats.ErrObjectAlreadyExists):
+			sendErrorResponse(w, err.Error(), http.StatusConflict)
+		default:
+			// Everything renamed before the failure was moved back, if it could be
+			var failure *store.RenameFolderError
+			if errors.As(err, &failure) && len(failure.Stranded) > 0 {
+				log.Printf("Failed to rename folder %s to %s, leaving %d objects in the new folder: %v", from, to, len(failure.Stranded), err)
+				sendJSONResponse(w, http.StatusInternalServerError, FolderResponse{
+					Status:   "error",
+					Message:  "Failed to rename folder; some objects were left in the new folder",
+					Stranded: failure.Stranded,
+				})
+				return
+			}
+			log.Printf("Failed to rename folder %s to %s: %v", from, to, err)
+			sendErrorResponse(w, "Failed to rename folder", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	prefix, _ := store.FolderPrefix(to)
+	log.Printf("Renamed folder %s to %s (%d objects)", from, to, moved)
+	sendJSONResponse(w, http.StatusOK, FolderResponse{
+		Status:      "success",
+		Message:     "Folder renamed successfully",
+		Prefix:      prefix,
+		Moved:       moved,
+		Breadcrumbs: store.Breadcrumbs(prefix),
+	})
+}
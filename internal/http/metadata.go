@@ -0,0 +1,124 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"soxdrawer/internal/audit"
+	"soxdrawer/internal/store"
+
+	"github.com/nats-io/nats.go"
+)
+
+type (
+	MetadataResponse struct {
+		Status   string              `json:"status"`
+		Message  string              `json:"message"`
+		Key      string              `json:"key,omitempty"`
+		Metadata *store.ItemMetadata `json:"metadata,omitempty"`
+	}
+
+	LabelsResponse struct {
+		Status  string             `json:"status"`
+		Message string             `json:"message"`
+		Labels  []store.LabelCount `json:"labels"`
+	}
+)
+
+// metadataHandler reads (GET) or partially updates (PATCH) the tags,
+// description and collections of an object
+func (s *Server) metadataHandler(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/api/meta/"))
+	if key == "" {
+		sendErrorResponse(w, "No key provided", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		meta, err := s.ObjectStore.GetMetadata(key)
+		if err != nil {
+			log.Printf("Failed to get metadata for %s: %v", key, err)
+			sendErrorResponse(w, "Failed to get metadata", http.StatusInternalServerError)
+			return
+		}
+		sendJSONResponse(w, http.StatusOK, MetadataResponse{
+			Status:   "success",
+			Key:      key,
+			Metadata: meta,
+		})
+
+	case http.MethodPatch:
+		var patch store.MetadataPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		meta, err := s.ObjectStore.PatchMetadata(key, &patch)
+		s.audit(r, audit.Record{Action: audit.ActionMetadata, Outcome: outcome(err), Bucket: s.ObjectStore.Name(), Key: key})
+		if err != nil {
+			if errors.Is(err, nats.ErrObjectNotFound) {
+				sendErrorResponse(w, "Object not found", http.StatusNotFound)
+				return
+			}
+			log.Printf("Failed to update metadata for %s: %v", key, err)
+			sendErrorResponse(w, "Failed to update metadata", http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("Updated metadata for object: %s", key)
+		sendJSONResponse(w, http.StatusOK, MetadataResponse{
+			Status:   "success",
+			Message:  "Metadata updated successfully",
+			Key:      key,
+			Metadata: meta,
+		})
+
+	default:
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// tagsHandler returns the tag cloud: every tag in use with its object count
+func (s *Server) tagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tags, err := s.ObjectStore.TagCounts()
+	if err != nil {
+		log.Printf("Failed to count tags: %v", err)
+		sendErrorResponse(w, "Failed to list tags", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, LabelsResponse{
+		Status: "success",
+		Labels: tags,
+	})
+}
+
+// collectionsHandler returns every collection in use with its member count
+func (s *Server) collectionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	collections, err := s.ObjectStore.CollectionCounts()
+	if err != nil {
+		log.Printf("Failed to count collections: %v", err)
+		sendErrorResponse(w, "Failed to list collections", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, LabelsResponse{
+		Status: "success",
+		Labels: collections,
+	})
+}
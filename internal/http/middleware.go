@@ -1,115 +1,200 @@
 package http
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+
+	"soxdrawer/internal/apikeys"
+	"soxdrawer/internal/ratelimit"
+	"soxdrawer/internal/session"
 )
 
+// SessionCookieName is the default name of the session cookie
+const SessionCookieName = "soxdrawer_session"
+
+type contextKey int
+
 const (
-	SessionCookieName = "soxdrawer_session"
-	SessionDuration   = 12 * time.Hour
+	sessionContextKey contextKey = iota
+	actorContextKey              // Overrides the audit actor, e.g. for upload keys
+	apiKeyContextKey             // The API key a request was authenticated with
 )
 
-// Session represents an authenticated session
-type Session struct {
-	Token     string    `json:"token"`
-	ExpiresAt time.Time `json:"expires_at"`
+// CookiePolicy controls the attributes of the session cookie
+type CookiePolicy struct {
+	Name     string
+	Secure   bool
+	SameSite http.SameSite
+	Domain   string
 }
 
-// createSessionToken creates a secure session token
-func createSessionToken(authToken string) string {
-	// Create a timestamp-based token with HMAC for integrity
-	timestamp := fmt.Sprintf("%d", time.Now().Unix())
-	h := hmac.New(sha256.New, []byte(authToken))
-	h.Write([]byte(timestamp))
-	signature := hex.EncodeToString(h.Sum(nil))
-	return fmt.Sprintf("%s.%s", timestamp, signature)
+// CORSPolicy controls which other origins may call the API from a browser
+type CORSPolicy struct {
+	AllowedOrigins   []string // Exact origins such as https://tools.example.com, or *
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool // Let allowed origins send the session cookie
+	MaxAge           time.Duration
 }
 
-// validateSessionToken validates a session token
-func validateSessionToken(sessionToken, authToken string) (bool, error) {
-	parts := strings.Split(sessionToken, ".")
-	if len(parts) != 2 {
-		return false, fmt.Errorf("invalid session token format")
+// DefaultCORSPolicy returns a policy that allows no other origins
+func DefaultCORSPolicy() CORSPolicy {
+	return CORSPolicy{
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodOptions},
+		AllowedHeaders: []string{"Content-Type", "Authorization", CSRFHeaderName},
+		MaxAge:         10 * time.Minute,
 	}
+}
 
-	timestamp := parts[0]
-	signature := parts[1]
-
-	// Verify HMAC signature
-	h := hmac.New(sha256.New, []byte(authToken))
-	h.Write([]byte(timestamp))
-	expectedSignature := hex.EncodeToString(h.Sum(nil))
-
-	if !hmac.Equal([]byte(signature), []byte(expectedSignature)) {
-		return false, fmt.Errorf("invalid session signature")
+func (p CORSPolicy) allows(origin string) bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
 	}
+	return false
+}
 
-	// Check if token is expired (12 hours)
-	timestampInt := int64(0)
-	fmt.Sscanf(timestamp, "%d", &timestampInt)
-	tokenTime := time.Unix(timestampInt, 0)
-
-	if time.Since(tokenTime) > SessionDuration {
-		return false, fmt.Errorf("session expired")
+// DefaultCookiePolicy returns the cookie policy used when none is configured
+func DefaultCookiePolicy() CookiePolicy {
+	return CookiePolicy{
+		Name:     SessionCookieName,
+		SameSite: http.SameSiteStrictMode,
 	}
+}
 
-	return true, nil
+// ParseSameSite converts a configured SameSite value into its http.SameSite mode
+func ParseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(value) {
+	case "", "strict":
+		return http.SameSiteStrictMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("invalid same_site value '%s': expected strict, lax or none", value)
+	}
 }
 
-// setSessionCookie sets a secure session cookie
-func setSessionCookie(w http.ResponseWriter, sessionToken string) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     SessionCookieName,
-		Value:    sessionToken,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
-		SameSite: http.SameSiteStrictMode,
-		MaxAge:   int(SessionDuration.Seconds()),
-	})
+// setSessionCookie sets the session cookie according to the policy
+func (p CookiePolicy) setSessionCookie(w http.ResponseWriter, sessionToken string, maxAge time.Duration) {
+	http.SetCookie(w, p.cookie(sessionToken, int(maxAge.Round(time.Second).Seconds())))
 }
 
 // clearSessionCookie clears the session cookie
-func clearSessionCookie(w http.ResponseWriter) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     SessionCookieName,
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false,
-		SameSite: http.SameSiteStrictMode,
-		MaxAge:   -1,
-	})
+func (p CookiePolicy) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, p.cookie("", -1))
 }
 
 // getSessionToken extracts the session token from cookies
-func getSessionToken(r *http.Request) string {
-	cookie, err := r.Cookie(SessionCookieName)
+func (p CookiePolicy) getSessionToken(r *http.Request) string {
+	cookie, err := r.Cookie(p.Name)
 	if err != nil {
 		return ""
 	}
 	return cookie.Value
 }
 
-// authMiddleware creates authentication middleware
-func authMiddleware(authToken string) func(http.Handler) http.Handler {
+func (p CookiePolicy) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     p.Name,
+		Value:    value,
+		Path:     "/",
+		Domain:   p.Domain,
+		HttpOnly: true,
+		// Browsers reject SameSite=None cookies that are not Secure
+		Secure:   p.Secure || p.SameSite == http.SameSiteNoneMode,
+		SameSite: p.SameSite,
+		MaxAge:   maxAge,
+	}
+}
+
+// sessionFromContext returns the session attached by authMiddleware, if any
+func sessionFromContext(ctx context.Context) *session.Session {
+	s, _ := ctx.Value(sessionContextKey).(*session.Session)
+	return s
+}
+
+// apiKeyFromContext returns the API key attached by authMiddleware, if any
+func apiKeyFromContext(ctx context.Context) *apikeys.Key {
+	key, _ := ctx.Value(apiKeyContextKey).(*apikeys.Key)
+	return key
+}
+
+// apiKeyAllowed reports whether an API key may call the endpoint at path.
+// Administration, sessions, second factors and the keys themselves need a
+// login.
+func apiKeyAllowed(path string) bool {
+	return !strings.HasPrefix(path, "/api/admin/") && !strings.HasPrefix(path, "/api/auth/") &&
+		path != "/api/keys" && !strings.HasPrefix(path, "/api/keys/")
+}
+
+// clientIP returns the address of the directly connected client
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// authMiddleware creates authentication middleware. Scripts may send an API
+// key as a bearer token instead of logging in; verifyKey checks it, and nil
+// disables that. API keys can't manage accounts, sessions, second factors or
+// keys, and failed ones count towards the login lockout.
+func authMiddleware(sessions *session.Store, cookies CookiePolicy, verifyKey func(string) (*apikeys.Key, error), lockout *ratelimit.Lockout) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Skip authentication for login page and API endpoints
 			if r.URL.Path == "/login" || r.URL.Path == "/api/auth/login" ||
-				r.URL.Path == "/api/auth/logout" || strings.HasPrefix(r.URL.Path, "/static/") {
+				r.URL.Path == "/api/auth/logout" || strings.HasPrefix(r.URL.Path, "/api/auth/oidc/") ||
+				strings.HasPrefix(r.URL.Path, "/static/") || strings.HasPrefix(r.URL.Path, "/embed/") ||
+				r.URL.Path == "/api/embed/upload" {
 				next.ServeHTTP(w, r)
 				return
 			}
 
+			if token := bearerToken(r); token != "" {
+				ip := clientIP(r)
+				if locked := lockout.Locked(ip); locked > 0 {
+					sendTooManyRequests(w, r, locked)
+					return
+				}
+				if verifyKey == nil {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				key, err := verifyKey(token)
+				if err != nil {
+					if !errors.Is(err, apikeys.ErrInvalidKey) {
+						log.Printf("Failed to verify API key: %v", err)
+						http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+						return
+					}
+					lockout.Fail(ip)
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				lockout.Reset(ip)
+				if !apiKeyAllowed(r.URL.Path) {
+					sendErrorResponse(w, "API keys can't be used for this endpoint; log in instead", http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, key)))
+				return
+			}
+
 			// Check for valid session
-			sessionToken := getSessionToken(r)
+			sessionToken := cookies.getSessionToken(r)
 			if sessionToken == "" {
 				// Redirect to login page for HTML requests
 				if strings.Contains(r.Header.Get("Accept"), "text/html") {
@@ -122,9 +207,9 @@ func authMiddleware(authToken string) func(http.Handler) http.Handler {
 			}
 
 			// Validate session token
-			valid, err := validateSessionToken(sessionToken, authToken)
-			if err != nil || !valid {
-				clearSessionCookie(w)
+			current, refreshed, err := sessions.Validate(sessionToken)
+			if err != nil {
+				cookies.clearSessionCookie(w)
 				if strings.Contains(r.Header.Get("Accept"), "text/html") {
 					http.Redirect(w, r, "/login", http.StatusSeeOther)
 					return
@@ -133,23 +218,57 @@ func authMiddleware(authToken string) func(http.Handler) http.Handler {
 				return
 			}
 
+			// Reissue the cookie so its lifetime follows the refreshed session
+			if refreshed {
+				cookies.setSessionCookie(w, sessionToken, time.Until(current.ExpiresAt))
+			}
+
 			// Session is valid, proceed
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionContextKey, current)))
 		})
 	}
 }
 
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+// corsMiddleware answers preflight requests and adds CORS headers for
+// origins allowed by the current policy. It does nothing when no origins
+// are allowed.
+func corsMiddleware(current func() CORSPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy := current()
+			if len(policy.AllowedOrigins) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			origin := r.Header.Get("Origin")
+			w.Header().Add("Vary", "Origin")
+			if origin == "" || !policy.allows(origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
+			// A wildcard can't be combined with credentials, so echo the origin instead
+			if policy.AllowCredentials || !slices.Contains(policy.AllowedOrigins, "*") {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			}
+			if policy.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.AllowedMethods, ", "))
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.AllowedHeaders, ", "))
+				if policy.MaxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
 
-		next.ServeHTTP(w, r)
-	})
+			next.ServeHTTP(w, r)
+		})
+	}
 }
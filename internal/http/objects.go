@@ -0,0 +1,228 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"path"
+
+	"soxdrawer/internal/audit"
+	"soxdrawer/internal/quota"
+	"soxdrawer/internal/store"
+
+	"github.com/nats-io/nats.go"
+)
+
+type (
+	ObjectOperationRequest struct {
+		Key    string `json:"key"`
+		NewKey string `json:"new_key"`
+		Bucket string `json:"bucket,omitempty"` // Destination bucket for moves and copies
+	}
+
+	ObjectOperationResponse struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Key     string `json:"key,omitempty"`
+		Bucket  string `json:"bucket,omitempty"`
+		Size    int64  `json:"size,omitempty"`
+	}
+)
+
+// renameHandler renames an object within its bucket
+func (s *Server) renameHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeObjectOperation(w, r)
+	if !ok {
+		return
+	}
+
+	info, err := s.ObjectStore.Rename(req.Key, req.NewKey)
+	s.audit(r, audit.Record{Action: audit.ActionRename, Outcome: outcome(err), Bucket: s.ObjectStore.Name(), Key: req.Key, Detail: "to " + req.NewKey})
+	if err != nil {
+		sendObjectOperationError(w, "rename", req, err)
+		return
+	}
+
+	log.Printf("Renamed object %s to %s", req.Key, req.NewKey)
+	sendJSONResponse(w, http.StatusOK, ObjectOperationResponse{
+		Status:  "success",
+		Message: "Object renamed successfully",
+		Key:     info.Name,
+		Bucket:  s.ObjectStore.Name(),
+		Size:    int64(info.Size),
+	})
+}
+
+// copyHandler copies an object, optionally into another bucket
+func (s *Server) copyHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeObjectOperation(w, r)
+	if !ok {
+		return
+	}
+
+	dst := s.ObjectStore
+	if req.Bucket != "" && req.Bucket != s.ObjectStore.Name() {
+		var err error
+		dst, err = s.ObjectStore.OpenBucket(req.Bucket)
+		if err != nil {
+			sendErrorResponse(w, "Destination bucket not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	// The copy belongs to whoever made it
+	actor := requestActor(r)
+	upload, err := s.reserveCopy(dst, req.Key, actor)
+	var info *nats.ObjectInfo
+	if err == nil {
+		defer upload.Done()
+		if dst == s.ObjectStore {
+			info, err = s.ObjectStore.Copy(req.Key, req.NewKey, actor)
+		} else {
+			// A cross-bucket copy is a move that keeps the original
+			info, err = s.ObjectStore.CopyTo(dst, req.Key, req.NewKey, actor)
+		}
+		if err == nil {
+			upload.Stored(info.Name, int64(info.Size))
+		}
+	}
+	s.audit(r, audit.Record{Action: audit.ActionCopy, Outcome: operationOutcome(err), Bucket: s.ObjectStore.Name(), Key: req.Key, Detail: "to " + dst.Name() + "/" + req.NewKey})
+	if err != nil {
+		sendObjectOperationError(w, "copy", req, err)
+		return
+	}
+
+	log.Printf("Copied object %s to %s/%s", req.Key, dst.Name(), req.NewKey)
+	sendJSONResponse(w, http.StatusOK, ObjectOperationResponse{
+		Status:  "success",
+		Message: "Object copied successfully",
+		Key:     info.Name,
+		Bucket:  dst.Name(),
+		Size:    int64(info.Size),
+	})
+}
+
+// moveHandler moves an object to a new key, optionally in another bucket
+func (s *Server) moveHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeObjectOperation(w, r)
+	if !ok {
+		return
+	}
+
+	dst := s.ObjectStore
+	if req.Bucket != "" && req.Bucket != s.ObjectStore.Name() {
+		var err error
+		dst, err = s.ObjectStore.OpenBucket(req.Bucket)
+		if err != nil {
+			sendErrorResponse(w, "Destination bucket not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	info, err := s.moveObject(dst, req.Key, req.NewKey)
+	s.audit(r, audit.Record{Action: audit.ActionMove, Outcome: operationOutcome(err), Bucket: s.ObjectStore.Name(), Key: req.Key, Detail: "to " + dst.Name() + "/" + req.NewKey})
+	if err != nil {
+		sendObjectOperationError(w, "move", req, err)
+		return
+	}
+
+	log.Printf("Moved object %s to %s/%s", req.Key, dst.Name(), req.NewKey)
+	sendJSONResponse(w, http.StatusOK, ObjectOperationResponse{
+		Status:  "success",
+		Message: "Object moved successfully",
+		Key:     info.Name,
+		Bucket:  dst.Name(),
+		Size:    int64(info.Size),
+	})
+}
+
+// moveObject moves key to newKey in dst. Moves into another bucket are
+// charged to its quota first; moves within a bucket don't change usage.
+func (s *Server) moveObject(dst *store.ObjectStore, key, newKey string) (*nats.ObjectInfo, error) {
+	if dst == s.ObjectStore {
+		return s.ObjectStore.MoveTo(dst, key, newKey)
+	}
+	upload, err := s.reserveCopy(dst, key, "")
+	if err != nil {
+		return nil, err
+	}
+	defer upload.Done()
+	info, err := s.ObjectStore.MoveTo(dst, key, newKey)
+	if err == nil {
+		upload.Stored(info.Name, int64(info.Size))
+	}
+	return info, err
+}
+
+// reserveCopy checks that a copy of key fits within the quotas of dst and
+// of owner, who the copy is charged to, and reserves room for it. An empty
+// owner charges the object's own. The reservation is nil when quotas are
+// off.
+func (s *Server) reserveCopy(dst *store.ObjectStore, key, owner string) (*quota.Upload, error) {
+	if s.quotas == nil {
+		return nil, nil
+	}
+	info, err := s.ObjectStore.GetInfo(key)
+	if err != nil {
+		return nil, err
+	}
+	if owner == "" {
+		owner = store.Owner(info)
+	}
+	return s.quotas.Reserve(dst.Name(), owner, int64(info.Size))
+}
+
+// operationOutcome maps an error to the audit outcome of an object
+// operation, which is denied when it would exceed a quota
+func operationOutcome(err error) string {
+	if errors.Is(err, quota.ErrQuotaExceeded) {
+		return audit.OutcomeDenied
+	}
+	return outcome(err)
+}
+
+// decodeObjectOperation parses and sanitizes a rename, copy or move request.
+// A new key without a folder keeps the folder of the source key.
+func decodeObjectOperation(w http.ResponseWriter, r *http.Request) (*ObjectOperationRequest, bool) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	var req ObjectOperationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+	if req.Key == "" || req.NewKey == "" {
+		sendErrorResponse(w, "Both key and new_key are required", http.StatusBadRequest)
+		return nil, false
+	}
+
+	newKey := sanitizePath(req.NewKey)
+	if path.Base(newKey) == newKey {
+		if dir := path.Dir(req.Key); dir != "." {
+			newKey = joinPath(dir, newKey)
+		}
+	}
+	req.NewKey = newKey
+
+	return &req, true
+}
+
+func sendObjectOperationError(w http.ResponseWriter, operation string, req *ObjectOperationRequest, err error) {
+	switch {
+	case errors.Is(err, store.ErrInvalidKey):
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, nats.ErrObjectNotFound):
+		sendErrorResponse(w, "Object not found", http.StatusNotFound)
+	case errors.Is(err, nats.ErrObjectAlreadyExists):
+		sendErrorResponse(w, "An object with that key already exists", http.StatusConflict)
+	case errors.Is(err, quota.ErrQuotaExceeded):
+		sendErrorResponse(w, err.Error(), http.StatusRequestEntityTooLarge)
+	default:
+		log.Printf("Failed to %s object %s to %s: %v", operation, req.Key, req.NewKey, err)
+		sendErrorResponse(w, "Failed to "+operation+" object", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,121 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"soxdrawer/internal/quota"
+	"soxdrawer/internal/store"
+	"soxdrawer/internal/users"
+)
+
+// newQuotaTestServer returns a logged-in browser for a server whose
+// buckets hold at most maxBytes, with key stored in the default bucket and
+// an empty archive bucket
+func newQuotaTestServer(t *testing.T, maxBytes int64, key, data string) (*Server, *browser) {
+	t.Helper()
+	server := newTestServer(t, func(config *Config, js nats.JetStreamContext) {
+		config.Quotas = quota.New(js, quota.Config{BucketDefault: quota.Quota{MaxBytes: maxBytes}})
+		if _, err := store.Open(js, "archive", 1); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err := server.quotas.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.quotas.Stop)
+
+	if _, err := server.ObjectStore.PutOwned(key, "alice", strings.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	waitForUsage(t, server.quotas, server.ObjectStore.Name(), int64(len(data)))
+
+	b := newBrowser(t, server.routes())
+	b.login(testToken)
+	return server, b
+}
+
+// waitForUsage waits until the tracker has counted bytes in bucket
+func waitForUsage(t *testing.T, tracker *quota.Tracker, bucket string, bytes int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, usage := range tracker.Buckets() {
+			if usage.Name == bucket && usage.Bytes == bytes {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("bucket %s never reached %d bytes: %+v", bucket, bytes, tracker.Buckets())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCopyChecksQuota(t *testing.T) {
+	server, b := newQuotaTestServer(t, 10, "report.txt", "123456")
+
+	response := b.postJSON("/api/objects/copy", `{"key": "report.txt", "new_key": "copy.txt"}`)
+	if response.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("copy over quota = %d, want %d", response.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+	if exists, _ := server.ObjectStore.Exists("copy.txt"); exists {
+		t.Fatal("copy over quota was stored")
+	}
+}
+
+func TestMoveChecksDestinationQuota(t *testing.T) {
+	server, b := newQuotaTestServer(t, 10, "report.txt", "123456")
+	other, err := server.ObjectStore.OpenBucket("archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.PutOwned("old.txt", "bob", strings.NewReader("1234567")); err != nil {
+		t.Fatal(err)
+	}
+	waitForUsage(t, server.quotas, "archive", 7)
+
+	response := b.postJSON("/api/objects/move", `{"key": "report.txt", "new_key": "report.txt", "bucket": "archive"}`)
+	if response.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("move over quota = %d, want %d", response.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+	if exists, _ := server.ObjectStore.Exists("report.txt"); !exists {
+		t.Fatal("move over quota removed the original")
+	}
+
+	response = b.postJSON("/api/bulk", `{"action": "move", "keys": ["report.txt"], "destination": {"bucket": "archive"}}`)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("bulk move = %d, want %d", response.StatusCode, http.StatusOK)
+	}
+	if exists, _ := server.ObjectStore.Exists("report.txt"); !exists {
+		t.Fatal("bulk move over quota removed the original")
+	}
+}
+
+func TestCopyBelongsToTheActor(t *testing.T) {
+	server, b := newQuotaTestServer(t, 1000, "report.txt", "123456")
+
+	response := b.postJSON("/api/objects/copy", `{"key": "report.txt", "new_key": "copy.txt"}`)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("copy = %d, want %d", response.StatusCode, http.StatusOK)
+	}
+	info, err := server.ObjectStore.GetInfo("copy.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner := store.Owner(info); owner != users.AdminUser {
+		t.Fatalf("copy belongs to %q, want %q", owner, users.AdminUser)
+	}
+
+	waitForUsage(t, server.quotas, server.ObjectStore.Name(), 12)
+	if usage := server.quotas.User(users.AdminUser); usage.Bytes != 6 {
+		t.Fatalf("%s is charged %d bytes, want 6", users.AdminUser, usage.Bytes)
+	}
+	if usage := server.quotas.User("alice"); usage.Bytes != 6 {
+		t.Fatalf("alice is charged %d bytes, want 6", usage.Bytes)
+	}
+}
@@ -0,0 +1,315 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"soxdrawer/internal/audit"
+	"soxdrawer/internal/oidc"
+	"soxdrawer/internal/users"
+)
+
+const (
+	// oidcCookieName holds the state, nonce and PKCE verifier between the
+	// redirect to the provider and the callback
+	oidcCookieName = "soxdrawer_oidc"
+	oidcCookieTTL  = 10 * time.Minute
+
+	// pendingCookieName holds the ticket of a single sign-on that still
+	// needs the user's second factor
+	pendingCookieName = "soxdrawer_2fa"
+	pendingTTL        = 5 * time.Minute
+)
+
+type (
+	// pendingLogins remembers single sign-ons waiting for a second factor.
+	// Like the rate limits they are kept in memory, so the code has to reach
+	// the server that handled the callback.
+	pendingLogins struct {
+		mu      sync.Mutex
+		pending map[string]pendingLogin // By ticket
+	}
+
+	pendingLogin struct {
+		user    string
+		expires time.Time
+	}
+)
+
+func newPendingLogins() *pendingLogins {
+	return &pendingLogins{pending: map[string]pendingLogin{}}
+}
+
+// add starts a pending login for user and returns its ticket
+func (p *pendingLogins) add(user string) (string, error) {
+	ticket, err := generateCSRFToken()
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for key, login := range p.pending {
+		if now.After(login.expires) {
+			delete(p.pending, key)
+		}
+	}
+	p.pending[ticket] = pendingLogin{user: user, expires: now.Add(pendingTTL)}
+	return ticket, nil
+}
+
+// get returns the user waiting on a ticket
+func (p *pendingLogins) get(ticket string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	login, ok := p.pending[ticket]
+	if !ok || time.Now().After(login.expires) {
+		return "", false
+	}
+	return login.user, true
+}
+
+func (p *pendingLogins) remove(ticket string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pending, ticket)
+}
+
+// oidcLoginHandler starts single sign-on by redirecting to the provider
+func (s *Server) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	challenge, err := oidc.NewChallenge()
+	if err != nil {
+		log.Printf("Failed to start OIDC login: %v", err)
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	// The callback is a cross-site navigation from the provider, so this
+	// cookie must be Lax rather than the session cookie's policy
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    strings.Join([]string{challenge.State, challenge.Nonce, challenge.Verifier}, "."),
+		Path:     "/api/auth/oidc/",
+		HttpOnly: true,
+		Secure:   s.cookies.Secure,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(oidcCookieTTL.Seconds()),
+	})
+
+	http.Redirect(w, r, s.oidc.AuthCodeURL(challenge), http.StatusFound)
+}
+
+// oidcCallbackHandler completes single sign-on: it checks the state,
+// exchanges the code, provisions the user and starts a session, or sends
+// users with a second factor to the login page for their code
+func (s *Server) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	challenge := readOIDCChallenge(r)
+	http.SetCookie(w, &http.Cookie{Name: oidcCookieName, Path: "/api/auth/oidc/", MaxAge: -1})
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		log.Printf("OIDC provider returned error: %s %s", providerErr, query.Get("error_description"))
+		s.audit(r, audit.Record{Action: audit.ActionLogin, Outcome: audit.OutcomeFailure, Detail: "oidc: provider error " + providerErr})
+		redirectLoginError(w, r, "Sign-in was cancelled or denied by the identity provider")
+		return
+	}
+	if challenge == nil || query.Get("state") == "" || query.Get("state") != challenge.State {
+		s.audit(r, audit.Record{Action: audit.ActionLogin, Outcome: audit.OutcomeFailure, Detail: "oidc: state mismatch"})
+		redirectLoginError(w, r, "Sign-in expired, please try again")
+		return
+	}
+
+	identity, err := s.oidc.Exchange(r.Context(), query.Get("code"), challenge)
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		s.audit(r, audit.Record{Action: audit.ActionLogin, Outcome: audit.OutcomeFailure, Detail: "oidc: " + err.Error()})
+		if errors.Is(err, oidc.ErrNoRole) {
+			redirectLoginError(w, r, "Your account is not allowed to use soxdrawer")
+			return
+		}
+		redirectLoginError(w, r, "Sign-in failed")
+		return
+	}
+
+	user, err := s.users.Provision(identity.Username, identity.Role, identity.Issuer, identity.Subject, identity.Email)
+	if err != nil {
+		log.Printf("Failed to provision OIDC user %s: %v", identity.Username, err)
+		s.audit(r, audit.Record{Actor: identity.Username, Action: audit.ActionLogin, Outcome: audit.OutcomeFailure, Detail: "oidc: " + err.Error()})
+		if errors.Is(err, users.ErrUserConflict) {
+			redirectLoginError(w, r, "That user name is already taken by another account")
+			return
+		}
+		redirectLoginError(w, r, "Failed to create your account")
+		return
+	}
+
+	// Users with a second factor enter it on the login page before they get
+	// a session, as with token logins
+	if err := s.users.VerifySecondFactor(user.Name, "", ""); err != nil {
+		if !errors.Is(err, users.ErrTOTPRequired) {
+			log.Printf("Failed to check second factor for %s: %v", user.Name, err)
+			redirectLoginError(w, r, "Sign-in failed")
+			return
+		}
+		ticket, err := s.pending.add(user.Name)
+		if err != nil {
+			log.Printf("Failed to start two-factor login for %s: %v", user.Name, err)
+			redirectLoginError(w, r, "Sign-in failed")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     pendingCookieName,
+			Value:    ticket,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   s.cookies.Secure,
+			SameSite: http.SameSiteLaxMode,
+			MaxAge:   int(pendingTTL.Seconds()),
+		})
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if !s.startOIDCSession(w, r, user) {
+		redirectLoginError(w, r, "Failed to create session")
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// oidcSecondFactorHandler finishes a single sign-on that is waiting for the
+// user's TOTP or recovery code: POST /api/auth/oidc/totp
+func (s *Server) oidcSecondFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ip := clientIP(r)
+	if locked := s.limits.lockout.Locked(ip); locked > 0 {
+		sendTooManyRequests(w, r, locked)
+		return
+	}
+	if ok, wait := s.limits.loginByIP.Allow(ip); !ok {
+		sendTooManyRequests(w, r, wait)
+		return
+	}
+
+	ticket := s.pendingTicket(r)
+	name, ok := s.pending.get(ticket)
+	if !ok {
+		sendErrorResponse(w, "Sign-in expired, please try again", http.StatusUnauthorized)
+		return
+	}
+
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.users.VerifySecondFactor(name, req.Code, req.RecoveryCode); err != nil {
+		if errors.Is(err, users.ErrTOTPRequired) {
+			sendJSONResponse(w, http.StatusUnauthorized, LoginResponse{
+				Status:  LoginTOTPRequired,
+				Message: "Enter the code from your authenticator app",
+			})
+			return
+		}
+		if errors.Is(err, users.ErrInvalidTOTP) {
+			if locked := s.limits.lockout.Fail(ip); locked > 0 {
+				log.Printf("Locked out %s for %s after repeated failed logins", ip, locked)
+			}
+			s.audit(r, audit.Record{Actor: name, Action: audit.ActionLogin, Outcome: audit.OutcomeFailure, Detail: "oidc: invalid two-factor code"})
+			sendErrorResponse(w, "Invalid two-factor code", http.StatusUnauthorized)
+			return
+		}
+		log.Printf("Failed to verify second factor for %s: %v", name, err)
+		sendErrorResponse(w, "Failed to verify two-factor code", http.StatusInternalServerError)
+		return
+	}
+	s.limits.lockout.Reset(ip)
+	s.pending.remove(ticket)
+	http.SetCookie(w, &http.Cookie{Name: pendingCookieName, Path: "/", MaxAge: -1})
+
+	user, err := s.users.Get(name)
+	if err != nil {
+		log.Printf("Failed to load user %s: %v", name, err)
+		sendErrorResponse(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+	if !s.startOIDCSession(w, r, user) {
+		sendErrorResponse(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	sendJSONResponse(w, http.StatusOK, LoginResponse{
+		Status:  "success",
+		Message: "Authentication successful",
+	})
+}
+
+// startOIDCSession logs a signed-on user in, reporting whether it worked
+func (s *Server) startOIDCSession(w http.ResponseWriter, r *http.Request, user *users.User) bool {
+	sessionToken, current, err := s.sessions.Create(user.Name, r.UserAgent(), clientIP(r))
+	if err != nil {
+		log.Printf("Failed to create session: %v", err)
+		return false
+	}
+	s.cookies.setSessionCookie(w, sessionToken, time.Until(current.ExpiresAt))
+	s.audit(r, audit.Record{Actor: user.Name, Action: audit.ActionLogin, Outcome: audit.OutcomeSuccess, Detail: "oidc"})
+
+	log.Printf("User %s signed in via OIDC with role %s", user.Name, user.Role)
+	return true
+}
+
+// pendingTicket returns the ticket of the request's pending second-factor
+// login, if any
+func (s *Server) pendingTicket(r *http.Request) string {
+	cookie, err := r.Cookie(pendingCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+func readOIDCChallenge(r *http.Request) *oidc.Challenge {
+	cookie, err := r.Cookie(oidcCookieName)
+	if err != nil {
+		return nil
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	return &oidc.Challenge{State: parts[0], Nonce: parts[1], Verifier: parts[2]}
+}
+
+// redirectLoginError sends the browser back to the login page with a message
+func redirectLoginError(w http.ResponseWriter, r *http.Request, message string) {
+	http.Redirect(w, r, "/login?error="+url.QueryEscape(message), http.StatusSeeOther)
+}
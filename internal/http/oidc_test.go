@@ -0,0 +1,334 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/nats-io/nats.go"
+	"github.com/pquerna/otp/totp"
+
+	"soxdrawer/internal/oidc"
+	"soxdrawer/internal/users"
+)
+
+const testClientID = "soxdrawer"
+
+// testIssuer is a minimal OpenID provider. The test plays the browser at
+// the authorization endpoint by calling authorize; the token endpoint checks
+// the PKCE verifier and returns a signed ID token.
+type testIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu        sync.Mutex
+	grants    map[string]testGrant // By authorization code
+	exchanges int
+}
+
+type testGrant struct {
+	challenge string
+	nonce     string
+	claims    map[string]any
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &testIssuer{key: key, grants: map[string]testGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                issuer.URL,
+			"authorization_endpoint":                issuer.URL + "/authorize",
+			"token_endpoint":                        issuer.URL + "/token",
+			"jwks_uri":                              issuer.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: string(jose.RS256), Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", issuer.token)
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+// authorize approves the login the browser was redirected to and returns
+// the callback query the provider would send it back with
+func (i *testIssuer) authorize(t *testing.T, location string, claims map[string]any) url.Values {
+	t.Helper()
+	redirect, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := redirect.Query()
+	if method := query.Get("code_challenge_method"); method != "S256" {
+		t.Fatalf("code_challenge_method = %q, want S256", method)
+	}
+	if query.Get("code_challenge") == "" || query.Get("nonce") == "" || query.Get("state") == "" {
+		t.Fatalf("authorization request is missing PKCE, nonce or state: %s", location)
+	}
+
+	code := rand.Text()
+	i.mu.Lock()
+	i.grants[code] = testGrant{challenge: query.Get("code_challenge"), nonce: query.Get("nonce"), claims: claims}
+	i.mu.Unlock()
+	return url.Values{"code": {code}, "state": {query.Get("state")}}
+}
+
+func (i *testIssuer) token(w http.ResponseWriter, r *http.Request) {
+	i.mu.Lock()
+	i.exchanges++
+	grant, ok := i.grants[r.FormValue("code")]
+	delete(i.grants, r.FormValue("code"))
+	i.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: i.key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "test"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	idToken, err := jwt.Signed(signer).Claims(jwt.Claims{
+		Issuer:   i.URL,
+		Subject:  "sub-" + grant.claims["preferred_username"].(string),
+		Audience: jwt.Audience{testClientID},
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(time.Minute)),
+	}).Claims(map[string]any{"nonce": grant.nonce}).Claims(grant.claims).Serialize()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+func newOIDCTestServer(t *testing.T) (*Server, *testIssuer) {
+	t.Helper()
+	issuer := newTestIssuer(t)
+	provider, err := oidc.New(context.Background(), oidc.Config{
+		Issuer:      issuer.URL,
+		ClientID:    testClientID,
+		RedirectURL: "http://soxdrawer.test/api/auth/oidc/callback",
+		RoleClaim:   "groups",
+		RoleMappings: map[string]string{
+			"sox-admins": users.RoleAdmin,
+			"sox-users":  users.RoleUser,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(t, func(config *Config, _ nats.JetStreamContext) {
+		config.OIDC = provider
+	})
+	return server, issuer
+}
+
+// startOIDCLogin follows the login redirect and returns where it went
+func startOIDCLogin(t *testing.T, b *browser) string {
+	t.Helper()
+	response := b.get("/api/auth/oidc/login")
+	if response.StatusCode != http.StatusFound {
+		t.Fatalf("login status = %d, want %d", response.StatusCode, http.StatusFound)
+	}
+	return response.Header.Get("Location")
+}
+
+func callback(b *browser, query url.Values) *http.Response {
+	return b.get("/api/auth/oidc/callback?" + query.Encode())
+}
+
+func claims(name string, groups ...string) map[string]any {
+	return map[string]any{"preferred_username": name, "groups": groups}
+}
+
+func TestOIDCLoginWithPKCE(t *testing.T) {
+	server, issuer := newOIDCTestServer(t)
+	b := newBrowser(t, server.routes())
+
+	query := issuer.authorize(t, startOIDCLogin(t, b), claims("alice", "sox-users"))
+	response := callback(b, query)
+
+	if response.StatusCode != http.StatusSeeOther || response.Header.Get("Location") != "/" {
+		t.Fatalf("callback = %d to %q, want %d to /", response.StatusCode, response.Header.Get("Location"), http.StatusSeeOther)
+	}
+	if _, ok := b.cookies[SessionCookieName]; !ok {
+		t.Fatal("callback did not set a session cookie")
+	}
+	if response := b.get("/api/auth/sessions"); response.StatusCode != http.StatusOK {
+		t.Fatalf("sessions after login = %d, want %d", response.StatusCode, http.StatusOK)
+	}
+}
+
+func TestOIDCLoginRejectsWrongVerifier(t *testing.T) {
+	server, issuer := newOIDCTestServer(t)
+	b := newBrowser(t, server.routes())
+
+	query := issuer.authorize(t, startOIDCLogin(t, b), claims("alice", "sox-users"))
+	state, nonce, _ := strings.Cut(b.cookies[oidcCookieName].Value, ".")
+	nonce, _, _ = strings.Cut(nonce, ".")
+	b.cookies[oidcCookieName].Value = state + "." + nonce + ".not-the-verifier"
+
+	response := callback(b, query)
+	if location := response.Header.Get("Location"); !strings.HasPrefix(location, "/login?error=") {
+		t.Fatalf("callback redirected to %q, want a login error", location)
+	}
+	if _, ok := b.cookies[SessionCookieName]; ok {
+		t.Fatal("callback set a session cookie for a wrong PKCE verifier")
+	}
+}
+
+func TestOIDCLoginStateMismatch(t *testing.T) {
+	server, issuer := newOIDCTestServer(t)
+	b := newBrowser(t, server.routes())
+
+	query := issuer.authorize(t, startOIDCLogin(t, b), claims("alice", "sox-users"))
+	query.Set("state", "forged")
+
+	response := callback(b, query)
+	if location := response.Header.Get("Location"); !strings.HasPrefix(location, "/login?error=") {
+		t.Fatalf("callback redirected to %q, want a login error", location)
+	}
+	if _, ok := b.cookies[SessionCookieName]; ok {
+		t.Fatal("callback set a session cookie for a forged state")
+	}
+	if issuer.exchanges != 0 {
+		t.Fatalf("code was exchanged %d times despite the state mismatch", issuer.exchanges)
+	}
+}
+
+func TestOIDCRoleMapping(t *testing.T) {
+	server, issuer := newOIDCTestServer(t)
+
+	tests := []struct {
+		name   string
+		groups []string
+		role   string // Empty when the login must be refused
+	}{
+		{"user", []string{"sox-users"}, users.RoleUser},
+		{"root", []string{"sox-admins"}, users.RoleAdmin},
+		{"both", []string{"sox-users", "sox-admins"}, users.RoleAdmin},
+		{"unmapped", []string{"staff"}, ""},
+		{"nogroups", nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newBrowser(t, server.routes())
+			response := callback(b, issuer.authorize(t, startOIDCLogin(t, b), claims(test.name, test.groups...)))
+
+			user, err := server.users.Get(test.name)
+			if test.role == "" {
+				if location := response.Header.Get("Location"); !strings.HasPrefix(location, "/login?error=") {
+					t.Fatalf("callback redirected to %q, want a login error", location)
+				}
+				if err == nil {
+					t.Fatalf("user %s was provisioned without a role", test.name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("user %s was not provisioned: %v", test.name, err)
+			}
+			if user.Role != test.role {
+				t.Fatalf("role = %q, want %q", user.Role, test.role)
+			}
+		})
+	}
+}
+
+func TestOIDCLoginRequiresSecondFactor(t *testing.T) {
+	server, issuer := newOIDCTestServer(t)
+
+	// A first login provisions the account so it can enroll
+	first := newBrowser(t, server.routes())
+	callback(first, issuer.authorize(t, startOIDCLogin(t, first), claims("carol", "sox-users")))
+	enrollment, err := server.users.BeginTOTP("carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.GenerateCode(enrollment.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoveryCodes, err := server.users.ConfirmTOTP("carol", code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := newBrowser(t, server.routes())
+	response := callback(b, issuer.authorize(t, startOIDCLogin(t, b), claims("carol", "sox-users")))
+	if location := response.Header.Get("Location"); location != "/login" {
+		t.Fatalf("callback redirected to %q, want /login for the second factor", location)
+	}
+	if _, ok := b.cookies[SessionCookieName]; ok {
+		t.Fatal("callback set a session cookie before the second factor")
+	}
+	if response := b.get("/api/list"); response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("list before the second factor = %d, want %d", response.StatusCode, http.StatusUnauthorized)
+	}
+
+	page := b.get("/login")
+	body := new(strings.Builder)
+	if _, err := io.Copy(body, page.Body); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body.String(), "secondFactorForm") {
+		t.Fatal("login page does not ask for the second factor")
+	}
+
+	verify := func(body string) *http.Response {
+		r := httptest.NewRequest(http.MethodPost, "/api/auth/oidc/totp", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		return b.do(r)
+	}
+	if response := verify(`{"code": "000000"}`); response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("wrong code = %d, want %d", response.StatusCode, http.StatusUnauthorized)
+	}
+	if response := verify(`{"recovery_code": "` + recoveryCodes[0] + `"}`); response.StatusCode != http.StatusOK {
+		t.Fatalf("recovery code = %d, want %d", response.StatusCode, http.StatusOK)
+	}
+	if _, ok := b.cookies[SessionCookieName]; !ok {
+		t.Fatal("second factor did not set a session cookie")
+	}
+	if response := verify(`{"recovery_code": "` + recoveryCodes[1] + `"}`); response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("reusing the pending login = %d, want %d", response.StatusCode, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,95 @@
+package http
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"soxdrawer/internal/ratelimit"
+)
+
+type (
+	// RateLimitConfig holds the request limits applied to sensitive endpoints
+	RateLimitConfig struct {
+		Login    ratelimit.Rate
+		Upload   ratelimit.Rate
+		Download ratelimit.Rate
+		Lockout  ratelimit.LockoutConfig
+	}
+
+	// rateLimits holds the limiters built from a RateLimitConfig. Uploads
+	// and downloads are limited per client IP and per credential (session or
+	// API key). Logins are limited per client IP, so one client guessing
+	// can't lock everyone else out; the lockout slows down each guesser.
+	rateLimits struct {
+		loginByIP            *ratelimit.Limiter
+		uploadByIP           *ratelimit.Limiter
+		uploadByCredential   *ratelimit.Limiter
+		downloadByIP         *ratelimit.Limiter
+		downloadByCredential *ratelimit.Limiter
+		lockout              *ratelimit.Lockout
+	}
+)
+
+// DefaultRateLimitConfig returns the limits used when none are configured
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Login:    ratelimit.Rate{PerMinute: 10, Burst: 5},
+		Upload:   ratelimit.Rate{PerMinute: 120, Burst: 30},
+		Download: ratelimit.Rate{PerMinute: 600, Burst: 100},
+		Lockout: ratelimit.LockoutConfig{
+			MaxFailures: 5,
+			BaseDelay:   30 * time.Second,
+			MaxDelay:    time.Hour,
+		},
+	}
+}
+
+func newRateLimits(config RateLimitConfig) *rateLimits {
+	return &rateLimits{
+		loginByIP:            ratelimit.New(config.Login),
+		uploadByIP:           ratelimit.New(config.Upload),
+		uploadByCredential:   ratelimit.New(config.Upload),
+		downloadByIP:         ratelimit.New(config.Download),
+		downloadByCredential: ratelimit.New(config.Download),
+		lockout:              ratelimit.NewLockout(config.Lockout),
+	}
+}
+
+// rateLimit wraps a handler with per-IP and per-session or per-API-key
+// token buckets
+func rateLimit(byIP, byCredential *ratelimit.Limiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := byIP.Allow(clientIP(r)); !ok {
+			sendTooManyRequests(w, r, wait)
+			return
+		}
+		credential := ""
+		if current := sessionFromContext(r.Context()); current != nil {
+			credential = current.ID
+		} else if key := apiKeyFromContext(r.Context()); key != nil {
+			credential = "api-key:" + key.ID
+		}
+		if credential != "" {
+			if ok, wait := byCredential.Allow(credential); !ok {
+				sendTooManyRequests(w, r, wait)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// sendTooManyRequests responds with 429 and a Retry-After header in whole seconds
+func sendTooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	log.Printf("Rate limited %s %s from %s for %ds", r.Method, r.URL.Path, clientIP(r), seconds)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	sendErrorResponse(w, "Too many requests, try again later", http.StatusTooManyRequests)
+}
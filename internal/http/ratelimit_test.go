@@ -0,0 +1,32 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestFailedLoginsOnlyLimitTheirOwnIP(t *testing.T) {
+	handler := newTestServer(t, nil).routes()
+
+	guesser := newBrowser(t, handler)
+	guesser.addr = "203.0.113.7:40000"
+	guesser.get("/login")
+	limited := false
+	for range 20 {
+		response := guesser.postJSON("/api/auth/login", `{"token": "wrong-token-0123456789abcdef0123456789"}`)
+		if response.StatusCode == http.StatusTooManyRequests {
+			limited = true
+			break
+		}
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("wrong token = %d, want %d", response.StatusCode, http.StatusUnauthorized)
+		}
+	}
+	if !limited {
+		t.Fatal("repeated wrong tokens were never limited")
+	}
+
+	admin := newBrowser(t, handler)
+	admin.addr = "198.51.100.20:50000"
+	admin.login(testToken)
+}
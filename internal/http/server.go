@@ -2,18 +2,33 @@ package http
 
 import (
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"net/http"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"soxdrawer/internal/apikeys"
+	"soxdrawer/internal/audit"
+	"soxdrawer/internal/hubsync"
+	"soxdrawer/internal/oidc"
+	"soxdrawer/internal/quota"
+	"soxdrawer/internal/session"
 	"soxdrawer/internal/store"
 	"soxdrawer/internal/templates"
+	"soxdrawer/internal/uploadkeys"
+	"soxdrawer/internal/users"
 
 	"github.com/a-h/templ"
 )
@@ -24,13 +39,44 @@ type (
 		ObjectStore    *store.ObjectStore
 		server         *http.Server
 		embeddedAssets embed.FS
+		mu             sync.RWMutex // Guards authToken and cors, which change on config reload
 		authToken      string
+		sessions       *session.Store
+		users          *users.Store
+		oidc           *oidc.Provider
+		ssoLabel       string
+		tokenLogin     bool
+		cookies        CookiePolicy
+		tlsConfig      *tls.Config
+		limits         *rateLimits
+		uploadKeys     *uploadkeys.Store
+		apiKeys        *apikeys.Store
+		cors           CORSPolicy
+		auditLog       *audit.Log
+		quotas         *quota.Tracker
+		sync           *hubsync.Syncer
+		pending        *pendingLogins
 	}
 
 	Config struct {
 		Address   string
 		Assets    embed.FS
 		AuthToken string
+		Sessions  *session.Store
+		Users     *users.Store
+		// Single sign-on; nil disables it
+		OIDC              *oidc.Provider
+		SSOLabel          string // Text of the login page's sign-on button
+		DisableTokenLogin bool   // Only allow single sign-on
+		Cookies           CookiePolicy
+		TLS               *tls.Config // Serve HTTPS when set
+		RateLimits        RateLimitConfig
+		UploadKeys        *uploadkeys.Store // Scoped keys for the embeddable upload widget; nil disables it
+		APIKeys           *apikeys.Store    // Per-user keys for scripts; nil disables them
+		CORS              CORSPolicy
+		AuditLog          *audit.Log      // Records authenticated actions; nil disables auditing
+		Quotas            *quota.Tracker  // Enforces storage quotas on uploads; nil disables them
+		Sync              *hubsync.Syncer // Syncs buckets with a hub; nil when this isn't a leaf node
 	}
 
 	UploadResponse struct {
@@ -42,13 +88,19 @@ type (
 	}
 
 	ListResponse struct {
-		Status  string              `json:"status"`
-		Message string              `json:"message"`
-		Objects []*store.ObjectInfo `json:"objects,omitempty"`
+		Status      string              `json:"status"`
+		Message     string              `json:"message"`
+		Objects     []*store.ObjectInfo `json:"objects"`
+		Folders     []string            `json:"folders,omitempty"`
+		Breadcrumbs []store.Breadcrumb  `json:"breadcrumbs,omitempty"`
+		Total       int                 `json:"total"`
+		NextCursor  string              `json:"next_cursor,omitempty"`
 	}
 
 	LoginRequest struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		Code         string `json:"code,omitempty"`          // TOTP code when two-factor is enabled
+		RecoveryCode string `json:"recovery_code,omitempty"` // Single-use alternative to Code
 	}
 
 	LoginResponse struct {
@@ -57,24 +109,124 @@ type (
 	}
 )
 
+// LoginTOTPRequired is the login response status asking the client for a second factor
+const LoginTOTPRequired = "totp_required"
+
+// DefaultPageSize is the number of objects returned by /api/list when no limit is given
+const DefaultPageSize = store.DefaultPageSize
+
 func DefaultConfig() *Config {
 	return &Config{
-		Address: ":8080",
+		Address:    ":8080",
+		Cookies:    DefaultCookiePolicy(),
+		RateLimits: DefaultRateLimitConfig(),
+		CORS:       DefaultCORSPolicy(),
 	}
 }
 
 // New creates a new HTTP server instance
 func New(config *Config, objectStore *store.ObjectStore) *Server {
-	return &Server{
+	server := &Server{
 		Address:        config.Address,
 		ObjectStore:    objectStore,
 		embeddedAssets: config.Assets,
 		authToken:      config.AuthToken,
-	}
+		sessions:       config.Sessions,
+		users:          config.Users,
+		oidc:           config.OIDC,
+		ssoLabel:       config.SSOLabel,
+		tokenLogin:     !config.DisableTokenLogin,
+		cookies:        config.Cookies,
+		tlsConfig:      config.TLS,
+		limits:         newRateLimits(config.RateLimits),
+		uploadKeys:     config.UploadKeys,
+		apiKeys:        config.APIKeys,
+		cors:           config.CORS,
+		auditLog:       config.AuditLog,
+		quotas:         config.Quotas,
+		sync:           config.Sync,
+		pending:        newPendingLogins(),
+	}
+	if server.oidc != nil && server.ssoLabel == "" {
+		server.ssoLabel = "Sign in with SSO"
+	}
+	if server.cookies.Name == "" {
+		server.cookies.Name = SessionCookieName
+	}
+	return server
+}
+
+// token returns the current HTTP authentication token
+func (s *Server) token() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.authToken
+}
+
+// SetAuthToken replaces the HTTP token. Existing sessions stay valid.
+func (s *Server) SetAuthToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authToken = token
+}
+
+// corsPolicy returns the current CORS policy
+func (s *Server) corsPolicy() CORSPolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cors
+}
+
+// SetCORS replaces the CORS policy
+func (s *Server) SetCORS(policy CORSPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cors = policy
+}
+
+// SetRateLimits changes the request limits and lockout policy. Clients keep
+// their current buckets and failure counts.
+func (s *Server) SetRateLimits(config RateLimitConfig) {
+	s.limits.loginByIP.SetRate(config.Login)
+	s.limits.uploadByIP.SetRate(config.Upload)
+	s.limits.uploadByCredential.SetRate(config.Upload)
+	s.limits.downloadByIP.SetRate(config.Download)
+	s.limits.downloadByCredential.SetRate(config.Download)
+	s.limits.lockout.SetConfig(config.Lockout)
 }
 
 // Start starts the HTTP server with routes
 func (s *Server) Start() error {
+	s.server = &http.Server{
+		Addr:    s.Address,
+		Handler: s.routes(),
+	}
+
+	if s.tlsConfig != nil {
+		s.server.TLSConfig = s.tlsConfig
+		log.Printf("Starting HTTPS server on %s", s.Address)
+	} else {
+		log.Printf("Starting HTTP server on %s", s.Address)
+	}
+
+	go func() {
+		var err error
+		if s.tlsConfig != nil {
+			// Certificates come from TLSConfig.GetCertificate
+			err = s.server.ListenAndServeTLS("", "")
+		} else {
+			err = s.server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to start HTTP server: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// routes builds the handler for every route, wrapped in the middleware
+func (s *Server) routes() http.Handler {
 	mux := http.NewServeMux()
 
 	httpAssets, err := fs.Sub(s.embeddedAssets, "web/dist")
@@ -88,31 +240,56 @@ func (s *Server) Start() error {
 	mux.HandleFunc("/login", s.loginPageHandler)
 	mux.HandleFunc("/api/auth/login", s.loginHandler)
 	mux.HandleFunc("/api/auth/logout", s.logoutHandler)
+	mux.HandleFunc("/api/auth/logout-all", s.logoutAllHandler)
+	mux.HandleFunc("/api/auth/sessions", s.sessionsHandler)
+	mux.HandleFunc("/api/auth/sessions/", s.revokeSessionHandler)
+	mux.HandleFunc("/api/auth/oidc/login", s.oidcLoginHandler)
+	mux.HandleFunc("/api/auth/oidc/callback", s.oidcCallbackHandler)
+	mux.HandleFunc("/api/auth/oidc/totp", s.oidcSecondFactorHandler)
+	mux.HandleFunc("/api/auth/totp", s.totpStatusHandler)
+	mux.HandleFunc("/api/auth/totp/enroll", s.totpEnrollHandler)
+	mux.HandleFunc("/api/auth/totp/confirm", s.totpConfirmHandler)
+	mux.HandleFunc("/api/auth/totp/disable", s.totpDisableHandler)
+	mux.HandleFunc("/api/admin/users/", s.adminUserHandler)
+	mux.HandleFunc("/api/admin/upload-keys", s.uploadKeysHandler)
+	mux.HandleFunc("/api/admin/upload-keys/", s.revokeUploadKeyHandler)
+	mux.HandleFunc("/api/admin/audit", s.auditHandler)
+	mux.HandleFunc("/api/keys", s.apiKeysHandler)
+	mux.HandleFunc("/api/keys/", s.revokeAPIKeyHandler)
+	mux.HandleFunc("/api/usage", s.usageHandler)
+	mux.HandleFunc("/api/sync", s.syncHandler)
+
+	// Embeddable upload widget, authenticated by upload keys
+	mux.HandleFunc("/embed/upload.js", s.uploadWidgetHandler)
+	mux.HandleFunc("/api/embed/upload", s.embedUploadHandler)
 
 	// Protected routes
 	mux.HandleFunc("/", s.indexHandler)
 	mux.HandleFunc("/api/list", s.listHandler)
-	mux.HandleFunc("/api/upload", s.uploadHandler)
+	mux.HandleFunc("/api/upload", rateLimit(s.limits.uploadByIP, s.limits.uploadByCredential, s.uploadHandler))
 	mux.HandleFunc("/api/delete/", s.deleteHandler)
-	mux.HandleFunc("/api/download/", s.downloadHandler)
+	mux.HandleFunc("/api/download/", rateLimit(s.limits.downloadByIP, s.limits.downloadByCredential, s.downloadHandler))
+	mux.HandleFunc("/api/meta/", s.metadataHandler)
+	mux.HandleFunc("/api/tags", s.tagsHandler)
+	mux.HandleFunc("/api/collections", s.collectionsHandler)
+	mux.HandleFunc("/api/objects/rename", s.renameHandler)
+	mux.HandleFunc("/api/objects/copy", s.copyHandler)
+	mux.HandleFunc("/api/objects/move", s.moveHandler)
+	mux.HandleFunc("/api/bulk", s.bulkHandler)
+	mux.HandleFunc("/api/bulk/download", rateLimit(s.limits.downloadByIP, s.limits.downloadByCredential, s.bulkDownloadHandler))
+	mux.HandleFunc("/api/folders", s.foldersHandler)
+	mux.HandleFunc("/api/folders/rename", s.renameFolderHandler)
 
 	// Apply middleware
-	handler := authMiddleware(s.authToken)(mux)
-
-	s.server = &http.Server{
-		Addr:    s.Address,
-		Handler: handler,
-	}
-
-	log.Printf("Starting HTTP server on %s", s.Address)
-
-	go func() {
-		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start HTTP server: %v", err)
-		}
-	}()
-
-	return nil
+	var verifyKey func(string) (*apikeys.Key, error)
+	if s.apiKeys != nil {
+		verifyKey = s.verifyAPIKey
+	}
+	// CSRF checks run after authentication so they know whether a bearer
+	// token was accepted as an API key
+	handler := csrfMiddleware(s.cookies)(mux)
+	handler = authMiddleware(s.sessions, s.cookies, verifyKey, s.limits.lockout)(handler)
+	return corsMiddleware(s.corsPolicy)(handler)
 }
 
 func (s *Server) Stop(ctx context.Context) error {
@@ -134,18 +311,60 @@ func (s *Server) listHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	objects, err := s.ObjectStore.ListObjectsForAPI()
+	query := r.URL.Query()
+	opts := store.ListOptions{
+		Tags:       query["tag"],
+		Collection: query.Get("collection"),
+		Prefix:     query.Get("prefix"),
+		Delimiter:  query.Get("delimiter"),
+		Kind:       query.Get("kind"),
+		Sort:       query.Get("sort"),
+		Order:      query.Get("order"),
+		Limit:      DefaultPageSize,
+		Cursor:     query.Get("cursor"),
+	}
+	var err error
+	if opts.Since, err = parseTimeParam(query.Get("since")); err != nil {
+		sendErrorResponse(w, "Invalid since", http.StatusBadRequest)
+		return
+	}
+	if opts.Until, err = parseTimeParam(query.Get("until")); err != nil {
+		sendErrorResponse(w, "Invalid until", http.StatusBadRequest)
+		return
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			sendErrorResponse(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		opts.Limit = n
+	}
+
+	result, err := s.ObjectStore.ListObjectsForAPI(opts)
 	if err != nil {
+		if errors.Is(err, store.ErrInvalidListOptions) {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		log.Printf("Failed to list objects: %v", err)
 		sendErrorResponse(w, "Failed to list objects", http.StatusInternalServerError)
 		return
 	}
 
-	sendJSONResponse(w, http.StatusOK, ListResponse{
-		Status:  "success",
-		Message: "",
-		Objects: objects,
-	})
+	response := ListResponse{
+		Status:     "success",
+		Message:    "",
+		Objects:    result.Objects,
+		Folders:    result.Folders,
+		Total:      result.Total,
+		NextCursor: result.NextCursor,
+	}
+	if opts.Delimiter != "" {
+		response.Breadcrumbs = store.Breadcrumbs(opts.Prefix)
+	}
+
+	sendJSONResponse(w, http.StatusOK, response)
 }
 
 func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request) {
@@ -154,8 +373,32 @@ func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.receiveUpload(w, r, s.ObjectStore, "", requestActor(r))
+}
+
+// receiveUpload stores the multipart "file" field in a bucket and charges it
+// to owner. The form's folder and relative path are placed below root, which
+// uploads cannot escape. Quotas are enforced while the request body streams
+// in, so an oversized upload is cut off as soon as it passes a limit.
+func (s *Server) receiveUpload(w http.ResponseWriter, r *http.Request, objects *store.ObjectStore, root, owner string) {
+	var upload *quota.Upload
+	if s.quotas != nil {
+		var err error
+		upload, err = s.quotas.Begin(objects.Name(), owner, r.ContentLength)
+		if err != nil {
+			s.sendQuotaExceeded(w, r, objects.Name(), owner, err)
+			return
+		}
+		defer upload.Done()
+		r.Body = io.NopCloser(upload.Reader(r.Body))
+	}
+
 	const maxMemory = 32 << 20
 	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		if errors.Is(err, quota.ErrQuotaExceeded) {
+			s.sendQuotaExceeded(w, r, objects.Name(), owner, err)
+			return
+		}
 		log.Printf("Failed to parse multipart form: %v", err)
 		sendErrorResponse(w, "Failed to parse form data", http.StatusBadRequest)
 		return
@@ -187,20 +430,39 @@ func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Files dropped as part of a folder carry their relative path so the
+	// structure is preserved below the destination folder
+	dir := joinPath(root, sanitizePath(r.FormValue("folder")))
+	if relPath := r.FormValue("path"); relPath != "" {
+		relPath = strings.ReplaceAll(relPath, "\\", "/")
+		dir = joinPath(dir, sanitizePath(path.Dir(relPath)))
+		filename = path.Base(relPath)
+	}
+
 	cleanFilename := sanitizeFilename(filename)
 	timestamp := time.Now().Unix()
-	key := fmt.Sprintf("%d_%s", timestamp, cleanFilename)
+	key := joinPath(dir, fmt.Sprintf("%d_%s", timestamp, cleanFilename))
 
 	log.Printf("Uploading %s: %s (original: %s) as key: %s", contentType, cleanFilename, filename, key)
 
-	info, err := s.ObjectStore.PutReader(key, file)
+	info, err := objects.PutOwned(key, owner, file)
 	if err != nil {
+		s.audit(r, audit.Record{Action: audit.ActionUpload, Outcome: audit.OutcomeFailure, Bucket: objects.Name(), Key: key})
 		log.Printf("Failed to store %s %s: %v", contentType, key, err)
 		sendErrorResponse(w, "Failed to store file", http.StatusInternalServerError)
 		return
 	}
+	upload.Stored(info.Name, int64(info.Size))
 
 	log.Printf("Successfully uploaded %s %s (size: %d bytes)", contentType, key, info.Size)
+	s.audit(r, audit.Record{
+		Action:  audit.ActionUpload,
+		Outcome: audit.OutcomeSuccess,
+		Bucket:  objects.Name(),
+		Key:     key,
+		Digest:  info.Digest,
+		Size:    info.Size,
+	})
 
 	sendJSONResponse(w, http.StatusOK, UploadResponse{
 		Status:   "success",
@@ -219,16 +481,16 @@ func (s *Server) deleteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Extract the key from the URL path
-	path := strings.TrimPrefix(r.URL.Path, "/delete/")
-	if path == "" {
+	key := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/api/delete/"))
+	if key == "" {
 		sendErrorResponse(w, "No key provided", http.StatusBadRequest)
 		return
 	}
 
-	key := strings.TrimSpace(path)
 	log.Printf("Deleting object: %s", key)
 
 	err := s.ObjectStore.Delete(key)
+	s.audit(r, audit.Record{Action: audit.ActionDelete, Outcome: outcome(err), Bucket: s.ObjectStore.Name(), Key: key})
 	if err != nil {
 		log.Printf("Failed to delete object %s: %v", key, err)
 		sendErrorResponse(w, "Failed to delete object", http.StatusInternalServerError)
@@ -252,35 +514,38 @@ func (s *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Extract the key from the URL path
-	path := strings.TrimPrefix(r.URL.Path, "/download/")
-	if path == "" {
+	key := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/api/download/"))
+	if key == "" {
 		http.Error(w, "No key provided", http.StatusBadRequest)
 		return
 	}
 
-	key := strings.TrimSpace(path)
 	log.Printf("Downloading object: %s", key)
 
 	// Get the object from the store
 	data, err := s.ObjectStore.Get(key)
 	if err != nil {
+		s.audit(r, audit.Record{Action: audit.ActionDownload, Outcome: audit.OutcomeFailure, Bucket: s.ObjectStore.Name(), Key: key})
 		log.Printf("Failed to get object %s: %v", key, err)
 		http.Error(w, "Object not found", http.StatusNotFound)
 		return
 	}
 
 	// Get object info for size
+	record := audit.Record{Action: audit.ActionDownload, Outcome: audit.OutcomeSuccess, Bucket: s.ObjectStore.Name(), Key: key}
 	info, err := s.ObjectStore.GetInfo(key)
 	if err != nil {
 		log.Printf("Failed to get object info %s: %v", key, err)
 		// Continue without size header
 	} else {
 		w.Header().Set("Content-Length", fmt.Sprintf("%d", info.Size))
+		record.Digest, record.Size = info.Digest, info.Size
 	}
+	s.audit(r, record)
 
 	// Set headers for file download
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", key))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", path.Base(key)))
 
 	// Write the data to the response
 	_, err = w.Write(data)
@@ -299,16 +564,22 @@ func (s *Server) loginPageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if user is already authenticated
-	sessionToken := getSessionToken(r)
-	if sessionToken != "" {
-		valid, _ := validateSessionToken(sessionToken, s.authToken)
-		if valid {
-			http.Redirect(w, r, "/", http.StatusSeeOther)
-			return
-		}
+	if _, _, err := s.sessions.Validate(s.cookies.getSessionToken(r)); err == nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
 	}
 
-	sendTemplateResponse(r.Context(), w, templates.LoginPage(), 200)
+	// A single sign-on waiting for its second factor only asks for the code
+	_, secondFactor := s.pending.get(s.pendingTicket(r))
+	sendTemplateResponse(r.Context(), w, templates.LoginPage(s.tokenLogin, s.oidcLabel(), secondFactor), 200)
+}
+
+// oidcLabel returns the sign-on button text, or empty when SSO is off
+func (s *Server) oidcLabel() string {
+	if s.oidc == nil {
+		return ""
+	}
+	return s.ssoLabel
 }
 
 // loginHandler handles authentication
@@ -318,6 +589,22 @@ func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.tokenLogin {
+		sendErrorResponse(w, "Token login is disabled, use single sign-on", http.StatusForbidden)
+		return
+	}
+
+	ip := clientIP(r)
+	if locked := s.limits.lockout.Locked(ip); locked > 0 {
+		s.audit(r, audit.Record{Actor: users.AdminUser, Action: audit.ActionLogin, Outcome: audit.OutcomeDenied, Detail: "locked out"})
+		sendTooManyRequests(w, r, locked)
+		return
+	}
+	if ok, wait := s.limits.loginByIP.Allow(ip); !ok {
+		sendTooManyRequests(w, r, wait)
+		return
+	}
+
 	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
@@ -330,14 +617,53 @@ func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate token
-	if req.Token != s.authToken {
+	if subtle.ConstantTimeCompare([]byte(req.Token), []byte(s.token())) != 1 {
+		if locked := s.limits.lockout.Fail(ip); locked > 0 {
+			log.Printf("Locked out %s for %s after repeated failed logins", ip, locked)
+		}
+		s.audit(r, audit.Record{Actor: users.AdminUser, Action: audit.ActionLogin, Outcome: audit.OutcomeFailure, Detail: "invalid token"})
 		sendErrorResponse(w, "Invalid authentication token", http.StatusUnauthorized)
 		return
 	}
 
+	// Token logins act as the admin account, whose second factor applies here
+	user, err := s.users.Ensure(users.AdminUser, users.RoleAdmin)
+	if err != nil {
+		log.Printf("Failed to load user %s: %v", users.AdminUser, err)
+		sendErrorResponse(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+	if err := s.users.VerifySecondFactor(user.Name, req.Code, req.RecoveryCode); err != nil {
+		if errors.Is(err, users.ErrTOTPRequired) {
+			sendJSONResponse(w, http.StatusUnauthorized, LoginResponse{
+				Status:  LoginTOTPRequired,
+				Message: "Enter the code from your authenticator app",
+			})
+			return
+		}
+		if errors.Is(err, users.ErrInvalidTOTP) {
+			if locked := s.limits.lockout.Fail(ip); locked > 0 {
+				log.Printf("Locked out %s for %s after repeated failed logins", ip, locked)
+			}
+			s.audit(r, audit.Record{Actor: user.Name, Action: audit.ActionLogin, Outcome: audit.OutcomeFailure, Detail: "invalid two-factor code"})
+			sendErrorResponse(w, "Invalid two-factor code", http.StatusUnauthorized)
+			return
+		}
+		log.Printf("Failed to verify second factor for %s: %v", user.Name, err)
+		sendErrorResponse(w, "Failed to verify two-factor code", http.StatusInternalServerError)
+		return
+	}
+	s.limits.lockout.Reset(ip)
+
 	// Create session
-	sessionToken := createSessionToken(s.authToken)
-	setSessionCookie(w, sessionToken)
+	sessionToken, current, err := s.sessions.Create(user.Name, r.UserAgent(), ip)
+	if err != nil {
+		log.Printf("Failed to create session: %v", err)
+		sendErrorResponse(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	s.cookies.setSessionCookie(w, sessionToken, time.Until(current.ExpiresAt))
+	s.audit(r, audit.Record{Actor: user.Name, Action: audit.ActionLogin, Outcome: audit.OutcomeSuccess, Detail: "token"})
 
 	sendJSONResponse(w, http.StatusOK, LoginResponse{
 		Status:  "success",
@@ -352,7 +678,20 @@ func (s *Server) logoutHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	clearSessionCookie(w)
+	if token := s.cookies.getSessionToken(r); token != "" {
+		actor := ""
+		if current, _, err := s.sessions.Validate(token); err == nil {
+			actor = current.User
+			if actor == "" {
+				actor = users.AdminUser
+			}
+		}
+		if err := s.sessions.Revoke(session.ID(token)); err != nil {
+			log.Printf("Failed to revoke session on logout: %v", err)
+		}
+		s.audit(r, audit.Record{Actor: actor, Action: audit.ActionLogout, Outcome: audit.OutcomeSuccess})
+	}
+	s.cookies.clearSessionCookie(w)
 
 	sendJSONResponse(w, http.StatusOK, LoginResponse{
 		Status:  "success",
@@ -411,3 +750,39 @@ func sanitizeFilename(filename string) string {
 
 	return cleaned
 }
+
+// parseTimeParam parses an RFC 3339 timestamp or a plain YYYY-MM-DD date.
+// An empty value yields the zero time.
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// sanitizePath cleans every segment of a slash-separated folder path,
+// dropping empty, "." and ".." segments
+func sanitizePath(p string) string {
+	var segments []string
+	for _, segment := range strings.Split(p, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			continue
+		}
+		segments = append(segments, sanitizeFilename(segment))
+	}
+	return strings.Join(segments, "/")
+}
+
+// joinPath joins a folder path and a name, omitting the separator at the root
+func joinPath(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	if name == "" {
+		return dir
+	}
+	return dir + "/" + name
+}
@@ -0,0 +1,156 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	natsServer "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"soxdrawer/internal/session"
+	"soxdrawer/internal/store"
+	"soxdrawer/internal/users"
+)
+
+const testToken = "test-token-0123456789abcdef0123456789"
+
+// startJetStream runs an in-process NATS server with JetStream for one test
+func startJetStream(t *testing.T) nats.JetStreamContext {
+	t.Helper()
+
+	ns, err := natsServer.NewServer(&natsServer.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create NATS server: %v", err)
+	}
+	ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("failed to connect to NATS: %v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	js, err := conn.JetStream()
+	if err != nil {
+		t.Fatalf("failed to get JetStream context: %v", err)
+	}
+	return js
+}
+
+// newTestServer returns a server backed by a fresh JetStream, letting
+// configure fill in anything more before it is created
+func newTestServer(t *testing.T, configure func(config *Config, js nats.JetStreamContext)) *Server {
+	t.Helper()
+	js := startJetStream(t)
+
+	objects, err := store.Open(js, "default", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := session.New(js, session.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts, err := users.New(js)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultConfig()
+	config.AuthToken = testToken
+	config.Sessions = sessions
+	config.Users = accounts
+	if configure != nil {
+		configure(config, js)
+	}
+	return New(config, objects)
+}
+
+// browser sends requests to a handler and keeps the cookies it sets, like
+// a browser would
+type browser struct {
+	t       *testing.T
+	handler http.Handler
+	cookies map[string]*http.Cookie
+	addr    string // Where requests come from; empty keeps httptest's
+}
+
+func newBrowser(t *testing.T, handler http.Handler) *browser {
+	return &browser{t: t, handler: handler, cookies: map[string]*http.Cookie{}}
+}
+
+func (b *browser) do(r *http.Request) *http.Response {
+	b.t.Helper()
+	if b.addr != "" {
+		r.RemoteAddr = b.addr
+	}
+	for _, cookie := range b.cookies {
+		r.AddCookie(cookie)
+	}
+	if r.Method != http.MethodGet {
+		if csrf, ok := b.cookies[CSRFCookieName]; ok {
+			r.Header.Set(CSRFHeaderName, csrf.Value)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	b.handler.ServeHTTP(recorder, r)
+	response := recorder.Result()
+	for _, cookie := range response.Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(b.cookies, cookie.Name)
+			continue
+		}
+		b.cookies[cookie.Name] = cookie
+	}
+	return response
+}
+
+func (b *browser) get(target string) *http.Response {
+	b.t.Helper()
+	return b.do(httptest.NewRequest(http.MethodGet, target, nil))
+}
+
+func (b *browser) postJSON(target, body string) *http.Response {
+	b.t.Helper()
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return b.do(r)
+}
+
+// login signs in with the HTTP token
+func (b *browser) login(token string) {
+	b.t.Helper()
+	b.get("/login")
+	if response := b.postJSON("/api/auth/login", `{"token": "`+token+`"}`); response.StatusCode != http.StatusOK {
+		b.t.Fatalf("login = %d, want %d", response.StatusCode, http.StatusOK)
+	}
+}
+
+func TestListRejectsEmptyPages(t *testing.T) {
+	b := newBrowser(t, newTestServer(t, nil).routes())
+	b.login(testToken)
+
+	for _, limit := range []string{"0", "-1"} {
+		if response := b.get("/api/list?limit=" + limit); response.StatusCode != http.StatusBadRequest {
+			t.Fatalf("limit=%s = %d, want %d", limit, response.StatusCode, http.StatusBadRequest)
+		}
+	}
+	if response := b.get("/api/list?limit=1"); response.StatusCode != http.StatusOK {
+		t.Fatalf("limit=1 = %d, want %d", response.StatusCode, http.StatusOK)
+	}
+}
@@ -0,0 +1,197 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"soxdrawer/internal/audit"
+	"soxdrawer/internal/session"
+	"soxdrawer/internal/users"
+)
+
+type (
+	SessionInfo struct {
+		ID        string    `json:"id"`
+		User      string    `json:"user"`
+		UserAgent string    `json:"user_agent"`
+		IP        string    `json:"ip"`
+		Created   time.Time `json:"created"`
+		LastSeen  time.Time `json:"last_seen"`
+		ExpiresAt time.Time `json:"expires_at"`
+		Current   bool      `json:"current"`
+	}
+
+	SessionsResponse struct {
+		Status   string        `json:"status"`
+		Message  string        `json:"message"`
+		Sessions []SessionInfo `json:"sessions"`
+	}
+)
+
+// sessionOwner returns the user a session belongs to. Sessions created
+// before accounts existed belong to the admin.
+func sessionOwner(sess *session.Session) string {
+	if sess.User == "" {
+		return users.AdminUser
+	}
+	return sess.User
+}
+
+// sessionsHandler lists the current user's active sessions (the devices
+// logged in). Administrators can add all=true to list everyone's.
+func (s *Server) sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := s.currentUser(r)
+	if err != nil {
+		log.Printf("Failed to load current user: %v", err)
+		sendErrorResponse(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+	all := r.URL.Query().Get("all") == "true"
+	if all && !user.IsAdmin() {
+		sendErrorResponse(w, "Administrator access required", http.StatusForbidden)
+		return
+	}
+
+	sessions, err := s.sessions.List()
+	if err != nil {
+		log.Printf("Failed to list sessions: %v", err)
+		sendErrorResponse(w, "Failed to list sessions", http.StatusInternalServerError)
+		return
+	}
+
+	var currentID string
+	if current := sessionFromContext(r.Context()); current != nil {
+		currentID = current.ID
+	}
+
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, sess := range sessions {
+		if !all && sessionOwner(sess) != user.Name {
+			continue
+		}
+		infos = append(infos, SessionInfo{
+			ID:        sess.ID,
+			User:      sessionOwner(sess),
+			UserAgent: sess.UserAgent,
+			IP:        sess.IP,
+			Created:   sess.Created,
+			LastSeen:  sess.LastSeen,
+			ExpiresAt: sess.ExpiresAt,
+			Current:   sess.ID == currentID,
+		})
+	}
+
+	sendJSONResponse(w, http.StatusOK, SessionsResponse{
+		Status:   "success",
+		Sessions: infos,
+	})
+}
+
+// revokeSessionHandler ends a single session by ID. Users can only end
+// their own sessions; administrators can end anyone's.
+func (s *Server) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/api/auth/sessions/"))
+	if id == "" {
+		sendErrorResponse(w, "No session ID provided", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.currentUser(r)
+	if err != nil {
+		log.Printf("Failed to load current user: %v", err)
+		sendErrorResponse(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+
+	sess, err := s.sessions.Get(id)
+	if err == nil && sessionOwner(sess) != user.Name && !user.IsAdmin() {
+		// Other users' sessions are reported as missing rather than forbidden
+		err = session.ErrInvalidSession
+	}
+	if err == nil {
+		err = s.sessions.Revoke(id)
+	}
+	s.audit(r, audit.Record{Action: audit.ActionSessionRevoke, Outcome: outcome(err), Detail: "session " + id})
+	if err != nil {
+		if errors.Is(err, session.ErrInvalidSession) {
+			sendErrorResponse(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to revoke session %s: %v", id, err)
+		sendErrorResponse(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	if current := sessionFromContext(r.Context()); current != nil && current.ID == id {
+		s.cookies.clearSessionCookie(w)
+	}
+
+	log.Printf("User %s revoked session %s of %s", user.Name, id, sessionOwner(sess))
+	sendJSONResponse(w, http.StatusOK, LoginResponse{
+		Status:  "success",
+		Message: "Session revoked",
+	})
+}
+
+// logoutAllHandler revokes every session of the current user, including the
+// caller's. Administrators can add all=true to log everyone out.
+func (s *Server) logoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := s.currentUser(r)
+	if err != nil {
+		log.Printf("Failed to load current user: %v", err)
+		sendErrorResponse(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+	all := r.URL.Query().Get("all") == "true"
+	if all && !user.IsAdmin() {
+		sendErrorResponse(w, "Administrator access required", http.StatusForbidden)
+		return
+	}
+
+	var revoked int
+	detail := "all sessions of " + user.Name
+	if all {
+		revoked, err = s.sessions.RevokeAll()
+		detail = "all sessions"
+	} else {
+		revoked, err = s.sessions.RevokeUser(user.Name)
+		if err == nil && user.Name == users.AdminUser {
+			// Sessions from before accounts existed have no user
+			var legacy int
+			legacy, err = s.sessions.RevokeUser("")
+			revoked += legacy
+		}
+	}
+	s.audit(r, audit.Record{Action: audit.ActionSessionRevoke, Outcome: outcome(err), Detail: fmt.Sprintf("%s (%d)", detail, revoked)})
+	if err != nil {
+		log.Printf("Failed to revoke %s after %d: %v", detail, revoked, err)
+		sendErrorResponse(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+	s.cookies.clearSessionCookie(w)
+
+	log.Printf("User %s revoked %s (%d)", user.Name, detail, revoked)
+	sendJSONResponse(w, http.StatusOK, LoginResponse{
+		Status:  "success",
+		Message: "Logged out everywhere",
+	})
+}
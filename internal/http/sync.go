@@ -0,0 +1,34 @@
+package http
+
+import (
+	"net/http"
+
+	"soxdrawer/internal/hubsync"
+)
+
+type (
+	SyncResponse struct {
+		Status  string          `json:"status"`
+		Message string          `json:"message"`
+		Sync    *hubsync.Status `json:"sync"`
+	}
+)
+
+// syncHandler reports whether the hub is reachable and how the last sync
+// of each bucket went
+func (s *Server) syncHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.sync == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	status := s.sync.Status()
+	sendJSONResponse(w, http.StatusOK, SyncResponse{
+		Status: "success",
+		Sync:   &status,
+	})
+}
@@ -0,0 +1,244 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"soxdrawer/internal/audit"
+	"soxdrawer/internal/users"
+)
+
+type (
+	TOTPStatusResponse struct {
+		Status                 string `json:"status"`
+		Message                string `json:"message"`
+		User                   string `json:"user"`
+		Enabled                bool   `json:"enabled"`
+		RecoveryCodesRemaining int    `json:"recovery_codes_remaining"`
+	}
+
+	TOTPEnrollResponse struct {
+		Status     string            `json:"status"`
+		Message    string            `json:"message"`
+		Enrollment *users.Enrollment `json:"enrollment"`
+	}
+
+	TOTPCodeRequest struct {
+		Code string `json:"code"`
+	}
+
+	TOTPConfirmResponse struct {
+		Status        string   `json:"status"`
+		Message       string   `json:"message"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+)
+
+// errNotLoggedIn is returned for requests with neither a session nor an API key
+var errNotLoggedIn = errors.New("not logged in")
+
+// currentUser returns the account behind the request's session or API key.
+// Sessions created before accounts existed belong to the admin, whose
+// account is created on first use. API keys never act as administrators.
+func (s *Server) currentUser(r *http.Request) (*users.User, error) {
+	if key := apiKeyFromContext(r.Context()); key != nil {
+		user, err := s.users.Get(key.User)
+		if err != nil {
+			return nil, err
+		}
+		user.Role = users.RoleUser
+		return user, nil
+	}
+	current := sessionFromContext(r.Context())
+	if current == nil {
+		return nil, errNotLoggedIn
+	}
+	if current.User != "" {
+		return s.users.Get(current.User)
+	}
+	return s.users.Ensure(users.AdminUser, users.RoleAdmin)
+}
+
+// totpStatusHandler reports whether the current user has two-factor enabled
+func (s *Server) totpStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := s.currentUser(r)
+	if err != nil {
+		log.Printf("Failed to load current user: %v", err)
+		sendErrorResponse(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, TOTPStatusResponse{
+		Status:                 "success",
+		User:                   user.Name,
+		Enabled:                user.TOTPEnabled,
+		RecoveryCodesRemaining: len(user.RecoveryCodes),
+	})
+}
+
+// totpEnrollHandler starts enrollment and returns the secret as an otpauth
+// URI and QR code for the authenticator app
+func (s *Server) totpEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := s.currentUser(r)
+	if err != nil {
+		log.Printf("Failed to load current user: %v", err)
+		sendErrorResponse(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+
+	enrollment, err := s.users.BeginTOTP(user.Name)
+	if err != nil {
+		if errors.Is(err, users.ErrTOTPAlreadyEnabled) {
+			sendErrorResponse(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Printf("Failed to start TOTP enrollment for %s: %v", user.Name, err)
+		sendErrorResponse(w, "Failed to start enrollment", http.StatusInternalServerError)
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, TOTPEnrollResponse{
+		Status:     "success",
+		Message:    "Scan the QR code, then confirm with a code from your authenticator app",
+		Enrollment: enrollment,
+	})
+}
+
+// totpConfirmHandler enables two-factor once a valid code is entered and
+// returns the recovery codes, which cannot be retrieved again
+func (s *Server) totpConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.currentUser(r)
+	if err != nil {
+		log.Printf("Failed to load current user: %v", err)
+		sendErrorResponse(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+
+	codes, err := s.users.ConfirmTOTP(user.Name, req.Code)
+	s.audit(r, audit.Record{Actor: user.Name, Action: audit.ActionTOTPEnable, Outcome: outcome(err)})
+	if err != nil {
+		if errors.Is(err, users.ErrInvalidTOTP) || errors.Is(err, users.ErrTOTPNotPending) {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Failed to confirm TOTP for %s: %v", user.Name, err)
+		sendErrorResponse(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Enabled two-factor authentication for %s", user.Name)
+	sendJSONResponse(w, http.StatusOK, TOTPConfirmResponse{
+		Status:        "success",
+		Message:       "Two-factor authentication enabled. Store the recovery codes somewhere safe.",
+		RecoveryCodes: codes,
+	})
+}
+
+// totpDisableHandler turns two-factor off for the current user, who must
+// enter a current code
+func (s *Server) totpDisableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.currentUser(r)
+	if err != nil {
+		log.Printf("Failed to load current user: %v", err)
+		sendErrorResponse(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+
+	err = s.users.DisableTOTP(user.Name, req.Code)
+	s.audit(r, audit.Record{Actor: user.Name, Action: audit.ActionTOTPDisable, Outcome: outcome(err)})
+	if err != nil {
+		if errors.Is(err, users.ErrInvalidTOTP) || errors.Is(err, users.ErrTOTPRequired) {
+			sendErrorResponse(w, "Invalid two-factor code", http.StatusBadRequest)
+			return
+		}
+		log.Printf("Failed to disable TOTP for %s: %v", user.Name, err)
+		sendErrorResponse(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Disabled two-factor authentication for %s", user.Name)
+	sendJSONResponse(w, http.StatusOK, LoginResponse{
+		Status:  "success",
+		Message: "Two-factor authentication disabled",
+	})
+}
+
+// adminUserHandler serves administrative actions on other accounts:
+// POST /api/admin/users/{name}/totp/reset clears a user's second factor and
+// ends their sessions so they can enroll again
+func (s *Server) adminUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	admin, err := s.currentUser(r)
+	if err != nil || !admin.IsAdmin() {
+		sendErrorResponse(w, "Administrator access required", http.StatusForbidden)
+		return
+	}
+
+	name, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/admin/users/"), "/")
+	if !ok || name == "" || action != "totp/reset" {
+		sendErrorResponse(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	err = s.users.ResetTOTP(name)
+	s.audit(r, audit.Record{Actor: admin.Name, Action: audit.ActionTOTPReset, Outcome: outcome(err), Detail: "user " + name})
+	if err != nil {
+		if errors.Is(err, users.ErrUserNotFound) {
+			sendErrorResponse(w, "User not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to reset TOTP for %s: %v", name, err)
+		sendErrorResponse(w, "Failed to reset two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	revoked, err := s.sessions.RevokeUser(name)
+	if err != nil {
+		log.Printf("Failed to revoke sessions for %s: %v", name, err)
+	}
+
+	log.Printf("Administrator %s reset two-factor authentication for %s and revoked %d sessions", admin.Name, name, revoked)
+	sendJSONResponse(w, http.StatusOK, LoginResponse{
+		Status:  "success",
+		Message: "Two-factor authentication reset",
+	})
+}
@@ -0,0 +1,57 @@
+package http
+
+import (
+	"log"
+	"net/http"
+
+	"soxdrawer/internal/audit"
+	"soxdrawer/internal/quota"
+)
+
+type (
+	UsageResponse struct {
+		Status  string        `json:"status"`
+		Message string        `json:"message"`
+		User    *quota.Usage  `json:"user,omitempty"`
+		Users   []quota.Usage `json:"users,omitempty"` // Every user, for administrators
+		Buckets []quota.Usage `json:"buckets"`
+	}
+)
+
+// usageHandler reports storage used against quotas for the current user and
+// every bucket; administrators also see every user
+func (s *Server) usageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.quotas == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	user, err := s.currentUser(r)
+	if err != nil {
+		log.Printf("Failed to load current user: %v", err)
+		sendErrorResponse(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+
+	usage := s.quotas.User(user.Name)
+	response := UsageResponse{
+		Status:  "success",
+		User:    &usage,
+		Buckets: s.quotas.Buckets(),
+	}
+	if user.IsAdmin() {
+		response.Users = s.quotas.Users()
+	}
+	sendJSONResponse(w, http.StatusOK, response)
+}
+
+// sendQuotaExceeded rejects an upload that would pass a quota with 413
+func (s *Server) sendQuotaExceeded(w http.ResponseWriter, r *http.Request, bucket, owner string, err error) {
+	log.Printf("Rejected upload by %s to %s: %v", owner, bucket, err)
+	s.audit(r, audit.Record{Action: audit.ActionUpload, Outcome: audit.OutcomeDenied, Bucket: bucket, Detail: err.Error()})
+	sendErrorResponse(w, err.Error(), http.StatusRequestEntityTooLarge)
+}
@@ -0,0 +1,97 @@
+// soxdrawer upload widget
+//
+// Embed in another page with a scoped upload key:
+//
+//   <div data-soxdrawer-upload data-key="sdu_..." data-folder="optional/subfolder"></div>
+//   <script src="https://soxdrawer.example.com/embed/upload.js" defer></script>
+//
+// The page's origin must be listed in [http.cors] allowed_origins. Each
+// finished upload fires a "soxdrawer:uploaded" event on the element with the
+// server's response as detail; failures fire "soxdrawer:error".
+(function () {
+  const script = document.currentScript
+  const server = script ? new URL(script.src).origin : window.location.origin
+
+  function upload(element, file) {
+    const item = document.createElement('li')
+    item.textContent = `${file.name}: uploading…`
+    element.querySelector('ul').appendChild(item)
+
+    const form = new FormData()
+    form.append('file', file)
+    form.append('type', 'file')
+    if (element.dataset.folder) {
+      form.append('folder', element.dataset.folder)
+    }
+
+    fetch(`${server}/api/embed/upload`, {
+      method: 'POST',
+      headers: { Authorization: `Bearer ${element.dataset.key}` },
+      body: form,
+    })
+      .then(response => response.json().then(body => ({ ok: response.ok, body })))
+      .then(({ ok, body }) => {
+        if (!ok) {
+          throw new Error(body.message || 'Upload failed')
+        }
+        item.textContent = `${file.name}: uploaded`
+        element.dispatchEvent(new CustomEvent('soxdrawer:uploaded', { detail: body, bubbles: true }))
+      })
+      .catch(error => {
+        item.textContent = `${file.name}: ${error.message}`
+        item.style.color = '#dc2626'
+        element.dispatchEvent(new CustomEvent('soxdrawer:error', { detail: { file: file.name, message: error.message }, bubbles: true }))
+      })
+  }
+
+  function mount(element) {
+    if (element.dataset.soxdrawerMounted) {
+      return
+    }
+    element.dataset.soxdrawerMounted = 'true'
+
+    const zone = document.createElement('div')
+    zone.textContent = element.dataset.label || 'Drop files here or click to upload'
+    zone.style.cssText = 'border:2px dashed #9ca3af;border-radius:8px;padding:24px;text-align:center;cursor:pointer;font:14px sans-serif;color:#374151'
+
+    const input = document.createElement('input')
+    input.type = 'file'
+    input.multiple = true
+    input.style.display = 'none'
+
+    const list = document.createElement('ul')
+    list.style.cssText = 'list-style:none;padding:0;margin:8px 0 0;font:13px sans-serif;color:#4b5563'
+
+    const send = files => Array.from(files).forEach(file => upload(element, file))
+
+    zone.addEventListener('click', () => input.click())
+    input.addEventListener('change', () => {
+      send(input.files)
+      input.value = ''
+    })
+    zone.addEventListener('dragover', event => {
+      event.preventDefault()
+      zone.style.borderColor = '#4f46e5'
+    })
+    zone.addEventListener('dragleave', () => {
+      zone.style.borderColor = '#9ca3af'
+    })
+    zone.addEventListener('drop', event => {
+      event.preventDefault()
+      zone.style.borderColor = '#9ca3af'
+      send(event.dataTransfer.files)
+    })
+
+    element.append(zone, input, list)
+  }
+
+  function mountAll() {
+    document.querySelectorAll('[data-soxdrawer-upload]').forEach(mount)
+  }
+
+  if (document.readyState === 'loading') {
+    document.addEventListener('DOMContentLoaded', mountAll)
+  } else {
+    mountAll()
+  }
+})()
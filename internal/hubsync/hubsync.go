@@ -0,0 +1,493 @@
+package hubsync
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"soxdrawer/internal/store"
+)
+
+const (
+	// StateBucket is the KV bucket on this side that remembers the version
+	// of every object both sides last agreed on, and how far each side's
+	// events have been read
+	StateBucket = "hubsync"
+
+	// fullSyncInterval is how often every object is compared, rather than
+	// only those the events say changed. This picks up changes made without
+	// an event, such as writes straight into the object store.
+	fullSyncInterval = time.Hour
+)
+
+type (
+	// Config names the buckets kept in step with the hub
+	Config struct {
+		Buckets  []string
+		Interval time.Duration
+		Replicas int // Replicas of buckets created on this side
+	}
+
+	// BucketStatus is the outcome of the last sync of one bucket
+	BucketStatus struct {
+		Bucket    string    `json:"bucket"`
+		LastSync  time.Time `json:"last_sync,omitzero"`
+		Pulled    int       `json:"pulled"`    // Changes copied from the hub in the last sync
+		Pushed    int       `json:"pushed"`    // Changes copied to the hub in the last sync
+		Conflicts int       `json:"conflicts"` // Objects both sides changed, settled in the hub's favour
+		Error     string    `json:"error,omitempty"`
+	}
+
+	// Status is the sync state of every bucket
+	Status struct {
+		Connected bool           `json:"connected"`
+		Checked   time.Time      `json:"checked,omitzero"` // When the hub was last tried
+		Buckets   []BucketStatus `json:"buckets"`
+	}
+
+	// Syncer keeps buckets on a leaf node in step with the same buckets on
+	// the hub, tags and descriptions included. Each pass reads the events
+	// both sides recorded since the last one and compares the objects they
+	// name with the version both sides last agreed on: an object only one
+	// side changed is copied to the other, deletes included, so either side
+	// can be written to while they are apart. When both changed it, the
+	// hub's version wins.
+	Syncer struct {
+		local    nats.JetStreamContext
+		hub      nats.JetStreamContext
+		config   Config
+		state    nats.KeyValue
+		lastFull map[string]time.Time // When each bucket was last compared in full
+		mu       sync.Mutex
+		status   Status
+		stop     chan struct{}
+		done     chan struct{}
+	}
+
+	// side is one bucket on one side, with the versions of its objects
+	side struct {
+		name     string
+		js       nats.JetStreamContext
+		store    *store.ObjectStore
+		versions map[string]string
+		lastSeq  uint64 // Last event to read in this pass
+	}
+
+	// fingerprint is what an object's version is computed from, besides
+	// its data
+	fingerprint struct {
+		Tags        []string `json:"tags,omitempty"`
+		Description string   `json:"description,omitempty"`
+		Collections []string `json:"collections,omitempty"`
+	}
+)
+
+// New creates a syncer between the local JetStream and the hub's. Call
+// Start to begin.
+func New(local, hub nats.JetStreamContext, config Config) *Syncer {
+	s := &Syncer{
+		local:    local,
+		hub:      hub,
+		config:   config,
+		lastFull: map[string]time.Time{},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, bucket := range config.Buckets {
+		s.status.Buckets = append(s.status.Buckets, BucketStatus{Bucket: bucket})
+	}
+	return s
+}
+
+// Start syncs now and then every interval until Stop
+func (s *Syncer) Start() {
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.config.Interval)
+		defer ticker.Stop()
+		for {
+			s.syncAll()
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop waits for a sync in progress to finish and stops syncing
+func (s *Syncer) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+// Status returns the current sync state
+func (s *Syncer) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	status.Buckets = slices.Clone(s.status.Buckets)
+	return status
+}
+
+// syncAll syncs every bucket if the hub can be reached
+func (s *Syncer) syncAll() {
+	_, err := s.hub.AccountInfo(nats.MaxWait(5 * time.Second))
+	connected := err == nil
+
+	s.mu.Lock()
+	if s.status.Checked.IsZero() || connected != s.status.Connected {
+		if connected {
+			log.Printf("Connected to the hub; syncing %d buckets", len(s.config.Buckets))
+		} else {
+			log.Printf("Hub unreachable; changes are kept here until it is back")
+		}
+	}
+	s.status.Connected = connected
+	s.status.Checked = time.Now().UTC()
+	s.mu.Unlock()
+	if !connected {
+		return
+	}
+
+	for i, bucket := range s.config.Buckets {
+		result := BucketStatus{Bucket: bucket}
+		err := s.syncBucket(&result)
+		if err != nil {
+			log.Printf("Failed to sync bucket %s with the hub: %v", bucket, err)
+			result.Error = err.Error()
+		} else if result.Pulled+result.Pushed > 0 {
+			log.Printf("Synced bucket %s with the hub: %d changes pulled, %d pushed", bucket, result.Pulled, result.Pushed)
+		}
+
+		s.mu.Lock()
+		result.LastSync = s.status.Buckets[i].LastSync
+		if err == nil {
+			result.LastSync = time.Now().UTC()
+		}
+		s.status.Buckets[i] = result
+		s.mu.Unlock()
+	}
+}
+
+// syncBucket copies each object that changed on one side since both last
+// agreed on it to the other, counting them in result. Both buckets are
+// created if they don't exist yet.
+func (s *Syncer) syncBucket(result *BucketStatus) error {
+	bucket := result.Bucket
+	if s.state == nil {
+		state, err := s.local.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:      StateBucket,
+			Description: "soxdrawer hub sync state",
+			Replicas:    s.config.Replicas,
+		})
+		if err != nil {
+			if state, err = s.local.KeyValue(StateBucket); err != nil {
+				return fmt.Errorf("failed to create or get sync state: %w", err)
+			}
+		}
+		s.state = state
+	}
+
+	hubReplicas, err := replicas(s.hub)
+	if err != nil {
+		return err
+	}
+	local, err := openSide("local", s.local, bucket, s.config.Replicas)
+	if err != nil {
+		return err
+	}
+	hub, err := openSide("hub", s.hub, bucket, hubReplicas)
+	if err != nil {
+		return err
+	}
+
+	// Only the objects named in events since the last pass can have
+	// changed, unless events were missed or it is time for a full pass
+	var names []string
+	full := time.Since(s.lastFull[bucket]) >= fullSyncInterval
+	if !full {
+		localNames, ok, err := s.changed(local)
+		if err != nil {
+			return err
+		}
+		hubNames, hubOK, err := s.changed(hub)
+		if err != nil {
+			return err
+		}
+		full = !ok || !hubOK
+		maps.Copy(localNames, hubNames)
+		names = slices.Sorted(maps.Keys(localNames))
+	}
+	if full {
+		if err := local.loadAll(); err != nil {
+			return err
+		}
+		if err := hub.loadAll(); err != nil {
+			return err
+		}
+		names = slices.Sorted(maps.Keys(local.versions))
+		for name := range hub.versions {
+			if _, ok := local.versions[name]; !ok {
+				names = append(names, name)
+			}
+		}
+	}
+
+	for _, name := range names {
+		if err := s.syncObject(result, local, hub, name); err != nil {
+			return err
+		}
+	}
+
+	for _, side := range []*side{local, hub} {
+		if _, err := s.state.Put(cursorKey(bucket, side.name), []byte(strconv.FormatUint(side.lastSeq, 10))); err != nil {
+			return fmt.Errorf("failed to save sync state: %w", err)
+		}
+	}
+	if full {
+		s.lastFull[bucket] = time.Now()
+	}
+	return nil
+}
+
+// syncObject brings one object in step on both sides. Whichever side's
+// version differs from the one both last agreed on has changed and is
+// copied to the other.
+func (s *Syncer) syncObject(result *BucketStatus, local, hub *side, name string) error {
+	l, err := local.version(name)
+	if err != nil {
+		return err
+	}
+	h, err := hub.version(name)
+	if err != nil {
+		return err
+	}
+	key := objectKey(local.store.Name(), name)
+	var agreed string
+	entry, err := s.state.Get(key)
+	switch {
+	case err == nil:
+		agreed = string(entry.Value())
+	case !errors.Is(err, nats.ErrKeyNotFound):
+		return fmt.Errorf("failed to get sync state of '%s': %w", name, err)
+	}
+
+	// Both sides end up with the version that was copied, or with the one
+	// they already shared
+	now := l
+	switch {
+	case l == h:
+	case l == agreed:
+		result.Pulled++
+		now, err = h, copyObject(hub.store, local.store, name, h, l)
+	case h == agreed:
+		result.Pushed++
+		err = copyObject(local.store, hub.store, name, l, h)
+	default:
+		log.Printf("Both sides changed %s/%s while apart; keeping the hub's version", local.store.Name(), name)
+		result.Pulled++
+		result.Conflicts++
+		now, err = h, copyObject(hub.store, local.store, name, h, l)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case now == agreed:
+		return nil
+	case now == "":
+		err = s.state.Delete(key)
+	default:
+		_, err = s.state.Put(key, []byte(now))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save sync state of '%s': %w", name, err)
+	}
+	return nil
+}
+
+// changed returns the objects named in the side's events since the last
+// pass. It is not ok when some of those events are no longer kept, or the
+// bucket was never synced, and every object has to be compared instead.
+func (s *Syncer) changed(side *side) (map[string]bool, bool, error) {
+	names := map[string]bool{}
+	entry, err := s.state.Get(cursorKey(side.store.Name(), side.name))
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return names, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get sync state: %w", err)
+	}
+	after, err := strconv.ParseUint(string(entry.Value()), 10, 64)
+	if err != nil {
+		return names, false, nil
+	}
+
+	info, err := side.js.StreamInfo(store.EventsStream)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get %s events: %w", side.name, err)
+	}
+	if info.State.LastSeq < after || info.State.FirstSeq > after+1 {
+		return names, false, nil
+	}
+	if info.State.LastSeq == after {
+		return names, true, nil
+	}
+
+	sub, err := side.js.SubscribeSync(store.EventsSubjectPrefix+".>",
+		nats.BindStream(store.EventsStream),
+		nats.OrderedConsumer(),
+		nats.StartSequence(after+1),
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read %s events: %w", side.name, err)
+	}
+	defer sub.Unsubscribe()
+
+	bucket := side.store.Name()
+	for {
+		msg, err := sub.NextMsg(10 * time.Second)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read %s events: %w", side.name, err)
+		}
+		var event store.Event
+		if err := json.Unmarshal(msg.Data, &event); err == nil && event.Bucket == bucket {
+			names[event.Key] = true
+			if event.NewKey != "" && event.NewBucket == "" {
+				names[event.NewKey] = true
+			}
+		}
+		meta, err := msg.Metadata()
+		if err != nil || meta.Sequence.Stream >= info.State.LastSeq || meta.NumPending == 0 {
+			return names, true, nil
+		}
+	}
+}
+
+// replicas returns the number of replicas a JetStream gives its streams,
+// which its soxdrawer keeps its events stream at
+func replicas(js nats.JetStreamContext) (int, error) {
+	info, err := js.StreamInfo(store.EventsStream)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the hub's events stream: %w", err)
+	}
+	return max(info.Config.Replicas, 1), nil
+}
+
+// openSide opens a bucket and notes the last event recorded so far, which
+// this pass reads up to
+func openSide(name string, js nats.JetStreamContext, bucket string, replicas int) (*side, error) {
+	objectStore, err := store.Open(js, bucket, replicas)
+	if err != nil {
+		return nil, err
+	}
+	info, err := js.StreamInfo(store.EventsStream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s events: %w", name, err)
+	}
+	return &side{
+		name:     name,
+		js:       js,
+		store:    objectStore,
+		versions: map[string]string{},
+		lastSeq:  info.State.LastSeq,
+	}, nil
+}
+
+// loadAll computes the version of every object in the bucket at once
+func (s *side) loadAll() error {
+	infos, err := s.store.Bucket().List()
+	if err != nil && !errors.Is(err, nats.ErrNoObjectsFound) {
+		return fmt.Errorf("failed to list bucket '%s': %w", s.store.Name(), err)
+	}
+	index, err := s.store.AllMetadata()
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		s.versions[info.Name] = version(info.Digest, index[info.Name])
+	}
+	return nil
+}
+
+// version returns the version of an object, or "" if it doesn't exist
+func (s *side) version(name string) (string, error) {
+	if v, ok := s.versions[name]; ok {
+		return v, nil
+	}
+	info, err := s.store.GetInfo(name)
+	if errors.Is(err, nats.ErrObjectNotFound) {
+		s.versions[name] = ""
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	meta, err := s.store.GetMetadata(name)
+	if err != nil {
+		return "", err
+	}
+	s.versions[name] = version(info.Digest, meta)
+	return s.versions[name], nil
+}
+
+// version identifies the content of an object by its data's digest and
+// its tags, description and collections. When they were last edited
+// doesn't count, so both sides agree on a copy.
+func version(digest string, meta *store.ItemMetadata) string {
+	if meta == nil || len(meta.Tags) == 0 && len(meta.Collections) == 0 && meta.Description == "" {
+		return digest
+	}
+	data, _ := json.Marshal(fingerprint{Tags: meta.Tags, Description: meta.Description, Collections: meta.Collections})
+	sum := sha256.Sum256(data)
+	return digest + " " + hex.EncodeToString(sum[:])
+}
+
+// copyObject makes the object in to match from's version of it, which
+// is "" when from deleted it. Only the metadata is copied when the data
+// is the same.
+func copyObject(from, to *store.ObjectStore, name, fromVersion, toVersion string) error {
+	switch {
+	case fromVersion == "":
+		return to.Delete(name)
+	case toVersion != "" && digest(fromVersion) == digest(toVersion):
+		return from.SyncMetadataTo(to, name)
+	default:
+		_, err := from.SyncTo(to, name)
+		return err
+	}
+}
+
+// digest returns the data part of a version
+func digest(version string) string {
+	d, _, _ := strings.Cut(version, " ")
+	return d
+}
+
+// objectKey is the state key of an object's agreed version
+func objectKey(bucket, name string) string {
+	return bucket + ".objects." + base64.RawURLEncoding.EncodeToString([]byte(name))
+}
+
+// cursorKey is the state key of the last event of a side read for a bucket
+func cursorKey(bucket, side string) string {
+	return bucket + ".events." + side
+}
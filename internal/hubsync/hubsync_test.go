@@ -0,0 +1,186 @@
+package hubsync
+
+import (
+	"testing"
+	"time"
+
+	natsServer "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"soxdrawer/internal/store"
+)
+
+// startJetStream runs an in-process NATS server with JetStream for one test
+func startJetStream(t *testing.T) nats.JetStreamContext {
+	t.Helper()
+
+	ns, err := natsServer.NewServer(&natsServer.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create NATS server: %v", err)
+	}
+	ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("failed to connect to NATS: %v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	js, err := conn.JetStream()
+	if err != nil {
+		t.Fatalf("failed to get JetStream context: %v", err)
+	}
+	return js
+}
+
+// newPair returns a syncer for the "photos" bucket between two separate
+// servers, and the bucket on each
+func newPair(t *testing.T) (*Syncer, *store.ObjectStore, *store.ObjectStore) {
+	t.Helper()
+	localJS, hubJS := startJetStream(t), startJetStream(t)
+	local, err := store.Open(localJS, "photos", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub, err := store.Open(hubJS, "photos", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(localJS, hubJS, Config{Buckets: []string{"photos"}, Replicas: 1}), local, hub
+}
+
+// syncOnce runs one pass and returns the bucket's outcome
+func syncOnce(t *testing.T, s *Syncer) BucketStatus {
+	t.Helper()
+	s.syncAll()
+	status := s.Status().Buckets[0]
+	if status.Error != "" {
+		t.Fatalf("sync failed: %s", status.Error)
+	}
+	return status
+}
+
+func content(t *testing.T, objects *store.ObjectStore, key string) string {
+	t.Helper()
+	data, err := objects.GetString(key)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", key, err)
+	}
+	return data
+}
+
+func TestSyncCopiesChangesBothWays(t *testing.T) {
+	s, local, hub := newPair(t)
+	if _, err := local.PutString("laptop.txt", "from the laptop"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hub.PutString("desk.txt", "from the desk"); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := syncOnce(t, s); status.Pulled != 1 || status.Pushed != 1 {
+		t.Fatalf("first sync pulled %d and pushed %d, want 1 and 1", status.Pulled, status.Pushed)
+	}
+	if got := content(t, hub, "laptop.txt"); got != "from the laptop" {
+		t.Fatalf("hub has %q", got)
+	}
+	if got := content(t, local, "desk.txt"); got != "from the desk" {
+		t.Fatalf("laptop has %q", got)
+	}
+
+	// Nothing changed, so nothing is copied back
+	if status := syncOnce(t, s); status.Pulled != 0 || status.Pushed != 0 {
+		t.Fatalf("second sync pulled %d and pushed %d, want none", status.Pulled, status.Pushed)
+	}
+
+	if err := hub.Delete("laptop.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if status := syncOnce(t, s); status.Pulled != 1 {
+		t.Fatalf("sync after a delete pulled %d, want 1", status.Pulled)
+	}
+	if exists, _ := local.Exists("laptop.txt"); exists {
+		t.Fatal("delete on the hub was not synced")
+	}
+}
+
+func TestSyncCopiesMetadata(t *testing.T) {
+	s, local, hub := newPair(t)
+	if _, err := local.PutString("photo.jpg", "pixels"); err != nil {
+		t.Fatal(err)
+	}
+	syncOnce(t, s)
+
+	tags := []string{"holiday"}
+	if _, err := local.PatchMetadata("photo.jpg", &store.MetadataPatch{Tags: &tags}); err != nil {
+		t.Fatal(err)
+	}
+	if status := syncOnce(t, s); status.Pushed != 1 {
+		t.Fatalf("sync after tagging pushed %d, want 1", status.Pushed)
+	}
+	meta, err := hub.GetMetadata("photo.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Tags) != 1 || meta.Tags[0] != "holiday" {
+		t.Fatalf("hub has tags %v, want [holiday]", meta.Tags)
+	}
+}
+
+func TestSyncConflictKeepsTheHubsVersion(t *testing.T) {
+	s, local, hub := newPair(t)
+	if _, err := local.PutString("notes.txt", "original"); err != nil {
+		t.Fatal(err)
+	}
+	syncOnce(t, s)
+
+	// The laptop's change is later, but clocks don't decide
+	if _, err := hub.PutString("notes.txt", "edited on the hub"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := local.PutString("notes.txt", "edited on the laptop"); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := syncOnce(t, s); status.Conflicts != 1 {
+		t.Fatalf("sync settled %d conflicts, want 1", status.Conflicts)
+	}
+	for name, objects := range map[string]*store.ObjectStore{"laptop": local, "hub": hub} {
+		if got := content(t, objects, "notes.txt"); got != "edited on the hub" {
+			t.Fatalf("%s has %q, want the hub's version", name, got)
+		}
+	}
+}
+
+func TestSyncWithoutEventsComparesEverything(t *testing.T) {
+	s, local, hub := newPair(t)
+	syncOnce(t, s)
+
+	// A write straight into the object store records no event
+	if _, err := hub.Bucket().PutString("raw.txt", "no event"); err != nil {
+		t.Fatal(err)
+	}
+	syncOnce(t, s)
+	if exists, _ := local.Exists("raw.txt"); exists {
+		t.Fatal("raw write synced before the next full pass")
+	}
+
+	s.lastFull = map[string]time.Time{}
+	if status := syncOnce(t, s); status.Pulled != 1 {
+		t.Fatalf("full sync pulled %d, want 1", status.Pulled)
+	}
+	if got := content(t, local, "raw.txt"); got != "no event" {
+		t.Fatalf("laptop has %q", got)
+	}
+}
@@ -0,0 +1,187 @@
+package nats
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"slices"
+	"sync"
+
+	natsServer "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nkeys"
+)
+
+type (
+	// Client is a program that logs in with its own nkey instead of the
+	// shared token, and may only use the buckets it was given
+	Client struct {
+		Name    string
+		NKey    string   // Public user nkey, starting with U
+		Access  string   // AccessRead, AccessWrite or AccessAdmin
+		Buckets []string // Buckets it may use; ignored for AccessAdmin
+	}
+
+	// authenticator checks every client connection against the token and
+	// the clients' nkeys. It replaces the server's own token check, which
+	// can't be combined with nkeys.
+	authenticator struct {
+		mu       sync.RWMutex
+		token    string
+		clients  map[string]Client           // By public nkey
+		internal map[string]*natsServer.User // soxdrawer's own connections, by public nkey
+	}
+)
+
+const (
+	AccessRead  = "read"  // Get and list objects
+	AccessWrite = "write" // Plus put them through the bridge
+	AccessAdmin = "admin" // Everything the token allows
+)
+
+// InboxPrefix is the inbox prefix a client has to connect with, using
+// nats.CustomInboxPrefix, since replies are only delivered to it there
+func InboxPrefix(name string) string {
+	return "_INBOX_" + name
+}
+
+func newAuthenticator(token string, clients []Client) *authenticator {
+	a := &authenticator{token: token}
+	a.setClients(clients)
+	return a
+}
+
+// setClients replaces the clients and returns the nkeys of those that were
+// removed or changed
+func (a *authenticator) setClients(clients []Client) []string {
+	byKey := map[string]Client{}
+	for _, client := range clients {
+		byKey[client.NKey] = client
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var changed []string
+	for key, old := range a.clients {
+		client, ok := byKey[key]
+		if !ok || client.Name != old.Name || client.Access != old.Access || !slices.Equal(client.Buckets, old.Buckets) {
+			changed = append(changed, key)
+		}
+	}
+	a.clients = byKey
+	return changed
+}
+
+func (a *authenticator) setToken(token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.token = token
+}
+
+// addInternal lets a connection of soxdrawer's own log in with nkey as user
+func (a *authenticator) addInternal(nkey string, user *natsServer.User) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.internal == nil {
+		a.internal = map[string]*natsServer.User{}
+	}
+	a.internal[nkey] = user
+}
+
+// clientName returns the name of the client with nkey
+func (a *authenticator) clientName(nkey string) (string, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	client, ok := a.clients[nkey]
+	return client.Name, ok
+}
+
+// isClient reports whether nkey belongs to one of the clients
+func (a *authenticator) isClient(nkey string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	_, ok := a.clients[nkey]
+	return ok
+}
+
+// Check implements natsServer.Authentication
+func (a *authenticator) Check(c natsServer.ClientAuthentication) bool {
+	// Leaf nodes log in with the leaf node token when they connect, which
+	// the server checks itself; reloads re-check them here
+	if c.Kind() == natsServer.LEAF {
+		return true
+	}
+
+	opts := c.GetOpts()
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if opts.Nkey != "" {
+		if user, ok := a.internal[opts.Nkey]; ok {
+			if c.Kind() != natsServer.CLIENT || !verify(opts.Nkey, opts.Sig, c.GetNonce()) {
+				return false
+			}
+			c.RegisterUser(user)
+			return true
+		}
+		client, ok := a.clients[opts.Nkey]
+		if !ok || !verify(opts.Nkey, opts.Sig, c.GetNonce()) {
+			return false
+		}
+		c.RegisterUser(&natsServer.User{Username: client.Name, Permissions: client.permissions()})
+		return true
+	}
+
+	if opts.Token == "" || subtle.ConstantTimeCompare([]byte(opts.Token), []byte(a.token)) != 1 {
+		return false
+	}
+	c.RegisterUser(&natsServer.User{})
+	return true
+}
+
+// verify checks the signature of the nonce the server sent the client
+func verify(publicKey, sig string, nonce []byte) bool {
+	if sig == "" || len(nonce) == 0 {
+		return false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		if signature, err = base64.StdEncoding.DecodeString(sig); err != nil {
+			return false
+		}
+	}
+	user, err := nkeys.FromPublicKey(publicKey)
+	return err == nil && user.Verify(nonce, signature) == nil
+}
+
+// permissions limits a client to the JetStream API calls and object store
+// subjects of its buckets. Admins get no limits. Write clients can't publish
+// to the object store itself, whose writes would skip quotas and the audit
+// log; they put objects through the bridge instead.
+func (c Client) permissions() *natsServer.Permissions {
+	if c.Access == AccessAdmin {
+		return nil
+	}
+
+	publish := []string{"$JS.API.INFO"}
+	for _, bucket := range c.Buckets {
+		stream := "OBJ_" + bucket
+		publish = append(publish,
+			"$JS.API.STREAM.INFO."+stream,
+			"$JS.API.STREAM.MSG.GET."+stream,
+			"$JS.API.DIRECT.GET."+stream,
+			"$JS.API.DIRECT.GET."+stream+".>",
+			"$JS.API.CONSUMER.CREATE."+stream,
+			"$JS.API.CONSUMER.CREATE."+stream+".>",
+			"$JS.API.CONSUMER.INFO."+stream+".>",
+			"$JS.API.CONSUMER.DELETE."+stream+".>",
+			"$JS.FC."+stream+".>",
+		)
+		if c.Access == AccessWrite {
+			publish = append(publish, "soxdrawer.put."+bucket+".>") // Stored by the bridge, see bridge.Prefix
+		}
+	}
+
+	return &natsServer.Permissions{
+		Publish:   &natsServer.SubjectPermission{Allow: publish},
+		Subscribe: &natsServer.SubjectPermission{Allow: []string{InboxPrefix(c.Name) + ".>"}},
+	}
+}
@@ -0,0 +1,93 @@
+package nats
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	natsServer "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+type (
+	// HubConfig connects to a hub soxdrawer as a leaf node
+	HubConfig struct {
+		URLs       []string // nats-leaf://host:port of the hub's servers
+		Token      string   // The hub's leaf node token
+		Domain     string   // The hub's JetStream domain, which leaf nodes reach it through
+		ServerName string   // This server's name, which the hub sees the link under
+		LogLevel   string   // The link's own log is only shown from LogDebug up
+	}
+
+	// HubLink is a NATS server with neither listeners nor JetStream that
+	// connects to the hub as a leaf node. Its connection reaches the hub's
+	// JetStream and nothing else: the local server's streams stay apart
+	// from the hub's, which share their subjects.
+	HubLink struct {
+		server *natsServer.Server
+		conn   *nats.Conn
+		domain string
+	}
+)
+
+// hubReconnectInterval is how often an unreachable hub is tried again
+const hubReconnectInterval = 10 * time.Second
+
+// ConnectHub starts the link. It keeps trying to reach the hub in the
+// background, so it succeeds while the hub is unreachable.
+func ConnectHub(config *HubConfig) (*HubLink, error) {
+	remote := &natsServer.RemoteLeafOpts{}
+	for _, hubURL := range config.URLs {
+		u, err := url.Parse(hubURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hub URL %q: %w", hubURL, err)
+		}
+		u.User = url.UserPassword(serverUser, config.Token)
+		remote.URLs = append(remote.URLs, u)
+	}
+
+	opts := &natsServer.Options{
+		ServerName: config.ServerName,
+		DontListen: true,
+		NoSigs:     true,
+		LeafNode: natsServer.LeafNodeOpts{
+			Remotes:           []*natsServer.RemoteLeafOpts{remote},
+			ReconnectInterval: hubReconnectInterval,
+		},
+	}
+	server, err := natsServer.NewServer(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create hub link: %w", err)
+	}
+	if config.LogLevel == LogDebug || config.LogLevel == LogTrace {
+		server.SetLoggerV2(logger{notices: true}, true, config.LogLevel == LogTrace, false)
+	}
+
+	go server.Start()
+	if !server.ReadyForConnections(10 * time.Second) {
+		server.Shutdown()
+		return nil, fmt.Errorf("hub link failed to start within timeout")
+	}
+
+	conn, err := nats.Connect("", nats.InProcessServer(server))
+	if err != nil {
+		server.Shutdown()
+		return nil, fmt.Errorf("failed to connect to hub link: %w", err)
+	}
+	return &HubLink{server: server, conn: conn, domain: config.Domain}, nil
+}
+
+// JetStream returns a JetStream context for the hub's domain
+func (h *HubLink) JetStream() (nats.JetStreamContext, error) {
+	js, err := h.conn.JetStream(nats.Domain(h.domain))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JetStream context for domain %s: %w", h.domain, err)
+	}
+	return js, nil
+}
+
+// Close disconnects from the hub
+func (h *HubLink) Close() {
+	h.conn.Close()
+	h.server.Shutdown()
+}
@@ -3,13 +3,18 @@ package nats
 import (
 	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"net/url"
+	"sync"
 	"time"
 
 	natsServer "github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
 )
 
 type (
@@ -18,15 +23,107 @@ type (
 		conn   *nats.Conn
 		js     nats.JetStreamContext
 		opts   *natsServer.Options
+		mu     sync.RWMutex // Guards token and opts, which change on reload
 		token  string
+		auth   *authenticator
+		quorum int // Cluster servers to wait for in Start
+
+		quorumTimeout time.Duration
 	}
 
 	Config struct {
-		Host     string
-		Port     int
-		StoreDir string
-		Token    string // Authentication token
+		Host          string
+		Port          int
+		StoreDir      string
+		Token         string        // Authentication token
+		Clients       []Client      // Log in with their own nkeys and permissions
+		TLS           *tls.Config   // Serve client connections over TLS when set
+		LogLevel      string        // LogInfo, LogDebug or LogTrace; empty means LogInfo
+		ServerName    string        // Empty uses the server's generated ID
+		MaxPayload    int32         // Largest message in bytes; 0 means DefaultMaxPayload
+		WriteDeadline time.Duration // 0 means DefaultWriteDeadline
+		MaxConns      int           // 0 means the server's default
+		PingInterval  time.Duration // 0 means the server's default
+		JetStream     JetStreamLimits
+		Cluster       *ClusterConfig   // Join a cluster when set; needs ServerName
+		LeafNodes     *LeafNodesConfig // Accept leaf nodes when set
+		WebSocket     *WebSocketConfig // Accept WebSocket clients when set
+		MQTT          *MQTTConfig      // Accept MQTT clients when set
+	}
+
+	// ClusterConfig connects servers into a cluster so JetStream can
+	// replicate streams between them
+	ClusterConfig struct {
+		Name   string
+		Host   string
+		Port   int
+		Routes []string // nats-route://host:port of the other servers; listing this one too is fine
+		Token  string   // Shared by every server in the cluster
+		Quorum int      // Servers, this one included, to wait for before starting; a majority of the replicas
+		// How long Start waits for the quorum; 0 means DefaultQuorumTimeout
+		QuorumTimeout time.Duration
+	}
+
+	// LeafNodesConfig accepts other servers as leaf nodes, so they can
+	// reach this one's JetStream through its domain
+	LeafNodesConfig struct {
+		Host  string
+		Port  int
+		Token string // Leaf nodes log in with it
+	}
+
+	// WebSocketConfig serves NATS over WebSocket. Clients log in the same
+	// way as on the NATS port.
+	WebSocketConfig struct {
+		Host           string
+		Port           int
+		NoTLS          bool     // Serve plain ws:// instead of using Config.TLS
+		AllowedOrigins []string // Empty allows any origin
+		Compression    bool
 	}
+
+	// MQTTConfig accepts MQTT clients, which give the token as their
+	// password. Their sessions are kept in JetStream.
+	MQTTConfig struct {
+		Host  string
+		Port  int
+		NoTLS bool // Accept plain TCP instead of using Config.TLS
+	}
+
+	// JetStreamLimits caps the resources JetStream may use. Zero limits
+	// leave the server's defaults, which are 75% of memory and of the free
+	// space under the store directory.
+	JetStreamLimits struct {
+		MaxMemory int64  // Bytes of memory storage
+		MaxStore  int64  // Bytes of file storage
+		Domain    string // JetStream domain, for telling servers apart once connected
+	}
+
+	// logger writes the server's log through the standard logger. Notices
+	// are only shown from the debug level up.
+	logger struct {
+		notices bool
+	}
+)
+
+// serverUser is the name soxdrawer servers log in to each other with, over
+// cluster routes and leaf node connections
+const serverUser = "soxdrawer"
+
+// bridgeAccount holds the bridge's connection. Messages reach it through a
+// service import, which makes the server add the publisher to each one.
+const bridgeAccount = "SOXDRAWER_BRIDGE"
+
+const (
+	DefaultMaxPayload    = 1 << 20 // 1MB
+	DefaultWriteDeadline = 10 * time.Second
+	DefaultQuorumTimeout = 2 * time.Minute
+)
+
+const (
+	LogInfo  = "info"  // Only the server's warnings and errors
+	LogDebug = "debug" // Plus notices and debug messages
+	LogTrace = "trace" // Plus protocol traces
 )
 
 func DefaultConfig() *Config {
@@ -55,21 +152,93 @@ func NewServer(config *Config) (*NATSServer, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to generate authentication token: %w", err)
 		}
-		log.Printf("Generated NATS authentication token: %s", token)
+		log.Printf("Generated NATS authentication token")
 	}
 
+	auth := newAuthenticator(token, config.Clients)
 	opts := &natsServer.Options{
-		Host:      config.Host,
-		Port:      config.Port,
-		JetStream: true,
-		StoreDir:  config.StoreDir,
-		
-		// Token-based authentication
-		Authorization: token,
-		
+		ServerName: config.ServerName,
+		Host:       config.Host,
+		Port:       config.Port,
+		JetStream:  true,
+		StoreDir:   config.StoreDir,
+
+		// Token and nkey authentication, both checked by the authenticator
+		// so the token can change without reloading the server
+		CustomClientAuthentication: auth,
+		AlwaysEnableNonce:          true,
+
 		// Additional security settings
-		WriteDeadline: 10 * time.Second,
-		MaxPayload:     1 << 20, // 1MB
+		WriteDeadline: config.WriteDeadline,
+		MaxPayload:    config.MaxPayload,
+		MaxConn:       config.MaxConns,
+		PingInterval:  config.PingInterval,
+
+		JetStreamMaxMemory: config.JetStream.MaxMemory,
+		JetStreamMaxStore:  config.JetStream.MaxStore,
+		JetStreamDomain:    config.JetStream.Domain,
+
+		// Signals are handled by the application, which reloads on SIGHUP
+		NoSigs: true,
+	}
+	if opts.WriteDeadline == 0 {
+		opts.WriteDeadline = DefaultWriteDeadline
+	}
+	if opts.MaxPayload == 0 {
+		opts.MaxPayload = DefaultMaxPayload
+	}
+
+	if cluster := config.Cluster; cluster != nil {
+		opts.Cluster = natsServer.ClusterOpts{
+			Name:     cluster.Name,
+			Host:     cluster.Host,
+			Port:     cluster.Port,
+			Username: serverUser,
+			Password: cluster.Token,
+		}
+		for _, route := range cluster.Routes {
+			u, err := url.Parse(route)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cluster route %q: %w", route, err)
+			}
+			u.User = url.UserPassword(serverUser, cluster.Token)
+			opts.Routes = append(opts.Routes, u)
+		}
+	}
+
+	if leaf := config.LeafNodes; leaf != nil {
+		opts.LeafNode.Host = leaf.Host
+		opts.LeafNode.Port = leaf.Port
+		opts.LeafNode.Username = serverUser
+		opts.LeafNode.Password = leaf.Token
+	}
+
+	if config.TLS != nil {
+		opts.TLSConfig = config.TLS
+		opts.TLS = true
+		opts.TLSVerify = config.TLS.ClientAuth == tls.RequireAndVerifyClientCert
+		opts.TLSTimeout = 2
+	}
+
+	if ws := config.WebSocket; ws != nil {
+		opts.Websocket = natsServer.WebsocketOpts{
+			Host:           ws.Host,
+			Port:           ws.Port,
+			NoTLS:          ws.NoTLS,
+			AllowedOrigins: ws.AllowedOrigins,
+			Compression:    ws.Compression,
+		}
+		if !ws.NoTLS {
+			opts.Websocket.TLSConfig = config.TLS
+		}
+	}
+	if mqtt := config.MQTT; mqtt != nil {
+		opts.MQTT.Host = mqtt.Host
+		opts.MQTT.Port = mqtt.Port
+		if !mqtt.NoTLS {
+			opts.MQTT.TLSConfig = config.TLS
+			opts.MQTT.TLSTimeout = 2
+		}
 	}
 
 	ns, err := natsServer.NewServer(opts)
@@ -77,11 +246,21 @@ func NewServer(config *Config) (*NATSServer, error) {
 		return nil, fmt.Errorf("failed to create NATS server: %w", err)
 	}
 
-	return &NATSServer{
+	server := &NATSServer{
 		server: ns,
 		opts:   opts,
 		token:  token,
-	}, nil
+		auth:   auth,
+	}
+	if config.Cluster != nil {
+		server.quorum = config.Cluster.Quorum
+		server.quorumTimeout = config.Cluster.QuorumTimeout
+		if server.quorumTimeout == 0 {
+			server.quorumTimeout = DefaultQuorumTimeout
+		}
+	}
+	server.SetLogLevel(config.LogLevel)
+	return server, nil
 }
 
 // Start starts the NATS server and establishes connections
@@ -94,8 +273,34 @@ func (ns *NATSServer) Start() error {
 
 	log.Printf("NATS server started on %s:%d with JetStream enabled and token authentication", ns.opts.Host, ns.opts.Port)
 
-	url := fmt.Sprintf("nats://%s:%d", ns.opts.Host, ns.opts.Port)
-	conn, err := nats.Connect(url, nats.Token(ns.token))
+	if ns.opts.Cluster.Port != 0 {
+		log.Printf("NATS cluster %s listening on %s:%d with %d routes", ns.opts.Cluster.Name, ns.opts.Cluster.Host, ns.opts.Cluster.Port, len(ns.opts.Routes))
+	}
+	if ns.opts.LeafNode.Port != 0 {
+		log.Printf("NATS accepting leaf nodes on %s:%d", ns.opts.LeafNode.Host, ns.opts.LeafNode.Port)
+	}
+	if ns.opts.Websocket.Port != 0 {
+		log.Printf("NATS accepting WebSocket clients on %s:%d", ns.opts.Websocket.Host, ns.opts.Websocket.Port)
+	}
+	if ns.opts.MQTT.Port != 0 {
+		log.Printf("NATS accepting MQTT clients on %s:%d", ns.opts.MQTT.Host, ns.opts.MQTT.Port)
+	}
+	if clients := len(ns.auth.clients); clients > 0 {
+		log.Printf("NATS accepting %d clients with their own nkeys", clients)
+	}
+
+	// The embedded connection goes through the in-process transport, which
+	// skips TLS and client certificate checks meant for network clients. It
+	// stays connected when the token rotates, and asks for the current token
+	// whenever it has to reconnect.
+	conn, err := nats.Connect("", nats.InProcessServer(ns.server), nats.TokenHandler(ns.Token),
+		nats.ReconnectWait(10*time.Millisecond),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			if !errors.Is(err, nats.ErrAuthorization) {
+				log.Printf("Embedded NATS connection error: %v", err)
+			}
+		}),
+	)
 	if err != nil {
 		return fmt.Errorf("failed to connect to NATS: %w", err)
 	}
@@ -108,6 +313,83 @@ func (ns *NATSServer) Start() error {
 	}
 	ns.js = js
 
+	if ns.opts.Cluster.Port != 0 {
+		return ns.waitForCluster()
+	}
+	return nil
+}
+
+// waitForCluster blocks until the quorum of servers is connected and
+// JetStream has elected a leader, since streams can't be created with
+// their replicas or opened before that. It gives up after the quorum
+// timeout rather than hang a server whose peers are gone for good.
+func (ns *NATSServer) waitForCluster() error {
+	deadline := time.Now().Add(ns.quorumTimeout)
+	next := time.Now().Add(5 * time.Second)
+	for {
+		peers := ns.peers()
+		if peers+1 >= ns.quorum {
+			if _, err := ns.js.AccountInfo(nats.MaxWait(time.Second)); err == nil {
+				log.Printf("Joined NATS cluster %s with %d other servers", ns.opts.Cluster.Name, peers)
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("NATS cluster %s has no JetStream leader after %s with %d of %d servers connected", ns.opts.Cluster.Name, ns.quorumTimeout, peers+1, ns.quorum)
+		}
+		if time.Now().After(next) {
+			log.Printf("Waiting for NATS cluster %s: %d of %d servers connected", ns.opts.Cluster.Name, peers+1, ns.quorum)
+			next = time.Now().Add(5 * time.Second)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// RetryPlacement runs create until JetStream can place its replicas. Just
+// after joining a cluster the leader may not have heard from every server
+// yet, so creating a replicated stream fails for a moment.
+func (ns *NATSServer) RetryPlacement(create func() error) error {
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		err := create()
+		var apiErr *nats.APIError
+		if !errors.As(err, &apiErr) || apiErr.ErrorCode != nats.ErrorCode(natsServer.JSClusterNoPeersErrF) || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// peers counts the other servers this one has routes to. There can be
+// several routes to each.
+func (ns *NATSServer) peers() int {
+	routez, err := ns.server.Routez(nil)
+	if err != nil {
+		return 0
+	}
+	servers := map[string]bool{}
+	for _, route := range routez.Routes {
+		servers[route.RemoteID] = true
+	}
+	return len(servers)
+}
+
+// ScaleStreams gives every stream the same number of replicas, so buckets
+// created before the server joined a cluster are replicated too, and those
+// created with the old count follow a change
+func (ns *NATSServer) ScaleStreams(replicas int) error {
+	for info := range ns.js.StreamsInfo() {
+		current := max(info.Config.Replicas, 1)
+		if current == replicas {
+			continue
+		}
+		config := info.Config
+		config.Replicas = replicas
+		if _, err := ns.js.UpdateStream(&config); err != nil {
+			return fmt.Errorf("failed to change stream %s from %d to %d replicas: %w", config.Name, current, replicas, err)
+		}
+		log.Printf("Changed stream %s from %d to %d replicas", config.Name, current, replicas)
+	}
 	return nil
 }
 
@@ -145,16 +427,156 @@ func (ns *NATSServer) JetStream() nats.JetStreamContext {
 
 // URL returns the server URL
 func (ns *NATSServer) URL() string {
-	return fmt.Sprintf("nats://%s:%d", ns.opts.Host, ns.opts.Port)
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+
+	scheme := "nats"
+	if ns.opts.TLSConfig != nil {
+		scheme = "tls"
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, ns.opts.Host, ns.opts.Port)
 }
 
 // Token returns the authentication token
 func (ns *NATSServer) Token() string {
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
 	return ns.token
 }
 
-// CreateClientConnection creates a new authenticated connection for external clients
+// SetToken rotates the authentication token. Only the authenticator's
+// secret changes; the server is not reloaded, so clients with nkeys, leaf
+// nodes and the embedded connection carry on. Other clients that logged in
+// with the old token are disconnected and have to reconnect with the new one.
+func (ns *NATSServer) SetToken(token string) error {
+	ns.mu.Lock()
+	ns.token = token
+	ns.auth.setToken(token)
+	ns.mu.Unlock()
+
+	var embedded uint64
+	if ns.conn != nil {
+		embedded, _ = ns.conn.GetClientID()
+	}
+	connz, err := ns.server.Connz(&natsServer.ConnzOptions{Username: true, Account: natsServer.DEFAULT_GLOBAL_ACCOUNT, Limit: 1 << 16})
+	if err != nil {
+		return fmt.Errorf("failed to list NATS connections: %w", err)
+	}
+	for _, conn := range connz.Conns {
+		if conn.Cid == embedded || ns.auth.isClient(conn.AuthorizedUser) {
+			continue
+		}
+		if err := ns.server.DisconnectClientByID(conn.Cid); err != nil {
+			log.Printf("Failed to disconnect NATS connection %d after the token change: %v", conn.Cid, err)
+		}
+	}
+	return nil
+}
+
+// SetClients replaces the clients allowed to log in with nkeys.
+// Connections of clients that were removed or whose access changed are
+// closed; the rest keep going, and changed clients get their new
+// permissions when they reconnect.
+func (ns *NATSServer) SetClients(clients []Client) {
+	for _, nkey := range ns.auth.setClients(clients) {
+		connz, err := ns.server.Connz(&natsServer.ConnzOptions{User: nkey, Limit: 1 << 16})
+		if err != nil {
+			log.Printf("Failed to list connections of NATS client %s: %v", nkey, err)
+			continue
+		}
+		for _, conn := range connz.Conns {
+			if err := ns.server.DisconnectClientByID(conn.Cid); err != nil {
+				log.Printf("Failed to disconnect NATS client %s: %v", nkey, err)
+			}
+		}
+	}
+}
+
+// SetLogLevel changes how much of the server's own log is shown
+func (ns *NATSServer) SetLogLevel(level string) {
+	ns.server.SetLoggerV2(logger{notices: level == LogDebug || level == LogTrace}, level == LogDebug || level == LogTrace, level == LogTrace, false)
+}
+
+// CreateClientConnection creates a new in-process connection with the
+// token, and so with full access. Programs that should only reach some
+// buckets connect over the network as a Client instead.
 func (ns *NATSServer) CreateClientConnection() (*nats.Conn, error) {
-	url := fmt.Sprintf("nats://%s:%d", ns.opts.Host, ns.opts.Port)
-	return nats.Connect(url, nats.Token(ns.token))
+	return nats.Connect("", nats.InProcessServer(ns.server), nats.TokenHandler(ns.Token))
+}
+
+// CreateBridgeConnection creates an in-process connection that receives
+// what anyone publishes on subject, which must end in '>', with the
+// publisher's nkey, if it has one, in the Nats-Request-Info header. It can
+// only subscribe to subject and answer requests.
+func (ns *NATSServer) CreateBridgeConnection(subject string) (*nats.Conn, error) {
+	user, err := nkeys.CreateUser()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bridge nkey: %w", err)
+	}
+	nkey, err := user.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bridge nkey: %w", err)
+	}
+
+	// The account is only added now, after JetStream started, since
+	// configuring accounts up front would turn JetStream off in the global
+	// account that holds the buckets
+	account, err := ns.server.LookupAccount(bridgeAccount)
+	if err != nil {
+		if account, err = ns.server.RegisterAccount(bridgeAccount); err != nil {
+			return nil, fmt.Errorf("failed to create bridge account: %w", err)
+		}
+		global := ns.server.GlobalAccount()
+		if err := account.AddServiceExport(subject, []*natsServer.Account{global}); err != nil {
+			return nil, fmt.Errorf("failed to export %s to the bridge: %w", subject, err)
+		}
+		if err := global.AddServiceImport(account, subject, ""); err != nil {
+			return nil, fmt.Errorf("failed to import %s into the bridge: %w", subject, err)
+		}
+		if err := global.SetServiceImportSharing(account, subject, true); err != nil {
+			return nil, fmt.Errorf("failed to share publishers with the bridge: %w", err)
+		}
+	}
+	ns.auth.addInternal(nkey, &natsServer.User{
+		Username: "bridge",
+		Account:  account,
+		Permissions: &natsServer.Permissions{
+			Publish:   &natsServer.SubjectPermission{Deny: []string{">"}},
+			Subscribe: &natsServer.SubjectPermission{Allow: []string{subject}},
+			Response:  &natsServer.ResponsePermission{MaxMsgs: 1},
+		},
+	})
+
+	return nats.Connect("", nats.InProcessServer(ns.server), nats.Nkey(nkey, user.Sign))
+}
+
+// ClientName returns the name of the client that logs in with nkey
+func (ns *NATSServer) ClientName(nkey string) (string, bool) {
+	return ns.auth.clientName(nkey)
+}
+
+func (l logger) Noticef(format string, v ...any) {
+	if l.notices {
+		log.Printf("NATS: "+format, v...)
+	}
+}
+
+func (l logger) Warnf(format string, v ...any) {
+	log.Printf("NATS warning: "+format, v...)
+}
+
+func (l logger) Fatalf(format string, v ...any) {
+	log.Fatalf("NATS fatal: "+format, v...)
+}
+
+func (l logger) Errorf(format string, v ...any) {
+	log.Printf("NATS error: "+format, v...)
+}
+
+func (l logger) Debugf(format string, v ...any) {
+	log.Printf("NATS debug: "+format, v...)
+}
+
+func (l logger) Tracef(format string, v ...any) {
+	log.Printf("NATS trace: "+format, v...)
 }
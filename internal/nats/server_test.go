@@ -0,0 +1,303 @@
+package nats
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+const testToken = "test-token-0123456789abcdef0123456789"
+
+// freePort returns a port nothing is listening on
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// newClusterNode creates one server of a cluster on localhost, stopped when
+// the test ends
+func newClusterNode(t *testing.T, name string, port int, routes []string, quorum int) *NATSServer {
+	t.Helper()
+	ns, err := NewServer(&Config{
+		Host:       "127.0.0.1",
+		Port:       -1,
+		StoreDir:   t.TempDir(),
+		Token:      testToken,
+		ServerName: name,
+		LogLevel:   LogInfo,
+		Cluster: &ClusterConfig{
+			Name:          "test",
+			Host:          "127.0.0.1",
+			Port:          port,
+			Routes:        routes,
+			Token:         testToken,
+			Quorum:        quorum,
+			QuorumTimeout: 30 * time.Second,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ns.Stop(context.Background()) })
+	return ns
+}
+
+func TestClusterSurvivesLosingANode(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a three-node cluster")
+	}
+
+	ports := []int{freePort(t), freePort(t), freePort(t)}
+	var routes []string
+	for _, port := range ports {
+		routes = append(routes, fmt.Sprintf("nats-route://127.0.0.1:%d", port))
+	}
+
+	// Three replicas need a majority of two servers to start
+	nodes := make([]*NATSServer, len(ports))
+	for i, port := range ports {
+		nodes[i] = newClusterNode(t, fmt.Sprintf("sox-%d", i+1), port, routes, 3/2+1)
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, len(nodes))
+	for i, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = node.Start()
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("node %d failed to start: %v", i+1, err)
+		}
+	}
+
+	var bucket nats.ObjectStore
+	err := nodes[0].RetryPlacement(func() (err error) {
+		bucket, err = nodes[0].JetStream().CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "replicated", Replicas: 3})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to create replicated bucket: %v", err)
+	}
+	if _, err := bucket.PutString("before", "written with three nodes"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := nodes[2].Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The stream may have to elect a new leader if the stopped node led it
+	err = eventually(func() error {
+		_, err := bucket.PutString("after", "written with two nodes")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("write after losing a node failed: %v", err)
+	}
+
+	other, err := nodes[1].JetStream().ObjectStore("replicated")
+	if err != nil {
+		t.Fatalf("failed to open the bucket on another node: %v", err)
+	}
+	for key, want := range map[string]string{"before": "written with three nodes", "after": "written with two nodes"} {
+		var got string
+		err := eventually(func() (err error) {
+			got, err = other.GetString(key)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("read of %s after losing a node failed: %v", key, err)
+		}
+		if got != want {
+			t.Fatalf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+// eventually retries f until it succeeds or half a minute has passed,
+// which is plenty for a cluster to settle after losing a node
+func eventually(f func() error) error {
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		err := f()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+func TestClusterStartGivesUpWithoutQuorum(t *testing.T) {
+	port, missing := freePort(t), freePort(t)
+	routes := []string{
+		fmt.Sprintf("nats-route://127.0.0.1:%d", port),
+		fmt.Sprintf("nats-route://127.0.0.1:%d", missing),
+	}
+	node := newClusterNode(t, "alone", port, routes, 2)
+	node.quorumTimeout = time.Second
+
+	started := time.Now()
+	if err := node.Start(); err == nil {
+		t.Fatal("Start succeeded without a quorum")
+	}
+	if waited := time.Since(started); waited > 15*time.Second {
+		t.Fatalf("Start gave up after %s, want about a second", waited)
+	}
+}
+
+func TestSetTokenKeepsOtherConnections(t *testing.T) {
+	user, nkey := newClientKey(t)
+
+	ns, err := NewServer(&Config{
+		Host:     "127.0.0.1",
+		Port:     -1,
+		StoreDir: t.TempDir(),
+		Token:    testToken,
+		Clients:  []Client{{Name: "reader", NKey: nkey, Access: AccessRead, Buckets: []string{"photos"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ns.Stop(context.Background()) })
+	url := ns.server.ClientURL()
+
+	closed := make(chan struct{})
+	old, err := nats.Connect(url, nats.Token(testToken), nats.MaxReconnects(0),
+		nats.ClosedHandler(func(*nats.Conn) { close(closed) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	reader, err := nats.Connect(url, nats.Nkey(nkey, user.Sign), nats.CustomInboxPrefix(InboxPrefix("reader")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	bridge, err := ns.CreateBridgeConnection("soxdrawer.put.>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bridge.Close()
+
+	const next = "next-token-0123456789abcdef0123456789"
+	if err := ns.SetToken(next); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client with the old token is still connected")
+	}
+	if _, err := nats.Connect(url, nats.Token(testToken)); err == nil {
+		t.Fatal("old token still accepted")
+	}
+	fresh, err := nats.Connect(url, nats.Token(next))
+	if err != nil {
+		t.Fatalf("new token rejected: %v", err)
+	}
+	fresh.Close()
+
+	for name, conn := range map[string]*nats.Conn{"embedded": ns.Connection(), "nkey": reader, "bridge": bridge} {
+		if err := conn.Flush(); err != nil {
+			t.Fatalf("%s connection failed after the token change: %v", name, err)
+		}
+		if reconnects := conn.Stats().Reconnects; reconnects != 0 {
+			t.Fatalf("%s connection reconnected %d times, want none", name, reconnects)
+		}
+	}
+}
+
+// newClientKey returns a new user nkey and its public key
+func newClientKey(t *testing.T) (nkeys.KeyPair, string) {
+	t.Helper()
+	user, err := nkeys.CreateUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nkey, err := user.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, nkey
+}
+
+func TestClientsPublishOnlyWhatTheirAccessAllows(t *testing.T) {
+	reader, readerKey := newClientKey(t)
+	writer, writerKey := newClientKey(t)
+	ns, err := NewServer(&Config{
+		Host:     "127.0.0.1",
+		Port:     -1,
+		StoreDir: t.TempDir(),
+		Token:    testToken,
+		Clients: []Client{
+			{Name: "reader", NKey: readerKey, Access: AccessRead, Buckets: []string{"photos"}},
+			{Name: "writer", NKey: writerKey, Access: AccessWrite, Buckets: []string{"photos"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ns.Stop(context.Background()) })
+
+	// Write clients put through the bridge, never straight into the object store
+	subjects := []string{"$JS.API.STREAM.INFO.OBJ_photos", "soxdrawer.put.photos.cat//jpg", "$O.photos.M.Y2F0LmpwZw==", "$JS.API.STREAM.PURGE.OBJ_photos", "$JS.API.STREAM.INFO.OBJ_default"}
+	for _, client := range []struct {
+		name    string
+		user    nkeys.KeyPair
+		key     string
+		allowed []bool
+	}{
+		{"reader", reader, readerKey, []bool{true, false, false, false, false}},
+		{"writer", writer, writerKey, []bool{true, true, false, false, false}},
+	} {
+		denied := make(chan string, 10)
+		conn, err := nats.Connect(ns.server.ClientURL(), nats.Nkey(client.key, client.user.Sign), nats.CustomInboxPrefix(InboxPrefix(client.name)),
+			nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) { denied <- err.Error() }))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		for i, subject := range subjects {
+			if err := conn.Publish(subject, []byte("pixels")); err != nil {
+				t.Fatal(err)
+			}
+			if err := conn.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case message := <-denied:
+				if client.allowed[i] {
+					t.Fatalf("%s publishing to %s was denied: %s", client.name, subject, message)
+				}
+			case <-time.After(200 * time.Millisecond):
+				if !client.allowed[i] {
+					t.Fatalf("%s publishing to %s was allowed", client.name, subject)
+				}
+			}
+		}
+	}
+}
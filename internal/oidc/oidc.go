@@ -0,0 +1,229 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+
+	"soxdrawer/internal/users"
+)
+
+var (
+	// ErrNoRole is returned when none of the user's claims map to a role and
+	// no default role is configured
+	ErrNoRole = errors.New("no role mapped for user")
+	// ErrNonceMismatch is returned when the ID token was not issued for this login
+	ErrNonceMismatch = errors.New("ID token nonce does not match")
+)
+
+type (
+	// Config holds the OpenID Connect client settings
+	Config struct {
+		Issuer        string
+		ClientID      string
+		ClientSecret  string // Optional; PKCE protects public clients
+		RedirectURL   string
+		Scopes        []string
+		UsernameClaim string            // Claim used as the soxdrawer user name
+		RoleClaim     string            // Claim holding a string or list of groups/roles
+		RoleMappings  map[string]string // Claim value to soxdrawer role
+		DefaultRole   string            // Role when nothing matches; empty denies login
+	}
+
+	// Provider runs the authorization code flow against a discovered issuer
+	Provider struct {
+		config   Config
+		oauth    *oauth2.Config
+		verifier *gooidc.IDTokenVerifier
+	}
+
+	// Identity is the verified result of a login
+	Identity struct {
+		Issuer   string
+		Subject  string
+		Username string
+		Email    string
+		Role     string
+	}
+
+	// Challenge is the per-login state the client must hold until the callback
+	Challenge struct {
+		State    string
+		Nonce    string
+		Verifier string // PKCE code verifier
+	}
+)
+
+// New discovers the issuer's endpoints and signing keys
+func New(ctx context.Context, config Config) (*Provider, error) {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("issuer, client_id and redirect_url are required for OIDC")
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "preferred_username"
+	}
+
+	provider, err := gooidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider '%s': %w", config.Issuer, err)
+	}
+
+	scopes := config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"profile", "email"}
+	}
+	if !contains(scopes, gooidc.ScopeOpenID) {
+		scopes = append([]string{gooidc.ScopeOpenID}, scopes...)
+	}
+
+	return &Provider{
+		config: config,
+		oauth: &oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier: provider.Verifier(&gooidc.Config{ClientID: config.ClientID}),
+	}, nil
+}
+
+// NewChallenge creates fresh state, nonce and PKCE verifier for one login
+func NewChallenge() (*Challenge, error) {
+	state, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	return &Challenge{
+		State:    state,
+		Nonce:    nonce,
+		Verifier: oauth2.GenerateVerifier(),
+	}, nil
+}
+
+// AuthCodeURL returns the provider URL the browser is sent to
+func (p *Provider) AuthCodeURL(challenge *Challenge) string {
+	return p.oauth.AuthCodeURL(challenge.State,
+		gooidc.Nonce(challenge.Nonce),
+		oauth2.S256ChallengeOption(challenge.Verifier),
+	)
+}
+
+// Exchange redeems the authorization code, verifies the ID token and maps
+// its claims to a soxdrawer identity
+func (p *Provider) Exchange(ctx context.Context, code string, challenge *Challenge) (*Identity, error) {
+	token, err := p.oauth.Exchange(ctx, code, oauth2.VerifierOption(challenge.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response did not include an ID token")
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify ID token: %w", err)
+	}
+	if idToken.Nonce != challenge.Nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to decode ID token claims: %w", err)
+	}
+
+	identity := &Identity{
+		Issuer:   idToken.Issuer,
+		Subject:  idToken.Subject,
+		Username: stringClaim(claims, p.config.UsernameClaim),
+		Email:    stringClaim(claims, "email"),
+	}
+	if identity.Username == "" {
+		identity.Username = identity.Email
+	}
+	if identity.Username == "" {
+		identity.Username = identity.Subject
+	}
+
+	identity.Role = p.mapRole(claims)
+	if identity.Role == "" {
+		return nil, fmt.Errorf("%w: '%s'", ErrNoRole, identity.Username)
+	}
+	return identity, nil
+}
+
+// mapRole picks the role for the first claim value with a mapping. Admin
+// mappings win over others so membership order in the token doesn't matter.
+func (p *Provider) mapRole(claims map[string]any) string {
+	role := ""
+	for _, value := range listClaim(claims, p.config.RoleClaim) {
+		mapped, ok := p.config.RoleMappings[value]
+		if !ok {
+			continue
+		}
+		if role == "" || mapped == users.RoleAdmin {
+			role = mapped
+		}
+	}
+	if role == "" {
+		role = p.config.DefaultRole
+	}
+	return role
+}
+
+func stringClaim(claims map[string]any, name string) string {
+	value, _ := claims[name].(string)
+	return strings.TrimSpace(value)
+}
+
+// listClaim reads a claim that may be a single string or a list of strings
+func listClaim(claims map[string]any, name string) []string {
+	if name == "" {
+		return nil
+	}
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []any:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+func contains(values []string, want string) bool {
+	for _, value := range values {
+		if value == want {
+			return true
+		}
+	}
+	return false
+}
+
+func randomString() (string, error) {
+	bytes := make([]byte, 24)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate random value: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
@@ -0,0 +1,456 @@
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+
+	"soxdrawer/internal/store"
+)
+
+// ErrQuotaExceeded is returned when an upload would take a user or bucket
+// over one of its limits
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+type (
+	// Quota limits the storage of a user or bucket. Zero means unlimited.
+	Quota struct {
+		MaxBytes      int64 `json:"max_bytes"`
+		MaxObjects    int64 `json:"max_objects"`
+		MaxObjectSize int64 `json:"max_object_size"`
+	}
+
+	// Config assigns quotas. Users and buckets without an entry get the default.
+	Config struct {
+		UserDefault   Quota
+		Users         map[string]Quota
+		BucketDefault Quota
+		Buckets       map[string]Quota
+	}
+
+	// Usage is the storage used by a user or bucket
+	Usage struct {
+		Name    string `json:"name"`
+		Bytes   int64  `json:"bytes"`
+		Objects int64  `json:"objects"`
+		Quota   Quota  `json:"quota"`
+	}
+
+	// Tracker keeps usage up to date from object events and enforces quotas
+	// on uploads. Uploads in progress count against the limits as they stream.
+	// It remembers the size and owner of every object, so applying an event
+	// whose change was already counted leaves the usage as it is.
+	Tracker struct {
+		js      nats.JetStreamContext
+		sub     *nats.Subscription
+		mu      sync.Mutex
+		config  Config
+		users   map[string]*counter
+		bucket  map[string]*counter
+		objects map[objectKey]object
+	}
+
+	objectKey struct {
+		bucket, key string
+	}
+
+	// object is what a stored object adds to its bucket's and owner's usage
+	object struct {
+		owner string
+		size  int64
+	}
+
+	counter struct {
+		bytes, objects     int64 // Stored, from events
+		uploading, uploads int64 // Reserved by uploads in progress
+	}
+
+	// scope is one set of limits an upload is checked against
+	scope struct {
+		kind, name string
+		quota      Quota
+		counter    *counter
+	}
+
+	// Upload reserves room for one object while it is streamed to the store
+	Upload struct {
+		tracker *Tracker
+		bucket  string
+		owner   string
+		written int64
+		done    bool
+	}
+
+	reader struct {
+		upload *Upload
+		r      io.Reader
+	}
+)
+
+// New creates a tracker. Call Start to load current usage.
+func New(js nats.JetStreamContext, config Config) *Tracker {
+	return &Tracker{
+		js:      js,
+		config:  config,
+		users:   map[string]*counter{},
+		bucket:  map[string]*counter{},
+		objects: map[objectKey]object{},
+	}
+}
+
+// SetConfig changes the quotas. Uploads in progress are checked against the
+// new limits as they continue.
+func (t *Tracker) SetConfig(config Config) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.config = config
+}
+
+// Start counts the objects already stored, then follows the events stream
+// from just before the count so usage stays current without rescanning.
+// Events for changes the count already saw are applied again, which leaves
+// those objects as counted.
+func (t *Tracker) Start() error {
+	info, err := t.js.StreamInfo(store.EventsStream)
+	if err != nil {
+		return fmt.Errorf("failed to get events stream: %w", err)
+	}
+	start := info.State.LastSeq + 1
+
+	if err := t.scan(); err != nil {
+		return err
+	}
+
+	t.sub, err = t.js.Subscribe(store.EventsSubjectPrefix+".>", t.handleEvent,
+		nats.BindStream(store.EventsStream),
+		nats.OrderedConsumer(),
+		nats.StartSequence(start),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to follow object events: %w", err)
+	}
+	return nil
+}
+
+// Stop stops following object events
+func (t *Tracker) Stop() {
+	if t.sub != nil {
+		t.sub.Unsubscribe()
+	}
+}
+
+func (t *Tracker) scan() error {
+	for status := range t.js.ObjectStores() {
+		name := status.Bucket()
+		bucket, err := t.js.ObjectStore(name)
+		if err != nil {
+			return fmt.Errorf("failed to open bucket '%s': %w", name, err)
+		}
+		objects, err := bucket.List()
+		if err != nil && !errors.Is(err, nats.ErrNoObjectsFound) {
+			return fmt.Errorf("failed to list bucket '%s': %w", name, err)
+		}
+
+		t.mu.Lock()
+		t.counter(t.bucket, name)
+		for _, info := range objects {
+			if !store.IsFolderMarker(info.Name) {
+				t.set(name, info.Name, store.Owner(info), int64(info.Size))
+			}
+		}
+		t.mu.Unlock()
+	}
+	return nil
+}
+
+func (t *Tracker) handleEvent(msg *nats.Msg) {
+	var event store.Event
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		log.Printf("Failed to decode object event for usage: %v", err)
+		return
+	}
+	if store.IsFolderMarker(event.Key) {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	size := int64(event.Size)
+	switch event.Type {
+	case store.EventPut:
+		t.set(event.Bucket, event.Key, event.Owner, size)
+	case store.EventDelete:
+		t.remove(event.Bucket, event.Key)
+	case store.EventRename:
+		// Older events don't carry the owner; the object keeps the one it had
+		owner := event.Owner
+		for _, key := range []string{event.Key, event.NewKey} {
+			if previous, ok := t.objects[objectKey{event.Bucket, key}]; ok && owner == "" {
+				owner = previous.owner
+			}
+		}
+		t.remove(event.Bucket, event.Key)
+		t.set(event.Bucket, event.NewKey, owner, size)
+	case store.EventCopy:
+		// Copies into another bucket are followed by a put there
+		if event.NewBucket == "" {
+			t.set(event.Bucket, event.NewKey, event.Owner, size)
+		}
+	case store.EventMove:
+		// So are moves, which leave nothing behind
+		if event.NewBucket != "" {
+			t.remove(event.Bucket, event.Key)
+		}
+	}
+}
+
+// set records the object stored under key, replacing what was counted for
+// it before; callers hold mu
+func (t *Tracker) set(bucket, key, owner string, size int64) {
+	t.remove(bucket, key)
+	t.objects[objectKey{bucket, key}] = object{owner: owner, size: size}
+	t.add(bucket, owner, size, 1)
+}
+
+// remove forgets the object stored under key, if it was counted; callers
+// hold mu
+func (t *Tracker) remove(bucket, key string) {
+	id := objectKey{bucket, key}
+	if previous, ok := t.objects[id]; ok {
+		delete(t.objects, id)
+		t.add(bucket, previous.owner, -previous.size, -1)
+	}
+}
+
+// add applies a change in stored bytes and objects; callers hold mu
+func (t *Tracker) add(bucket, owner string, bytes, objects int64) {
+	for _, c := range t.counters(bucket, owner) {
+		c.bytes += bytes
+		c.objects += objects
+	}
+}
+
+func (t *Tracker) counter(counters map[string]*counter, name string) *counter {
+	c, ok := counters[name]
+	if !ok {
+		c = &counter{}
+		counters[name] = c
+	}
+	return c
+}
+
+// counters returns the bucket's counter and the owner's, if the object has one
+func (t *Tracker) counters(bucket, owner string) []*counter {
+	counters := []*counter{t.counter(t.bucket, bucket)}
+	if owner != "" {
+		counters = append(counters, t.counter(t.users, owner))
+	}
+	return counters
+}
+
+func (t *Tracker) userQuota(name string) Quota {
+	if quota, ok := t.config.Users[name]; ok {
+		return quota
+	}
+	return t.config.UserDefault
+}
+
+func (t *Tracker) bucketQuota(name string) Quota {
+	if quota, ok := t.config.Buckets[name]; ok {
+		return quota
+	}
+	return t.config.BucketDefault
+}
+
+// Begin reserves an object for an upload by owner into bucket. size is the
+// expected size, or -1 when unknown. Wrap the data with Reader, call Stored
+// once the object is stored, and Done in any case.
+func (t *Tracker) Begin(bucket, owner string, size int64) (*Upload, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	upload := &Upload{tracker: t, bucket: bucket, owner: owner}
+	if err := t.check(upload, max(size, 0), 1); err != nil {
+		return nil, err
+	}
+	for _, c := range t.counters(bucket, owner) {
+		c.uploads++
+	}
+	return upload, nil
+}
+
+// Reserve checks that an object of a known size, such as a copy, fits within
+// the quotas of bucket and owner and reserves all of it at once. Call Stored
+// once it has been stored, and Done in any case.
+func (t *Tracker) Reserve(bucket, owner string, size int64) (*Upload, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	upload := &Upload{tracker: t, bucket: bucket, owner: owner}
+	if err := t.check(upload, size, 1); err != nil {
+		return nil, err
+	}
+	upload.written = size
+	for _, c := range t.counters(bucket, owner) {
+		c.uploading += size
+		c.uploads++
+	}
+	return upload, nil
+}
+
+// check verifies that extra bytes and objects fit within the quotas of the
+// upload's bucket and owner; callers hold mu
+func (t *Tracker) check(upload *Upload, bytes, objects int64) error {
+	scopes := []scope{{"bucket", upload.bucket, t.bucketQuota(upload.bucket), t.counter(t.bucket, upload.bucket)}}
+	if upload.owner != "" {
+		scopes = append(scopes, scope{"user", upload.owner, t.userQuota(upload.owner), t.counter(t.users, upload.owner)})
+	}
+
+	for _, scope := range scopes {
+		quota, c := scope.quota, scope.counter
+		if quota.MaxObjectSize > 0 && upload.written+bytes > quota.MaxObjectSize {
+			return fmt.Errorf("%w: %s '%s' allows objects up to %s", ErrQuotaExceeded, scope.kind, scope.name, FormatBytes(quota.MaxObjectSize))
+		}
+		if quota.MaxBytes > 0 && c.bytes+c.uploading+bytes > quota.MaxBytes {
+			return fmt.Errorf("%w: %s '%s' is limited to %s", ErrQuotaExceeded, scope.kind, scope.name, FormatBytes(quota.MaxBytes))
+		}
+		if quota.MaxObjects > 0 && c.objects+c.uploads+objects > quota.MaxObjects {
+			return fmt.Errorf("%w: %s '%s' is limited to %d objects", ErrQuotaExceeded, scope.kind, scope.name, quota.MaxObjects)
+		}
+	}
+	return nil
+}
+
+// Reader counts data against the reservation as it is read and fails with
+// ErrQuotaExceeded as soon as a limit would be passed
+func (u *Upload) Reader(r io.Reader) io.Reader {
+	return &reader{upload: u, r: r}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if quotaErr := r.upload.consume(int64(n)); quotaErr != nil {
+			return 0, quotaErr
+		}
+	}
+	return n, err
+}
+
+func (u *Upload) consume(n int64) error {
+	t := u.tracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.check(u, n, 0); err != nil {
+		return err
+	}
+	u.written += n
+	for _, c := range t.counters(u.bucket, u.owner) {
+		c.uploading += n
+	}
+	return nil
+}
+
+// Stored counts the object the upload stored under key and releases the
+// reservation in one step, so its room is never free in between. The put
+// event that follows finds it already counted. Stored on a nil Upload does
+// nothing.
+func (u *Upload) Stored(key string, size int64) {
+	if u == nil {
+		return
+	}
+	t := u.tracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !u.done {
+		t.set(u.bucket, key, u.owner, size)
+		u.release()
+	}
+}
+
+// Done releases the reservation of an upload that failed, or that Stored
+// already counted. Done on a nil Upload does nothing.
+func (u *Upload) Done() {
+	if u == nil {
+		return
+	}
+	t := u.tracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !u.done {
+		u.release()
+	}
+}
+
+// release gives back the reserved room; callers hold mu
+func (u *Upload) release() {
+	u.done = true
+	for _, c := range u.tracker.counters(u.bucket, u.owner) {
+		c.uploading -= u.written
+		c.uploads--
+	}
+}
+
+// User returns the usage and quota of a user
+func (t *Tracker) User(name string) Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.usage(t.users, name, t.userQuota(name))
+}
+
+// Users returns the usage of every user that owns objects, sorted by name
+func (t *Tracker) Users() []Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.all(t.users, t.userQuota)
+}
+
+// Buckets returns the usage of every bucket, sorted by name
+func (t *Tracker) Buckets() []Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.all(t.bucket, t.bucketQuota)
+}
+
+func (t *Tracker) usage(counters map[string]*counter, name string, quota Quota) Usage {
+	usage := Usage{Name: name, Quota: quota}
+	if c, ok := counters[name]; ok {
+		usage.Bytes, usage.Objects = c.bytes, c.objects
+	}
+	return usage
+}
+
+func (t *Tracker) all(counters map[string]*counter, quotaFor func(string) Quota) []Usage {
+	usages := make([]Usage, 0, len(counters))
+	for name := range counters {
+		usages = append(usages, t.usage(counters, name, quotaFor(name)))
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].Name < usages[j].Name
+	})
+	return usages
+}
+
+// FormatBytes renders a byte count for messages, e.g. 1.5 GB
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,209 @@
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	natsServer "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"soxdrawer/internal/store"
+)
+
+// startJetStream runs an in-process NATS server with JetStream for one test
+func startJetStream(t *testing.T) nats.JetStreamContext {
+	t.Helper()
+
+	ns, err := natsServer.NewServer(&natsServer.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create NATS server: %v", err)
+	}
+	ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("failed to connect to NATS: %v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	js, err := conn.JetStream()
+	if err != nil {
+		t.Fatalf("failed to get JetStream context: %v", err)
+	}
+	return js
+}
+
+// newTracker opens a bucket and starts a tracker over it
+func newTracker(t *testing.T, config Config) (*Tracker, *store.ObjectStore) {
+	t.Helper()
+	js := startJetStream(t)
+	objects, err := store.Open(js, "default", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := New(js, config)
+	if err := tracker.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(tracker.Stop)
+	return tracker, objects
+}
+
+// replay applies an event again, as the tracker would on seeing it twice
+func replay(t *testing.T, tracker *Tracker, event store.Event) {
+	t.Helper()
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.handleEvent(&nats.Msg{Data: data})
+}
+
+// waitForUsage waits until the user's usage has caught up with the events
+func waitForUsage(t *testing.T, tracker *Tracker, user string, bytes, objects int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		usage := tracker.User(user)
+		if usage.Bytes == bytes && usage.Objects == objects {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("usage of %s = %d bytes in %d objects, want %d in %d", user, usage.Bytes, usage.Objects, bytes, objects)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestStoredUploadKeepsItsRoom(t *testing.T) {
+	tracker, objects := newTracker(t, Config{UserDefault: Quota{MaxBytes: 10}})
+
+	upload, err := tracker.Begin(objects.Name(), "alice", 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := objects.PutOwned("first", "alice", upload.Reader(strings.NewReader("12345678")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload.Stored(info.Name, int64(info.Size))
+	upload.Done()
+
+	// The put event may not have arrived yet, but the object already counts
+	if _, err := tracker.Begin(objects.Name(), "alice", 8); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("second upload = %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	// And the event does not count it again when it does
+	waitForUsage(t, tracker, "alice", 8, 1)
+	replay(t, tracker, store.Event{Type: store.EventPut, Bucket: objects.Name(), Key: "first", Size: 8, Owner: "alice"})
+	waitForUsage(t, tracker, "alice", 8, 1)
+}
+
+func TestFailedUploadReleasesItsRoom(t *testing.T) {
+	tracker, objects := newTracker(t, Config{UserDefault: Quota{MaxBytes: 10}})
+
+	upload, err := tracker.Begin(objects.Name(), "alice", 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload.Done()
+
+	if upload, err := tracker.Begin(objects.Name(), "alice", 8); err != nil {
+		t.Fatalf("upload after a failed one = %v", err)
+	} else {
+		upload.Done()
+	}
+	waitForUsage(t, tracker, "alice", 0, 0)
+}
+
+func TestStartDoesNotCountTwice(t *testing.T) {
+	js := startJetStream(t)
+	objects, err := store.Open(js, "default", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := objects.PutOwned("before", "alice", strings.NewReader("1234")); err != nil {
+		t.Fatal(err)
+	}
+
+	tracker := New(js, Config{})
+	if err := tracker.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(tracker.Stop)
+	if _, err := objects.PutOwned("after", "alice", strings.NewReader("123456")); err != nil {
+		t.Fatal(err)
+	}
+	waitForUsage(t, tracker, "alice", 10, 2)
+
+	// Events for changes the scan already saw, as when they race with it
+	replay(t, tracker, store.Event{Type: store.EventPut, Bucket: objects.Name(), Key: "before", Size: 4, Owner: "alice"})
+	replay(t, tracker, store.Event{Type: store.EventPut, Bucket: objects.Name(), Key: "after", Size: 6, Owner: "alice"})
+	waitForUsage(t, tracker, "alice", 10, 2)
+
+	if _, err := objects.Rename("before", "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := objects.Delete("after"); err != nil {
+		t.Fatal(err)
+	}
+	waitForUsage(t, tracker, "alice", 4, 1)
+	replay(t, tracker, store.Event{Type: store.EventRename, Bucket: objects.Name(), Key: "before", NewKey: "renamed", Size: 4})
+	replay(t, tracker, store.Event{Type: store.EventDelete, Bucket: objects.Name(), Key: "after"})
+	waitForUsage(t, tracker, "alice", 4, 1)
+}
+
+func TestUserLimits(t *testing.T) {
+	tracker, objects := newTracker(t, Config{UserDefault: Quota{MaxObjects: 2, MaxObjectSize: 5}})
+	if _, err := objects.PutString("unowned", "counts for nobody"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tracker.Begin(objects.Name(), "alice", 6); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("upload over the object size = %v, want %v", err, ErrQuotaExceeded)
+	}
+	// An upload of unknown size is stopped once it grows too large
+	upload, err := tracker.Begin(objects.Name(), "alice", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := objects.PutOwned("big", "alice", upload.Reader(strings.NewReader("123456"))); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("growing upload = %v, want %v", err, ErrQuotaExceeded)
+	}
+	upload.Done()
+
+	for _, key := range []string{"first", "second"} {
+		if _, err := objects.PutOwned(key, "alice", strings.NewReader("123")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForUsage(t, tracker, "alice", 6, 2)
+	if _, err := tracker.Begin(objects.Name(), "alice", 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("third object = %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	if err := objects.Delete("first"); err != nil {
+		t.Fatal(err)
+	}
+	waitForUsage(t, tracker, "alice", 3, 1)
+	if upload, err := tracker.Begin(objects.Name(), "alice", 1); err != nil {
+		t.Fatalf("object after a delete = %v", err)
+	} else {
+		upload.Done()
+	}
+}
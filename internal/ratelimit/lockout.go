@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// LockoutConfig controls how failed attempts lock a key out. After
+	// MaxFailures consecutive failures each further failure locks the key for
+	// BaseDelay doubled per extra failure, capped at MaxDelay.
+	LockoutConfig struct {
+		MaxFailures int
+		BaseDelay   time.Duration
+		MaxDelay    time.Duration
+	}
+
+	// Lockout tracks consecutive failures per key
+	Lockout struct {
+		config  LockoutConfig
+		mu      sync.Mutex
+		entries map[string]*lockoutEntry
+		swept   time.Time
+	}
+
+	lockoutEntry struct {
+		failures    int
+		lockedUntil time.Time
+		last        time.Time
+	}
+)
+
+// NewLockout creates a lockout tracker. A zero MaxFailures disables it.
+func NewLockout(config LockoutConfig) *Lockout {
+	return &Lockout{
+		config:  config,
+		entries: make(map[string]*lockoutEntry),
+	}
+}
+
+// Locked returns how long the key remains locked out, or zero
+func (l *Lockout) Locked(key string) time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.config.MaxFailures <= 0 {
+		return 0
+	}
+
+	entry, ok := l.entries[key]
+	if !ok {
+		return 0
+	}
+	if remaining := time.Until(entry.lockedUntil); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// Fail records a failed attempt and returns the lockout it triggered, if any
+func (l *Lockout) Fail(key string) time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.config.MaxFailures <= 0 {
+		return 0
+	}
+
+	l.sweep(now)
+
+	entry, ok := l.entries[key]
+	if !ok {
+		entry = &lockoutEntry{}
+		l.entries[key] = entry
+	}
+	entry.failures++
+	entry.last = now
+
+	over := entry.failures - l.config.MaxFailures
+	if over < 0 {
+		return 0
+	}
+
+	delay := l.config.BaseDelay
+	for i := 0; i < over && delay < l.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if l.config.MaxDelay > 0 && delay > l.config.MaxDelay {
+		delay = l.config.MaxDelay
+	}
+	entry.lockedUntil = now.Add(delay)
+	return delay
+}
+
+// SetConfig changes the lockout policy. Failures already counted are kept.
+func (l *Lockout) SetConfig(config LockoutConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.config = config
+}
+
+// Reset clears the failures for a key after a successful attempt
+func (l *Lockout) Reset(key string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// sweep forgets keys whose last failure is older than the longest lockout
+func (l *Lockout) sweep(now time.Time) {
+	if now.Sub(l.swept) < idleExpiry {
+		return
+	}
+	l.swept = now
+
+	forget := l.config.MaxDelay
+	if forget < idleExpiry {
+		forget = idleExpiry
+	}
+	for key, entry := range l.entries {
+		if now.Sub(entry.last) > forget && now.After(entry.lockedUntil) {
+			delete(l.entries, key)
+		}
+	}
+}
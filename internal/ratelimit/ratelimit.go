@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// idleExpiry is how long an untouched bucket is kept before it is discarded
+const idleExpiry = 10 * time.Minute
+
+type (
+	// Rate is the sustained request rate and burst size of a token bucket.
+	// A zero PerMinute disables the limit.
+	Rate struct {
+		PerMinute float64
+		Burst     int
+	}
+
+	// Limiter keeps one token bucket per key, such as a client IP or session
+	Limiter struct {
+		rate    Rate
+		mu      sync.Mutex
+		buckets map[string]*bucket
+		swept   time.Time
+	}
+
+	bucket struct {
+		tokens float64
+		last   time.Time
+	}
+)
+
+// New creates a limiter for the given rate
+func New(rate Rate) *Limiter {
+	if rate.Burst < 1 {
+		rate.Burst = 1
+	}
+	return &Limiter{
+		rate:    rate,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Enabled reports whether the limiter restricts anything
+func (l *Limiter) Enabled() bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate.PerMinute > 0
+}
+
+// SetRate changes the limiter's rate. Buckets keep their tokens, capped at
+// the new burst.
+func (l *Limiter) SetRate(rate Rate) {
+	if rate.Burst < 1 {
+		rate.Burst = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+}
+
+// Allow takes a token from the key's bucket. When the bucket is empty it
+// returns false and how long until the next token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate.PerMinute <= 0 {
+		return true, 0
+	}
+	perSecond := l.rate.PerMinute / 60
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.rate.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(l.rate.Burst), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	return false, wait
+}
+
+// sweep drops buckets that have been idle long enough to be full again
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < idleExpiry {
+		return
+	}
+	l.swept = now
+
+	refill := time.Duration(float64(l.rate.Burst) / l.rate.PerMinute * float64(time.Minute))
+	for key, b := range l.buckets {
+		if idle := now.Sub(b.last); idle > idleExpiry && idle > refill {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// BucketName is the KV bucket holding active sessions
+	BucketName = "sessions"
+
+	DefaultDuration    = 12 * time.Hour
+	DefaultIdleTimeout = 2 * time.Hour
+	DefaultMaxLifetime = 7 * 24 * time.Hour
+
+	// touchInterval limits how often LastSeen is written back for a busy session
+	touchInterval = time.Minute
+)
+
+var (
+	// ErrInvalidSession is returned for unknown, revoked or malformed session tokens
+	ErrInvalidSession = errors.New("invalid session")
+	// ErrSessionExpired is returned when a session passed its idle or absolute timeout
+	ErrSessionExpired = errors.New("session expired")
+)
+
+type (
+	// Session is a server-side login session. The ID is the SHA-256 of the
+	// token held in the client's cookie, so the store never contains
+	// credentials that could be replayed.
+	Session struct {
+		ID        string    `json:"id"`
+		User      string    `json:"user"`
+		UserAgent string    `json:"user_agent"`
+		IP        string    `json:"ip"`
+		Created   time.Time `json:"created"`
+		LastSeen  time.Time `json:"last_seen"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	// Config holds session timeouts
+	Config struct {
+		Duration       time.Duration // Lifetime of a session until it expires or is refreshed
+		IdleTimeout    time.Duration // Sessions unused for this long are invalid
+		MaxLifetime    time.Duration // Sessions older than this are invalid regardless of refreshes
+		SlidingRefresh bool          // Extend sessions used in the second half of their lifetime
+	}
+
+	// Store keeps sessions in a JetStream KV bucket
+	Store struct {
+		js     nats.JetStreamContext
+		kv     nats.KeyValue
+		mu     sync.RWMutex
+		config Config
+	}
+)
+
+func DefaultConfig() *Config {
+	return &Config{
+		Duration:       DefaultDuration,
+		IdleTimeout:    DefaultIdleTimeout,
+		MaxLifetime:    DefaultMaxLifetime,
+		SlidingRefresh: true,
+	}
+}
+
+// New creates or opens the session bucket
+func New(js nats.JetStreamContext, config *Config) (*Store, error) {
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{
+		Bucket:      BucketName,
+		Description: "soxdrawer login sessions",
+		TTL:         bucketTTL(config),
+	})
+	if err != nil {
+		kv, err = js.KeyValue(BucketName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create or get session bucket: %w", err)
+		}
+	}
+
+	store := &Store{js: js, kv: kv}
+	if err := store.SetConfig(config); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// bucketTTL returns how long entries are kept: they outlive their last
+// write by at most the longest timeout; Validate enforces the exact limits
+func bucketTTL(config *Config) time.Duration {
+	ttl := config.Duration
+	if config.SlidingRefresh && config.MaxLifetime > ttl {
+		ttl = config.MaxLifetime
+	}
+	return ttl
+}
+
+// SetConfig changes the session timeouts. Existing sessions are checked
+// against the new limits the next time they are used.
+func (s *Store) SetConfig(config *Config) error {
+	stream := "KV_" + BucketName
+	info, err := s.js.StreamInfo(stream)
+	if err != nil {
+		return fmt.Errorf("failed to get session bucket: %w", err)
+	}
+	if ttl := bucketTTL(config); info.Config.MaxAge != ttl {
+		updated := info.Config
+		updated.MaxAge = ttl
+		if _, err := s.js.UpdateStream(&updated); err != nil {
+			return fmt.Errorf("failed to update session bucket TTL: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = *config
+	return nil
+}
+
+// policy returns the current session timeouts
+func (s *Store) policy() Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
+// Duration returns the lifetime of a new or refreshed session
+func (s *Store) Duration() time.Duration {
+	return s.policy().Duration
+}
+
+// Create starts a new session for a user and returns the token to hand to the client
+func (s *Store) Create(user, userAgent, ip string) (string, *Session, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now().UTC()
+	session := &Session{
+		ID:        ID(token),
+		User:      user,
+		UserAgent: userAgent,
+		IP:        ip,
+		Created:   now,
+		LastSeen:  now,
+		ExpiresAt: s.expiry(now, now),
+	}
+	if err := s.put(session); err != nil {
+		return "", nil, err
+	}
+
+	return token, session, nil
+}
+
+// Validate looks up the session for a token, enforcing the idle and absolute
+// timeouts, and records the activity. With sliding refresh enabled a session
+// used in the second half of its lifetime is extended; refreshed reports
+// whether that happened so the caller can reissue the cookie.
+func (s *Store) Validate(token string) (session *Session, refreshed bool, err error) {
+	if token == "" {
+		return nil, false, ErrInvalidSession
+	}
+
+	session, err = s.get(ID(token))
+	if err != nil {
+		return nil, false, err
+	}
+
+	now := time.Now().UTC()
+	if s.expired(session, now) {
+		s.Revoke(session.ID)
+		return nil, false, ErrSessionExpired
+	}
+
+	dirty := now.Sub(session.LastSeen) > touchInterval
+	session.LastSeen = now
+
+	if config := s.policy(); config.SlidingRefresh && session.ExpiresAt.Sub(now) < config.Duration/2 {
+		if expiresAt := s.expiry(session.Created, now); expiresAt.After(session.ExpiresAt) {
+			session.ExpiresAt = expiresAt
+			refreshed = true
+			dirty = true
+		}
+	}
+
+	if dirty {
+		if err := s.put(session); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return session, refreshed, nil
+}
+
+// expiry returns when a session created at created and (re)issued at now expires
+func (s *Store) expiry(created, now time.Time) time.Time {
+	config := s.policy()
+	expiresAt := now.Add(config.Duration)
+	if config.MaxLifetime > 0 {
+		if limit := created.Add(config.MaxLifetime); expiresAt.After(limit) {
+			expiresAt = limit
+		}
+	}
+	return expiresAt
+}
+
+func (s *Store) expired(session *Session, now time.Time) bool {
+	if now.After(session.ExpiresAt) {
+		return true
+	}
+	config := s.policy()
+	return config.IdleTimeout > 0 && now.Sub(session.LastSeen) > config.IdleTimeout
+}
+
+// List returns all live sessions, most recently used first
+func (s *Store) List() ([]*Session, error) {
+	keys, err := s.kv.Keys()
+	if err != nil {
+		if errors.Is(err, nats.ErrNoKeysFound) {
+			return []*Session{}, nil
+		}
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	now := time.Now().UTC()
+	sessions := make([]*Session, 0, len(keys))
+	for _, key := range keys {
+		session, err := s.get(key)
+		if err != nil {
+			continue
+		}
+		if s.expired(session, now) {
+			s.Revoke(session.ID)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+// Get returns a live session by ID
+func (s *Store) Get(id string) (*Session, error) {
+	session, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if s.expired(session, time.Now().UTC()) {
+		s.Revoke(session.ID)
+		return nil, ErrInvalidSession
+	}
+	return session, nil
+}
+
+// Revoke ends a single session by ID
+func (s *Store) Revoke(id string) error {
+	if err := s.kv.Delete(id); err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return nil
+}
+
+// RevokeAll ends every session and returns how many were revoked
+func (s *Store) RevokeAll() (int, error) {
+	keys, err := s.kv.Keys()
+	if err != nil {
+		if errors.Is(err, nats.ErrNoKeysFound) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	for i, key := range keys {
+		if err := s.Revoke(key); err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
+
+// RevokeUser ends every session belonging to a user and returns how many were revoked
+func (s *Store) RevokeUser(user string) (int, error) {
+	sessions, err := s.List()
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for _, session := range sessions {
+		if session.User != user {
+			continue
+		}
+		if err := s.Revoke(session.ID); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+// ID derives the public session ID from a session token
+func ID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Store) get(id string) (*Session, error) {
+	entry, err := s.kv.Get(id)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return nil, ErrInvalidSession
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	var session Session
+	if err := json.Unmarshal(entry.Value(), &session); err != nil {
+		return nil, fmt.Errorf("failed to decode session: %w", err)
+	}
+	return &session, nil
+}
+
+func (s *Store) put(session *Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+	if _, err := s.kv.Put(session.ID, data); err != nil {
+		return fmt.Errorf("failed to store session: %w", err)
+	}
+	return nil
+}
+
+// generateToken creates a random session token
+func generateToken() (string, error) {
+	bytes := make([]byte, 32) // 256 bits
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate session token: %w", err)
+	}
+	return hex.EncodeToString(bytes), nil
+}
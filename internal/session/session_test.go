@@ -0,0 +1,175 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	natsServer "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// startJetStream runs an in-process NATS server with JetStream for one test
+func startJetStream(t *testing.T) nats.JetStreamContext {
+	t.Helper()
+
+	ns, err := natsServer.NewServer(&natsServer.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create NATS server: %v", err)
+	}
+	ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("failed to connect to NATS: %v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	js, err := conn.JetStream()
+	if err != nil {
+		t.Fatalf("failed to get JetStream context: %v", err)
+	}
+	return js
+}
+
+func newStore(t *testing.T, config *Config) *Store {
+	t.Helper()
+	store, err := New(startJetStream(t), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// age moves a session's times back as if d had passed
+func age(t *testing.T, store *Store, id string, d time.Duration) {
+	t.Helper()
+	session, err := store.get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Created = session.Created.Add(-d)
+	session.LastSeen = session.LastSeen.Add(-d)
+	session.ExpiresAt = session.ExpiresAt.Add(-d)
+	if err := store.put(session); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionsAreStoredByTheTokensHash(t *testing.T) {
+	store := newStore(t, DefaultConfig())
+	token, session, err := store.Create("alice", "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.ID == token || session.ID != ID(token) {
+		t.Fatalf("session ID = %s, want the hash of the token", session.ID)
+	}
+	if _, err := store.kv.Get(token); !errors.Is(err, nats.ErrKeyNotFound) {
+		t.Fatalf("looking up the token itself = %v, want %v", err, nats.ErrKeyNotFound)
+	}
+
+	valid, _, err := store.Validate(token)
+	if err != nil || valid.User != "alice" {
+		t.Fatalf("Validate = %+v, %v; want alice's session", valid, err)
+	}
+	if _, _, err := store.Validate(session.ID); !errors.Is(err, ErrInvalidSession) {
+		t.Fatalf("Validate with the ID = %v, want %v", err, ErrInvalidSession)
+	}
+}
+
+func TestRevokedSessionsAreInvalid(t *testing.T) {
+	store := newStore(t, DefaultConfig())
+	alice, aliceSession, err := store.Create("alice", "laptop", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alicePhone, _, err := store.Create("alice", "phone", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, _, err := store.Create("bob", "laptop", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Revoke(aliceSession.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Validate(alice); !errors.Is(err, ErrInvalidSession) {
+		t.Fatalf("revoked session = %v, want %v", err, ErrInvalidSession)
+	}
+	if _, _, err := store.Validate(alicePhone); err != nil {
+		t.Fatalf("alice's other session = %v", err)
+	}
+
+	if revoked, err := store.RevokeUser("alice"); err != nil || revoked != 1 {
+		t.Fatalf("RevokeUser = %d, %v; want 1, nil", revoked, err)
+	}
+	sessions, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].User != "bob" {
+		t.Fatalf("sessions left = %+v, want bob's", sessions)
+	}
+
+	if revoked, err := store.RevokeAll(); err != nil || revoked != 1 {
+		t.Fatalf("RevokeAll = %d, %v; want 1, nil", revoked, err)
+	}
+	if _, _, err := store.Validate(bob); !errors.Is(err, ErrInvalidSession) {
+		t.Fatalf("session after RevokeAll = %v, want %v", err, ErrInvalidSession)
+	}
+}
+
+func TestIdleSessionsExpire(t *testing.T) {
+	store := newStore(t, &Config{Duration: 24 * time.Hour, IdleTimeout: time.Hour, MaxLifetime: 48 * time.Hour})
+	token, session, err := store.Create("alice", "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	age(t, store, session.ID, 2*time.Hour)
+	if _, _, err := store.Validate(token); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("idle session = %v, want %v", err, ErrSessionExpired)
+	}
+	// And it is gone for good
+	if _, _, err := store.Validate(token); !errors.Is(err, ErrInvalidSession) {
+		t.Fatalf("expired session used again = %v, want %v", err, ErrInvalidSession)
+	}
+}
+
+func TestSlidingRefreshStopsAtTheMaxLifetime(t *testing.T) {
+	store := newStore(t, &Config{Duration: time.Hour, MaxLifetime: 90 * time.Minute, SlidingRefresh: true})
+	token, session, err := store.Create("alice", "test", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Early on the session is left alone
+	if _, refreshed, err := store.Validate(token); err != nil || refreshed {
+		t.Fatalf("Validate = refreshed %v, %v; want no refresh", refreshed, err)
+	}
+
+	// In the second half of its lifetime it is extended, but only as far
+	// as the maximum lifetime allows
+	age(t, store, session.ID, 40*time.Minute)
+	valid, refreshed, err := store.Validate(token)
+	if err != nil || !refreshed {
+		t.Fatalf("Validate = refreshed %v, %v; want a refresh", refreshed, err)
+	}
+	if limit := valid.Created.Add(90 * time.Minute); !valid.ExpiresAt.Equal(limit) {
+		t.Fatalf("refreshed until %v, want the maximum lifetime %v", valid.ExpiresAt, limit)
+	}
+}
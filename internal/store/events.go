@@ -0,0 +1,106 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// EventsStream is the JetStream stream recording object changes
+	EventsStream = "SOXDRAWER_EVENTS"
+	// EventsSubjectPrefix prefixes event subjects: soxdrawer.events.<bucket>.<type>
+	EventsSubjectPrefix = "soxdrawer.events"
+	// EventsMaxAge is how long events are retained in the stream
+	EventsMaxAge = 7 * 24 * time.Hour
+
+	EventPut          = "put"
+	EventDelete       = "delete"
+	EventRename       = "rename"
+	EventCopy         = "copy"
+	EventMove         = "move"
+	EventMetadata     = "metadata"
+	EventFolderCreate = "folder_create"
+)
+
+// Event describes a change to an object. Copies into another bucket set
+// NewBucket and are followed by a put event in the destination bucket. A put
+// that overwrote an existing object sets Replaced and the previous size and
+// owner.
+type Event struct {
+	Type          string    `json:"type"`
+	Bucket        string    `json:"bucket"`
+	Key           string    `json:"key"`
+	NewKey        string    `json:"new_key,omitempty"`
+	NewBucket     string    `json:"new_bucket,omitempty"`
+	Size          uint64    `json:"size,omitempty"`
+	Digest        string    `json:"digest,omitempty"`
+	Owner         string    `json:"owner,omitempty"`
+	Replaced      bool      `json:"replaced,omitempty"`
+	PreviousSize  uint64    `json:"previous_size,omitempty"`
+	PreviousOwner string    `json:"previous_owner,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+// EventSubject returns the subject events of a type are published on for a bucket
+func EventSubject(bucket, eventType string) string {
+	return fmt.Sprintf("%s.%s.%s", EventsSubjectPrefix, bucket, eventType)
+}
+
+// ensureEventsStream creates the events stream if it does not exist yet
+func ensureEventsStream(js nats.JetStreamContext, replicas int) error {
+	if _, err := js.StreamInfo(EventsStream); err == nil {
+		return nil
+	}
+
+	_, err := js.AddStream(&nats.StreamConfig{
+		Name:        EventsStream,
+		Description: "soxdrawer object change events",
+		Subjects:    []string{EventsSubjectPrefix + ".>"},
+		MaxAge:      EventsMaxAge,
+		Storage:     nats.FileStorage,
+		Replicas:    replicas,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create events stream: %w", err)
+	}
+	return nil
+}
+
+// emit publishes an event for this bucket. Failures are logged rather than
+// returned since the change itself has already been applied.
+func (os *ObjectStore) emit(event Event) {
+	os.listing.invalidate()
+	event.Bucket = os.name
+	event.Time = time.Now().UTC()
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode %s event for %s: %v", event.Type, event.Key, err)
+		return
+	}
+	if _, err := os.js.Publish(EventSubject(os.name, event.Type), data); err != nil {
+		log.Printf("Failed to publish %s event for %s: %v", event.Type, event.Key, err)
+	}
+}
+
+// emitPut publishes a put event for a freshly stored object. previous is
+// the object it replaced, if any.
+func (os *ObjectStore) emitPut(info, previous *nats.ObjectInfo) {
+	event := Event{
+		Type:   EventPut,
+		Key:    info.Name,
+		Size:   info.Size,
+		Digest: info.Digest,
+		Owner:  Owner(info),
+	}
+	if previous != nil && !previous.Deleted {
+		event.Replaced = true
+		event.PreviousSize = previous.Size
+		event.PreviousOwner = Owner(previous)
+	}
+	os.emit(event)
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestChangesAreRecordedAsEvents(t *testing.T) {
+	objects := openTestBucket(t)
+	other, err := Open(objects.js, "other", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := objects.js.SubscribeSync(EventsSubjectPrefix+"."+objects.Name()+".>", nats.BindStream(EventsStream), nats.DeliverNew())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	if _, err := objects.PutOwned("a.txt", "alice", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := objects.Rename("a.txt", "dir/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := objects.Copy("dir/b.txt", "c.txt", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := objects.Copy("dir/b.txt", "c.txt", "bob"); err == nil {
+		t.Fatal("copy over an existing object succeeded")
+	}
+	if _, err := objects.MoveTo(other, "c.txt", "moved.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := objects.Delete("dir/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Event{
+		{Type: EventPut, Key: "a.txt", Owner: "alice"},
+		{Type: EventRename, Key: "a.txt", NewKey: "dir/b.txt", Owner: "alice"},
+		{Type: EventCopy, Key: "dir/b.txt", NewKey: "c.txt", Owner: "bob"},
+		{Type: EventMove, Key: "c.txt", NewKey: "moved.txt", NewBucket: "other", Owner: "bob"},
+		{Type: EventDelete, Key: "dir/b.txt", Owner: "alice"},
+	}
+	for _, w := range want {
+		msg, err := sub.NextMsg(5 * time.Second)
+		if err != nil {
+			t.Fatalf("no %s event: %v", w.Type, err)
+		}
+		var event Event
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Subject != EventSubject(objects.Name(), w.Type) || event.Type != w.Type || event.Key != w.Key ||
+			event.NewKey != w.NewKey || event.NewBucket != w.NewBucket || event.Owner != w.Owner || event.Size != 5 {
+			t.Fatalf("event on %s = %+v, want %+v of 5 bytes", msg.Subject, event, w)
+		}
+	}
+
+	if data, err := other.GetString("moved.txt"); err != nil || data != "hello" {
+		t.Fatalf("moved object = %q, %v", data, err)
+	}
+}
+
+func TestFailedMoveLeavesNoCopy(t *testing.T) {
+	objects := openTestBucket(t, "a.txt")
+	other, err := Open(objects.js, "other", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A sealed stream can still be read, but nothing can be removed from it
+	info, err := objects.js.StreamInfo("OBJ_" + objects.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := info.Config
+	config.Sealed = true
+	if _, err := objects.js.UpdateStream(&config); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := objects.MoveTo(other, "a.txt", "a.txt"); err == nil || !strings.Contains(err.Error(), "failed to remove 'a.txt'") {
+		t.Fatalf("move = %v, want it to fail removing the original", err)
+	}
+	if exists, err := other.Exists("a.txt"); err != nil || exists {
+		t.Fatalf("copy in the destination = %v, %v; want it removed", exists, err)
+	}
+	if data, err := objects.GetString("a.txt"); err != nil || data != "a.txt" {
+		t.Fatalf("original = %q, %v", data, err)
+	}
+}
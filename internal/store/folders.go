@@ -0,0 +1,204 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// PathSeparator separates folders in object keys
+const PathSeparator = "/"
+
+var (
+	// ErrInvalidPath is returned for empty folder paths or paths containing
+	// empty, "." or ".." segments
+	ErrInvalidPath = errors.New("invalid path")
+	// ErrFolderExists is returned when the target of a folder rename already exists
+	ErrFolderExists = errors.New("folder already exists")
+)
+
+type (
+	// Breadcrumb is one step in the path from the root to the current folder
+	Breadcrumb struct {
+		Name   string `json:"name"`
+		Prefix string `json:"prefix"`
+	}
+
+	// RenameFolderError is returned when a folder rename fails partway. The
+	// objects already renamed are moved back; Stranded lists, by their new
+	// keys, those that couldn't be.
+	RenameFolderError struct {
+		Key      string // The object that couldn't be renamed
+		Stranded []string
+		Err      error
+	}
+)
+
+func (e *RenameFolderError) Error() string {
+	if len(e.Stranded) > 0 {
+		return fmt.Sprintf("%v; %d objects were left in the new folder", e.Err, len(e.Stranded))
+	}
+	return e.Err.Error()
+}
+
+func (e *RenameFolderError) Unwrap() error {
+	return e.Err
+}
+
+// IsFolderMarker reports whether a key is the placeholder object that keeps
+// an otherwise empty folder in existence
+func IsFolderMarker(key string) bool {
+	return strings.HasSuffix(key, PathSeparator)
+}
+
+// FolderPrefix normalizes a folder path into a key prefix ending in the separator.
+// The root folder is represented by the empty string.
+func FolderPrefix(folder string) (string, error) {
+	folder = strings.Trim(folder, PathSeparator)
+	if folder == "" {
+		return "", nil
+	}
+
+	for _, segment := range strings.Split(folder, PathSeparator) {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", fmt.Errorf("%w: '%s'", ErrInvalidPath, folder)
+		}
+	}
+	return folder + PathSeparator, nil
+}
+
+// Breadcrumbs returns the chain of folders leading to prefix, starting at the root
+func Breadcrumbs(prefix string) []Breadcrumb {
+	crumbs := []Breadcrumb{{Name: "", Prefix: ""}}
+
+	current := ""
+	for _, segment := range strings.Split(strings.Trim(prefix, PathSeparator), PathSeparator) {
+		if segment == "" {
+			continue
+		}
+		current += segment + PathSeparator
+		crumbs = append(crumbs, Breadcrumb{Name: segment, Prefix: current})
+	}
+	return crumbs
+}
+
+// CreateFolder creates an empty folder by storing a zero-length marker object
+func (os *ObjectStore) CreateFolder(folder string) (string, error) {
+	prefix, err := FolderPrefix(folder)
+	if err != nil {
+		return "", err
+	}
+	if prefix == "" {
+		return "", fmt.Errorf("%w: cannot create the root folder", ErrInvalidPath)
+	}
+
+	if _, err := os.bucket.Put(&nats.ObjectMeta{Name: prefix}, bytes.NewReader(nil)); err != nil {
+		return "", fmt.Errorf("failed to create folder '%s': %w", prefix, err)
+	}
+	os.emit(Event{Type: EventFolderCreate, Key: prefix})
+	return prefix, nil
+}
+
+// RenameFolder moves every object below one folder to another, rewriting
+// their keys. Moving a folder into a different parent is a rename of its path.
+// If an object can't be renamed, those renamed before it are moved back and
+// the error is a *RenameFolderError.
+func (os *ObjectStore) RenameFolder(from, to string) (int, error) {
+	fromPrefix, err := FolderPrefix(from)
+	if err != nil {
+		return 0, err
+	}
+	toPrefix, err := FolderPrefix(to)
+	if err != nil {
+		return 0, err
+	}
+	if fromPrefix == "" || toPrefix == "" {
+		return 0, fmt.Errorf("%w: cannot rename the root folder", ErrInvalidPath)
+	}
+	if strings.HasPrefix(toPrefix, fromPrefix) {
+		return 0, fmt.Errorf("%w: cannot move folder '%s' into itself", ErrInvalidPath, fromPrefix)
+	}
+
+	keys, err := os.ListKeys()
+	if err != nil {
+		if errors.Is(err, nats.ErrNoObjectsFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var moving []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, toPrefix) {
+			return 0, fmt.Errorf("%w: '%s'", ErrFolderExists, toPrefix)
+		}
+		if strings.HasPrefix(key, fromPrefix) {
+			moving = append(moving, key)
+		}
+	}
+
+	for i, key := range moving {
+		newKey := toPrefix + strings.TrimPrefix(key, fromPrefix)
+		if err := os.renameInFolder(key, newKey); err != nil {
+			return 0, os.undoRenames(moving[:i], fromPrefix, toPrefix, &RenameFolderError{Key: key, Err: err})
+		}
+	}
+
+	return len(moving), nil
+}
+
+// undoRenames moves keys back from toPrefix to fromPrefix after a folder
+// rename failed, noting in failure those that stay where they are
+func (os *ObjectStore) undoRenames(keys []string, fromPrefix, toPrefix string, failure *RenameFolderError) error {
+	for i := len(keys) - 1; i >= 0; i-- {
+		newKey := toPrefix + strings.TrimPrefix(keys[i], fromPrefix)
+		if err := os.renameInFolder(newKey, keys[i]); err != nil {
+			failure.Stranded = append(failure.Stranded, newKey)
+		}
+	}
+	return failure
+}
+
+// renameInFolder renames one object of a folder being renamed
+func (os *ObjectStore) renameInFolder(key, newKey string) error {
+	info, err := os.renameObject(key, newKey)
+	if err != nil {
+		return err
+	}
+	os.emit(Event{Type: EventRename, Key: key, NewKey: newKey, Size: info.Size, Digest: info.Digest, Owner: Owner(info)})
+	return nil
+}
+
+// renameObject changes the key of an object in place without copying its
+// data and carries its tags, description and collections over to the new key
+func (os *ObjectStore) renameObject(oldKey, newKey string) (*nats.ObjectInfo, error) {
+	info, err := os.bucket.GetInfo(oldKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get info for object '%s': %w", oldKey, err)
+	}
+	if oldKey == newKey {
+		return info, nil
+	}
+
+	meta := info.ObjectMeta
+	meta.Name = newKey
+	if err := os.bucket.UpdateMeta(oldKey, &meta); err != nil {
+		return nil, fmt.Errorf("failed to rename object '%s' to '%s': %w", oldKey, newKey, err)
+	}
+	info.Name = newKey
+
+	itemMeta, err := os.meta.Get(metadataKey(oldKey))
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return info, nil
+		}
+		return nil, fmt.Errorf("failed to get metadata for object '%s': %w", oldKey, err)
+	}
+	if _, err := os.meta.Put(metadataKey(newKey), itemMeta.Value()); err != nil {
+		return nil, fmt.Errorf("failed to store metadata for object '%s': %w", newKey, err)
+	}
+	return info, os.DeleteMetadata(oldKey)
+}
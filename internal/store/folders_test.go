@@ -0,0 +1,67 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestRenameFolderEventsCarryTheOwner(t *testing.T) {
+	objects := openTestBucket(t)
+	if _, err := objects.PutOwned("old/a.txt", "alice", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := objects.js.SubscribeSync(EventSubject(objects.Name(), EventRename), nats.BindStream(EventsStream), nats.DeliverNew())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	if moved, err := objects.RenameFolder("old", "new"); err != nil || moved != 1 {
+		t.Fatalf("RenameFolder = %d, %v; want 1, nil", moved, err)
+	}
+	msg, err := sub.NextMsg(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event Event
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Key != "old/a.txt" || event.NewKey != "new/a.txt" || event.Owner != "alice" {
+		t.Fatalf("event = %+v, want old/a.txt renamed to new/a.txt and owned by alice", event)
+	}
+}
+
+func TestRenameFolderUndoesPartialRenames(t *testing.T) {
+	objects := openTestBucket(t, "old/a.txt", "old/b.txt")
+
+	// The first object was renamed before the rename failed
+	if err := objects.renameInFolder("old/a.txt", "new/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	failure := &RenameFolderError{Key: "old/b.txt", Err: errors.New("failed")}
+	err := objects.undoRenames([]string{"old/a.txt", "old/gone.txt"}, "old/", "new/", failure)
+
+	var got *RenameFolderError
+	if !errors.As(err, &got) {
+		t.Fatalf("undoRenames = %v, want a *RenameFolderError", err)
+	}
+	if !slices.Equal(got.Stranded, []string{"new/gone.txt"}) {
+		t.Fatalf("stranded = %v, want [new/gone.txt]", got.Stranded)
+	}
+	keys, err := objects.ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(keys)
+	if want := []string{"old/a.txt", "old/b.txt"}; !slices.Equal(keys, want) {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+}
@@ -0,0 +1,281 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	SortByName    = "name"
+	SortBySize    = "size"
+	SortByCreated = "created"
+
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+
+	KindText     = "text"
+	KindImage    = "image"
+	KindDocument = "document"
+	KindArchive  = "archive"
+	KindFile     = "file"
+
+	// DefaultPageSize is the page size when no limit is given
+	DefaultPageSize = 100
+	// MaxPageSize caps the number of objects returned in a single page
+	MaxPageSize = 1000
+)
+
+var (
+	// ErrInvalidListOptions is returned for unknown sort options or a
+	// pagination cursor that cannot be decoded or does not match the sort order
+	ErrInvalidListOptions = errors.New("invalid list options")
+
+	kindsByExtension = map[string]string{
+		".txt": KindText, ".md": KindText, ".json": KindText, ".csv": KindText,
+		".log": KindText, ".yaml": KindText, ".yml": KindText, ".toml": KindText,
+		".xml": KindText, ".html": KindText,
+		".png": KindImage, ".jpg": KindImage, ".jpeg": KindImage, ".gif": KindImage,
+		".webp": KindImage, ".bmp": KindImage, ".svg": KindImage,
+		".pdf": KindDocument, ".doc": KindDocument, ".docx": KindDocument,
+		".xls": KindDocument, ".xlsx": KindDocument, ".ppt": KindDocument,
+		".pptx": KindDocument, ".odt": KindDocument,
+		".zip": KindArchive, ".rar": KindArchive, ".7z": KindArchive,
+		".tar": KindArchive, ".gz": KindArchive, ".tgz": KindArchive,
+	}
+)
+
+type (
+	// ListOptions filters, sorts and paginates the objects returned by ListObjectsForAPI
+	ListOptions struct {
+		Tags       []string  // Objects must carry every listed tag
+		Collection string    // Objects must belong to this collection
+		Prefix     string    // Object keys must start with this prefix
+		Delimiter  string    // Roll keys containing this after Prefix up into Folders
+		Kind       string    // Objects must be of this kind (text, image, document, archive, file)
+		Since      time.Time // Objects must have been created at or after this time
+		Until      time.Time // Objects must have been created before this time
+		Sort       string    // Field to sort by: name, size or created (default created)
+		Order      string    // Sort direction: asc or desc (default desc)
+		Limit      int       // Maximum objects per page, at most MaxPageSize; 0 means DefaultPageSize
+		Cursor     string    // Opaque cursor returned as NextCursor by the previous page
+	}
+
+	// ListResult is a single page of objects
+	ListResult struct {
+		Objects    []*ObjectInfo `json:"objects"`
+		Folders    []string      `json:"folders,omitempty"` // Sub-folder prefixes, first page only
+		Total      int           `json:"total"`
+		NextCursor string        `json:"next_cursor,omitempty"`
+	}
+
+	// listCursor is the decoded form of ListOptions.Cursor; it records the
+	// position of the last object returned so pages stay stable across inserts
+	listCursor struct {
+		Sort    string `json:"s"`
+		Order   string `json:"o"`
+		Name    string `json:"n"`
+		Size    uint64 `json:"z,omitempty"`
+		Created int64  `json:"c,omitempty"`
+	}
+)
+
+// KindOf classifies an object key by its file extension
+func KindOf(key string) string {
+	if kind, ok := kindsByExtension[strings.ToLower(path.Ext(key))]; ok {
+		return kind
+	}
+	return KindFile
+}
+
+// normalize fills in defaults and validates the sort options
+func (o *ListOptions) normalize() error {
+	switch o.Sort {
+	case "":
+		o.Sort = SortByCreated
+	case SortByName, SortBySize, SortByCreated:
+	default:
+		return fmt.Errorf("%w: unknown sort field '%s'", ErrInvalidListOptions, o.Sort)
+	}
+
+	switch o.Order {
+	case "":
+		o.Order = OrderDesc
+	case OrderAsc, OrderDesc:
+	default:
+		return fmt.Errorf("%w: unknown sort order '%s'", ErrInvalidListOptions, o.Order)
+	}
+
+	if o.Limit < 1 {
+		o.Limit = DefaultPageSize
+	}
+	if o.Limit > MaxPageSize {
+		o.Limit = MaxPageSize
+	}
+	return nil
+}
+
+// matches reports whether an object passes the tag, collection, kind and time filters
+func (o *ListOptions) matches(obj *ObjectInfo, meta *ItemMetadata) bool {
+	if o.Kind != "" && obj.Kind != o.Kind {
+		return false
+	}
+	if !o.Since.IsZero() && obj.Created.Before(o.Since) {
+		return false
+	}
+	if !o.Until.IsZero() && !obj.Created.Before(o.Until) {
+		return false
+	}
+	if len(o.Tags) > 0 && !meta.HasTags(o.Tags) {
+		return false
+	}
+	if o.Collection != "" && !meta.InCollection(o.Collection) {
+		return false
+	}
+	return true
+}
+
+// ListObjectsForAPI returns a page of objects with simplified metadata.
+// The bucket is only read again after it changed; pages are cut from the
+// cached listing, sorted once per order.
+func (os *ObjectStore) ListObjectsForAPI(opts ListOptions) (*ListResult, error) {
+	if err := opts.normalize(); err != nil {
+		return nil, err
+	}
+
+	var cursor *listCursor
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts.Cursor)
+		if err != nil || c.Sort != opts.Sort || c.Order != opts.Order {
+			return nil, fmt.Errorf("%w: bad cursor", ErrInvalidListOptions)
+		}
+		cursor = c
+	}
+
+	entries, _, err := os.listing.load(os, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries are already sorted, so the page starts right after the cursor
+	less := objectLess(opts.Sort, opts.Order)
+	start := 0
+	if cursor != nil {
+		last := cursor.object()
+		start = sort.Search(len(entries), func(i int) bool {
+			return less(last, entries[i].info)
+		})
+	}
+
+	result := &ListResult{Objects: []*ObjectInfo{}}
+	folders := make(map[string]bool)
+	for i, entry := range entries {
+		name := entry.info.Name
+		if !strings.HasPrefix(name, opts.Prefix) {
+			continue
+		}
+		if opts.Delimiter != "" {
+			rest := strings.TrimPrefix(name, opts.Prefix)
+			if i := strings.Index(rest, opts.Delimiter); i >= 0 {
+				if rest != opts.Delimiter {
+					folders[opts.Prefix+rest[:i+len(opts.Delimiter)]] = true
+				}
+				continue
+			}
+		}
+		if IsFolderMarker(name) || !opts.matches(entry.info, entry.meta) {
+			continue
+		}
+
+		result.Total++
+		if i < start {
+			continue
+		}
+		if opts.Limit > 0 && len(result.Objects) == opts.Limit {
+			if result.NextCursor == "" {
+				result.NextCursor = encodeCursor(opts, result.Objects[len(result.Objects)-1])
+			}
+			continue
+		}
+		result.Objects = append(result.Objects, entry.info)
+	}
+
+	if cursor == nil && len(folders) > 0 {
+		for folder := range folders {
+			result.Folders = append(result.Folders, folder)
+		}
+		sort.Strings(result.Folders)
+	}
+	return result, nil
+}
+
+// objectLess returns an ordering on objects for the given sort field and
+// direction, with the object key as tie breaker so the order is total
+func objectLess(field, order string) func(a, b *ObjectInfo) bool {
+	return func(a, b *ObjectInfo) bool {
+		var c int
+		switch field {
+		case SortByName:
+			c = strings.Compare(a.Name, b.Name)
+		case SortBySize:
+			c = compareUint(a.Size, b.Size)
+		default:
+			c = a.Created.Compare(b.Created)
+		}
+		if c == 0 {
+			c = strings.Compare(a.Name, b.Name)
+		}
+		if order == OrderDesc {
+			return c > 0
+		}
+		return c < 0
+	}
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func encodeCursor(opts ListOptions, last *ObjectInfo) string {
+	data, _ := json.Marshal(listCursor{
+		Sort:    opts.Sort,
+		Order:   opts.Order,
+		Name:    last.Name,
+		Size:    last.Size,
+		Created: last.Created.UnixNano(),
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(encoded string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// object rebuilds enough of the last returned object to compare against
+func (c *listCursor) object() *ObjectInfo {
+	return &ObjectInfo{
+		Name:    c.Name,
+		Size:    c.Size,
+		Created: time.Unix(0, c.Created),
+	}
+}
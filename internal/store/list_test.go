@@ -0,0 +1,166 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// names returns the keys of a page
+func names(result *ListResult) []string {
+	var keys []string
+	for _, object := range result.Objects {
+		keys = append(keys, object.Name)
+	}
+	return keys
+}
+
+func TestListPagesFollowTheCursor(t *testing.T) {
+	objects := openTestBucket(t, "e.txt", "a.txt", "d.txt", "c.txt", "b.txt")
+
+	var got []string
+	opts := ListOptions{Sort: SortByName, Order: OrderAsc, Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("listing did not end")
+		}
+		result, err := objects.ListObjectsForAPI(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Total != 5 {
+			t.Fatalf("total = %d, want 5", result.Total)
+		}
+		got = append(got, names(result)...)
+		if result.NextCursor == "" {
+			break
+		}
+		opts.Cursor = result.NextCursor
+	}
+	if want := []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"}; !slices.Equal(got, want) {
+		t.Fatalf("pages = %v, want %v", got, want)
+	}
+
+	// A cursor from one order is refused in another
+	opts.Order = OrderDesc
+	if _, err := objects.ListObjectsForAPI(opts); err == nil {
+		t.Fatal("cursor accepted for another order")
+	}
+}
+
+func TestListRollsUpFolders(t *testing.T) {
+	objects := openTestBucket(t, "top.txt", "photos/a.jpg", "photos/2024/b.jpg", "docs/c.pdf")
+
+	result, err := objects.ListObjectsForAPI(ListOptions{Delimiter: "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(result); !slices.Equal(got, []string{"top.txt"}) {
+		t.Fatalf("objects = %v, want [top.txt]", got)
+	}
+	if want := []string{"docs/", "photos/"}; !slices.Equal(result.Folders, want) {
+		t.Fatalf("folders = %v, want %v", result.Folders, want)
+	}
+}
+
+func TestListSeesChanges(t *testing.T) {
+	objects := openTestBucket(t, "a.txt")
+	if _, err := objects.ListObjectsForAPI(ListOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes made here show up at once
+	if _, err := objects.PutString("b.txt", "b"); err != nil {
+		t.Fatal(err)
+	}
+	tags := []string{"red"}
+	if _, err := objects.PatchMetadata("a.txt", &MetadataPatch{Tags: &tags}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := objects.ListObjectsForAPI(ListOptions{Tags: []string{"red"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(result); !slices.Equal(got, []string{"a.txt"}) {
+		t.Fatalf("tagged = %v, want [a.txt]", got)
+	}
+	if result, _ := objects.ListObjectsForAPI(ListOptions{}); result.Total != 2 {
+		t.Fatalf("total = %d, want 2", result.Total)
+	}
+
+	// Changes made elsewhere show up once the watch sees them
+	if _, err := objects.Bucket().PutString("c.txt", "written by another client"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		result, err := objects.ListObjectsForAPI(ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Total == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("total = %d after another client's write, want 3", result.Total)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	counts, err := objects.TagCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 1 || counts[0] != (LabelCount{Name: "red", Count: 1}) {
+		t.Fatalf("tag counts = %v, want [{red 1}]", counts)
+	}
+}
+
+func TestListFiltersByKindAndTag(t *testing.T) {
+	objects := openTestBucket(t, "a.txt", "b.png", "c.bin")
+	tags := []string{"Foo", " bar", "foo"}
+	if _, err := objects.PatchMetadata("a.txt", &MetadataPatch{Tags: &tags}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := objects.ListObjectsForAPI(ListOptions{Kind: KindImage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(result); !slices.Equal(got, []string{"b.png"}) {
+		t.Fatalf("images = %v, want [b.png]", got)
+	}
+	result, err = objects.ListObjectsForAPI(ListOptions{Tags: []string{"FOO"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(result); !slices.Equal(got, []string{"a.txt"}) {
+		t.Fatalf("tagged foo = %v, want [a.txt]", got)
+	}
+
+	counts, err := objects.TagCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []LabelCount{{"bar", 1}, {"foo", 1}}; !slices.Equal(counts, want) {
+		t.Fatalf("tag counts = %v, want %v", counts, want)
+	}
+}
+
+func TestListWithoutALimitReturnsOnePage(t *testing.T) {
+	objects := openTestBucket(t)
+	for i := range DefaultPageSize + 1 {
+		if _, err := objects.PutString(fmt.Sprintf("%03d.txt", i), "x"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := objects.ListObjectsForAPI(ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Objects) != DefaultPageSize || result.NextCursor == "" {
+		t.Fatalf("listing without a limit returned %d objects, want a page of %d", len(result.Objects), DefaultPageSize)
+	}
+}
@@ -0,0 +1,160 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+type (
+	// listing caches the objects of a bucket with their metadata, so pages
+	// after the first are served from memory. Any change to the bucket or
+	// its metadata, from this process or another, clears it; the next
+	// listing reads the bucket again.
+	listing struct {
+		mu         sync.Mutex
+		watching   bool
+		generation uint64 // Bumped by every change
+		valid      bool
+		entries    []*listEntry
+		meta       map[string]*ItemMetadata
+		sorted     map[string][]*listEntry // By sort field and order
+	}
+
+	listEntry struct {
+		info *ObjectInfo
+		meta *ItemMetadata
+	}
+
+	listingKey struct {
+		js   nats.JetStreamContext
+		name string
+	}
+)
+
+// listings holds one listing per bucket, shared by every ObjectStore
+// opened on it
+var listings sync.Map
+
+func listingFor(js nats.JetStreamContext, name string) *listing {
+	l, _ := listings.LoadOrStore(listingKey{js, name}, &listing{})
+	return l.(*listing)
+}
+
+// invalidate clears the cache after a change
+func (l *listing) invalidate() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.generation++
+	l.valid = false
+	l.entries, l.meta, l.sorted = nil, nil, nil
+}
+
+// watch starts clearing the cache on changes made anywhere. Without it the
+// cache is never used.
+func (l *listing) watch(os *ObjectStore) error {
+	objects, err := os.bucket.Watch(nats.UpdatesOnly())
+	if err != nil {
+		return fmt.Errorf("failed to watch bucket '%s': %w", os.name, err)
+	}
+	meta, err := os.meta.WatchAll(nats.UpdatesOnly())
+	if err != nil {
+		objects.Stop()
+		return fmt.Errorf("failed to watch metadata of bucket '%s': %w", os.name, err)
+	}
+
+	go func() {
+		for range objects.Updates() {
+			l.invalidate()
+		}
+	}()
+	go func() {
+		for range meta.Updates() {
+			l.invalidate()
+		}
+	}()
+	return nil
+}
+
+// load returns the objects of the bucket sorted for opts, with the
+// metadata index, reading them again when they changed
+func (l *listing) load(os *ObjectStore, opts ListOptions) ([]*listEntry, map[string]*ItemMetadata, error) {
+	l.mu.Lock()
+	if !l.watching {
+		if err := l.watch(os); err != nil {
+			log.Printf("Not caching listings: %v", err)
+		} else {
+			l.watching = true
+		}
+	}
+	if l.valid {
+		defer l.mu.Unlock()
+		return l.sortedBy(opts), l.meta, nil
+	}
+	generation := l.generation
+	l.mu.Unlock()
+
+	natsObjects, err := os.bucket.List()
+	if err != nil && !errors.Is(err, nats.ErrNoObjectsFound) {
+		return nil, nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	index, err := os.AllMetadata()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries := make([]*listEntry, 0, len(natsObjects))
+	for _, obj := range natsObjects {
+		meta, ok := index[obj.Name]
+		if !ok {
+			meta = &ItemMetadata{}
+		}
+		entries = append(entries, &listEntry{
+			info: &ObjectInfo{
+				Name:        obj.Name,
+				Size:        obj.Size,
+				Created:     obj.ModTime,
+				Kind:        KindOf(obj.Name),
+				Tags:        meta.Tags,
+				Description: meta.Description,
+				Collections: meta.Collections,
+			},
+			meta: meta,
+		})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	current := &listing{entries: entries, meta: index}
+	// Keep what was read unless something changed meanwhile
+	if l.watching && l.generation == generation {
+		l.valid = true
+		l.entries, l.meta, l.sorted = entries, index, nil
+		current = l
+	}
+	return current.sortedBy(opts), index, nil
+}
+
+// sortedBy returns the entries in the order opts asks for; callers hold mu
+// unless the listing is private
+func (l *listing) sortedBy(opts ListOptions) []*listEntry {
+	key := opts.Sort + " " + opts.Order
+	if sorted, ok := l.sorted[key]; ok {
+		return sorted
+	}
+	sorted := make([]*listEntry, len(l.entries))
+	copy(sorted, l.entries)
+	less := objectLess(opts.Sort, opts.Order)
+	sort.Slice(sorted, func(i, j int) bool {
+		return less(sorted[i].info, sorted[j].info)
+	})
+	if l.sorted == nil {
+		l.sorted = map[string][]*listEntry{}
+	}
+	l.sorted[key] = sorted
+	return sorted
+}
@@ -0,0 +1,315 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// MaxTagLength is the longest tag or collection name accepted, in bytes
+	MaxTagLength = 64
+	// MaxDescriptionLength is the longest free-text description accepted
+	MaxDescriptionLength = 4096
+)
+
+type (
+	// ItemMetadata holds the user-editable organization data for an object
+	ItemMetadata struct {
+		Tags        []string  `json:"tags,omitempty"`
+		Description string    `json:"description,omitempty"`
+		Collections []string  `json:"collections,omitempty"`
+		Updated     time.Time `json:"updated"`
+	}
+
+	// MetadataPatch describes a partial update to an object's metadata.
+	// Nil fields are left untouched; Add/Remove lists are applied after replacements.
+	MetadataPatch struct {
+		Tags              *[]string `json:"tags,omitempty"`
+		AddTags           []string  `json:"add_tags,omitempty"`
+		RemoveTags        []string  `json:"remove_tags,omitempty"`
+		Description       *string   `json:"description,omitempty"`
+		Collections       *[]string `json:"collections,omitempty"`
+		AddCollections    []string  `json:"add_collections,omitempty"`
+		RemoveCollections []string  `json:"remove_collections,omitempty"`
+	}
+
+	// LabelCount is a tag or collection name with the number of objects carrying it
+	LabelCount struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+)
+
+// metadataBucketName returns the KV bucket holding the metadata index for an object bucket
+func metadataBucketName(bucket string) string {
+	return bucket + "_meta"
+}
+
+// metadataKey encodes an object key into a valid KV key
+func metadataKey(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// objectKeyFromMetadataKey reverses metadataKey
+func objectKeyFromMetadataKey(kvKey string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(kvKey)
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+// openMetadataIndex creates or opens the KV bucket used for the metadata index
+func openMetadataIndex(js nats.JetStreamContext, bucket string, replicas int) (nats.KeyValue, error) {
+	name := metadataBucketName(bucket)
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{
+		Bucket:      name,
+		Description: fmt.Sprintf("soxdrawer metadata index for bucket '%s'", bucket),
+		Replicas:    replicas,
+	})
+	if err != nil {
+		kv, err = js.KeyValue(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create or get metadata bucket '%s': %w", name, err)
+		}
+	}
+	return kv, nil
+}
+
+// GetMetadata returns the metadata for an object, or empty metadata if none has been set
+func (os *ObjectStore) GetMetadata(key string) (*ItemMetadata, error) {
+	entry, err := os.meta.Get(metadataKey(key))
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return &ItemMetadata{}, nil
+		}
+		return nil, fmt.Errorf("failed to get metadata for object '%s': %w", key, err)
+	}
+
+	var meta ItemMetadata
+	if err := json.Unmarshal(entry.Value(), &meta); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata for object '%s': %w", key, err)
+	}
+	return &meta, nil
+}
+
+// SetMetadata replaces the metadata for an object
+func (os *ObjectStore) SetMetadata(key string, meta *ItemMetadata) error {
+	meta.Tags = normalizeLabels(meta.Tags)
+	meta.Collections = normalizeLabels(meta.Collections)
+	meta.Description = strings.TrimSpace(meta.Description)
+	if len(meta.Description) > MaxDescriptionLength {
+		return fmt.Errorf("description exceeds %d characters", MaxDescriptionLength)
+	}
+	meta.Updated = time.Now().UTC()
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata for object '%s': %w", key, err)
+	}
+	if _, err := os.meta.Put(metadataKey(key), data); err != nil {
+		return fmt.Errorf("failed to store metadata for object '%s': %w", key, err)
+	}
+	os.listing.invalidate()
+	return nil
+}
+
+// PatchMetadata applies a partial update to an object's metadata and returns the result
+func (os *ObjectStore) PatchMetadata(key string, patch *MetadataPatch) (*ItemMetadata, error) {
+	exists, err := os.Exists(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("failed to patch metadata for object '%s': %w", key, nats.ErrObjectNotFound)
+	}
+
+	meta, err := os.GetMetadata(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if patch.Tags != nil {
+		meta.Tags = *patch.Tags
+	}
+	meta.Tags = applyLabelChanges(meta.Tags, patch.AddTags, patch.RemoveTags)
+
+	if patch.Collections != nil {
+		meta.Collections = *patch.Collections
+	}
+	meta.Collections = applyLabelChanges(meta.Collections, patch.AddCollections, patch.RemoveCollections)
+
+	if patch.Description != nil {
+		meta.Description = *patch.Description
+	}
+
+	if err := os.SetMetadata(key, meta); err != nil {
+		return nil, err
+	}
+	os.emit(Event{Type: EventMetadata, Key: key})
+	return meta, nil
+}
+
+// DeleteMetadata removes the metadata for an object
+func (os *ObjectStore) DeleteMetadata(key string) error {
+	err := os.meta.Delete(metadataKey(key))
+	if err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+		return fmt.Errorf("failed to delete metadata for object '%s': %w", key, err)
+	}
+	os.listing.invalidate()
+	return nil
+}
+
+// AllMetadata loads the complete metadata index keyed by object key
+func (os *ObjectStore) AllMetadata() (map[string]*ItemMetadata, error) {
+	watcher, err := os.meta.WatchAll(nats.IgnoreDeletes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata index: %w", err)
+	}
+	defer watcher.Stop()
+
+	index := make(map[string]*ItemMetadata)
+	for entry := range watcher.Updates() {
+		// A nil entry marks the end of the initial values
+		if entry == nil {
+			break
+		}
+
+		key, err := objectKeyFromMetadataKey(entry.Key())
+		if err != nil {
+			continue
+		}
+
+		var meta ItemMetadata
+		if err := json.Unmarshal(entry.Value(), &meta); err != nil {
+			continue
+		}
+		index[key] = &meta
+	}
+
+	return index, nil
+}
+
+// cachedMetadata returns the metadata index kept with the cached listing
+func (os *ObjectStore) cachedMetadata() (map[string]*ItemMetadata, error) {
+	opts := ListOptions{}
+	if err := opts.normalize(); err != nil {
+		return nil, err
+	}
+	_, index, err := os.listing.load(os, opts)
+	return index, err
+}
+
+// TagCounts returns every tag in use with the number of objects carrying it
+func (os *ObjectStore) TagCounts() ([]LabelCount, error) {
+	index, err := os.cachedMetadata()
+	if err != nil {
+		return nil, err
+	}
+	return countLabels(index, func(m *ItemMetadata) []string { return m.Tags }), nil
+}
+
+// CollectionCounts returns every collection in use with the number of member objects
+func (os *ObjectStore) CollectionCounts() ([]LabelCount, error) {
+	index, err := os.cachedMetadata()
+	if err != nil {
+		return nil, err
+	}
+	return countLabels(index, func(m *ItemMetadata) []string { return m.Collections }), nil
+}
+
+// HasTags reports whether the metadata carries all of the given tags
+func (m *ItemMetadata) HasTags(tags []string) bool {
+	for _, tag := range normalizeLabels(tags) {
+		if !containsLabel(m.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// InCollection reports whether the metadata lists the given collection
+func (m *ItemMetadata) InCollection(collection string) bool {
+	return containsLabel(m.Collections, normalizeLabel(collection))
+}
+
+func countLabels(index map[string]*ItemMetadata, labels func(*ItemMetadata) []string) []LabelCount {
+	counts := make(map[string]int)
+	for _, meta := range index {
+		for _, label := range labels(meta) {
+			counts[label]++
+		}
+	}
+
+	result := make([]LabelCount, 0, len(counts))
+	for name, count := range counts {
+		result = append(result, LabelCount{Name: name, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func applyLabelChanges(labels, add, remove []string) []string {
+	labels = append(labels, add...)
+	labels = normalizeLabels(labels)
+
+	removed := normalizeLabels(remove)
+	kept := labels[:0]
+	for _, label := range labels {
+		if !containsLabel(removed, label) {
+			kept = append(kept, label)
+		}
+	}
+	return kept
+}
+
+// normalizeLabels lowercases, trims, de-duplicates and sorts tags or collection names
+func normalizeLabels(labels []string) []string {
+	seen := make(map[string]bool, len(labels))
+	result := make([]string, 0, len(labels))
+	for _, label := range labels {
+		label = normalizeLabel(label)
+		if label == "" || seen[label] {
+			continue
+		}
+		seen[label] = true
+		result = append(result, label)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func normalizeLabel(label string) string {
+	label = strings.ToLower(strings.TrimSpace(label))
+	if len(label) > MaxTagLength {
+		// Cut before the character that doesn't fit, not in the middle of it
+		cut := MaxTagLength
+		for cut > 0 && !utf8.RuneStart(label[cut]) {
+			cut--
+		}
+		label = strings.TrimSpace(label[:cut])
+	}
+	return label
+}
+
+func containsLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestNormalizeLabelKeepsWholeCharacters(t *testing.T) {
+	for _, label := range []string{
+		strings.Repeat("a", MaxTagLength+10),
+		strings.Repeat("é", MaxTagLength),       // Two bytes each
+		"a" + strings.Repeat("日", MaxTagLength), // Three bytes each, starting off the boundary
+		strings.Repeat("🦊", MaxTagLength),       // Four bytes each
+	} {
+		got := normalizeLabel(label)
+		if len(got) > MaxTagLength {
+			t.Errorf("normalizeLabel kept %d bytes, want at most %d", len(got), MaxTagLength)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("normalizeLabel(%.8q...) = %q, which is not valid UTF-8", label, got)
+		}
+		if len(got) < MaxTagLength-utf8.UTFMax {
+			t.Errorf("normalizeLabel cut %q down to %d bytes, more than needed", label, len(got))
+		}
+	}
+}
+
+func TestNormalizeLabels(t *testing.T) {
+	got := normalizeLabels([]string{" Holiday ", "holiday", "", "Beach"})
+	if strings.Join(got, ",") != "beach,holiday" {
+		t.Fatalf("normalizeLabels = %v, want [beach holiday]", got)
+	}
+}
@@ -0,0 +1,201 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// ErrInvalidKey is returned for empty keys or keys naming a folder
+var ErrInvalidKey = errors.New("invalid object key")
+
+// validateKey checks that a key can name a regular object
+func validateKey(key string) error {
+	if strings.TrimSpace(key) == "" || IsFolderMarker(key) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidKey, key)
+	}
+	return nil
+}
+
+// Rename changes the key of an object within the bucket. The data is not
+// copied; tags, description and collections follow the object.
+func (os *ObjectStore) Rename(oldKey, newKey string) (*nats.ObjectInfo, error) {
+	if err := validateKey(newKey); err != nil {
+		return nil, err
+	}
+
+	info, err := os.renameObject(oldKey, newKey)
+	if err != nil {
+		return nil, err
+	}
+
+	os.emit(Event{Type: EventRename, Key: oldKey, NewKey: newKey, Size: info.Size, Digest: info.Digest, Owner: Owner(info)})
+	return info, nil
+}
+
+// Copy duplicates an object under a new key in the same bucket. The copy
+// belongs to owner, who made it.
+func (os *ObjectStore) Copy(srcKey, dstKey, owner string) (*nats.ObjectInfo, error) {
+	info, err := os.copyTo(os, srcKey, dstKey, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	os.emit(Event{Type: EventCopy, Key: srcKey, NewKey: dstKey, Size: info.Size, Digest: info.Digest, Owner: Owner(info)})
+	return info, nil
+}
+
+// CopyTo duplicates an object into another bucket, keeping the original.
+// The copy belongs to owner, who made it.
+func (os *ObjectStore) CopyTo(dst *ObjectStore, srcKey, dstKey, owner string) (*nats.ObjectInfo, error) {
+	info, err := os.copyTo(dst, srcKey, dstKey, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	os.emit(Event{Type: EventCopy, Key: srcKey, NewKey: dstKey, NewBucket: dst.name, Size: info.Size, Digest: info.Digest, Owner: Owner(info)})
+	dst.emitPut(info, nil)
+	return info, nil
+}
+
+// MoveTo moves an object into another bucket, streaming its data across and
+// removing the original once the copy is complete. If the original can't be
+// removed, the copy is removed again, so the object stays where it was.
+func (os *ObjectStore) MoveTo(dst *ObjectStore, srcKey, dstKey string) (*nats.ObjectInfo, error) {
+	if dst.name == os.name {
+		return os.Rename(srcKey, dstKey)
+	}
+
+	info, err := os.copyTo(dst, srcKey, dstKey, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.bucket.Delete(srcKey); err != nil {
+		err = fmt.Errorf("failed to remove '%s' after copying it to bucket '%s': %w", srcKey, dst.name, err)
+		if undoErr := dst.remove(dstKey); undoErr != nil {
+			// The object is in both buckets now, so announce the copy
+			dst.emitPut(info, nil)
+			return nil, errors.Join(err, undoErr)
+		}
+		return nil, err
+	}
+	// The object has moved; stale metadata left behind is only logged
+	if err := os.DeleteMetadata(srcKey); err != nil {
+		log.Printf("Moved '%s' to bucket '%s' but kept its metadata: %v", srcKey, dst.name, err)
+	}
+
+	os.emit(Event{Type: EventMove, Key: srcKey, NewKey: dstKey, NewBucket: dst.name, Size: info.Size, Digest: info.Digest, Owner: Owner(info)})
+	dst.emitPut(info, nil)
+	return info, nil
+}
+
+// remove deletes an object and its metadata without recording an event,
+// undoing a copy nobody was told about
+func (os *ObjectStore) remove(key string) error {
+	if err := os.bucket.Delete(key); err != nil {
+		return fmt.Errorf("failed to remove '%s' from bucket '%s': %w", key, os.name, err)
+	}
+	return os.DeleteMetadata(key)
+}
+
+// copyTo streams an object into dst under dstKey, charged to owner if set.
+// It refuses to overwrite an existing object.
+func (os *ObjectStore) copyTo(dst *ObjectStore, srcKey, dstKey, owner string) (*nats.ObjectInfo, error) {
+	if err := validateKey(dstKey); err != nil {
+		return nil, err
+	}
+
+	exists, err := dst.Exists(dstKey)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("failed to copy '%s' to '%s': %w", srcKey, dstKey, nats.ErrObjectAlreadyExists)
+	}
+	return os.streamTo(dst, srcKey, dstKey, owner, false)
+}
+
+// SyncTo makes the object under key in dst a copy of this one, replacing
+// what is there, tags and description included
+func (os *ObjectStore) SyncTo(dst *ObjectStore, key string) (*nats.ObjectInfo, error) {
+	previous, _ := dst.bucket.GetInfo(key)
+	info, err := os.streamTo(dst, key, key, "", true)
+	if err != nil {
+		return nil, err
+	}
+	dst.emitPut(info, previous)
+	return info, nil
+}
+
+// streamTo streams an object into dst under dstKey, preserving its
+// headers, description, user metadata and tags. A non-empty owner replaces
+// the one recorded. With replace, tags dst had for dstKey are dropped when
+// the object has none.
+func (os *ObjectStore) streamTo(dst *ObjectStore, srcKey, dstKey, owner string, replace bool) (*nats.ObjectInfo, error) {
+	result, err := os.bucket.Get(srcKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object '%s': %w", srcKey, err)
+	}
+	defer result.Close()
+
+	srcInfo, err := result.Info()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get info for object '%s': %w", srcKey, err)
+	}
+
+	headers := srcInfo.Headers
+	if owner != "" {
+		headers = nats.Header{}
+		for name, values := range srcInfo.Headers {
+			headers[name] = slices.Clone(values)
+		}
+		headers.Set(OwnerHeader, owner)
+	}
+
+	info, err := dst.bucket.Put(&nats.ObjectMeta{
+		Name:        dstKey,
+		Description: srcInfo.Description,
+		Headers:     headers,
+		Metadata:    srcInfo.Metadata,
+	}, result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy '%s' to '%s': %w", srcKey, dstKey, err)
+	}
+
+	if err := os.copyMetadata(dst, srcKey, dstKey, replace); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// SyncMetadataTo makes the tags, description and collections of the object
+// under key in dst match this one's, leaving its data alone
+func (os *ObjectStore) SyncMetadataTo(dst *ObjectStore, key string) error {
+	if err := os.copyMetadata(dst, key, key, true); err != nil {
+		return err
+	}
+	dst.emit(Event{Type: EventMetadata, Key: key})
+	return nil
+}
+
+// copyMetadata copies the tags, description and collections of srcKey to
+// dstKey in dst. With replace, those dst had for dstKey are dropped when
+// srcKey has none.
+func (os *ObjectStore) copyMetadata(dst *ObjectStore, srcKey, dstKey string, replace bool) error {
+	itemMeta, err := os.GetMetadata(srcKey)
+	if err != nil {
+		return err
+	}
+	if len(itemMeta.Tags) > 0 || len(itemMeta.Collections) > 0 || itemMeta.Description != "" {
+		return dst.SetMetadata(dstKey, itemMeta)
+	}
+	if replace {
+		return dst.DeleteMetadata(dstKey)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"time"
@@ -8,34 +9,91 @@ import (
 	"github.com/nats-io/nats.go"
 )
 
+// DefaultBucket is the object store bucket used by the web interface
+const DefaultBucket = "default"
+
+// OwnerHeader records the user who uploaded an object so quotas can be
+// charged to them. A copy belongs to whoever made it; moved and synced
+// objects keep their owner.
+const OwnerHeader = "Soxdrawer-Owner"
+
 type ObjectStore struct {
-	bucket nats.ObjectStore
-	js     nats.JetStreamContext
+	name     string
+	bucket   nats.ObjectStore
+	meta     nats.KeyValue
+	js       nats.JetStreamContext
+	replicas int // Copies kept of anything created, when clustered
+	listing  *listing
 }
 
-func New(js nats.JetStreamContext) (*ObjectStore, error) {
+// New opens the default bucket, creating it if needed with the given
+// number of replicas
+func New(js nats.JetStreamContext, replicas int) (*ObjectStore, error) {
+	return Open(js, DefaultBucket, replicas)
+}
+
+// Open opens the named bucket, creating it if needed
+func Open(js nats.JetStreamContext, name string, replicas int) (*ObjectStore, error) {
 	bucket, err := js.CreateObjectStore(&nats.ObjectStoreConfig{
-		Bucket: "default",
+		Bucket:   name,
+		Replicas: replicas,
 	})
 	if err != nil {
-		bucket, err = js.ObjectStore("default")
+		createErr := err
+		bucket, err = js.ObjectStore(name)
+		if errors.Is(err, nats.ErrStreamNotFound) {
+			err = createErr
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to create or get object store bucket 'default': %w", err)
+			return nil, fmt.Errorf("failed to create or get object store bucket '%s': %w", name, err)
 		}
 	}
 
+	return wrap(js, name, bucket, replicas)
+}
+
+// OpenBucket opens another existing bucket on the same JetStream context
+func (os *ObjectStore) OpenBucket(name string) (*ObjectStore, error) {
+	bucket, err := os.js.ObjectStore(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object store bucket '%s': %w", name, err)
+	}
+	return wrap(os.js, name, bucket, os.replicas)
+}
+
+func wrap(js nats.JetStreamContext, name string, bucket nats.ObjectStore, replicas int) (*ObjectStore, error) {
+	meta, err := openMetadataIndex(js, name, replicas)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ensureEventsStream(js, replicas); err != nil {
+		return nil, err
+	}
+
 	return &ObjectStore{
-		bucket: bucket,
-		js:     js,
+		name:     name,
+		bucket:   bucket,
+		meta:     meta,
+		js:       js,
+		replicas: replicas,
+		listing:  listingFor(js, name),
 	}, nil
 }
 
+// Name returns the name of the bucket
+func (os *ObjectStore) Name() string {
+	return os.name
+}
+
 // Put stores an object with the given key and data
 func (os *ObjectStore) Put(key string, data []byte) (*nats.ObjectInfo, error) {
+	previous, _ := os.bucket.GetInfo(key)
 	info, err := os.bucket.PutBytes(key, data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to put object '%s': %w", key, err)
 	}
+	os.emitPut(info, previous)
 	return info, nil
 }
 
@@ -46,13 +104,35 @@ func (os *ObjectStore) PutString(key, data string) (*nats.ObjectInfo, error) {
 
 // PutReader stores an object from a reader
 func (os *ObjectStore) PutReader(key string, reader io.Reader) (*nats.ObjectInfo, error) {
-	info, err := os.bucket.Put(&nats.ObjectMeta{Name: key}, reader)
+	return os.PutOwned(key, "", reader)
+}
+
+// PutOwned stores an object from a reader and records the user it is
+// charged to. If the reader fails, the partial object is discarded.
+func (os *ObjectStore) PutOwned(key, owner string, reader io.Reader) (*nats.ObjectInfo, error) {
+	meta := &nats.ObjectMeta{Name: key}
+	if owner != "" {
+		meta.Headers = nats.Header{}
+		meta.Headers.Set(OwnerHeader, owner)
+	}
+
+	previous, _ := os.bucket.GetInfo(key)
+	info, err := os.bucket.Put(meta, reader)
 	if err != nil {
 		return nil, fmt.Errorf("failed to put object '%s' from reader: %w", key, err)
 	}
+	os.emitPut(info, previous)
 	return info, nil
 }
 
+// Owner returns the user an object is charged to, if recorded
+func Owner(info *nats.ObjectInfo) string {
+	if info == nil || info.Headers == nil {
+		return ""
+	}
+	return info.Headers.Get(OwnerHeader)
+}
+
 // Get retrieves an object by key
 func (os *ObjectStore) Get(key string) ([]byte, error) {
 	result, err := os.bucket.GetBytes(key)
@@ -62,6 +142,15 @@ func (os *ObjectStore) Get(key string) ([]byte, error) {
 	return result, nil
 }
 
+// GetReader opens an object for streaming. The caller must close the result.
+func (os *ObjectStore) GetReader(key string) (nats.ObjectResult, error) {
+	result, err := os.bucket.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object '%s': %w", key, err)
+	}
+	return result, nil
+}
+
 // GetString retrieves an object as a string by key
 func (os *ObjectStore) GetString(key string) (string, error) {
 	data, err := os.Get(key)
@@ -82,11 +171,19 @@ func (os *ObjectStore) GetInfo(key string) (*nats.ObjectInfo, error) {
 
 // Delete removes an object by key
 func (os *ObjectStore) Delete(key string) error {
+	event := Event{Type: EventDelete, Key: key}
+	if info, err := os.bucket.GetInfo(key); err == nil {
+		event.Size = info.Size
+		event.Digest = info.Digest
+		event.Owner = Owner(info)
+	}
+
 	err := os.bucket.Delete(key)
 	if err != nil {
 		return fmt.Errorf("failed to delete object '%s': %w", key, err)
 	}
-	return nil
+	os.emit(event)
+	return os.DeleteMetadata(key)
 }
 
 // ListKeys returns a list of all object keys in the bucket
@@ -141,26 +238,11 @@ func (os *ObjectStore) ListObjects() ([]*nats.ObjectInfo, error) {
 
 // ObjectInfo represents simplified object metadata for JSON responses
 type ObjectInfo struct {
-    Name    string    `json:"name"`
-    Size    uint64    `json:"size"`
-    Created time.Time `json:"created"`
-}
-
-// ListObjectsForAPI returns a list of objects with simplified metadata
-func (os *ObjectStore) ListObjectsForAPI() ([]*ObjectInfo, error) {
-	natsObjects, err := os.bucket.List()
-	if err != nil {
-		return nil, fmt.Errorf("failed to list objects: %w", err)
-	}
-
-    objects := make([]*ObjectInfo, len(natsObjects))
-    for i, obj := range natsObjects {
-        objects[i] = &ObjectInfo{
-            Name:    obj.Name,
-            Size:    obj.Size,
-            Created: obj.ModTime,
-        }
-    }
-
-	return objects, nil
+	Name        string    `json:"name"`
+	Size        uint64    `json:"size"`
+	Created     time.Time `json:"created"`
+	Kind        string    `json:"kind"`
+	Tags        []string  `json:"tags,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Collections []string  `json:"collections,omitempty"`
 }
@@ -0,0 +1,186 @@
+package uploadkeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// BucketName is the KV bucket holding upload keys
+	BucketName = "upload_keys"
+
+	// Prefix starts every upload key so they are easy to recognise in
+	// embedding pages and logs
+	Prefix = "sdu_"
+)
+
+var (
+	// ErrKeyNotFound is returned when no upload key exists for an ID
+	ErrKeyNotFound = errors.New("upload key not found")
+	// ErrInvalidKey is returned when a presented key is malformed, unknown or revoked
+	ErrInvalidKey = errors.New("invalid upload key")
+)
+
+type (
+	// Key is a scoped credential that can only upload into one bucket,
+	// optionally below a folder. Only a hash of the secret is stored.
+	Key struct {
+		ID        string    `json:"id"`
+		Name      string    `json:"name"`
+		Bucket    string    `json:"bucket"`
+		Folder    string    `json:"folder,omitempty"`
+		Created   time.Time `json:"created"`
+		CreatedBy string    `json:"created_by"`
+		Hash      string    `json:"hash,omitempty"` // SHA-256 of the full key
+	}
+
+	// Store keeps upload keys in a JetStream KV bucket
+	Store struct {
+		kv nats.KeyValue
+	}
+)
+
+// New creates or opens the upload keys bucket
+func New(js nats.JetStreamContext) (*Store, error) {
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{
+		Bucket:      BucketName,
+		Description: "soxdrawer scoped upload keys",
+	})
+	if err != nil {
+		kv, err = js.KeyValue(BucketName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create or get upload keys bucket: %w", err)
+		}
+	}
+
+	return &Store{kv: kv}, nil
+}
+
+// Create issues a new upload key and returns it with the secret, which is
+// not stored and cannot be shown again
+func (s *Store) Create(name, bucket, folder, createdBy string) (*Key, string, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return nil, "", err
+	}
+
+	full := Prefix + id + "_" + secret
+	key := &Key{
+		ID:        id,
+		Name:      name,
+		Bucket:    bucket,
+		Folder:    folder,
+		Created:   time.Now().UTC(),
+		CreatedBy: createdBy,
+		Hash:      hash(full),
+	}
+
+	data, err := json.Marshal(key)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode upload key: %w", err)
+	}
+	if _, err := s.kv.Create(id, data); err != nil {
+		return nil, "", fmt.Errorf("failed to store upload key: %w", err)
+	}
+	return key, full, nil
+}
+
+// Get returns the upload key with the given ID
+func (s *Store) Get(id string) (*Key, error) {
+	entry, err := s.kv.Get(id)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return nil, fmt.Errorf("%w: '%s'", ErrKeyNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to get upload key '%s': %w", id, err)
+	}
+
+	var key Key
+	if err := json.Unmarshal(entry.Value(), &key); err != nil {
+		return nil, fmt.Errorf("failed to decode upload key '%s': %w", id, err)
+	}
+	return &key, nil
+}
+
+// Verify returns the upload key a presented secret belongs to
+func (s *Store) Verify(full string) (*Key, error) {
+	id, _, ok := strings.Cut(strings.TrimPrefix(full, Prefix), "_")
+	if !strings.HasPrefix(full, Prefix) || !ok || id == "" || strings.ContainsAny(id, ".*> ") {
+		return nil, ErrInvalidKey
+	}
+
+	key, err := s.Get(id)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return nil, ErrInvalidKey
+		}
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash(full))) != 1 {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// Revoke deletes an upload key so it can no longer be used
+func (s *Store) Revoke(id string) error {
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	if err := s.kv.Delete(id); err != nil {
+		return fmt.Errorf("failed to revoke upload key '%s': %w", id, err)
+	}
+	return nil
+}
+
+// List returns all upload keys, newest first
+func (s *Store) List() ([]*Key, error) {
+	ids, err := s.kv.Keys()
+	if err != nil {
+		if errors.Is(err, nats.ErrNoKeysFound) {
+			return []*Key{}, nil
+		}
+		return nil, fmt.Errorf("failed to list upload keys: %w", err)
+	}
+
+	keys := make([]*Key, 0, len(ids))
+	for _, id := range ids {
+		key, err := s.Get(id)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Created.After(keys[j].Created)
+	})
+	return keys, nil
+}
+
+func hash(full string) string {
+	sum := sha256.Sum256([]byte(full))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate upload key: %w", err)
+	}
+	return hex.EncodeToString(bytes), nil
+}
@@ -0,0 +1,232 @@
+package users
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/png"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	// TOTPIssuer names the service in authenticator apps
+	TOTPIssuer = "soxdrawer"
+
+	// RecoveryCodeCount is how many single-use recovery codes are issued
+	RecoveryCodeCount = 10
+
+	totpPeriod = 30 // seconds
+	totpSkew   = 1  // steps accepted either side of now for clock drift
+	qrCodeSize = 256
+)
+
+var (
+	// ErrTOTPRequired is returned when a login needs a second factor that was not given
+	ErrTOTPRequired = errors.New("two-factor code required")
+	// ErrInvalidTOTP is returned for a wrong, expired or reused code
+	ErrInvalidTOTP = errors.New("invalid two-factor code")
+	// ErrTOTPNotPending is returned when confirming without starting enrollment
+	ErrTOTPNotPending = errors.New("two-factor enrollment not started")
+	// ErrTOTPAlreadyEnabled is returned when enrolling a user that already has TOTP
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication already enabled")
+)
+
+// Enrollment is what a user needs to add the account to an authenticator app
+type Enrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+	QRCode string `json:"qr_code"` // PNG data URI encoding URI
+}
+
+var totpOpts = totp.ValidateOpts{
+	Period:    totpPeriod,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// BeginTOTP generates a new secret for the user and keeps it pending until
+// ConfirmTOTP proves the authenticator app produces matching codes
+func (s *Store) BeginTOTP(name string) (*Enrollment, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      TOTPIssuer,
+		AccountName: name,
+		Period:      totpPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+
+	img, err := key.Image(qrCodeSize, qrCodeSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render QR code: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode QR code: %w", err)
+	}
+
+	_, err = s.update(name, func(user *User) error {
+		if user.TOTPEnabled {
+			return ErrTOTPAlreadyEnabled
+		}
+		user.TOTPPendingSecret = key.Secret()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Enrollment{
+		Secret: key.Secret(),
+		URI:    key.URL(),
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// ConfirmTOTP enables TOTP once the user enters a valid code for the pending
+// secret. It returns the recovery codes, which are only stored hashed.
+func (s *Store) ConfirmTOTP(name, code string) ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.update(name, func(user *User) error {
+		if user.TOTPPendingSecret == "" {
+			return ErrTOTPNotPending
+		}
+		step, ok := matchTOTP(user.TOTPPendingSecret, code, 0)
+		if !ok {
+			return ErrInvalidTOTP
+		}
+
+		user.TOTPEnabled = true
+		user.TOTPSecret = user.TOTPPendingSecret
+		user.TOTPPendingSecret = ""
+		user.TOTPLastStep = step
+		user.RecoveryCodes = hashes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifySecondFactor checks a TOTP code or, failing that, a recovery code.
+// A used recovery code is removed. Users without TOTP always pass. The code
+// is spent in the same update that checks it, so two logins racing with one
+// code can't both pass.
+func (s *Store) VerifySecondFactor(name, code, recoveryCode string) error {
+	code = strings.TrimSpace(code)
+	recoveryCode = normalizeRecoveryCode(recoveryCode)
+
+	_, err := s.update(name, func(user *User) error {
+		if !user.TOTPEnabled {
+			return errUnchanged
+		}
+		if code == "" && recoveryCode == "" {
+			return ErrTOTPRequired
+		}
+
+		if code != "" {
+			step, ok := matchTOTP(user.TOTPSecret, code, user.TOTPLastStep)
+			if !ok {
+				return ErrInvalidTOTP
+			}
+			user.TOTPLastStep = step
+			return nil
+		}
+
+		hash := hashRecoveryCode(recoveryCode)
+		for i, stored := range user.RecoveryCodes {
+			if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+				user.RecoveryCodes = slices.Delete(slices.Clone(user.RecoveryCodes), i, i+1)
+				return nil
+			}
+		}
+		return ErrInvalidTOTP
+	})
+	return err
+}
+
+// DisableTOTP turns off the second factor for a user after they prove
+// possession of it
+func (s *Store) DisableTOTP(name, code string) error {
+	if err := s.VerifySecondFactor(name, code, ""); err != nil {
+		return err
+	}
+	return s.ResetTOTP(name)
+}
+
+// ResetTOTP clears all second-factor state without verification. It is the
+// administrator's way to recover a user who lost their authenticator.
+func (s *Store) ResetTOTP(name string) error {
+	_, err := s.update(name, func(user *User) error {
+		user.TOTPEnabled = false
+		user.TOTPSecret = ""
+		user.TOTPPendingSecret = ""
+		user.TOTPLastStep = 0
+		user.RecoveryCodes = nil
+		return nil
+	})
+	return err
+}
+
+// matchTOTP looks for code among the steps around now, skipping steps at or
+// before lastStep so a code cannot be used twice
+func matchTOTP(secret, code string, lastStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	now := time.Now().Unix() / totpPeriod
+
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totpOpts)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// generateRecoveryCodes returns codes formatted as xxxxx-xxxxx alongside their hashes
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		bytes := make([]byte, 5)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		raw := hex.EncodeToString(bytes)
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashRecoveryCode(raw)
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
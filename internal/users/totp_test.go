@@ -0,0 +1,233 @@
+package users
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	natsServer "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/pquerna/otp/totp"
+)
+
+// startJetStream runs an in-process NATS server with JetStream for one test
+func startJetStream(t *testing.T) nats.JetStreamContext {
+	t.Helper()
+
+	ns, err := natsServer.NewServer(&natsServer.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create NATS server: %v", err)
+	}
+	ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("failed to connect to NATS: %v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	js, err := conn.JetStream()
+	if err != nil {
+		t.Fatalf("failed to get JetStream context: %v", err)
+	}
+	return js
+}
+
+func newStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := New(startJetStream(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// code returns the TOTP code for secret steps periods from now
+func code(t *testing.T, secret string, steps int) string {
+	t.Helper()
+	code, err := totp.GenerateCodeCustom(secret, time.Now().Add(time.Duration(steps)*totpPeriod*time.Second), totpOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// enroll turns on TOTP for a new user and returns the secret and recovery codes
+func enroll(t *testing.T, store *Store, name string) (string, []string) {
+	t.Helper()
+	if _, err := store.Ensure(name, RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	enrollment, err := store.BeginTOTP(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/") || !strings.HasPrefix(enrollment.QRCode, "data:image/png;base64,") {
+		t.Fatalf("enrollment = %+v", enrollment)
+	}
+	recovery, err := store.ConfirmTOTP(name, code(t, enrollment.Secret, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovery) != RecoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(recovery), RecoveryCodeCount)
+	}
+	return enrollment.Secret, recovery
+}
+
+func TestEnrollmentNeedsAMatchingCode(t *testing.T) {
+	store := newStore(t)
+	if _, err := store.Ensure("alice", RoleUser); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.ConfirmTOTP("alice", "123456"); !errors.Is(err, ErrTOTPNotPending) {
+		t.Fatalf("confirm before enrolling = %v, want %v", err, ErrTOTPNotPending)
+	}
+	enrollment, err := store.BeginTOTP("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ConfirmTOTP("alice", code(t, enrollment.Secret, 5)); !errors.Is(err, ErrInvalidTOTP) {
+		t.Fatalf("confirm with a wrong code = %v, want %v", err, ErrInvalidTOTP)
+	}
+	// Until confirmed, logins don't ask for a code
+	if err := store.VerifySecondFactor("alice", "", ""); err != nil {
+		t.Fatalf("login while pending = %v", err)
+	}
+
+	if _, err := store.ConfirmTOTP("alice", code(t, enrollment.Secret, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.BeginTOTP("alice"); !errors.Is(err, ErrTOTPAlreadyEnabled) {
+		t.Fatalf("enrolling again = %v, want %v", err, ErrTOTPAlreadyEnabled)
+	}
+	user, err := store.Get("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.TOTPEnabled || user.TOTPPendingSecret != "" || user.TOTPSecret != enrollment.Secret {
+		t.Fatalf("user after confirming = %+v", user)
+	}
+}
+
+func TestCodesCanOnlyBeUsedOnce(t *testing.T) {
+	store := newStore(t)
+	secret, _ := enroll(t, store, "alice")
+
+	if err := store.VerifySecondFactor("alice", "", ""); !errors.Is(err, ErrTOTPRequired) {
+		t.Fatalf("login without a code = %v, want %v", err, ErrTOTPRequired)
+	}
+	// The code used to confirm is spent already
+	if err := store.VerifySecondFactor("alice", code(t, secret, 0), ""); !errors.Is(err, ErrInvalidTOTP) {
+		t.Fatalf("replayed confirmation code = %v, want %v", err, ErrInvalidTOTP)
+	}
+	next := code(t, secret, 1)
+	if err := store.VerifySecondFactor("alice", next, ""); err != nil {
+		t.Fatalf("fresh code = %v", err)
+	}
+	if err := store.VerifySecondFactor("alice", next, ""); !errors.Is(err, ErrInvalidTOTP) {
+		t.Fatalf("replayed code = %v, want %v", err, ErrInvalidTOTP)
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	store := newStore(t)
+	_, recovery := enroll(t, store, "alice")
+
+	user, err := store.Get("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stored := range user.RecoveryCodes {
+		if stored == recovery[0] || stored == normalizeRecoveryCode(recovery[0]) {
+			t.Fatal("recovery code stored in the clear")
+		}
+	}
+
+	// Case and dashes don't matter
+	if err := store.VerifySecondFactor("alice", "", strings.ToUpper(strings.ReplaceAll(recovery[0], "-", ""))); err != nil {
+		t.Fatalf("recovery code = %v", err)
+	}
+	if err := store.VerifySecondFactor("alice", "", recovery[0]); !errors.Is(err, ErrInvalidTOTP) {
+		t.Fatalf("used recovery code = %v, want %v", err, ErrInvalidTOTP)
+	}
+	if err := store.VerifySecondFactor("alice", "", recovery[1]); err != nil {
+		t.Fatalf("another recovery code = %v", err)
+	}
+}
+
+func TestConcurrentLoginsSpendACodeOnce(t *testing.T) {
+	store := newStore(t)
+	secret, recovery := enroll(t, store, "alice")
+
+	for name, verify := range map[string]func() error{
+		"code":          func() error { return store.VerifySecondFactor("alice", code(t, secret, 1), "") },
+		"recovery code": func() error { return store.VerifySecondFactor("alice", "", recovery[0]) },
+	} {
+		var wg sync.WaitGroup
+		results := make(chan error, 10)
+		for range cap(results) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results <- verify()
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		passed := 0
+		for err := range results {
+			switch {
+			case err == nil:
+				passed++
+			case !errors.Is(err, ErrInvalidTOTP):
+				t.Fatalf("racing login with a %s = %v, want %v", name, err, ErrInvalidTOTP)
+			}
+		}
+		if passed != 1 {
+			t.Fatalf("%d racing logins passed with one %s, want 1", passed, name)
+		}
+	}
+}
+
+func TestDisableAndReset(t *testing.T) {
+	store := newStore(t)
+	secret, _ := enroll(t, store, "alice")
+
+	if err := store.DisableTOTP("alice", "000000"); !errors.Is(err, ErrInvalidTOTP) && code(t, secret, 0) != "000000" {
+		t.Fatalf("disable with a wrong code = %v, want %v", err, ErrInvalidTOTP)
+	}
+	if err := store.DisableTOTP("alice", code(t, secret, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.VerifySecondFactor("alice", "", ""); err != nil {
+		t.Fatalf("login after disabling = %v", err)
+	}
+
+	enroll(t, store, "bob")
+	if err := store.ResetTOTP("bob"); err != nil {
+		t.Fatal(err)
+	}
+	user, err := store.Get("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.TOTPEnabled || user.TOTPSecret != "" || len(user.RecoveryCodes) != 0 {
+		t.Fatalf("user after reset = %+v", user)
+	}
+}
@@ -0,0 +1,292 @@
+package users
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// BucketName is the KV bucket holding user accounts
+	BucketName = "users"
+
+	// AdminUser is the account that logins with the shared HTTP token use
+	AdminUser = "admin"
+
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+var (
+	// ErrUserNotFound is returned when no account exists for a name
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserConflict is returned when an external identity claims a name
+	// that belongs to a different account
+	ErrUserConflict = errors.New("user name belongs to another account")
+	// ErrUserBusy is returned when a user kept changing while being updated
+	ErrUserBusy = errors.New("user changed too often to update")
+
+	// errUnchanged tells update that the user needs no saving
+	errUnchanged = errors.New("unchanged")
+)
+
+// updateAttempts is how often update reads a user again after losing a race
+const updateAttempts = 5
+
+type (
+	// User is a web login account. Its second-factor settings live here so
+	// they apply however the user authenticates.
+	User struct {
+		Name    string    `json:"name"`
+		Role    string    `json:"role"`
+		Created time.Time `json:"created"`
+		Updated time.Time `json:"updated"`
+
+		// Set for accounts provisioned from an OpenID Connect login
+		Issuer  string `json:"issuer,omitempty"`
+		Subject string `json:"subject,omitempty"`
+		Email   string `json:"email,omitempty"`
+
+		TOTPEnabled       bool     `json:"totp_enabled"`
+		TOTPSecret        string   `json:"totp_secret,omitempty"`
+		TOTPPendingSecret string   `json:"totp_pending_secret,omitempty"` // Awaiting confirmation
+		TOTPLastStep      int64    `json:"totp_last_step,omitempty"`      // Rejects replay of a used code
+		RecoveryCodes     []string `json:"recovery_codes,omitempty"`      // SHA-256 hashes of unused codes
+	}
+
+	// Store keeps users in a JetStream KV bucket
+	Store struct {
+		kv nats.KeyValue
+	}
+)
+
+// IsAdmin reports whether the user may manage other accounts
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// New creates or opens the users bucket
+func New(js nats.JetStreamContext) (*Store, error) {
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{
+		Bucket:      BucketName,
+		Description: "soxdrawer user accounts",
+	})
+	if err != nil {
+		kv, err = js.KeyValue(BucketName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create or get users bucket: %w", err)
+		}
+	}
+
+	store := &Store{kv: kv}
+	if err := store.migrateKeys(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// userKey encodes a user name as a KV key. Names from identity providers
+// may hold characters such as '@' or spaces that keys can't.
+func userKey(name string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(name))
+}
+
+// migrateKeys moves users stored under their plain name, as earlier
+// versions did, to their encoded key
+func (s *Store) migrateKeys() error {
+	keys, err := s.kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list users: %w", err)
+	}
+
+	for _, key := range keys {
+		user, _, err := s.read(key)
+		if err != nil {
+			return err
+		}
+		if key == userKey(user.Name) {
+			continue
+		}
+		data, err := json.Marshal(user)
+		if err != nil {
+			return fmt.Errorf("failed to encode user '%s': %w", user.Name, err)
+		}
+		if _, err := s.kv.Put(userKey(user.Name), data); err != nil {
+			return fmt.Errorf("failed to migrate user '%s': %w", user.Name, err)
+		}
+		if err := s.kv.Delete(key); err != nil {
+			return fmt.Errorf("failed to migrate user '%s': %w", user.Name, err)
+		}
+		log.Printf("Migrated user '%s' to an encoded key", user.Name)
+	}
+	return nil
+}
+
+// Get returns the user with the given name
+func (s *Store) Get(name string) (*User, error) {
+	user, _, err := s.get(name)
+	return user, err
+}
+
+// get returns the user with the given name and the revision it was read at
+func (s *Store) get(name string) (*User, uint64, error) {
+	user, revision, err := s.read(userKey(name))
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, 0, fmt.Errorf("%w: '%s'", ErrUserNotFound, name)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get user '%s': %w", name, err)
+	}
+	return user, revision, nil
+}
+
+// read decodes the user stored under a KV key
+func (s *Store) read(key string) (*User, uint64, error) {
+	entry, err := s.kv.Get(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var user User
+	if err := json.Unmarshal(entry.Value(), &user); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode user at '%s': %w", key, err)
+	}
+	return &user, entry.Revision(), nil
+}
+
+// update applies change to the user and saves the result only if nobody
+// else saved the user meanwhile; otherwise it starts again from the newer
+// version, so checks made by change always see earlier updates. A change
+// returning errUnchanged leaves the user as it is.
+func (s *Store) update(name string, change func(*User) error) (*User, error) {
+	for range updateAttempts {
+		user, revision, err := s.get(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := change(user); errors.Is(err, errUnchanged) {
+			return user, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		user.Updated = time.Now().UTC()
+		data, err := json.Marshal(user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode user '%s': %w", name, err)
+		}
+		_, err = s.kv.Update(userKey(name), data, revision)
+		if errors.Is(err, nats.ErrKeyExists) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to store user '%s': %w", name, err)
+		}
+		return user, nil
+	}
+	return nil, fmt.Errorf("%w: '%s'", ErrUserBusy, name)
+}
+
+// Ensure returns the named user, creating it with the given role if needed
+func (s *Store) Ensure(name, role string) (*User, error) {
+	user, err := s.Get(name)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	user = &User{
+		Name:    name,
+		Role:    role,
+		Created: now,
+		Updated: now,
+	}
+	if err := s.Save(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Provision creates or updates the account for an external identity on
+// login. The role follows the identity provider on every login. A name
+// already held by a local account or another identity is refused, and the
+// token login's admin account is always local.
+func (s *Store) Provision(name, role, issuer, subject, email string) (*User, error) {
+	if name == AdminUser {
+		return nil, fmt.Errorf("%w: '%s'", ErrUserConflict, name)
+	}
+
+	user, err := s.Get(name)
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		now := time.Now().UTC()
+		user = &User{
+			Name:    name,
+			Created: now,
+			Issuer:  issuer,
+			Subject: subject,
+		}
+	case err != nil:
+		return nil, err
+	case user.Issuer != issuer || user.Subject != subject:
+		return nil, fmt.Errorf("%w: '%s'", ErrUserConflict, name)
+	}
+
+	user.Role = role
+	user.Email = email
+	if err := s.Save(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Save stores a user, replacing any previous version
+func (s *Store) Save(user *User) error {
+	user.Updated = time.Now().UTC()
+
+	data, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("failed to encode user '%s': %w", user.Name, err)
+	}
+	if _, err := s.kv.Put(userKey(user.Name), data); err != nil {
+		return fmt.Errorf("failed to store user '%s': %w", user.Name, err)
+	}
+	return nil
+}
+
+// List returns all users sorted by name
+func (s *Store) List() ([]*User, error) {
+	keys, err := s.kv.Keys()
+	if err != nil {
+		if errors.Is(err, nats.ErrNoKeysFound) {
+			return []*User{}, nil
+		}
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	users := make([]*User, 0, len(keys))
+	for _, key := range keys {
+		user, _, err := s.read(key)
+		if err != nil {
+			continue
+		}
+		users = append(users, user)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+	return users, nil
+}
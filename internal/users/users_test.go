@@ -0,0 +1,75 @@
+package users
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProvisionAcceptsNamesThatAreNotKeys(t *testing.T) {
+	store := newStore(t)
+
+	for _, name := range []string{"alice@example.com", "Bob Smith", "ünï/cøde"} {
+		user, err := store.Provision(name, RoleUser, "https://idp.example.com", "sub-"+name, name)
+		if err != nil {
+			t.Fatalf("Provision(%q) = %v", name, err)
+		}
+		if user.Name != name {
+			t.Fatalf("provisioned name = %q, want %q", user.Name, name)
+		}
+		got, err := store.Get(name)
+		if err != nil {
+			t.Fatalf("Get(%q) = %v", name, err)
+		}
+		if got.Subject != "sub-"+name || got.Email != name {
+			t.Fatalf("stored user = %+v", got)
+		}
+	}
+
+	// Another identity can't take over the name
+	if _, err := store.Provision("alice@example.com", RoleAdmin, "https://idp.example.com", "someone-else", ""); !errors.Is(err, ErrUserConflict) {
+		t.Fatalf("Provision by another subject = %v, want %v", err, ErrUserConflict)
+	}
+
+	users, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 3 || users[0].Name != "Bob Smith" || users[1].Name != "alice@example.com" {
+		t.Fatalf("List() = %v", users)
+	}
+}
+
+func TestPlainKeysAreMigrated(t *testing.T) {
+	js := startJetStream(t)
+	store, err := New(js)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(&User{Name: "alice", Role: RoleAdmin, Created: time.Now().UTC()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.kv.Put("alice", data); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = New(js)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := store.Get("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.IsAdmin() {
+		t.Fatalf("migrated user = %+v", user)
+	}
+	if _, err := store.kv.Get("alice"); err == nil {
+		t.Fatal("plain key kept after migration")
+	}
+	if users, err := store.List(); err != nil || len(users) != 1 {
+		t.Fatalf("List() = %v, %v", users, err)
+	}
+}
@@ -3,62 +3,160 @@ package main
 import (
 	"context"
 	"embed"
+	"errors"
+	"flag"
 	"log"
+	"maps"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
+	"soxdrawer/internal/apikeys"
+	"soxdrawer/internal/audit"
+	"soxdrawer/internal/bridge"
+	"soxdrawer/internal/certs"
 	"soxdrawer/internal/config"
 	"soxdrawer/internal/http"
+	"soxdrawer/internal/hubsync"
 	"soxdrawer/internal/nats"
+	"soxdrawer/internal/oidc"
+	"soxdrawer/internal/quota"
+	"soxdrawer/internal/ratelimit"
+	"soxdrawer/internal/session"
 	"soxdrawer/internal/store"
+	"soxdrawer/internal/uploadkeys"
+	"soxdrawer/internal/users"
 )
 
 //go:embed web/dist/*
 var content embed.FS
 
 func main() {
-	// Load configuration
-	cfg, err := config.LoadConfig("")
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(configCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "nats" {
+		os.Exit(natsCommand(os.Args[2:]))
+	}
+
+	// Load configuration: defaults, file, SOXDRAWER_* environment, flags
+	loaded, err := config.Load(os.Args[1:], os.Environ())
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	for _, warning := range loaded.Warnings {
+		log.Printf("Warning: %s", warning)
+	}
+	if loaded.PrintConfig {
+		loaded.Print(os.Stdout)
+		return
+	}
+	cfg := loaded.Config
 
-	// Generate token if not present
+	if !loaded.Exists {
+		if err := config.SaveConfig(loaded.File, loaded.Path); err != nil {
+			log.Printf("Warning: Config file %s does not exist and could not be created: %v", loaded.Path, err)
+		} else {
+			log.Printf("Created config file %s with default settings", loaded.Path)
+		}
+	}
+
+	// Generate missing tokens into the secrets file; the config file never
+	// gets them
+	generated := map[string]string{}
 	if cfg.NATS.Token == "" {
 		if err := cfg.GenerateToken(); err != nil {
 			log.Fatalf("Failed to generate NATS token: %v", err)
 		}
-
-		// Save the updated configuration
-		if err := config.SaveConfig(cfg, ""); err != nil {
-			log.Printf("Warning: Failed to save configuration with generated token: %v", err)
-		} else {
-			log.Println("Generated and saved new NATS authentication token")
-		}
+		generated["nats.token"] = cfg.NATS.Token
 	}
-
-	// Generate HTTP authentication token if not present
 	if cfg.HTTP.Auth.Token == "" {
 		if err := cfg.GenerateHTTPToken(); err != nil {
 			log.Fatalf("Failed to generate HTTP authentication token: %v", err)
 		}
-
-		// Save the updated configuration
-		if err := config.SaveConfig(cfg, ""); err != nil {
-			log.Printf("Warning: Failed to save configuration with generated HTTP token: %v", err)
+		generated["http.auth.token"] = cfg.HTTP.Auth.Token
+	}
+	if len(generated) > 0 {
+		names := strings.Join(slices.Sorted(maps.Keys(generated)), " and ")
+		if err := loaded.SaveSecrets(generated); err != nil {
+			log.Printf("Warning: Failed to save generated %s; new ones will be generated on restart: %v", names, err)
 		} else {
-			log.Println("Generated and saved new HTTP authentication token")
+			log.Printf("Generated %s and saved to %s", names, loaded.SecretsPath)
 		}
 	}
 
+	// Certificate reloaders watch their files until shutdown
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+
 	// Create NATS configuration from loaded config
 	natsConfig := &nats.Config{
 		Host:     cfg.NATS.Host,
 		Port:     cfg.NATS.Port,
 		StoreDir: cfg.NATS.StoreDir,
 		Token:    cfg.NATS.Token,
+		Clients:  natsClients(cfg.NATS.Clients),
+		LogLevel: cfg.Log.Level,
+
+		ServerName:    cfg.NATS.ServerName,
+		MaxPayload:    int32(cfg.NATS.MaxPayloadKB) << 10,
+		WriteDeadline: time.Duration(cfg.NATS.WriteDeadlineSeconds) * time.Second,
+		MaxConns:      cfg.NATS.MaxConnections,
+		PingInterval:  time.Duration(cfg.NATS.PingIntervalSeconds) * time.Second,
+		JetStream: nats.JetStreamLimits{
+			MaxMemory: cfg.NATS.JetStream.MaxMemoryMB << 20,
+			MaxStore:  cfg.NATS.JetStream.MaxStoreGB << 30,
+			Domain:    cfg.NATS.JetStream.Domain,
+		},
+	}
+	if cluster := cfg.NATS.Cluster; cluster.Port != 0 {
+		natsConfig.Cluster = &nats.ClusterConfig{
+			Name:   cluster.Name,
+			Host:   cluster.Host,
+			Port:   cluster.Port,
+			Routes: cluster.Routes,
+			Token:  cluster.Token,
+			// A majority of the replicas is enough to elect leaders, so one
+			// server being down doesn't stop the others from starting
+			Quorum: cfg.NATS.JetStream.Replicas/2 + 1,
+		}
+	}
+	if leaf := cfg.NATS.LeafNodes; leaf.Port != 0 {
+		natsConfig.LeafNodes = &nats.LeafNodesConfig{
+			Host:  leaf.Host,
+			Port:  leaf.Port,
+			Token: leaf.Token,
+		}
+	}
+	if ws := cfg.NATS.WebSocket; ws.Port != 0 {
+		natsConfig.WebSocket = &nats.WebSocketConfig{
+			Host:           ws.Host,
+			Port:           ws.Port,
+			NoTLS:          ws.NoTLS,
+			AllowedOrigins: ws.AllowedOrigins,
+			Compression:    ws.Compression,
+		}
+	}
+	if mqtt := cfg.NATS.MQTT; mqtt.Port != 0 {
+		natsConfig.MQTT = &nats.MQTTConfig{
+			Host:  mqtt.Host,
+			Port:  mqtt.Port,
+			NoTLS: mqtt.NoTLS,
+		}
+	}
+	if cfg.NATS.TLS.Enabled {
+		natsCerts, err := certs.New(cfg.NATS.TLS.Certs())
+		if err != nil {
+			log.Fatalf("Failed to set up NATS TLS: %v", err)
+		}
+		go natsCerts.Watch(watchCtx, certs.ReloadInterval)
+		natsConfig.TLS = natsCerts.TLSConfig()
 	}
 
 	natsServer, err := nats.NewServer(natsConfig)
@@ -72,36 +170,294 @@ func main() {
 
 	log.Printf("NATS server is secured with token authentication")
 
-	store, err := store.New(natsServer.JetStream())
+	replicas := cfg.NATS.JetStream.Replicas
+	var objectStore *store.ObjectStore
+	err = natsServer.RetryPlacement(func() (err error) {
+		objectStore, err = store.New(natsServer.JetStream(), replicas)
+		return err
+	})
 	if err != nil {
 		log.Fatalf("Failed to create object store: %v", err)
 	}
 
-	status, _ := store.Status()
+	status, _ := objectStore.Status()
 	log.Printf("Object store status - Bucket: %s, Size: %d", status.Bucket(), status.Size())
 
+	auth := cfg.HTTP.Auth
+	sessions, err := session.New(natsServer.JetStream(), sessionConfig(auth))
+	if err != nil {
+		log.Fatalf("Failed to create session store: %v", err)
+	}
+
+	accounts, err := users.New(natsServer.JetStream())
+	if err != nil {
+		log.Fatalf("Failed to create user store: %v", err)
+	}
+
+	uploadKeys, err := uploadkeys.New(natsServer.JetStream())
+	if err != nil {
+		log.Fatalf("Failed to create upload key store: %v", err)
+	}
+
+	apiKeys, err := apikeys.New(natsServer.JetStream())
+	if err != nil {
+		log.Fatalf("Failed to create API key store: %v", err)
+	}
+
+	var auditLog *audit.Log
+	if cfg.Audit.Enabled {
+		auditLog, err = audit.New(natsServer.JetStream(), time.Duration(cfg.Audit.RetentionDays)*24*time.Hour)
+		if err != nil {
+			log.Fatalf("Failed to create audit log: %v", err)
+		}
+	}
+
+	// Streams created before joining a cluster, or before a change of
+	// replicas, get the configured number too
+	if err := natsServer.ScaleStreams(replicas); err != nil {
+		log.Fatalf("Failed to replicate streams: %v", err)
+	}
+
+	quotas := quota.New(natsServer.JetStream(), quotaConfig(cfg.Quotas))
+	if err := quotas.Start(); err != nil {
+		log.Fatalf("Failed to load storage usage: %v", err)
+	}
+
+	// As a leaf node, keep the synced buckets in step with the hub's
+	var syncer *hubsync.Syncer
+	if hub := cfg.NATS.Hub; len(hub.URLs) > 0 {
+		link, err := nats.ConnectHub(&nats.HubConfig{
+			URLs:       hub.URLs,
+			Token:      hub.Token,
+			Domain:     hub.Domain,
+			ServerName: cfg.NATS.ServerName,
+			LogLevel:   cfg.Log.Level,
+		})
+		if err != nil {
+			log.Fatalf("Failed to connect to the hub: %v", err)
+		}
+		defer link.Close()
+		hubJS, err := link.JetStream()
+		if err != nil {
+			log.Fatalf("Failed to connect to the hub: %v", err)
+		}
+		syncer = hubsync.New(natsServer.JetStream(), hubJS, hubsync.Config{
+			Buckets:  hub.SyncBuckets,
+			Interval: time.Duration(hub.SyncIntervalSeconds) * time.Second,
+			Replicas: replicas,
+		})
+		syncer.Start()
+		log.Printf("Syncing buckets %s with the hub at %s", strings.Join(hub.SyncBuckets, ", "), strings.Join(hub.URLs, ", "))
+	}
+
+	// Messages published to soxdrawer.put.<bucket>.<key> become objects
+	var natsBridge *bridge.Bridge
+	if buckets := cfg.NATS.Bridge.Buckets; len(buckets) > 0 {
+		conn, err := natsServer.CreateBridgeConnection(bridge.Prefix + ".>")
+		if err != nil {
+			log.Fatalf("Failed to connect the NATS bridge: %v", err)
+		}
+		err = natsServer.RetryPlacement(func() (err error) {
+			natsBridge, err = bridge.New(conn, natsServer.JetStream(), buckets, replicas, quotas, auditLog, natsServer.ClientName)
+			return err
+		})
+		if err != nil {
+			log.Fatalf("Failed to create NATS bridge: %v", err)
+		}
+		if err := natsBridge.Start(); err != nil {
+			log.Fatalf("Failed to start NATS bridge: %v", err)
+		}
+		log.Printf("Storing messages on %s.<bucket>.<key> in buckets %s", bridge.Prefix, strings.Join(buckets, ", "))
+	}
+
+	var sso *oidc.Provider
+	if auth.OIDC.Enabled {
+		sso, err = oidc.New(context.Background(), oidc.Config{
+			Issuer:        auth.OIDC.Issuer,
+			ClientID:      auth.OIDC.ClientID,
+			ClientSecret:  auth.OIDC.ClientSecret,
+			RedirectURL:   auth.OIDC.RedirectURL,
+			Scopes:        auth.OIDC.Scopes,
+			UsernameClaim: auth.OIDC.UsernameClaim,
+			RoleClaim:     auth.OIDC.RoleClaim,
+			RoleMappings:  auth.OIDC.RoleMappings,
+			DefaultRole:   auth.OIDC.DefaultRole,
+		})
+		if err != nil {
+			log.Fatalf("Failed to set up OIDC login: %v", err)
+		}
+		log.Printf("OIDC single sign-on enabled with issuer %s", auth.OIDC.Issuer)
+	}
+
+	sameSite, err := http.ParseSameSite(auth.Cookie.SameSite)
+	if err != nil {
+		log.Fatalf("Invalid cookie configuration: %v", err)
+	}
+	if strings.EqualFold(auth.Cookie.SameSite, "none") && !auth.Cookie.Secure {
+		log.Printf("Warning: same_site = \"none\" requires secure cookies; marking the session cookie Secure")
+	}
+
 	httpCfg := &http.Config{
-		Address:   cfg.HTTP.Address,
-		Assets:    content,
-		AuthToken: cfg.HTTP.Auth.Token,
+		Address:           cfg.HTTP.Address,
+		Assets:            content,
+		AuthToken:         cfg.HTTP.Auth.Token,
+		Sessions:          sessions,
+		Users:             accounts,
+		OIDC:              sso,
+		SSOLabel:          auth.OIDC.ButtonLabel,
+		DisableTokenLogin: !auth.TokenLogin,
+		Cookies: http.CookiePolicy{
+			Name:     auth.Cookie.Name,
+			Secure:   auth.Cookie.Secure,
+			SameSite: sameSite,
+			Domain:   auth.Cookie.Domain,
+		},
+		RateLimits: rateLimitConfig(cfg.HTTP.RateLimit),
+		UploadKeys: uploadKeys,
+		APIKeys:    apiKeys,
+		CORS:       corsPolicy(cfg.HTTP.CORS),
+		AuditLog:   auditLog,
+		Quotas:     quotas,
+		Sync:       syncer,
 	}
-	httpServer := http.New(httpCfg, store)
+	if cfg.HTTP.TLS.Enabled {
+		httpCerts, err := certs.New(cfg.HTTP.TLS.Certs())
+		if err != nil {
+			log.Fatalf("Failed to set up HTTP TLS: %v", err)
+		}
+		go httpCerts.Watch(watchCtx, certs.ReloadInterval)
+		httpCfg.TLS = httpCerts.TLSConfig()
+		if !auth.Cookie.Secure {
+			log.Printf("Warning: HTTPS is enabled but the session cookie is not marked secure; set http.auth.cookie.secure = true")
+		}
+	}
+	httpServer := http.New(httpCfg, objectStore)
 	if err := httpServer.Start(); err != nil {
 		log.Fatalf("Failed to start HTTP server: %v", err)
 	}
 
+	// Apply config changes on SIGHUP and, if enabled, when the file changes
+	reloads := &reloader{
+		args:       os.Args[1:],
+		started:    cfg,
+		applied:    cfg,
+		natsServer: natsServer,
+		httpServer: httpServer,
+		sessions:   sessions,
+		quotas:     quotas,
+		auditLog:   auditLog,
+	}
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			reloads.reload("SIGHUP")
+		}
+	}()
+	if cfg.Reload.WatchFile {
+		interval := time.Duration(cfg.Reload.IntervalSeconds) * time.Second
+		go config.Watch(watchCtx, loaded.Path, interval, func() {
+			reloads.reload("change to " + loaded.Path)
+		})
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	log.Println("soxdrawer is running. Press Ctrl+C to stop.")
-	log.Printf("HTTP server: http://%s", cfg.HTTP.Address)
+	scheme := "http"
+	if cfg.HTTP.TLS.Enabled {
+		scheme = "https"
+	}
+	log.Printf("HTTP server: %s://%s", scheme, cfg.HTTP.Address)
 	log.Printf("NATS server: %s (token required)", natsServer.URL())
-	log.Printf("HTTP authentication token: %s", cfg.HTTP.Auth.Token)
+	log.Printf("HTTP authentication token: from %s", loaded.Origin("http.auth.token"))
 
 	<-sigChan
+	if natsBridge != nil {
+		natsBridge.Stop()
+	}
+	if syncer != nil {
+		syncer.Stop()
+	}
+	quotas.Stop()
 	shutdown(natsServer, httpServer)
 }
 
+// sessionConfig converts the TOML session settings
+func sessionConfig(auth config.AuthConfig) *session.Config {
+	return &session.Config{
+		Duration:       time.Duration(auth.SessionDuration) * time.Hour,
+		IdleTimeout:    time.Duration(auth.SessionIdleTimeout) * time.Minute,
+		MaxLifetime:    time.Duration(auth.SessionMaxLifetime) * time.Hour,
+		SlidingRefresh: auth.SlidingRefresh,
+	}
+}
+
+// rateLimitConfig converts the TOML rate limit settings for the HTTP server
+func rateLimitConfig(limits config.RateLimitConfig) http.RateLimitConfig {
+	return http.RateLimitConfig{
+		Login:    ratelimit.Rate{PerMinute: limits.LoginPerMinute, Burst: limits.LoginBurst},
+		Upload:   ratelimit.Rate{PerMinute: limits.UploadPerMinute, Burst: limits.UploadBurst},
+		Download: ratelimit.Rate{PerMinute: limits.DownloadPerMinute, Burst: limits.DownloadBurst},
+		Lockout: ratelimit.LockoutConfig{
+			MaxFailures: limits.Lockout.MaxFailures,
+			BaseDelay:   time.Duration(limits.Lockout.BaseSeconds) * time.Second,
+			MaxDelay:    time.Duration(limits.Lockout.MaxSeconds) * time.Second,
+		},
+	}
+}
+
+// corsPolicy converts the TOML CORS settings for the HTTP server
+func corsPolicy(cors config.CORSConfig) http.CORSPolicy {
+	return http.CORSPolicy{
+		AllowedOrigins:   cors.AllowedOrigins,
+		AllowedMethods:   cors.AllowedMethods,
+		AllowedHeaders:   cors.AllowedHeaders,
+		AllowCredentials: cors.AllowCredentials,
+		MaxAge:           time.Duration(cors.MaxAgeSeconds) * time.Second,
+	}
+}
+
+// natsClients converts the TOML NATS clients for the NATS server
+func natsClients(clients map[string]config.NATSClientConfig) []nats.Client {
+	var result []nats.Client
+	for name, client := range clients {
+		result = append(result, nats.Client{
+			Name:    name,
+			NKey:    client.NKey,
+			Access:  client.Access,
+			Buckets: client.Buckets,
+		})
+	}
+	return result
+}
+
+// quotaConfig converts the TOML quota settings, given in megabytes
+func quotaConfig(quotas config.QuotasConfig) quota.Config {
+	convert := func(q config.QuotaConfig) quota.Quota {
+		return quota.Quota{
+			MaxBytes:      q.MaxSizeMB << 20,
+			MaxObjects:    q.MaxObjects,
+			MaxObjectSize: q.MaxObjectSizeMB << 20,
+		}
+	}
+
+	result := quota.Config{
+		UserDefault:   convert(quotas.UserDefault),
+		Users:         map[string]quota.Quota{},
+		BucketDefault: convert(quotas.BucketDefault),
+		Buckets:       map[string]quota.Quota{},
+	}
+	for name, q := range quotas.Users {
+		result.Users[name] = convert(q)
+	}
+	for name, q := range quotas.Buckets {
+		result.Buckets[name] = convert(q)
+	}
+	return result
+}
+
 func shutdown(natsServer *nats.NATSServer, httpServer *http.Server) {
 	log.Println("Shutting down SoxDrawer...")
 
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"soxdrawer/internal/audit"
+	"soxdrawer/internal/config"
+	"soxdrawer/internal/http"
+	"soxdrawer/internal/nats"
+	"soxdrawer/internal/quota"
+	"soxdrawer/internal/session"
+)
+
+// liveSettings are the settings, or tables of settings, that a reload
+// applies without a restart
+var liveSettings = []string{
+	"secrets_file",
+	"nats.token",
+	"nats.token_file",
+	"nats.clients",
+	"http.auth.token",
+	"http.auth.token_file",
+	"http.auth.session_duration_hours",
+	"http.auth.session_idle_minutes",
+	"http.auth.session_max_lifetime_hours",
+	"http.auth.sliding_refresh",
+	"http.rate_limit",
+	"http.cors",
+	"quotas",
+	"log.level",
+}
+
+type (
+	// reloader applies configuration changes to the running server on
+	// SIGHUP or when the config file changes
+	reloader struct {
+		mu         sync.Mutex
+		args       []string
+		started    *config.Config // Settings that need a restart keep these values
+		applied    *config.Config // Last configuration applied
+		natsServer *nats.NATSServer
+		httpServer *http.Server
+		sessions   *session.Store
+		quotas     *quota.Tracker
+		auditLog   *audit.Log
+	}
+
+	// liveChange applies one group of settings, or puts them back in the
+	// new configuration if that fails so the next reload tries again
+	liveChange struct {
+		keys   []string
+		apply  func() error
+		revert func()
+	}
+)
+
+// reload loads the configuration again, the same way it was loaded at
+// startup, and applies what changed. An invalid configuration is rejected
+// as a whole.
+func (r *reloader) reload(trigger string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	loaded, err := config.Load(r.args, os.Environ())
+	if err == nil && !loaded.Exists {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		log.Printf("Config reload after %s failed, keeping the current configuration: %v", trigger, err)
+		r.record(audit.OutcomeFailure, trigger+": "+err.Error())
+		return
+	}
+	for _, warning := range loaded.Warnings {
+		log.Printf("Warning: %s", warning)
+	}
+
+	next, current := loaded.Config, r.applied
+	// Generated tokens are kept when they couldn't be saved to the secrets file
+	if next.NATS.Token == "" {
+		next.NATS.Token = current.NATS.Token
+	}
+	if next.HTTP.Auth.Token == "" {
+		next.HTTP.Auth.Token = current.HTTP.Auth.Token
+	}
+
+	changes := []liveChange{
+		{
+			keys:   []string{"nats.token"},
+			apply:  func() error { return r.natsServer.SetToken(next.NATS.Token) },
+			revert: func() { next.NATS.Token = current.NATS.Token },
+		},
+		{
+			keys:  []string{"nats.clients"},
+			apply: func() error { r.natsServer.SetClients(natsClients(next.NATS.Clients)); return nil },
+		},
+		{
+			keys:  []string{"http.auth.token"},
+			apply: func() error { r.httpServer.SetAuthToken(next.HTTP.Auth.Token); return nil },
+		},
+		{
+			keys: []string{"http.auth.session_duration_hours", "http.auth.session_idle_minutes",
+				"http.auth.session_max_lifetime_hours", "http.auth.sliding_refresh"},
+			apply: func() error { return r.sessions.SetConfig(sessionConfig(next.HTTP.Auth)) },
+			revert: func() {
+				next.HTTP.Auth.SessionDuration = current.HTTP.Auth.SessionDuration
+				next.HTTP.Auth.SessionIdleTimeout = current.HTTP.Auth.SessionIdleTimeout
+				next.HTTP.Auth.SessionMaxLifetime = current.HTTP.Auth.SessionMaxLifetime
+				next.HTTP.Auth.SlidingRefresh = current.HTTP.Auth.SlidingRefresh
+			},
+		},
+		{
+			keys:  []string{"http.rate_limit"},
+			apply: func() error { r.httpServer.SetRateLimits(rateLimitConfig(next.HTTP.RateLimit)); return nil },
+		},
+		{
+			keys:  []string{"http.cors"},
+			apply: func() error { r.httpServer.SetCORS(corsPolicy(next.HTTP.CORS)); return nil },
+		},
+		{
+			keys:  []string{"quotas"},
+			apply: func() error { r.quotas.SetConfig(quotaConfig(next.Quotas)); return nil },
+		},
+		{
+			keys:  []string{"log.level"},
+			apply: func() error { r.natsServer.SetLogLevel(next.Log.Level); return nil },
+		},
+	}
+
+	changed := config.Changed(current, next)
+	var applied, failed []string
+	for _, change := range changes {
+		keys := matching(changed, change.keys)
+		if len(keys) == 0 {
+			continue
+		}
+		if err := change.apply(); err != nil {
+			log.Printf("Failed to apply %s: %v", strings.Join(keys, ", "), err)
+			if change.revert != nil {
+				change.revert()
+			}
+			failed = append(failed, keys...)
+			continue
+		}
+		applied = append(applied, keys...)
+	}
+	r.applied = next
+
+	restart := restartNeeded(r.started, next)
+
+	var details []string
+	if len(applied) > 0 {
+		log.Printf("Config reloaded after %s; applied %s", trigger, strings.Join(applied, ", "))
+		details = append(details, "applied "+strings.Join(applied, ", "))
+	} else if len(failed) == 0 {
+		log.Printf("Config reloaded after %s; no changes to apply", trigger)
+	}
+	if len(failed) > 0 {
+		details = append(details, "failed "+strings.Join(failed, ", "))
+	}
+	if len(restart) > 0 {
+		log.Printf("Warning: These settings changed but only take effect after a restart: %s", strings.Join(restart, ", "))
+		details = append(details, "restart needed for "+strings.Join(restart, ", "))
+	}
+
+	if len(details) > 0 {
+		outcome := audit.OutcomeSuccess
+		if len(failed) > 0 {
+			outcome = audit.OutcomeFailure
+		}
+		r.record(outcome, trigger+": "+strings.Join(details, "; "))
+	}
+}
+
+// record adds a config change to the audit log
+func (r *reloader) record(outcome, detail string) {
+	if r.auditLog == nil {
+		return
+	}
+	r.auditLog.Record(audit.Record{
+		Actor:   "system",
+		Action:  audit.ActionConfigChange,
+		Outcome: outcome,
+		Detail:  detail,
+	})
+}
+
+// restartNeeded returns the settings that changed since startup but are
+// not live, so only take effect after a restart
+func restartNeeded(started, next *config.Config) []string {
+	var restart []string
+	for _, key := range config.Changed(started, next) {
+		if len(matching([]string{key}, liveSettings)) == 0 {
+			restart = append(restart, key)
+		}
+	}
+	return restart
+}
+
+// matching returns the changed keys that are, or fall under, one of keys
+func matching(changed, keys []string) []string {
+	var result []string
+	for _, key := range changed {
+		for _, prefix := range keys {
+			if key == prefix || strings.HasPrefix(key, prefix+".") {
+				result = append(result, key)
+				break
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"soxdrawer/internal/config"
+)
+
+func TestRestartNeededSkipsLiveSettings(t *testing.T) {
+	started := config.DefaultConfig()
+
+	live := config.DefaultConfig()
+	live.NATS.Token = "a-new-token-that-is-long-enough-to-pass"
+	live.NATS.Clients = map[string]config.NATSClientConfig{"backup": {Access: "read"}}
+	live.HTTP.Auth.SessionIdleTimeout = 5
+	live.HTTP.RateLimit.LoginPerMinute = 1
+	live.HTTP.CORS.AllowedOrigins = []string{"https://app.example.com"}
+	live.Quotas.Users = map[string]config.QuotaConfig{"alice": {MaxObjects: 10}}
+	live.Log.Level = "debug"
+	if restart := restartNeeded(started, live); len(restart) > 0 {
+		t.Fatalf("restartNeeded = %v, want none for live settings", restart)
+	}
+
+	next := config.DefaultConfig()
+	next.NATS.Port++
+	next.HTTP.Address = ":9999"
+	next.HTTP.TLS.Enabled = true
+	next.Log.Level = "debug"
+	restart := restartNeeded(started, next)
+	slices.Sort(restart)
+	if want := []string{"http.address", "http.tls.enabled", "nats.port"}; !slices.Equal(restart, want) {
+		t.Fatalf("restartNeeded = %v, want %v", restart, want)
+	}
+}
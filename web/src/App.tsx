@@ -12,15 +12,34 @@ import {
   Download,
   Clock,
   RefreshCw,
-  LogOut
+  LogOut,
+  Tag,
+  Folder,
+  FolderPlus,
+  Pencil,
+  ChevronRight
 } from 'lucide-react'
 import clsx from 'clsx'
 import { useApi } from './hooks/useApi'
+import { apiService, csrfHeaders } from './services/api'
+import { ItemFilter } from './types'
+import { TwoFactorSettings } from './components/TwoFactorSettings'
 
 function App() {
   const [notification, setNotification] = useState<{ message: string; type: 'success' | 'error' } | null>(null)
   const {
     items,
+    folders,
+    breadcrumbs,
+    openFolder,
+    createFolder,
+    renameFolder,
+    total,
+    hasMore,
+    loadMore,
+    tags,
+    filter,
+    setFilter,
     isLoading,
     isUploading,
     error,
@@ -29,13 +48,25 @@ function App() {
     uploadText,
     uploadUrl,
     deleteItem,
+    renameItem,
+    bulkDelete,
+    downloadArchive,
+    updateMetadata,
   } = useApi()
+  const [editingId, setEditingId] = useState<string | null>(null)
+  const [selected, setSelected] = useState<Set<string>>(new Set())
+  const currentFolder = filter.prefix ?? ''
 
   // Load items from API on mount
   useEffect(() => {
     loadItems()
   }, [loadItems])
 
+  // Selection does not carry across folders or filters
+  useEffect(() => {
+    setSelected(new Set())
+  }, [filter])
+
   const showNotification = (message: string, type: 'success' | 'error') => {
     setNotification({ message, type })
     setTimeout(() => setNotification(null), 3000)
@@ -47,6 +78,7 @@ function App() {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
+          ...csrfHeaders(),
         },
       })
       
@@ -60,11 +92,24 @@ function App() {
     }
   }
 
+  const handleLogoutEverywhere = async () => {
+    if (!window.confirm('Log out of every device, including this one?')) return
+
+    try {
+      await apiService.logoutEverywhere()
+      window.location.href = '/login'
+    } catch (error) {
+      showNotification('Failed to log out everywhere', 'error')
+    }
+  }
+
   const handleDrop = async (acceptedFiles: File[]) => {
     let successCount = 0
     
     for (const file of acceptedFiles) {
-      const result = await uploadFile(file)
+      // Files from a dropped folder carry their path relative to the drop
+      const relativePath = (file as File & { path?: string }).path
+      const result = await uploadFile(file, relativePath?.includes('/') ? relativePath : undefined)
       if (result.success) {
         successCount++
       }
@@ -123,6 +168,104 @@ function App() {
     }
   }
 
+  const toggleSelected = (id: string) => {
+    setSelected(prev => {
+      const next = new Set(prev)
+      if (next.has(id)) {
+        next.delete(id)
+      } else {
+        next.add(id)
+      }
+      return next
+    })
+  }
+
+  const handleBulkDownload = async (format: 'zip' | 'tar.gz') => {
+    const result = await downloadArchive(Array.from(selected), format)
+    if (!result.success || !result.blob) {
+      showNotification('Failed to download archive', 'error')
+      return
+    }
+
+    const url = URL.createObjectURL(result.blob)
+    const link = document.createElement('a')
+    link.href = url
+    link.download = `soxdrawer.${format}`
+    link.click()
+    URL.revokeObjectURL(url)
+  }
+
+  const handleBulkDelete = async () => {
+    if (!window.confirm(`Delete ${selected.size} item(s)?`)) return
+
+    const result = await bulkDelete(Array.from(selected))
+    setSelected(new Set())
+    if (result.success) {
+      showNotification(`Removed ${result.result?.succeeded ?? 0} item(s)`, 'success')
+    } else {
+      showNotification(result.result?.message ?? 'Failed to delete items', 'error')
+    }
+  }
+
+  const handleRename = async (id: string, newName: string) => {
+    setEditingId(null)
+    const currentName = id.slice(currentFolder.length)
+    if (!newName.trim() || newName === currentName) return
+
+    const result = await renameItem(id, newName.trim())
+    if (result.success) {
+      showNotification('Item renamed', 'success')
+    } else {
+      showNotification('Failed to rename item', 'error')
+    }
+  }
+
+  const handleEditTags = async (id: string, currentTags: string[] = []) => {
+    const input = window.prompt('Tags (comma separated)', currentTags.join(', '))
+    if (input === null) return
+
+    const nextTags = input.split(',').map(tag => tag.trim()).filter(Boolean)
+    const result = await updateMetadata(id, { tags: nextTags })
+    if (result.success) {
+      showNotification('Tags updated', 'success')
+    } else {
+      showNotification('Failed to update tags', 'error')
+    }
+  }
+
+  const handleCreateFolder = async () => {
+    const name = window.prompt('New folder name')
+    if (!name) return
+
+    const result = await createFolder(name)
+    if (result.success) {
+      showNotification('Folder created', 'success')
+    } else {
+      showNotification('Failed to create folder', 'error')
+    }
+  }
+
+  const handleRenameFolder = async (folder: string) => {
+    const from = folder.replace(/\/$/, '')
+    const to = window.prompt('Rename or move folder to', from)
+    if (!to || to === from) return
+
+    const result = await renameFolder(from, to)
+    if (result.success) {
+      showNotification('Folder renamed', 'success')
+    } else {
+      showNotification('Failed to rename folder', 'error')
+    }
+  }
+
+  const toggleTagFilter = (tag: string) => {
+    const active = filter.tags ?? []
+    const nextTags = active.includes(tag)
+      ? active.filter(t => t !== tag)
+      : [...active, tag]
+    setFilter({ ...filter, tags: nextTags })
+  }
+
   const copyToClipboard = async (content: string) => {
     try {
       await navigator.clipboard.writeText(content)
@@ -152,7 +295,8 @@ function App() {
   const getItemIcon = (type: string) => {
     switch (type) {
       case 'link': return <Link className="w-5 h-5" />
-      case 'text': return <FileText className="w-5 h-5" />
+      case 'text':
+      case 'document': return <FileText className="w-5 h-5" />
       case 'image': return <Image className="w-5 h-5" />
       default: return <Download className="w-5 h-5" />
     }
@@ -178,8 +322,9 @@ function App() {
                 <span>Refresh</span>
               </button>
               <div className="text-sm text-gray-500">
-                {items.length} item{items.length !== 1 ? 's' : ''} stored
+                {total} item{total !== 1 ? 's' : ''} stored
               </div>
+              <TwoFactorSettings onNotify={showNotification} />
               <button
                 onClick={handleLogout}
                 className="flex items-center space-x-2 px-3 py-2 text-sm text-gray-600 hover:text-red-600 transition-colors"
@@ -188,6 +333,13 @@ function App() {
                 <LogOut className="w-4 h-4" />
                 <span>Logout</span>
               </button>
+              <button
+                onClick={handleLogoutEverywhere}
+                className="px-3 py-2 text-sm text-gray-600 hover:text-red-600 transition-colors"
+                title="Revoke every session on every device"
+              >
+                Log out everywhere
+              </button>
             </div>
           </div>
         </div>
@@ -295,6 +447,27 @@ function App() {
           </div>
         )}
 
+        {/* Tag Cloud */}
+        {tags.length > 0 && (
+          <div className="mb-6 flex flex-wrap items-center gap-2">
+            <Tag className="w-4 h-4 text-gray-500" />
+            {tags.map(tag => (
+              <button
+                key={tag.name}
+                onClick={() => toggleTagFilter(tag.name)}
+                className={clsx(
+                  'px-2 py-1 rounded-full text-xs border transition-colors',
+                  filter.tags?.includes(tag.name)
+                    ? 'bg-primary-600 text-white border-primary-600'
+                    : 'bg-white text-gray-700 border-gray-300 hover:border-primary-500'
+                )}
+              >
+                {tag.name} <span className="opacity-70">{tag.count}</span>
+              </button>
+            ))}
+          </div>
+        )}
+
         {/* Loading State */}
         {isLoading && (
           <div className="text-center py-12">
@@ -304,10 +477,82 @@ function App() {
         )}
 
         {/* Items List */}
-        {!isLoading && items.length > 0 && (
+        {!isLoading && (items.length > 0 || folders.length > 0 || currentFolder || filter.kind) && (
           <div className="bg-white rounded-lg shadow-sm border border-gray-200">
-            <div className="px-6 py-4 border-b border-gray-200">
-              <h2 className="text-xl font-semibold text-gray-900">Stored Items</h2>
+            <div className="px-6 py-4 border-b border-gray-200 flex items-center justify-between">
+              <nav className="flex items-center text-xl font-semibold text-gray-900">
+                {(breadcrumbs.length > 0 ? breadcrumbs : [{ name: '', prefix: '' }]).map((crumb, index) => (
+                  <span key={crumb.prefix} className="flex items-center">
+                    {index > 0 && <ChevronRight className="w-4 h-4 mx-1 text-gray-400" />}
+                    <button
+                      onClick={() => openFolder(crumb.prefix)}
+                      className="hover:text-primary-600 transition-colors"
+                    >
+                      {crumb.name || 'Stored Items'}
+                    </button>
+                  </span>
+                ))}
+              </nav>
+              <div className="flex items-center space-x-2 text-sm">
+                {selected.size > 0 && (
+                  <>
+                    <span className="text-gray-500">{selected.size} selected</span>
+                    <button
+                      onClick={() => handleBulkDownload('zip')}
+                      className="px-2 py-1 text-gray-600 hover:text-gray-900 transition-colors"
+                    >
+                      Download ZIP
+                    </button>
+                    <button
+                      onClick={() => handleBulkDownload('tar.gz')}
+                      className="px-2 py-1 text-gray-600 hover:text-gray-900 transition-colors"
+                    >
+                      Download tar.gz
+                    </button>
+                    <button
+                      onClick={handleBulkDelete}
+                      className="px-2 py-1 text-gray-600 hover:text-red-600 transition-colors"
+                    >
+                      Delete
+                    </button>
+                  </>
+                )}
+                <button
+                  onClick={handleCreateFolder}
+                  className="flex items-center space-x-1 px-2 py-1 text-gray-600 hover:text-gray-900 transition-colors"
+                  title="New folder"
+                >
+                  <FolderPlus className="w-4 h-4" />
+                  <span>New folder</span>
+                </button>
+                <select
+                  value={filter.kind ?? ''}
+                  onChange={(e) => setFilter({ ...filter, kind: (e.target.value || undefined) as ItemFilter['kind'] })}
+                  className="border border-gray-300 rounded-md px-2 py-1"
+                >
+                  <option value="">All kinds</option>
+                  <option value="text">Text</option>
+                  <option value="image">Images</option>
+                  <option value="document">Documents</option>
+                  <option value="archive">Archives</option>
+                  <option value="file">Other files</option>
+                </select>
+                <select
+                  value={`${filter.sort ?? 'created'}:${filter.order ?? 'desc'}`}
+                  onChange={(e) => {
+                    const [sort, order] = e.target.value.split(':') as [ItemFilter['sort'], ItemFilter['order']]
+                    setFilter({ ...filter, sort, order })
+                  }}
+                  className="border border-gray-300 rounded-md px-2 py-1"
+                >
+                  <option value="created:desc">Newest first</option>
+                  <option value="created:asc">Oldest first</option>
+                  <option value="name:asc">Name A-Z</option>
+                  <option value="name:desc">Name Z-A</option>
+                  <option value="size:desc">Largest first</option>
+                  <option value="size:asc">Smallest first</option>
+                </select>
+              </div>
             </div>
             
             <DragDropContext onDragEnd={handleDragEnd}>
@@ -318,6 +563,28 @@ function App() {
                     ref={provided.innerRef}
                     className="divide-y divide-gray-200"
                   >
+                    {folders.map(folder => (
+                      <div key={folder} className="item-card">
+                        <div className="flex items-center justify-between">
+                          <button
+                            onClick={() => openFolder(folder)}
+                            className="flex items-center space-x-4 text-left"
+                          >
+                            <Folder className="w-5 h-5 text-primary-600" />
+                            <span className="font-medium text-gray-900">
+                              {folder.slice(currentFolder.length).replace(/\/$/, '')}
+                            </span>
+                          </button>
+                          <button
+                            onClick={() => handleRenameFolder(folder)}
+                            className="p-2 text-gray-400 hover:text-gray-600 transition-colors"
+                            title="Rename or move folder"
+                          >
+                            <Pencil className="w-4 h-4" />
+                          </button>
+                        </div>
+                      </div>
+                    ))}
                     {items.map((item, index) => (
                       <Draggable key={item.id} draggableId={item.id} index={index}>
                         {(provided, snapshot) => (
@@ -332,13 +599,37 @@ function App() {
                           >
                             <div className="flex items-center justify-between">
                               <div className="flex items-center space-x-4">
+                                <input
+                                  type="checkbox"
+                                  checked={selected.has(item.id)}
+                                  onChange={() => toggleSelected(item.id)}
+                                  className="h-4 w-4"
+                                  aria-label={`Select ${item.name}`}
+                                />
                                 <div className="text-primary-600">
                                   {getItemIcon(item.type)}
                                 </div>
                                 <div className="flex-1">
-                                  <h3 className="font-medium text-gray-900 truncate">
-                                    {item.name}
-                                  </h3>
+                                  {editingId === item.id ? (
+                                    <input
+                                      autoFocus
+                                      defaultValue={item.name.slice(currentFolder.length)}
+                                      className="font-medium text-gray-900 border border-gray-300 rounded px-1"
+                                      onBlur={(e) => handleRename(item.id, e.currentTarget.value)}
+                                      onKeyDown={(e) => {
+                                        if (e.key === 'Enter') handleRename(item.id, e.currentTarget.value)
+                                        if (e.key === 'Escape') setEditingId(null)
+                                      }}
+                                    />
+                                  ) : (
+                                    <h3
+                                      className="font-medium text-gray-900 truncate cursor-text"
+                                      onDoubleClick={() => setEditingId(item.id)}
+                                      title="Double-click to rename"
+                                    >
+                                      {item.name.slice(currentFolder.length)}
+                                    </h3>
+                                  )}
                                   <div className="flex items-center space-x-4 text-sm text-gray-500">
                                     <span className="flex items-center">
                                       <Clock className="w-4 h-4 mr-1" />
@@ -347,11 +638,38 @@ function App() {
                                     {item.size && (
                                       <span>{formatFileSize(item.size)}</span>
                                     )}
+                                    {item.tags?.map(tag => (
+                                      <span
+                                        key={tag}
+                                        className="px-2 py-0.5 rounded-full bg-gray-100 text-xs text-gray-600"
+                                      >
+                                        {tag}
+                                      </span>
+                                    ))}
                                   </div>
+                                  {item.description && (
+                                    <p className="text-sm text-gray-500 truncate">{item.description}</p>
+                                  )}
                                 </div>
                               </div>
                               
                               <div className="flex items-center space-x-2">
+                                <button
+                                  onClick={() => setEditingId(item.id)}
+                                  className="p-2 text-gray-400 hover:text-gray-600 transition-colors"
+                                  title="Rename item"
+                                >
+                                  <Pencil className="w-4 h-4" />
+                                </button>
+
+                                <button
+                                  onClick={() => handleEditTags(item.id, item.tags)}
+                                  className="p-2 text-gray-400 hover:text-gray-600 transition-colors"
+                                  title="Edit tags"
+                                >
+                                  <Tag className="w-4 h-4" />
+                                </button>
+
                                 <button
                                   onClick={() => copyToClipboard(item.content)}
                                   className="p-2 text-gray-400 hover:text-gray-600 transition-colors"
@@ -390,11 +708,22 @@ function App() {
                 )}
               </Droppable>
             </DragDropContext>
+
+            {hasMore && (
+              <div className="px-6 py-4 border-t border-gray-200 text-center">
+                <button
+                  onClick={loadMore}
+                  className="text-sm text-primary-600 hover:text-primary-700"
+                >
+                  Load more ({items.length} of {total})
+                </button>
+              </div>
+            )}
           </div>
         )}
 
         {/* Empty State */}
-        {!isLoading && items.length === 0 && (
+        {!isLoading && items.length === 0 && folders.length === 0 && !currentFolder && !filter.kind && (
           <div className="text-center py-12">
             <Upload className="mx-auto h-16 w-16 text-gray-300 mb-4" />
             <h3 className="text-lg font-medium text-gray-900 mb-2">No items yet</h3>
@@ -0,0 +1,146 @@
+import React, { useEffect, useState } from 'react'
+import { ShieldCheck } from 'lucide-react'
+import { apiService } from '../services/api'
+import { TOTPEnrollment, TOTPStatus } from '../types'
+
+interface TwoFactorSettingsProps {
+  onNotify: (message: string, type: 'success' | 'error') => void
+}
+
+export const TwoFactorSettings: React.FC<TwoFactorSettingsProps> = ({ onNotify }) => {
+  const [open, setOpen] = useState(false)
+  const [status, setStatus] = useState<TOTPStatus | null>(null)
+  const [enrollment, setEnrollment] = useState<TOTPEnrollment | null>(null)
+  const [recoveryCodes, setRecoveryCodes] = useState<string[]>([])
+  const [code, setCode] = useState('')
+
+  const refresh = async () => {
+    try {
+      setStatus(await apiService.getTOTPStatus())
+    } catch (error) {
+      onNotify('Failed to load two-factor status', 'error')
+    }
+  }
+
+  useEffect(() => {
+    if (open) refresh()
+  }, [open])
+
+  const close = () => {
+    setOpen(false)
+    setEnrollment(null)
+    setRecoveryCodes([])
+    setCode('')
+  }
+
+  const handleEnroll = async () => {
+    try {
+      setEnrollment(await apiService.enrollTOTP())
+    } catch (error) {
+      onNotify(error instanceof Error ? error.message : 'Failed to start enrollment', 'error')
+    }
+  }
+
+  const handleConfirm = async () => {
+    try {
+      setRecoveryCodes(await apiService.confirmTOTP(code))
+      setEnrollment(null)
+      setCode('')
+      onNotify('Two-factor authentication enabled', 'success')
+      refresh()
+    } catch (error) {
+      onNotify(error instanceof Error ? error.message : 'Invalid code', 'error')
+    }
+  }
+
+  const handleDisable = async () => {
+    try {
+      await apiService.disableTOTP(code)
+      setCode('')
+      onNotify('Two-factor authentication disabled', 'success')
+      refresh()
+    } catch (error) {
+      onNotify(error instanceof Error ? error.message : 'Invalid code', 'error')
+    }
+  }
+
+  return (
+    <>
+      <button
+        onClick={() => setOpen(true)}
+        className="flex items-center space-x-2 px-3 py-2 text-sm text-gray-600 hover:text-gray-900 transition-colors"
+        title="Two-factor authentication"
+      >
+        <ShieldCheck className="w-4 h-4" />
+        <span>2FA</span>
+      </button>
+
+      {open && (
+        <div className="fixed inset-0 z-40 flex items-center justify-center bg-black/40" onClick={close}>
+          <div className="bg-white rounded-lg shadow-lg p-6 w-full max-w-sm" onClick={(e) => e.stopPropagation()}>
+            <h3 className="text-lg font-medium text-gray-900 mb-4">Two-factor authentication</h3>
+
+            {recoveryCodes.length > 0 ? (
+              <div>
+                <p className="text-sm text-gray-600 mb-2">
+                  Save these recovery codes now. Each works once and they will not be shown again.
+                </p>
+                <ul className="font-mono text-sm grid grid-cols-2 gap-1 mb-4">
+                  {recoveryCodes.map(recoveryCode => (
+                    <li key={recoveryCode}>{recoveryCode}</li>
+                  ))}
+                </ul>
+              </div>
+            ) : enrollment ? (
+              <div>
+                <p className="text-sm text-gray-600 mb-2">Scan with your authenticator app, then enter the code it shows.</p>
+                <img src={enrollment.qr_code} alt="TOTP QR code" className="mx-auto mb-2 w-48 h-48" />
+                <p className="font-mono text-xs text-gray-500 break-all mb-4">{enrollment.secret}</p>
+              </div>
+            ) : status?.enabled ? (
+              <p className="text-sm text-gray-600 mb-4">
+                Enabled for {status.user}. {status.recovery_codes_remaining} recovery code(s) left.
+                Enter a current code to turn it off.
+              </p>
+            ) : (
+              <p className="text-sm text-gray-600 mb-4">
+                Require a code from an authenticator app in addition to your token when signing in.
+              </p>
+            )}
+
+            {recoveryCodes.length === 0 && (enrollment || status?.enabled) && (
+              <input
+                type="text"
+                inputMode="numeric"
+                autoComplete="one-time-code"
+                value={code}
+                onChange={(e) => setCode(e.target.value)}
+                placeholder="123456"
+                className="w-full p-2 border border-gray-300 rounded-md mb-4"
+              />
+            )}
+
+            <div className="flex justify-end space-x-2">
+              <button onClick={close} className="px-3 py-2 text-sm text-gray-600 hover:text-gray-900">
+                Close
+              </button>
+              {recoveryCodes.length === 0 && (enrollment ? (
+                <button onClick={handleConfirm} className="px-3 py-2 text-sm bg-primary-600 text-white rounded-md hover:bg-primary-700">
+                  Confirm
+                </button>
+              ) : status?.enabled ? (
+                <button onClick={handleDisable} className="px-3 py-2 text-sm bg-red-600 text-white rounded-md hover:bg-red-700">
+                  Disable
+                </button>
+              ) : status && (
+                <button onClick={handleEnroll} className="px-3 py-2 text-sm bg-primary-600 text-white rounded-md hover:bg-primary-700">
+                  Set up
+                </button>
+              ))}
+            </div>
+          </div>
+        </div>
+      )}
+    </>
+  )
+}
@@ -1,5 +1,5 @@
 import { useState, useCallback } from 'react'
-import { StoredItem } from '../types'
+import { StoredItem, LabelCount, ItemFilter, MetadataPatch, Breadcrumb } from '../types'
 import { apiService } from '../services/api'
 
 export const useApi = () => {
@@ -7,13 +7,27 @@ export const useApi = () => {
   const [isLoading, setIsLoading] = useState(true)
   const [isUploading, setIsUploading] = useState(false)
   const [error, setError] = useState<string | null>(null)
+  const [filter, setFilter] = useState<ItemFilter>({ prefix: '', delimiter: '/' })
+  const [folders, setFolders] = useState<string[]>([])
+  const [breadcrumbs, setBreadcrumbs] = useState<Breadcrumb[]>([])
+  const [tags, setTags] = useState<LabelCount[]>([])
+  const [total, setTotal] = useState(0)
+  const [nextCursor, setNextCursor] = useState<string | undefined>(undefined)
 
   const loadItems = useCallback(async () => {
     try {
       setIsLoading(true)
       setError(null)
-      const objects = await apiService.listObjects()
-      setItems(objects)
+      const [page, tagCounts] = await Promise.all([
+        apiService.listObjects(filter),
+        apiService.listTags(),
+      ])
+      setItems(page.items)
+      setFolders(page.folders)
+      setBreadcrumbs(page.breadcrumbs)
+      setTotal(page.total)
+      setNextCursor(page.nextCursor)
+      setTags(tagCounts)
     } catch (err) {
       const errorMessage = err instanceof Error ? err.message : 'Failed to load items'
       setError(errorMessage)
@@ -21,13 +35,28 @@ export const useApi = () => {
     } finally {
       setIsLoading(false)
     }
-  }, [])
+  }, [filter])
 
-  const uploadFile = useCallback(async (file: File) => {
+  const loadMore = useCallback(async () => {
+    if (!nextCursor) return
+    try {
+      setError(null)
+      const page = await apiService.listObjects(filter, nextCursor)
+      setItems(prev => [...prev, ...page.items])
+      setTotal(page.total)
+      setNextCursor(page.nextCursor)
+    } catch (err) {
+      const errorMessage = err instanceof Error ? err.message : 'Failed to load more items'
+      setError(errorMessage)
+      console.error('Failed to load more items:', err)
+    }
+  }, [filter, nextCursor])
+
+  const uploadFile = useCallback(async (file: File, relativePath?: string) => {
     try {
       setIsUploading(true)
       setError(null)
-      const response = await apiService.uploadFile(file)
+      const response = await apiService.uploadFile(file, 'file', filter.prefix ?? '', relativePath)
       if (response.status === 'success' && response.key) {
         await loadItems() // Reload to get updated list
         return { success: true, key: response.key }
@@ -41,13 +70,13 @@ export const useApi = () => {
     } finally {
       setIsUploading(false)
     }
-  }, [loadItems])
+  }, [filter.prefix, loadItems])
 
   const uploadText = useCallback(async (content: string) => {
     try {
       setIsUploading(true)
       setError(null)
-      const response = await apiService.uploadText(content)
+      const response = await apiService.uploadText(content, filter.prefix ?? '')
       if (response.status === 'success' && response.key) {
         await loadItems() // Reload to get updated list
         return { success: true, key: response.key }
@@ -61,13 +90,13 @@ export const useApi = () => {
     } finally {
       setIsUploading(false)
     }
-  }, [loadItems])
+  }, [filter.prefix, loadItems])
 
   const uploadUrl = useCallback(async (url: string) => {
     try {
       setIsUploading(true)
       setError(null)
-      const response = await apiService.uploadUrl(url)
+      const response = await apiService.uploadUrl(url, filter.prefix ?? '')
       if (response.status === 'success' && response.key) {
         await loadItems() // Reload to get updated list
         return { success: true, key: response.key }
@@ -81,13 +110,14 @@ export const useApi = () => {
     } finally {
       setIsUploading(false)
     }
-  }, [loadItems])
+  }, [filter.prefix, loadItems])
 
   const deleteItem = useCallback(async (id: string) => {
     try {
       setError(null)
       await apiService.deleteObject(id)
       setItems(prev => prev.filter(item => item.id !== id))
+      setTotal(prev => Math.max(0, prev - 1))
       return { success: true }
     } catch (err) {
       const errorMessage = err instanceof Error ? err.message : 'Failed to delete item'
@@ -123,8 +153,113 @@ export const useApi = () => {
     }
   }, [])
 
+  const updateMetadata = useCallback(async (id: string, patch: MetadataPatch) => {
+    try {
+      setError(null)
+      await apiService.updateMetadata(id, patch)
+      await loadItems() // Reload to refresh tag counts and filters
+      return { success: true }
+    } catch (err) {
+      const errorMessage = err instanceof Error ? err.message : 'Failed to update item'
+      setError(errorMessage)
+      console.error('Failed to update metadata:', err)
+      return { success: false, error: errorMessage }
+    }
+  }, [loadItems])
+
+  const renameItem = useCallback(async (id: string, newName: string) => {
+    try {
+      setError(null)
+      const response = await apiService.renameObject(id, newName)
+      if (response.key) {
+        const newKey = response.key
+        setItems(prev => prev.map(item => item.id === id
+          ? { ...item, id: newKey, name: newKey, content: newKey, url: `/api/download/${newKey}` }
+          : item))
+      }
+      return { success: true }
+    } catch (err) {
+      const errorMessage = err instanceof Error ? err.message : 'Failed to rename item'
+      setError(errorMessage)
+      console.error('Failed to rename item:', err)
+      return { success: false, error: errorMessage }
+    }
+  }, [])
+
+  const bulkDelete = useCallback(async (ids: string[]) => {
+    try {
+      setError(null)
+      const result = await apiService.bulkDelete(ids)
+      const deleted = new Set(result.results.filter(r => r.status === 'success').map(r => r.key))
+      setItems(prev => prev.filter(item => !deleted.has(item.id)))
+      setTotal(prev => Math.max(0, prev - deleted.size))
+      return { success: result.failed === 0, result }
+    } catch (err) {
+      const errorMessage = err instanceof Error ? err.message : 'Failed to delete items'
+      setError(errorMessage)
+      console.error('Failed to delete items:', err)
+      return { success: false, error: errorMessage }
+    }
+  }, [])
+
+  const downloadArchive = useCallback(async (ids: string[], format: 'zip' | 'tar.gz' = 'zip') => {
+    try {
+      setError(null)
+      const blob = await apiService.bulkDownload(ids, format)
+      return { success: true, blob }
+    } catch (err) {
+      const errorMessage = err instanceof Error ? err.message : 'Failed to download archive'
+      setError(errorMessage)
+      console.error('Failed to download archive:', err)
+      return { success: false, error: errorMessage }
+    }
+  }, [])
+
+  const openFolder = useCallback((prefix: string) => {
+    setFilter(prev => ({ ...prev, prefix }))
+  }, [])
+
+  const createFolder = useCallback(async (name: string) => {
+    try {
+      setError(null)
+      await apiService.createFolder(`${filter.prefix ?? ''}${name}`)
+      await loadItems()
+      return { success: true }
+    } catch (err) {
+      const errorMessage = err instanceof Error ? err.message : 'Failed to create folder'
+      setError(errorMessage)
+      console.error('Failed to create folder:', err)
+      return { success: false, error: errorMessage }
+    }
+  }, [filter.prefix, loadItems])
+
+  const renameFolder = useCallback(async (from: string, to: string) => {
+    try {
+      setError(null)
+      await apiService.renameFolder(from, to)
+      await loadItems()
+      return { success: true }
+    } catch (err) {
+      const errorMessage = err instanceof Error ? err.message : 'Failed to rename folder'
+      setError(errorMessage)
+      console.error('Failed to rename folder:', err)
+      return { success: false, error: errorMessage }
+    }
+  }, [loadItems])
+
   return {
     items,
+    folders,
+    breadcrumbs,
+    openFolder,
+    createFolder,
+    renameFolder,
+    total,
+    hasMore: nextCursor !== undefined,
+    loadMore,
+    tags,
+    filter,
+    setFilter,
     isLoading,
     isUploading,
     error,
@@ -133,7 +268,11 @@ export const useApi = () => {
     uploadText,
     uploadUrl,
     deleteItem,
+    renameItem,
+    bulkDelete,
+    downloadArchive,
     downloadItem,
     getItemContent,
+    updateMetadata,
   }
 } 
\ No newline at end of file
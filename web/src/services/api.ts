@@ -1,4 +1,4 @@
-import { StoredItem } from '../types'
+import { StoredItem, LabelCount, ItemFilter, ItemPage, MetadataPatch, Breadcrumb, BulkResult, SessionInfo, TOTPStatus, TOTPEnrollment } from '../types'
 
 // API Response types
 interface UploadResponse {
@@ -16,7 +16,39 @@ interface ListResponse {
     name: string
     size: number
     created: string
+    kind: 'text' | 'image' | 'document' | 'archive' | 'file'
+    tags?: string[]
+    description?: string
+    collections?: string[]
   }>
+  folders?: string[]
+  breadcrumbs?: Breadcrumb[]
+  total: number
+  next_cursor?: string
+}
+
+interface FolderResponse {
+  status: string
+  message: string
+  prefix?: string
+  moved?: number
+}
+
+interface MetadataResponse {
+  status: string
+  message: string
+  key?: string
+  metadata?: {
+    tags?: string[]
+    description?: string
+    collections?: string[]
+  }
+}
+
+interface LabelsResponse {
+  status: string
+  message: string
+  labels: LabelCount[]
 }
 
 interface ApiError {
@@ -24,6 +56,16 @@ interface ApiError {
   message: string
 }
 
+// The server sets a CSRF cookie that must be echoed in this header on
+// every state-changing request (double-submit)
+const CSRF_COOKIE = 'soxdrawer_csrf'
+const CSRF_HEADER = 'X-CSRF-Token'
+
+export function csrfHeaders(): Record<string, string> {
+  const match = document.cookie.split('; ').find(cookie => cookie.startsWith(`${CSRF_COOKIE}=`))
+  return match ? { [CSRF_HEADER]: decodeURIComponent(match.slice(CSRF_COOKIE.length + 1)) } : {}
+}
+
 class ApiService {
   private baseUrl: string
 
@@ -38,11 +80,12 @@ class ApiService {
     const url = `${this.baseUrl}${endpoint}`
     
     const response = await fetch(url, {
+      ...options,
       headers: {
         'Content-Type': 'application/json',
+        ...csrfHeaders(),
         ...options.headers,
       },
-      ...options,
     })
 
     if (!response.ok) {
@@ -63,14 +106,24 @@ class ApiService {
     return response.json()
   }
 
-  // Upload a file
-  async uploadFile(file: File, type: 'file' | 'text' | 'url' = 'file'): Promise<UploadResponse> {
+  // Upload a file into a folder; relativePath preserves the structure of dropped folders
+  async uploadFile(
+    file: File,
+    type: 'file' | 'text' | 'url' = 'file',
+    folder: string = '',
+    relativePath?: string
+  ): Promise<UploadResponse> {
     const formData = new FormData()
     formData.append('file', file)
     formData.append('type', type)
+    formData.append('folder', folder)
+    if (relativePath) {
+      formData.append('path', relativePath)
+    }
 
     const response = await fetch(`${this.baseUrl}/upload`, {
       method: 'POST',
+      headers: csrfHeaders(),
       body: formData,
     })
 
@@ -92,36 +145,160 @@ class ApiService {
   }
 
   // Upload text content
-  async uploadText(content: string): Promise<UploadResponse> {
+  async uploadText(content: string, folder: string = ''): Promise<UploadResponse> {
     const blob = new Blob([content], { type: 'text/plain' })
     const file = new File([blob], 'text.txt', { type: 'text/plain' })
-    return this.uploadFile(file, 'text')
+    return this.uploadFile(file, 'text', folder)
   }
 
   // Upload URL
-  async uploadUrl(url: string): Promise<UploadResponse> {
+  async uploadUrl(url: string, folder: string = ''): Promise<UploadResponse> {
     const blob = new Blob([url], { type: 'text/plain' })
     const file = new File([blob], 'url.txt', { type: 'text/plain' })
-    return this.uploadFile(file, 'url')
+    return this.uploadFile(file, 'url', folder)
+  }
+
+  // Rename an object; a bare name keeps the object in its current folder
+  async renameObject(key: string, newKey: string): Promise<UploadResponse> {
+    return this.request('/objects/rename', {
+      method: 'POST',
+      body: JSON.stringify({ key, new_key: newKey }),
+    })
+  }
+
+  // Copy an object, optionally into another bucket
+  async copyObject(key: string, newKey: string, bucket?: string): Promise<UploadResponse> {
+    return this.request('/objects/copy', {
+      method: 'POST',
+      body: JSON.stringify({ key, new_key: newKey, bucket }),
+    })
+  }
+
+  // Move an object to a new key, optionally in another bucket
+  async moveObject(key: string, newKey: string, bucket?: string): Promise<UploadResponse> {
+    return this.request('/objects/move', {
+      method: 'POST',
+      body: JSON.stringify({ key, new_key: newKey, bucket }),
+    })
   }
 
-  // List all objects
-  async listObjects(): Promise<StoredItem[]> {
-    const response: ListResponse = await this.request('/list?json=true')
+  // Download several objects as one archive, streamed by the server
+  async bulkDownload(keys: string[], format: 'zip' | 'tar.gz' = 'zip'): Promise<Blob> {
+    const response = await fetch(`${this.baseUrl}/bulk/download`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json', ...csrfHeaders() },
+      body: JSON.stringify({ keys, format }),
+    })
+
+    if (!response.ok) {
+      if (response.status === 401) {
+        window.location.href = '/login'
+        throw new Error('Authentication required')
+      }
+      throw new Error(`Failed to download archive: ${response.statusText}`)
+    }
+
+    return response.blob()
+  }
+
+  // Delete several objects at once
+  async bulkDelete(keys: string[]): Promise<BulkResult> {
+    return this.request('/bulk', {
+      method: 'POST',
+      body: JSON.stringify({ action: 'delete', keys }),
+    })
+  }
+
+  // Apply a tag/collection change to several objects at once
+  async bulkTag(keys: string[], metadata: MetadataPatch): Promise<BulkResult> {
+    return this.request('/bulk', {
+      method: 'POST',
+      body: JSON.stringify({ action: 'tag', keys, metadata }),
+    })
+  }
+
+  // Move several objects into a folder, optionally in another bucket
+  async bulkMove(keys: string[], folder: string, bucket?: string): Promise<BulkResult> {
+    return this.request('/bulk', {
+      method: 'POST',
+      body: JSON.stringify({ action: 'move', keys, destination: { folder, bucket } }),
+    })
+  }
+
+  // Create an empty folder
+  async createFolder(path: string): Promise<FolderResponse> {
+    return this.request('/folders', {
+      method: 'POST',
+      body: JSON.stringify({ path }),
+    })
+  }
+
+  // Rename or move a folder and everything inside it
+  async renameFolder(from: string, to: string): Promise<FolderResponse> {
+    return this.request('/folders/rename', {
+      method: 'POST',
+      body: JSON.stringify({ from, to }),
+    })
+  }
+
+  // List a page of objects, optionally filtered and sorted
+  async listObjects(filter: ItemFilter = {}, cursor?: string, limit: number = 100): Promise<ItemPage> {
+    const params = new URLSearchParams({ json: 'true', limit: String(limit) })
+    filter.tags?.forEach(tag => params.append('tag', tag))
+    if (filter.collection) params.set('collection', filter.collection)
+    if (filter.prefix) params.set('prefix', filter.prefix)
+    if (filter.delimiter) params.set('delimiter', filter.delimiter)
+    if (filter.kind) params.set('kind', filter.kind)
+    if (filter.sort) params.set('sort', filter.sort)
+    if (filter.order) params.set('order', filter.order)
+    if (cursor) params.set('cursor', cursor)
+
+    const response: ListResponse = await this.request(`/list?${params.toString()}`)
     
     if (response.status !== 'success' || !response.objects) {
       throw new Error(response.message || 'Failed to list objects')
     }
 
-    return response.objects.map(obj => ({
+    const items: StoredItem[] = response.objects.map(obj => ({
       id: obj.name,
-      type: this.determineType(obj.name),
+      type: obj.kind ?? this.determineType(obj.name),
       name: obj.name,
       content: obj.name, // We'll need to fetch content separately if needed
       size: obj.size,
       timestamp: new Date(obj.created),
       url: `/api/download/${obj.name}`,
+      tags: obj.tags ?? [],
+      description: obj.description ?? '',
+      collections: obj.collections ?? [],
     }))
+
+    return {
+      items,
+      folders: response.folders ?? [],
+      breadcrumbs: response.breadcrumbs ?? [],
+      total: response.total,
+      nextCursor: response.next_cursor,
+    }
+  }
+
+  // Update tags, description or collections of an object
+  async updateMetadata(key: string, patch: MetadataPatch): Promise<MetadataResponse> {
+    return this.request(`/meta/${key}`, {
+      method: 'PATCH',
+      body: JSON.stringify(patch),
+    })
+  }
+
+  // List all tags with their object counts
+  async listTags(): Promise<LabelCount[]> {
+    const response: LabelsResponse = await this.request('/tags')
+    return response.labels ?? []
+  }
+
+  // List all collections with their object counts
+  async listCollections(): Promise<LabelCount[]> {
+    const response: LabelsResponse = await this.request('/collections')
+    return response.labels ?? []
   }
 
   // Delete an object
@@ -161,6 +338,56 @@ class ApiService {
     return response.text()
   }
 
+  // List active login sessions
+  async listSessions(): Promise<SessionInfo[]> {
+    const response: { sessions: SessionInfo[] } = await this.request('/auth/sessions')
+    return response.sessions ?? []
+  }
+
+  // Revoke a single login session
+  async revokeSession(id: string): Promise<void> {
+    await this.request(`/auth/sessions/${id}`, {
+      method: 'DELETE',
+    })
+  }
+
+  // Revoke every login session, including this one
+  async logoutEverywhere(): Promise<void> {
+    await this.request('/auth/logout-all', {
+      method: 'POST',
+    })
+  }
+
+  // Get the current user's two-factor status
+  async getTOTPStatus(): Promise<TOTPStatus> {
+    return this.request('/auth/totp')
+  }
+
+  // Start two-factor enrollment; returns the secret, otpauth URI and QR code
+  async enrollTOTP(): Promise<TOTPEnrollment> {
+    const response: { enrollment: TOTPEnrollment } = await this.request('/auth/totp/enroll', {
+      method: 'POST',
+    })
+    return response.enrollment
+  }
+
+  // Confirm enrollment with a code; returns the one-time recovery codes
+  async confirmTOTP(code: string): Promise<string[]> {
+    const response: { recovery_codes: string[] } = await this.request('/auth/totp/confirm', {
+      method: 'POST',
+      body: JSON.stringify({ code }),
+    })
+    return response.recovery_codes
+  }
+
+  // Turn two-factor off, proving possession with a current code
+  async disableTOTP(code: string): Promise<void> {
+    await this.request('/auth/totp/disable', {
+      method: 'POST',
+      body: JSON.stringify({ code }),
+    })
+  }
+
   // Determine the type of object based on filename
   private determineType(filename: string): 'file' | 'link' | 'text' | 'image' {
     const ext = filename.toLowerCase().split('.').pop()
@@ -181,8 +408,8 @@ class ApiService {
   // Get object info
   async getObjectInfo(key: string): Promise<StoredItem | null> {
     try {
-      const objects = await this.listObjects()
-      return objects.find(obj => obj.id === key) || null
+      const page = await this.listObjects({ prefix: key, sort: 'name', order: 'asc' })
+      return page.items.find(obj => obj.id === key) || null
     } catch (error) {
       console.error('Failed to get object info:', error)
       return null
@@ -1,11 +1,52 @@
 export interface StoredItem {
   id: string
-  type: 'file' | 'link' | 'text' | 'image'
+  type: 'file' | 'link' | 'text' | 'image' | 'document' | 'archive'
   name: string
   content: string
   size?: number
   timestamp: Date
   url?: string
+  tags?: string[]
+  description?: string
+  collections?: string[]
+}
+
+export interface LabelCount {
+  name: string
+  count: number
+}
+
+export interface ItemFilter {
+  tags?: string[]
+  collection?: string
+  prefix?: string
+  delimiter?: string
+  kind?: 'text' | 'image' | 'document' | 'archive' | 'file'
+  sort?: 'name' | 'size' | 'created'
+  order?: 'asc' | 'desc'
+}
+
+export interface Breadcrumb {
+  name: string
+  prefix: string
+}
+
+export interface ItemPage {
+  items: StoredItem[]
+  folders: string[]
+  breadcrumbs: Breadcrumb[]
+  total: number
+  nextCursor?: string
+}
+
+export interface MetadataPatch {
+  tags?: string[]
+  add_tags?: string[]
+  remove_tags?: string[]
+  description?: string
+  collections?: string[]
+  add_collections?: string[]
+  remove_collections?: string[]
 }
 
 export interface Notification {
@@ -24,4 +65,40 @@ export interface DragDropResult {
     index: number
     droppableId: string
   }
-} 
\ No newline at end of file
+} 
+export interface BulkItemResult {
+  key: string
+  status: 'success' | 'error'
+  new_key?: string
+  error?: string
+}
+
+export interface BulkResult {
+  status: string
+  message: string
+  succeeded: number
+  failed: number
+  results: BulkItemResult[]
+}
+
+export interface SessionInfo {
+  id: string
+  user_agent: string
+  ip: string
+  created: string
+  last_seen: string
+  expires_at: string
+  current: boolean
+}
+
+export interface TOTPStatus {
+  user: string
+  enabled: boolean
+  recovery_codes_remaining: number
+}
+
+export interface TOTPEnrollment {
+  secret: string
+  otpauth_uri: string
+  qr_code: string
+}